@@ -0,0 +1,307 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"context-extender/internal/daemon"
+	"context-extender/internal/database"
+	"context-extender/internal/retention"
+	"github.com/spf13/cobra"
+)
+
+// databaseBackuper is implemented by backends that can back up and
+// restore their database
+type databaseBackuper interface {
+	CreateBackup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error)
+	RestoreBackup(ctx context.Context, backupPath string) (*database.RestoreResult, error)
+	BackupSchedule(ctx context.Context) (*database.BackupSchedule, error)
+	SetBackupSchedule(ctx context.Context, schedule *database.BackupSchedule) error
+	ScheduledBackup(ctx context.Context) (*database.BackupResult, error)
+}
+
+var backupDbCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Back up the database",
+	Long: `Take a consistent snapshot of the database while capture keeps writing.
+
+The snapshot is checked with PRAGMA integrity_check before it is stored in
+the backups directory next to the database. With --compress it is gzipped,
+with --encrypt it is sealed under the passphrase (CONTEXT_EXTENDER_PASSPHRASE,
+the key file in CONTEXT_EXTENDER_KEY_FILE, or a prompt). Backups beyond
+--keep and older than --max-age are pruned; the newest one is always kept.
+
+With --schedule (hourly, daily, weekly or a duration such as 12h) no backup
+is taken now; instead capture takes one whenever the newest backup is older
+than that, with the other flags given here. --schedule off turns it off.
+Encrypted scheduled backups need the passphrase in the environment of the
+hooks.`,
+	Example: `  context-extender database backup --compress
+  context-extender database backup --schedule daily --keep 7 --compress
+  context-extender database backup list`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts, err := backupOptionsFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+		schedule, _ := cmd.Flags().GetString("schedule")
+
+		ctx := cmd.Context()
+		backend, closeBackend, err := openBackuper(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeBackend()
+
+		if schedule != "" {
+			return setBackupSchedule(ctx, backend, schedule, opts)
+		}
+
+		fmt.Println("💾 Backing up database...")
+		result, err := backend.CreateBackup(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("failed to back up database: %w", err)
+		}
+		fmt.Printf("✅ Backup written to %s (%s, schema version %d)\n", result.Backup.Path, formatBytes(result.Backup.Size), result.SchemaVersion)
+		if len(result.Pruned) > 0 {
+			fmt.Printf("🗑️  Pruned %d old backups\n", len(result.Pruned))
+		}
+		return nil
+	},
+}
+
+var backupListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List database backups",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, _ := cmd.Flags().GetString("dir")
+		if dir == "" {
+			dir = database.BackupDir(database.DefaultDatabaseConfig().DatabasePath)
+		}
+
+		backups, err := database.ListBackups(dir)
+		if err != nil {
+			return err
+		}
+		if len(backups) == 0 {
+			fmt.Printf("No backups in %s\n", dir)
+			return nil
+		}
+
+		fmt.Printf("Backups in %s:\n", dir)
+		for _, backup := range backups {
+			var flags []string
+			if backup.Compressed {
+				flags = append(flags, "compressed")
+			}
+			if backup.Encrypted {
+				flags = append(flags, "🔐 encrypted")
+			}
+			fmt.Printf("  %s  %-10s %s\n", backup.CreatedAt.Format("2006-01-02 15:04:05"), formatBytes(backup.Size), strings.Join(flags, ", "))
+			fmt.Printf("    %s\n", backup.Path)
+		}
+		return nil
+	},
+}
+
+var restoreDbCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "Restore the database from a backup",
+	Long: `Replace the database with a backup taken by 'database backup', or with a
+pre-migration backup.
+
+The backup is decrypted and decompressed as needed, checked with PRAGMA
+integrity_check and its schema version compared with this version's. The
+capture daemon is stopped and the current database is copied to the
+backups directory before its contents are swapped for the backup's in a
+single transaction; hooks that write meanwhile wait or spool their events.
+Backups with an older schema are migrated afterwards.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		force, _ := cmd.Flags().GetBool("force")
+		if !force && !promptContinue(fmt.Sprintf("⚠️  Replace the database with %s?", args[0])) {
+			fmt.Println("Restore cancelled")
+			return nil
+		}
+
+		// The daemon keeps the database open, with the keys and settings it
+		// loaded, so it is stopped while the database is replaced
+		daemonStopped := false
+		if socketPath, err := daemonSocketPath(); err == nil {
+			if _, err := daemon.Stop(socketPath); err == nil {
+				daemonStopped = true
+				fmt.Println("⏹️  Stopped the capture daemon")
+			} else if !errors.Is(err, daemon.ErrUnavailable) {
+				return fmt.Errorf("failed to stop daemon: %w", err)
+			}
+		}
+
+		ctx := cmd.Context()
+		backend, closeBackend, err := openBackuper(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeBackend()
+
+		fmt.Println("♻️  Restoring database...")
+		result, err := backend.RestoreBackup(ctx, args[0])
+		if err != nil {
+			return fmt.Errorf("failed to restore database: %w", err)
+		}
+		fmt.Printf("✅ Restored schema version %d from %s\n", result.SchemaVersion, args[0])
+		fmt.Printf("💾 The previous database was saved to %s\n", result.SafetyBackup)
+
+		if result.SchemaVersion < database.SchemaVersion {
+			if migrator, ok := backend.(database.DatabaseBackend); ok {
+				if err := migrator.CreateSchema(ctx); err != nil {
+					return fmt.Errorf("failed to migrate restored database: %w", err)
+				}
+				fmt.Printf("🔄 Migrated to schema version %d\n", database.SchemaVersion)
+			}
+		}
+		if daemonStopped {
+			fmt.Println("💡 Start the daemon again with 'context-extender daemon start'")
+		}
+		return nil
+	},
+}
+
+// openBackuper opens the configured database, which must support backups
+func openBackuper(ctx context.Context) (databaseBackuper, func(), error) {
+	manager := database.NewManager(database.DefaultDatabaseConfig())
+	if err := manager.Initialize(ctx); err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	backend, err := manager.GetBackend()
+	if err != nil {
+		manager.Close()
+		return nil, nil, fmt.Errorf("failed to get backend: %w", err)
+	}
+	backuper, ok := backend.(databaseBackuper)
+	if !ok {
+		manager.Close()
+		return nil, nil, fmt.Errorf("the %s backend doesn't support backups", backend.GetBackendInfo().Name)
+	}
+	return backuper, func() { manager.Close() }, nil
+}
+
+// backupOptionsFromFlags reads the backup options of a command
+func backupOptionsFromFlags(cmd *cobra.Command) (*database.BackupOptions, error) {
+	opts := &database.BackupOptions{}
+	opts.Dir, _ = cmd.Flags().GetString("dir")
+	opts.Compress, _ = cmd.Flags().GetBool("compress")
+	opts.Encrypt, _ = cmd.Flags().GetBool("encrypt")
+	opts.Keep, _ = cmd.Flags().GetInt("keep")
+
+	if maxAge, _ := cmd.Flags().GetString("max-age"); maxAge != "" {
+		age, err := retention.ParseAge(maxAge)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --max-age: %w", err)
+		}
+		opts.MaxAge = age
+	}
+	return opts, nil
+}
+
+// setBackupSchedule stores or removes the backup schedule
+func setBackupSchedule(ctx context.Context, backend databaseBackuper, value string, opts *database.BackupOptions) error {
+	if value == "off" {
+		if err := backend.SetBackupSchedule(ctx, nil); err != nil {
+			return fmt.Errorf("failed to remove backup schedule: %w", err)
+		}
+		fmt.Println("✅ Scheduled backups turned off")
+		return nil
+	}
+
+	interval, err := parseBackupInterval(value)
+	if err != nil {
+		return err
+	}
+	schedule := &database.BackupSchedule{BackupOptions: *opts, Interval: interval}
+	if err := backend.SetBackupSchedule(ctx, schedule); err != nil {
+		return fmt.Errorf("failed to save backup schedule: %w", err)
+	}
+
+	fmt.Printf("✅ Capture now backs up the database every %s\n", interval)
+	if opts.Encrypt {
+		fmt.Printf("💡 Hooks need %s or %s to seal backups\n", database.PassphraseEnv, database.PassphraseFileEnv)
+	}
+	return nil
+}
+
+// parseBackupInterval parses a --schedule value
+func parseBackupInterval(value string) (time.Duration, error) {
+	switch value {
+	case "hourly":
+		return time.Hour, nil
+	case "daily":
+		return 24 * time.Hour, nil
+	case "weekly":
+		return 7 * 24 * time.Hour, nil
+	}
+	interval, err := retention.ParseAge(value)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid --schedule %q, use hourly, daily, weekly, off or a duration", value)
+	}
+	return interval, nil
+}
+
+// printBackupStatus shows the newest backup and the backup schedule
+func printBackupStatus(ctx context.Context, backend databaseBackuper, databasePath string) {
+	dir := database.BackupDir(databasePath)
+	schedule, err := backend.BackupSchedule(ctx)
+	if err == nil && schedule != nil && schedule.Dir != "" {
+		dir = schedule.Dir
+	}
+
+	backups, _ := database.ListBackups(dir)
+	if len(backups) > 0 {
+		fmt.Printf("  Backups: %d, newest %s\n", len(backups), backups[0].CreatedAt.Format("2006-01-02 15:04:05"))
+	} else {
+		fmt.Printf("  Backups: none\n")
+	}
+	if schedule != nil {
+		fmt.Printf("  Backup Schedule: every %s, keeping %d\n", schedule.Interval, schedule.Keep)
+	}
+}
+
+// runScheduledBackup takes a scheduled backup when one is due. Failures
+// only warn, capture itself already succeeded.
+func runScheduledBackup(ctx context.Context, backend database.DatabaseBackend, out io.Writer) {
+	backuper, ok := backend.(databaseBackuper)
+	if !ok {
+		return
+	}
+
+	result, err := backuper.ScheduledBackup(ctx)
+	if err != nil {
+		fmt.Fprintf(out, "Warning: scheduled backup failed: %v\n", err)
+		return
+	}
+	if result != nil {
+		fmt.Fprintf(out, "Scheduled backup written to %s\n", result.Backup.Path)
+	}
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{backupDbCmd, backupListCmd} {
+		cmd.Flags().String("dir", "", "Backup directory (default: backups next to the database)")
+	}
+	backupDbCmd.Flags().Bool("compress", false, "Compress the backup with gzip")
+	backupDbCmd.Flags().Bool("encrypt", false, "Seal the backup under the passphrase")
+	backupDbCmd.Flags().Int("keep", 10, "Backups to keep, 0 keeps all")
+	backupDbCmd.Flags().String("max-age", "", "Prune backups older than this, e.g. 30d")
+	backupDbCmd.Flags().String("schedule", "", "Back up from capture every interval: hourly, daily, weekly, a duration, or off")
+	restoreDbCmd.Flags().BoolP("force", "f", false, "Skip the confirmation prompt")
+
+	backupDbCmd.AddCommand(backupListCmd)
+	databaseCmd.AddCommand(backupDbCmd)
+	databaseCmd.AddCommand(restoreDbCmd)
+}
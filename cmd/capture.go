@@ -2,11 +2,22 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"time"
 
+	preserver "context-extender/internal/context"
+	"context-extender/internal/daemon"
 	"context-extender/internal/database"
+	"context-extender/internal/filter"
+	"context-extender/internal/hooks"
+	"context-extender/internal/importer"
+	"context-extender/internal/spool"
+	"context-extender/internal/storage"
 	"github.com/spf13/cobra"
 )
 
@@ -19,12 +30,29 @@ var captureRootCmd = &cobra.Command{
 This command is primarily used by Claude Code hooks for automatic conversation capture.
 It accepts event types via the --event flag and routes them to appropriate handlers.
 
+The hook payload Claude Code writes to stdin (session_id, cwd, transcript_path,
+prompt, ...) is used when present. The --data flag and the CLAUDE_SESSION_ID
+environment variable are only used as fallbacks when stdin is empty.
+
+When the capture daemon is running (context-extender daemon start) events are
+forwarded to it over its socket; otherwise they are written to the database
+directly. Events that can't be written because the database is unavailable
+are spooled to disk and replayed by the next successful capture or by
+'capture flush'.
+
+Capture filter rules (see 'context-extender configure rules') are evaluated
+before anything is written; events in excluded directories are ignored.
+
 Supported events:
-  - session-start: Start of a new Claude Code session
+  - session-start: Start of a new Claude Code session; after compaction
+    (source=compact) the preserved context is returned as additionalContext
   - user-prompt: User prompt submitted to Claude
-  - claude-response: Claude's response (including tool usage)
+  - claude-response: Claude finished responding (Stop); new transcript entries
+    are ingested incrementally from the hook's transcript_path
+  - tool-use: Claude is about to run a tool (PreToolUse)
+  - tool-result: A tool finished running (PostToolUse)
   - session-end: End of Claude Code session
-  - conversation-compress: Conversation compression event (preserves critical context)
+  - conversation-compress: Conversation compression event (PreCompact, preserves critical context)
   - context-request: Request for context reinjection after compression
 
 Example usage:
@@ -40,201 +68,973 @@ Example usage:
 			return fmt.Errorf("--event flag is required")
 		}
 
-		// Initialize database with new manager approach
-		config := database.DefaultDatabaseConfig()
-		manager := database.NewManager(config)
+		// Claude Code delivers the hook payload as JSON on stdin
+		payload, err := hooks.ReadStdinPayload()
+		if err != nil {
+			return err
+		}
+
+		input, err := newCaptureInput(event, data, payload)
+		if err != nil {
+			return err
+		}
+
+		// Don't even open the database for excluded locations
+		capture, err := input.applyCaptureRules()
+		if err != nil {
+			return err
+		}
+		if !capture {
+			return nil
+		}
+
+		// Hand the event to the daemon when one is running
+		if socketPath, err := daemonSocketPath(); err == nil {
+			response, err := daemon.Forward(socketPath, input.daemonRequest())
+			if err == nil {
+				fmt.Print(response.Output)
+				if response.OK {
+					return nil
+				}
+				if response.InputError {
+					return errors.New(response.Error)
+				}
+				return spoolCapture(input, errors.New(response.Error))
+			}
+			if !errors.Is(err, daemon.ErrUnavailable) {
+				// The daemon may have stored the event already, but record
+				// IDs are idempotent so replaying it is safe
+				return spoolCapture(input, err)
+			}
+		}
 
 		ctx := context.Background()
-		if err := manager.Initialize(ctx); err != nil {
-			return fmt.Errorf("failed to initialize database: %w", err)
-		}
-		defer manager.Close()
-
-		// Route to appropriate handler based on event type
-		switch event {
-		case "session-start":
-			return handleSessionStart(ctx, manager, data)
-		case "user-prompt":
-			return handleUserPrompt(ctx, manager, data)
-		case "claude-response":
-			return handleClaudeResponse(ctx, manager, data)
-		case "session-end":
-			return handleSessionEnd(ctx, manager, data)
-		case "conversation-compress":
-			return handleConversationCompress(ctx, manager, data)
-		case "context-request":
-			return handleContextRequest(ctx, manager, data)
-		default:
-			return fmt.Errorf("unknown event type: %s", event)
+		backend, closeBackend, err := openCaptureBackend(ctx)
+		if err != nil {
+			return spoolCapture(input, err)
 		}
+		defer closeBackend()
+
+		return captureAndDrain(ctx, backend, input, os.Stdout)
 	},
 }
 
-// handleSessionStart processes session start events
-func handleSessionStart(ctx context.Context, manager *database.Manager, data string) error {
-	// Get session ID from environment or generate new one
-	sessionID := os.Getenv("CLAUDE_SESSION_ID")
-	if sessionID == "" {
-		// Generate a new session ID based on working directory and timestamp
-		wd, _ := os.Getwd()
-		sessionID = fmt.Sprintf("%s_%d", filepath.Base(wd), os.Getpid())
+var captureFlushCmd = &cobra.Command{
+	Use:   "flush",
+	Short: "Replay events spooled while the database was unavailable",
+	Long: `Replay capture events that were written to the spool because the database
+could not be opened or written. Spooled events are also replayed automatically
+by the next capture that reaches the database.
+
+An event whose replay keeps failing is set aside in the spool's dead letter
+directory after a few attempts, so it doesn't hold back the events after it.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		backend, closeBackend, err := openCaptureBackend(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeBackend()
+
+		result, err := drainSpool(ctx, backend, 0)
+		if errors.Is(err, spool.ErrLocked) {
+			fmt.Println("ℹ️  The spool is being replayed by another process")
+			return nil
+		}
+		if result != nil {
+			fmt.Printf("✅ Replayed %d spooled events\n", result.Replayed)
+			if result.Dropped > 0 {
+				fmt.Printf("⚠️  Dropped %d events that can't be replayed\n", result.Dropped)
+			}
+			if result.DeadLettered > 0 {
+				fmt.Printf("⚠️  Set aside %d events that failed %d times\n", result.DeadLettered, spool.MaxAttempts)
+			}
+			if result.Remaining > 0 {
+				fmt.Printf("⏳ %d events remain in the spool\n", result.Remaining)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to replay spool: %w", err)
+		}
+		return nil
+	},
+}
+
+// autoDrainTimeout bounds how long a capture spends replaying the spool
+const autoDrainTimeout = 5 * time.Second
+
+// openCaptureBackend opens the database and makes sure tables added since it
+// was initialized exist
+func openCaptureBackend(ctx context.Context) (database.DatabaseBackend, func(), error) {
+	return openCaptureBackendWith(ctx, database.DefaultDatabaseConfig())
+}
+
+// openCaptureBackendWith opens the capture backend described by config
+func openCaptureBackendWith(ctx context.Context, config *database.DatabaseConfig) (database.DatabaseBackend, func(), error) {
+	manager := database.NewManager(config)
+
+	if err := manager.Initialize(ctx); err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
 
 	backend, err := manager.GetBackend()
 	if err != nil {
-		return fmt.Errorf("failed to get backend: %w", err)
+		manager.Close()
+		return nil, nil, fmt.Errorf("failed to get backend: %w", err)
+	}
+	if err := backend.CreateSchema(ctx); err != nil {
+		manager.Close()
+		return nil, nil, err
+	}
+
+	return backend, func() { manager.Close() }, nil
+}
+
+// errSpoolPending is why an event is spooled while older spooled events
+// are still waiting for replay
+var errSpoolPending = errors.New("older events are waiting in the spool")
+
+// captureAndDrain replays events spooled while the database was
+// unavailable, handles a capture event and takes a scheduled backup when
+// one is due. Spooled events go first, so a replayed start or prompt never
+// lands after a newer end of its session; while some are left, the event
+// is spooled behind them. Events that fail because of the database are
+// spooled instead of lost.
+func captureAndDrain(ctx context.Context, backend database.DatabaseBackend, input *captureInput, out io.Writer) error {
+	result, err := drainSpool(ctx, backend, autoDrainTimeout)
+	if err != nil && !errors.Is(err, spool.ErrLocked) {
+		fmt.Fprintf(os.Stderr, "Warning: failed to replay spooled events: %v\n", err)
+	}
+	if result != nil && result.Replayed > 0 {
+		fmt.Fprintf(os.Stderr, "Replayed %d spooled events\n", result.Replayed)
+	}
+	if errors.Is(err, spool.ErrLocked) || (result != nil && result.Remaining > 0) {
+		return spoolCapture(input, errSpoolPending)
+	}
+
+	if err := dispatchCapture(ctx, backend, input, out); err != nil {
+		if isInputError(err) {
+			return err
+		}
+		return spoolCapture(input, err)
+	}
+
+	runScheduledBackup(ctx, backend, os.Stderr)
+	return nil
+}
+
+// captureSpool returns the spool in the default storage directory
+func captureSpool() (*spool.Spool, error) {
+	storageManager, err := storage.NewStorageManager(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage manager: %w", err)
+	}
+	return spool.New(filepath.Join(storageManager.GetBaseDir(), "spool")), nil
+}
+
+// spoolCapture stores an event that couldn't be written so it is replayed
+// later. The hook succeeds once the event is safely on disk.
+func spoolCapture(input *captureInput, cause error) error {
+	captureSpool, err := captureSpool()
+	if err == nil {
+		_, err = captureSpool.Append(input.daemonRequest(), cause.Error())
+	}
+	if err != nil {
+		return fmt.Errorf("%v (failed to spool event: %w)", cause, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Warning: event spooled for replay: %v\n", cause)
+	return nil
+}
+
+// drainSpool replays spooled events against backend. A zero timeout drains
+// until the spool is empty or an event fails.
+func drainSpool(ctx context.Context, backend database.DatabaseBackend, timeout time.Duration) (*spool.DrainResult, error) {
+	captureSpool, err := captureSpool()
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return captureSpool.Drain(ctx, func(entry *spool.Entry) error {
+		var req daemon.Request
+		if err := json.Unmarshal(entry.Record, &req); err != nil {
+			return fmt.Errorf("%w: %v", spool.ErrDrop, err)
+		}
+
+		// Neither bad input nor a session that was purged or never
+		// recorded gets better with time
+		err := dispatchCapture(ctx, backend, captureInputFromRequest(&req), io.Discard)
+		if isInputError(err) || errors.Is(err, database.ErrSessionNotFound) {
+			return fmt.Errorf("%w: %v", spool.ErrDrop, err)
+		}
+		return err
+	})
+}
+
+// dispatchCapture routes a capture event to its handler
+func dispatchCapture(ctx context.Context, backend database.DatabaseBackend, input *captureInput, out io.Writer) error {
+	// Rules are evaluated again here because the daemon and spool replays
+	// don't go through the command, and the rules may have changed since
+	capture, err := input.applyCaptureRules()
+	if err != nil {
+		return err
+	}
+	if !capture {
+		return nil
+	}
+
+	switch input.Event {
+	case "session-start":
+		return handleSessionStart(ctx, backend, input, out)
+	case "user-prompt":
+		return handleUserPrompt(ctx, backend, input, out)
+	case "claude-response":
+		return handleClaudeResponse(ctx, backend, input, out)
+	case "tool-use":
+		return handleToolUse(ctx, backend, input, out)
+	case "tool-result":
+		return handleToolResult(ctx, backend, input, out)
+	case "session-end":
+		return handleSessionEnd(ctx, backend, input, out)
+	case "conversation-compress":
+		return handleConversationCompress(ctx, backend, input, out)
+	case "context-request":
+		return handleContextRequest(ctx, backend, input, out)
+	default:
+		return inputError(fmt.Errorf("unknown event type: %s", input.Event))
+	}
+}
+
+// captureInput describes a single capture invocation. Values come from the
+// hook payload on stdin and only fall back to flags and environment
+// variables when the payload doesn't provide them.
+type captureInput struct {
+	Event          string
+	Data           string
+	Payload        []byte
+	SessionID      string
+	CWD            string
+	TranscriptPath string
+	PID            int       // process that received the hook, used in legacy record IDs
+	CapturedAt     time.Time // when the hook fired; record IDs derive from it so replays are idempotent
+
+	skipPrompt importer.PromptFilter // set by applyCaptureRules when prompt rules exist
+}
+
+// applyCaptureRules evaluates the capture filter rules for the input's
+// working directory. It reports false when nothing may be captured there,
+// and otherwise sets up the prompt filter used by the handlers.
+func (in *captureInput) applyCaptureRules() (bool, error) {
+	rules, err := filter.LoadRules()
+	if err != nil {
+		return false, err
+	}
+
+	location := filter.ResolveLocation(in.CWD)
+	if !rules.Evaluate(location).Capture {
+		return false, nil
+	}
+
+	in.skipPrompt = nil
+	if rules.HasPromptRules() {
+		in.skipPrompt = func(prompt string) bool {
+			return !rules.EvaluatePrompt(location, prompt).Capture
+		}
+	}
+	return true, nil
+}
+
+// captureInputError marks errors caused by the hook input itself. Retrying
+// them can't succeed, so they are never spooled.
+type captureInputError struct {
+	err error
+}
+
+func (e *captureInputError) Error() string { return e.err.Error() }
+func (e *captureInputError) Unwrap() error { return e.err }
+
+// Is lets the daemon tell input errors apart from failures worth spooling
+func (e *captureInputError) Is(target error) bool { return target == daemon.ErrInvalidInput }
+
+// inputError marks err as caused by the hook input
+func inputError(err error) error {
+	return &captureInputError{err: err}
+}
+
+// isInputError reports whether err was caused by the hook input
+func isInputError(err error) bool {
+	var inputErr *captureInputError
+	return errors.As(err, &inputErr)
+}
+
+// decodeEventPayload decodes the event-specific part of the hook payload
+func decodeEventPayload(input *captureInput, target interface{}) error {
+	if err := hooks.DecodePayload(input.Payload, target); err != nil {
+		return inputError(err)
+	}
+	return nil
+}
+
+// newCaptureInput resolves the session, working directory and transcript
+// for a capture invocation
+func newCaptureInput(event, data string, payload []byte) (*captureInput, error) {
+	var common hooks.HookInput
+	if err := hooks.DecodePayload(payload, &common); err != nil {
+		return nil, err
+	}
+
+	input := &captureInput{
+		Event:          event,
+		Data:           data,
+		Payload:        payload,
+		SessionID:      common.SessionID,
+		CWD:            common.CWD,
+		TranscriptPath: common.TranscriptPath,
+		PID:            os.Getpid(),
+		CapturedAt:     time.Now(),
+	}
+
+	if input.SessionID == "" {
+		input.SessionID = os.Getenv("CLAUDE_SESSION_ID")
+	}
+	if input.CWD == "" {
+		input.CWD, _ = os.Getwd()
+	}
+
+	return input, nil
+}
+
+// daemonRequest converts the input into a daemon capture request
+func (in *captureInput) daemonRequest() *daemon.Request {
+	return &daemon.Request{
+		Op:             daemon.OpCapture,
+		Event:          in.Event,
+		Data:           in.Data,
+		Payload:        in.Payload,
+		SessionID:      in.SessionID,
+		CWD:            in.CWD,
+		TranscriptPath: in.TranscriptPath,
+		PID:            in.PID,
+		CapturedAt:     in.CapturedAt,
+	}
+}
+
+// captureInputFromRequest rebuilds a capture input forwarded to the daemon
+// or read back from the spool
+func captureInputFromRequest(req *daemon.Request) *captureInput {
+	input := &captureInput{
+		Event:          req.Event,
+		Data:           req.Data,
+		Payload:        req.Payload,
+		SessionID:      req.SessionID,
+		CWD:            req.CWD,
+		TranscriptPath: req.TranscriptPath,
+		PID:            req.PID,
+		CapturedAt:     req.CapturedAt,
+	}
+	if input.CapturedAt.IsZero() {
+		input.CapturedAt = time.Now()
+	}
+	return input
+}
+
+// requireSessionID returns the resolved session ID or an error when neither
+// the hook payload nor the environment provided one
+func (in *captureInput) requireSessionID() (string, error) {
+	if in.SessionID == "" {
+		return "", inputError(fmt.Errorf("no session_id in hook payload and CLAUDE_SESSION_ID environment variable not set"))
+	}
+	return in.SessionID, nil
+}
+
+// sessionMetadata builds the JSON metadata stored on the session record
+func (in *captureInput) sessionMetadata(extra map[string]string) string {
+	metadata := map[string]string{
+		"working_directory": in.CWD,
+		"transcript_path":   in.TranscriptPath,
+	}
+	if in.CWD != "" {
+		metadata["project"] = filepath.Base(in.CWD)
+	}
+	if in.Data != "" {
+		metadata["data"] = in.Data
+	}
+	for key, value := range extra {
+		if value != "" {
+			metadata[key] = value
+		}
+	}
+
+	data, _ := json.Marshal(metadata)
+	return string(data)
+}
+
+// ensureSession makes sure a session record exists, so events captured after
+// context-extender was installed mid-session still have a parent session
+func ensureSession(ctx context.Context, tx database.BackendTx, input *captureInput) error {
+	_, err := tx.GetSession(ctx, input.SessionID)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, database.ErrSessionNotFound) {
+		return fmt.Errorf("failed to get session: %w", err)
 	}
 
-	// Create session record
+	now := input.CapturedAt
 	session := &database.Session{
-		ID:        sessionID,
+		ID:        input.SessionID,
+		CreatedAt: now,
+		UpdatedAt: now,
 		Status:    "active",
-		Metadata:  fmt.Sprintf(`{"working_directory":"%s","data":%q}`, os.Getenv("PWD"), data),
+		Metadata:  input.sessionMetadata(nil),
 	}
 
-	if err := backend.CreateSession(ctx, session); err != nil {
+	if err := tx.CreateSession(ctx, session); err != nil {
 		return fmt.Errorf("failed to create session: %w", err)
 	}
-
-	fmt.Printf("Session %s started\n", sessionID)
 	return nil
 }
 
-// handleUserPrompt processes user prompt events
-func handleUserPrompt(ctx context.Context, manager *database.Manager, data string) error {
-	sessionID := os.Getenv("CLAUDE_SESSION_ID")
+// handleSessionStart processes session start events
+func handleSessionStart(ctx context.Context, backend database.DatabaseBackend, input *captureInput, out io.Writer) error {
+	var payload hooks.SessionStartInput
+	if err := decodeEventPayload(input, &payload); err != nil {
+		return err
+	}
+
+	sessionID := input.SessionID
 	if sessionID == "" {
-		return fmt.Errorf("CLAUDE_SESSION_ID environment variable not set")
+		// No hook payload or environment: derive an ID from the working directory
+		sessionID = fmt.Sprintf("%s_%d", filepath.Base(input.CWD), input.PID)
+		input.SessionID = sessionID
 	}
 
-	backend, err := manager.GetBackend()
+	now := input.CapturedAt
+	metadata := input.sessionMetadata(map[string]string{"source": payload.Source})
+
+	// Resumed and compacted sessions keep their session_id, so reactivate
+	// the existing record instead of creating a duplicate
+	err := backend.WithTx(ctx, func(tx database.BackendTx) error {
+		existing, err := tx.GetSession(ctx, sessionID)
+		switch {
+		case err == nil:
+			existing.Status = "active"
+			existing.UpdatedAt = now
+			existing.Metadata = metadata
+			if err := tx.UpdateSession(ctx, existing); err != nil {
+				return fmt.Errorf("failed to update session: %w", err)
+			}
+		case errors.Is(err, database.ErrSessionNotFound):
+			session := &database.Session{
+				ID:        sessionID,
+				CreatedAt: now,
+				UpdatedAt: now,
+				Status:    "active",
+				Metadata:  metadata,
+			}
+			if err := tx.CreateSession(ctx, session); err != nil {
+				return fmt.Errorf("failed to create session: %w", err)
+			}
+		default:
+			return fmt.Errorf("failed to get session: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to get backend: %w", err)
+		return err
+	}
+
+	// After compaction, hand the preserved context back to Claude. Hook
+	// stdout must then be the JSON document only.
+	if payload.Source == "compact" {
+		emitted, err := emitCompactionContext(ctx, backend, sessionID, out)
+		if err != nil {
+			return err
+		}
+		if emitted {
+			return nil
+		}
+	}
+
+	fmt.Fprintf(out, "Session %s started\n", sessionID)
+	return nil
+}
+
+// handleUserPrompt processes user prompt events
+func handleUserPrompt(ctx context.Context, backend database.DatabaseBackend, input *captureInput, out io.Writer) error {
+	sessionID, err := input.requireSessionID()
+	if err != nil {
+		return err
+	}
+
+	var payload hooks.UserPromptSubmitInput
+	if err := decodeEventPayload(input, &payload); err != nil {
+		return err
+	}
+
+	content := payload.Prompt
+	if content == "" {
+		content = input.Data
 	}
 
-	// Create conversation record for user prompt
-	conversation := &database.Conversation{
-		ID:          fmt.Sprintf("%s_user_%d", sessionID, os.Getpid()),
-		SessionID:   sessionID,
-		MessageType: "user",
-		Content:     data,
-		Metadata:    "{}",
+	if input.skipPrompt != nil && input.skipPrompt(content) {
+		return nil
 	}
 
-	if err := backend.CreateConversation(ctx, conversation); err != nil {
-		return fmt.Errorf("failed to create conversation: %w", err)
+	err = backend.WithTx(ctx, func(tx database.BackendTx) error {
+		if err := ensureSession(ctx, tx, input); err != nil {
+			return err
+		}
+
+		// With a transcript the prompt is ingested from it on Stop, so only
+		// record that it was submitted
+		if input.TranscriptPath != "" {
+			event := &database.Event{
+				ID:        fmt.Sprintf("%s_prompt_%d", sessionID, input.CapturedAt.UnixNano()),
+				SessionID: sessionID,
+				EventType: "user_prompt",
+				Timestamp: input.CapturedAt,
+				Data:      content,
+			}
+			if err := tx.CreateEvent(ctx, event); err != nil && !database.IsUniqueViolation(err) {
+				return fmt.Errorf("failed to create event: %w", err)
+			}
+			return nil
+		}
+
+		// Create conversation record for user prompt
+		conversation := &database.Conversation{
+			ID:          fmt.Sprintf("%s_user_%d", sessionID, input.PID),
+			SessionID:   sessionID,
+			MessageType: "user",
+			Content:     content,
+			Timestamp:   input.CapturedAt,
+			Metadata:    "{}",
+		}
+
+		if err := tx.CreateConversation(ctx, conversation); err != nil && !database.IsUniqueViolation(err) {
+			return fmt.Errorf("failed to create conversation: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	fmt.Printf("User prompt captured for session %s\n", sessionID)
+	fmt.Fprintf(out, "User prompt captured for session %s\n", sessionID)
 	return nil
 }
 
 // handleClaudeResponse processes Claude response events
-func handleClaudeResponse(ctx context.Context, manager *database.Manager, data string) error {
-	sessionID := os.Getenv("CLAUDE_SESSION_ID")
-	if sessionID == "" {
-		return fmt.Errorf("CLAUDE_SESSION_ID environment variable not set")
+func handleClaudeResponse(ctx context.Context, backend database.DatabaseBackend, input *captureInput, out io.Writer) error {
+	sessionID, err := input.requireSessionID()
+	if err != nil {
+		return err
 	}
 
-	backend, err := manager.GetBackend()
+	// Ingest everything Claude wrote to the transcript since the last Stop
+	if input.TranscriptPath != "" {
+		result, err := ingestTranscript(ctx, backend, input, 1)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "Captured %d new messages for session %s\n", result.NewMessages, sessionID)
+		return nil
+	}
+
+	err = backend.WithTx(ctx, func(tx database.BackendTx) error {
+		if err := ensureSession(ctx, tx, input); err != nil {
+			return err
+		}
+
+		// Create conversation record for Claude response
+		conversation := &database.Conversation{
+			ID:          fmt.Sprintf("%s_claude_%d", sessionID, input.PID),
+			SessionID:   sessionID,
+			MessageType: "assistant",
+			Content:     input.Data,
+			Timestamp:   input.CapturedAt,
+			Metadata:    "{}",
+		}
+
+		if err := tx.CreateConversation(ctx, conversation); err != nil && !database.IsUniqueViolation(err) {
+			return fmt.Errorf("failed to create conversation: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to get backend: %w", err)
+		return err
 	}
 
-	// Create conversation record for Claude response
-	conversation := &database.Conversation{
-		ID:          fmt.Sprintf("%s_claude_%d", sessionID, os.Getpid()),
-		SessionID:   sessionID,
-		MessageType: "assistant",
-		Content:     data,
-		Metadata:    "{}",
+	fmt.Fprintf(out, "Claude response captured for session %s\n", sessionID)
+	return nil
+}
+
+// handleSessionEnd processes session end events
+func handleSessionEnd(ctx context.Context, backend database.DatabaseBackend, input *captureInput, out io.Writer) error {
+	sessionID, err := input.requireSessionID()
+	if err != nil {
+		return err
 	}
 
-	if err := backend.CreateConversation(ctx, conversation); err != nil {
-		return fmt.Errorf("failed to create conversation: %w", err)
+	var payload hooks.SessionEndInput
+	if err := decodeEventPayload(input, &payload); err != nil {
+		return err
+	}
+
+	// Capture whatever the last Stop hook didn't get to
+	if input.TranscriptPath != "" {
+		if _, err := ingestTranscript(ctx, backend, input, maxIngestRuns); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to ingest transcript: %v\n", err)
+		}
+	}
+
+	// Update session status to completed
+	err = backend.WithTx(ctx, func(tx database.BackendTx) error {
+		session, err := tx.GetSession(ctx, sessionID)
+		if err != nil {
+			return fmt.Errorf("failed to get session: %w", err)
+		}
+
+		session.Status = "completed"
+		session.UpdatedAt = input.CapturedAt
+		if payload.Reason != "" {
+			session.Metadata = mergeSessionMetadata(session.Metadata, map[string]string{"end_reason": payload.Reason})
+		}
+		if err := tx.UpdateSession(ctx, session); err != nil {
+			return fmt.Errorf("failed to update session: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	fmt.Printf("Claude response captured for session %s\n", sessionID)
+	fmt.Fprintf(out, "Session %s ended\n", sessionID)
 	return nil
 }
 
-// handleSessionEnd processes session end events
-func handleSessionEnd(ctx context.Context, manager *database.Manager, data string) error {
-	sessionID := os.Getenv("CLAUDE_SESSION_ID")
-	if sessionID == "" {
-		return fmt.Errorf("CLAUDE_SESSION_ID environment variable not set")
+// ingestTimeout keeps transcript ingestion well inside the hook timeout
+const ingestTimeout = 20 * time.Second
+
+// maxIngestRuns bounds how many chunks session-end ingests to catch up
+const maxIngestRuns = 16
+
+// ingestTranscript copies new transcript entries into the database, parsing
+// at most runs chunks of importer.DefaultIngestMaxBytes
+func ingestTranscript(ctx context.Context, backend database.DatabaseBackend, input *captureInput, runs int) (*importer.IngestResult, error) {
+	if err := ensureSession(ctx, backend, input); err != nil {
+		return nil, err
 	}
 
-	backend, err := manager.GetBackend()
+	ctx, cancel := context.WithTimeout(ctx, ingestTimeout)
+	defer cancel()
+
+	total := &importer.IngestResult{}
+	for i := 0; i < runs; i++ {
+		result, err := importer.IngestTranscriptFiltered(ctx, backend, input.SessionID, input.TranscriptPath, importer.DefaultIngestMaxBytes, input.skipPrompt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to ingest transcript: %w", err)
+		}
+
+		total.NewMessages += result.NewMessages
+		total.Duplicates += result.Duplicates
+		total.Skipped += result.Skipped
+		total.Filtered += result.Filtered
+		total.EndOffset = result.EndOffset
+		total.Complete = result.Complete
+		if i == 0 {
+			total.StartOffset = result.StartOffset
+			total.Reset = result.Reset
+		}
+
+		if result.Complete || ctx.Err() != nil {
+			break
+		}
+	}
+
+	return total, nil
+}
+
+// mergeSessionMetadata adds values to existing JSON session metadata
+func mergeSessionMetadata(existing string, values map[string]string) string {
+	metadata := make(map[string]interface{})
+	if existing != "" {
+		json.Unmarshal([]byte(existing), &metadata)
+	}
+	for key, value := range values {
+		metadata[key] = value
+	}
+
+	data, err := json.Marshal(metadata)
 	if err != nil {
-		return fmt.Errorf("failed to get backend: %w", err)
+		return existing
 	}
+	return string(data)
+}
 
-	// Update session status to completed
-	session, err := backend.GetSession(ctx, sessionID)
+// maxInlineToolOutput is the largest tool output stored in the database.
+// Larger outputs are truncated and the full output is spilled to a file,
+// unless the database is encrypted: the files would be plaintext, so the
+// full output is stored, encrypted, instead.
+const maxInlineToolOutput = 16 * 1024
+
+// handleToolUse records the start of a tool invocation
+func handleToolUse(ctx context.Context, backend database.DatabaseBackend, input *captureInput, out io.Writer) error {
+	sessionID, err := input.requireSessionID()
 	if err != nil {
-		return fmt.Errorf("failed to get session: %w", err)
+		return err
+	}
+
+	var payload hooks.PreToolUseInput
+	if err := decodeEventPayload(input, &payload); err != nil {
+		return err
+	}
+	if payload.ToolName == "" {
+		return inputError(fmt.Errorf("tool-use event requires a PreToolUse hook payload"))
 	}
 
-	session.Status = "completed"
-	if err := backend.UpdateSession(ctx, session); err != nil {
-		return fmt.Errorf("failed to update session: %w", err)
+	now := input.CapturedAt
+	invocation := &database.ToolInvocation{
+		ID:        fmt.Sprintf("%s_tool_%d", sessionID, now.UnixNano()),
+		SessionID: sessionID,
+		ToolUseID: payload.InvocationKey(),
+		ToolName:  payload.ToolName,
+		Input:     string(payload.ToolInput),
+		Status:    database.ToolStatusRunning,
+		StartedAt: now,
 	}
 
-	fmt.Printf("Session %s ended\n", sessionID)
+	err = backend.WithTx(ctx, func(tx database.BackendTx) error {
+		if err := ensureSession(ctx, tx, input); err != nil {
+			return err
+		}
+		if err := tx.CreateToolInvocation(ctx, invocation); err != nil && !database.IsUniqueViolation(err) {
+			return fmt.Errorf("failed to create tool invocation: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// PreToolUse stdout is only shown in transcript mode, so stay quiet
 	return nil
 }
 
-// handleConversationCompress captures when a conversation is compressed
-func handleConversationCompress(ctx context.Context, manager *database.Manager, data string) error {
-	sessionID := os.Getenv("CLAUDE_SESSION_ID")
-	if sessionID == "" {
-		return fmt.Errorf("CLAUDE_SESSION_ID environment variable not set")
+// handleToolResult records the result of a tool invocation started by handleToolUse
+func handleToolResult(ctx context.Context, backend database.DatabaseBackend, input *captureInput, out io.Writer) error {
+	sessionID, err := input.requireSessionID()
+	if err != nil {
+		return err
 	}
 
-	backend, err := manager.GetBackend()
+	var payload hooks.PostToolUseInput
+	if err := decodeEventPayload(input, &payload); err != nil {
+		return err
+	}
+	if payload.ToolName == "" {
+		return inputError(fmt.Errorf("tool-result event requires a PostToolUse hook payload"))
+	}
+
+	encrypted, err := fieldEncrypted(ctx, backend)
 	if err != nil {
-		return fmt.Errorf("failed to get backend: %w", err)
+		return err
 	}
 
-	// Store compression event with preserved context
-	event := &database.Event{
-		ID:          fmt.Sprintf("%s_compress_%d", sessionID, os.Getpid()),
-		SessionID:   sessionID,
-		EventType:   "compression",
-		SequenceNum: 0,
-		Data:        data, // Contains critical context to preserve
+	now := input.CapturedAt
+	key := payload.InvocationKey()
+
+	return backend.WithTx(ctx, func(tx database.BackendTx) error {
+		if err := ensureSession(ctx, tx, input); err != nil {
+			return err
+		}
+
+		invocation, err := tx.GetToolInvocation(ctx, sessionID, key)
+		isNew := false
+		switch {
+		case err == nil:
+			if invocation.Status != database.ToolStatusRunning {
+				// A replayed result whose invocation was already completed
+				return nil
+			}
+		case errors.Is(err, database.ErrToolInvocationNotFound):
+			// The PreToolUse hook didn't run (e.g. hooks installed mid-call),
+			// so record the invocation without a duration
+			isNew = true
+			invocation = &database.ToolInvocation{
+				ID:        fmt.Sprintf("%s_tool_%d", sessionID, now.UnixNano()),
+				SessionID: sessionID,
+				ToolUseID: key,
+				ToolName:  payload.ToolName,
+				Input:     string(payload.ToolInput),
+				StartedAt: now,
+			}
+		default:
+			return fmt.Errorf("failed to get tool invocation: %w", err)
+		}
+
+		output := payload.ResponseText()
+		if len(output) > maxInlineToolOutput && !encrypted {
+			path, err := spillToolOutput(sessionID, invocation.ID, output)
+			if err != nil {
+				return err
+			}
+			output = database.TruncateToolOutput(output, maxInlineToolOutput)
+			invocation.OutputTruncated = true
+			invocation.OutputPath = path
+		}
+
+		invocation.Output = output
+		invocation.Success = payload.Succeeded()
+		invocation.Status = database.ToolStatusCompleted
+		if !invocation.Success {
+			invocation.Status = database.ToolStatusFailed
+		}
+		invocation.EndedAt = &now
+		if !isNew {
+			invocation.DurationMs = now.Sub(invocation.StartedAt).Milliseconds()
+		}
+
+		if isNew {
+			err = tx.CreateToolInvocation(ctx, invocation)
+		} else {
+			err = tx.UpdateToolInvocation(ctx, invocation)
+		}
+		if err != nil && !database.IsUniqueViolation(err) {
+			return fmt.Errorf("failed to save tool invocation: %w", err)
+		}
+		return nil
+	})
+}
+
+// fieldEncrypted reports whether the backend encrypts what it stores
+func fieldEncrypted(ctx context.Context, backend database.DatabaseBackend) (bool, error) {
+	encrypter, ok := backend.(fieldEncrypter)
+	if !ok {
+		return false, nil
 	}
+	state, err := encrypter.FieldEncryption(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get encryption state: %w", err)
+	}
+	return state != nil, nil
+}
 
-	if err := backend.CreateEvent(ctx, event); err != nil {
-		return fmt.Errorf("failed to create compression event: %w", err)
+// spillToolOutput writes a full tool output to the storage directory and
+// returns the path of the file
+func spillToolOutput(sessionID, invocationID, output string) (string, error) {
+	storageManager, err := storage.NewStorageManager(nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create storage manager: %w", err)
 	}
 
-	// Extract and store critical context points separately for quick retrieval
-	// This includes: key decisions, user preferences, technical constraints, current objectives
-	fmt.Printf("Compression event captured for session %s\n", sessionID)
-	return nil
+	dir := filepath.Join(storageManager.GetBaseDir(), database.ToolOutputDir, sessionID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create tool output directory: %w", err)
+	}
+
+	path := filepath.Join(dir, invocationID+".txt")
+	if err := os.WriteFile(path, []byte(output), 0600); err != nil {
+		return "", fmt.Errorf("failed to write tool output: %w", err)
+	}
+
+	return path, nil
 }
 
-// handleContextRequest retrieves preserved context after compression
-func handleContextRequest(ctx context.Context, manager *database.Manager, data string) error {
-	sessionID := os.Getenv("CLAUDE_SESSION_ID")
-	if sessionID == "" {
-		return fmt.Errorf("CLAUDE_SESSION_ID environment variable not set")
+// handleConversationCompress preserves critical context before Claude compacts
+// the conversation (PreCompact)
+func handleConversationCompress(ctx context.Context, backend database.DatabaseBackend, input *captureInput, out io.Writer) error {
+	sessionID, err := input.requireSessionID()
+	if err != nil {
+		return err
 	}
 
-	backend, err := manager.GetBackend()
+	var payload hooks.PreCompactInput
+	if err := decodeEventPayload(input, &payload); err != nil {
+		return err
+	}
+
+	// Bring the conversation up to date before analyzing it
+	if input.TranscriptPath != "" {
+		if _, err := ingestTranscript(ctx, backend, input, maxIngestRuns); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to ingest transcript: %v\n", err)
+		}
+	}
+
+	conversations, err := backend.GetConversationsBySession(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to get conversations: %w", err)
+	}
+
+	var contents []string
+	for _, conv := range conversations {
+		contents = append(contents, conv.Content)
+	}
+	for _, extra := range []string{payload.CustomInstructions, input.Data} {
+		if extra != "" {
+			contents = append(contents, extra)
+		}
+	}
+
+	summary, err := preserver.ExtractCriticalContext(contents)
+	if err != nil {
+		return fmt.Errorf("failed to extract context: %w", err)
+	}
+	if summary.WorkingDirectory == "" {
+		summary.WorkingDirectory = input.CWD
+	}
+
+	contextJSON, err := preserver.SaveCompressionContext(summary)
 	if err != nil {
-		return fmt.Errorf("failed to get backend: %w", err)
+		return fmt.Errorf("failed to save context: %w", err)
 	}
 
-	// Retrieve latest compression events
+	// Store compression event with preserved context
+	event := &database.Event{
+		ID:        fmt.Sprintf("%s_compress_%d", sessionID, input.CapturedAt.UnixNano()),
+		SessionID: sessionID,
+		EventType: "compression",
+		Timestamp: input.CapturedAt,
+		Data:      contextJSON,
+	}
+
+	err = backend.WithTx(ctx, func(tx database.BackendTx) error {
+		if err := ensureSession(ctx, tx, input); err != nil {
+			return err
+		}
+		if err := tx.CreateEvent(ctx, event); err != nil && !database.IsUniqueViolation(err) {
+			return fmt.Errorf("failed to create compression event: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	trigger := payload.Trigger
+	if trigger == "" {
+		trigger = "manual"
+	}
+	fmt.Fprintf(out, "Compression event captured for session %s (%s)\n", sessionID, trigger)
+	return nil
+}
+
+// latestCompressionSummary returns the context preserved by the most recent
+// compression of a session, or nil when the session was never compressed
+func latestCompressionSummary(ctx context.Context, backend database.DatabaseBackend, sessionID string) (*preserver.CompressionSummary, error) {
 	events, err := backend.GetEventsBySession(ctx, sessionID)
 	if err != nil {
-		return fmt.Errorf("failed to get compression events: %w", err)
+		return nil, fmt.Errorf("failed to get compression events: %w", err)
 	}
 
-	// Find latest compression event
 	var latestCompression *database.Event
 	for _, event := range events {
 		if event.EventType == "compression" {
@@ -242,15 +1042,50 @@ func handleContextRequest(ctx context.Context, manager *database.Manager, data s
 		}
 	}
 
-	if latestCompression != nil {
+	if latestCompression == nil {
+		return nil, nil
+	}
+
+	return preserver.LoadCompressionContext(latestCompression.Data)
+}
+
+// emitCompactionContext writes the preserved context as SessionStart hook
+// output so Claude Code adds it to the compacted conversation
+func emitCompactionContext(ctx context.Context, backend database.DatabaseBackend, sessionID string, out io.Writer) (bool, error) {
+	summary, err := latestCompressionSummary(ctx, backend, sessionID)
+	if err != nil || summary == nil {
+		return false, err
+	}
+
+	prompt := preserver.GenerateContextPrompt(summary)
+	output := hooks.NewAdditionalContextOutput("SessionStart", prompt)
+	if err := hooks.WriteHookOutput(out, output); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// handleContextRequest retrieves preserved context after compression
+func handleContextRequest(ctx context.Context, backend database.DatabaseBackend, input *captureInput, out io.Writer) error {
+	sessionID, err := input.requireSessionID()
+	if err != nil {
+		return err
+	}
+
+	summary, err := latestCompressionSummary(ctx, backend, sessionID)
+	if err != nil {
+		return err
+	}
+
+	if summary != nil {
 		// Output the preserved context for reinjection
-		fmt.Printf("Context preserved from compression:\n%s\n", latestCompression.Data)
+		fmt.Fprintf(out, "Context preserved from compression:\n%s\n", preserver.GenerateContextPrompt(summary))
 	}
 
 	// Also retrieve recent conversations
 	conversations, err := backend.GetConversationsBySession(ctx, sessionID)
 	if err == nil && len(conversations) > 0 {
-		fmt.Println("\nRecent critical conversations:")
+		fmt.Fprintln(out, "\nRecent critical conversations:")
 		// Show last 5 conversations
 		start := 0
 		if len(conversations) > 5 {
@@ -262,7 +1097,7 @@ func handleContextRequest(ctx context.Context, manager *database.Manager, data s
 			if len(content) > 100 {
 				content = content[:100] + "..."
 			}
-			fmt.Printf("- [%s] %s\n", conv.MessageType, content)
+			fmt.Fprintf(out, "- [%s] %s\n", conv.MessageType, content)
 		}
 	}
 
@@ -271,8 +1106,9 @@ func handleContextRequest(ctx context.Context, manager *database.Manager, data s
 
 func init() {
 	rootCmd.AddCommand(captureRootCmd)
+	captureRootCmd.AddCommand(captureFlushCmd)
 
 	// Add flags
-	captureRootCmd.Flags().StringP("event", "e", "", "Event type (session-start, user-prompt, claude-response, session-end)")
-	captureRootCmd.Flags().StringP("data", "d", "", "Event data (JSON or plain text)")
+	captureRootCmd.Flags().StringP("event", "e", "", "Event type (session-start, user-prompt, claude-response, tool-use, tool-result, session-end)")
+	captureRootCmd.Flags().StringP("data", "d", "", "Event data (JSON or plain text), used when no hook payload is on stdin")
 }
\ No newline at end of file
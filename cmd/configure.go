@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 
@@ -22,7 +23,7 @@ to enable automatic conversation capture.
 
 This command will:
 - Validate Claude Code installation
-- Create a backup of existing settings
+- Show the changes to your settings and back up the previous version
 - Install context-extender hooks for conversation capture
 - Verify the installation was successful
 
@@ -31,25 +32,58 @@ The hooks will capture:
 - User prompts submitted to Claude
 - Claude responses and tool usage
 
+Hooks can be installed for a scope:
+  user     Your Claude Code settings, capturing every project (default)
+  project  <repo>/.claude/settings.json, shared with everyone using the repo
+  local    <repo>/.claude/settings.local.json, only you in this repo
+
+Every change to a settings file is backed up first. The last 20 backups of
+each settings file are kept and can be restored with --restore.
+
 Example:
-  context-extender configure          # Install hooks
-  context-extender configure --status # Check installation status
-  context-extender configure --remove # Remove hooks`,
+  context-extender configure                 # Install hooks for all projects
+  context-extender configure --scope local   # Capture only in this repository
+  context-extender configure --status        # Check installation status of every scope
+  context-extender configure --remove        # Remove hooks from every scope
+  context-extender configure --backups       # List settings backups
+  context-extender configure --restore 20250101-120000`,
 
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Check flags
 		status, _ := cmd.Flags().GetBool("status")
 		remove, _ := cmd.Flags().GetBool("remove")
+		scopeName, _ := cmd.Flags().GetString("scope")
+		backups, _ := cmd.Flags().GetBool("backups")
+		restoreID, _ := cmd.Flags().GetString("restore")
+
+		scope, err := config.ParseSettingsScope(scopeName)
+		if err != nil {
+			return err
+		}
 
 		if status {
 			return showInstallationStatus()
 		}
 
+		if backups {
+			return listSettingsBackups()
+		}
+
+		config.SettingsDiffOutput = settingsDiffWriter{}
+
+		if restoreID != "" {
+			return restoreSettingsBackup(restoreID)
+		}
+
 		if remove {
-			return removeHooks()
+			// Without an explicit scope, clean up everywhere
+			if !cmd.Flags().Changed("scope") {
+				return removeHooksFromAllScopes()
+			}
+			return removeHooks(scope)
 		}
 
-		return installHooks()
+		return installHooks(scope)
 	},
 }
 
@@ -59,9 +93,12 @@ func init() {
 	// Add flags
 	configureCmd.Flags().BoolP("status", "s", false, "Show current hook installation status")
 	configureCmd.Flags().BoolP("remove", "r", false, "Remove context-extender hooks")
+	configureCmd.Flags().String("scope", string(config.ScopeUser), "Settings scope to install into or remove from (user, project, local)")
+	configureCmd.Flags().Bool("backups", false, "List backups of Claude Code settings")
+	configureCmd.Flags().String("restore", "", "Restore the settings backup with this ID")
 }
 
-func installHooks() error {
+func installHooks(scope config.SettingsScope) error {
 	fmt.Println("Configuring Claude Code hooks for context-extender...")
 
 	// Validate Claude Code installation
@@ -72,25 +109,46 @@ func installHooks() error {
 	}
 	fmt.Println("✅ OK")
 
+	projectDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+	settingsPath, err := config.GetScopedSettingsPath(scope, projectDir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Settings scope: %s (%s)\n", scope, settingsPath)
+
 	// Check if already installed
 	fmt.Print("Checking existing installation... ")
-	installed, err := hooks.IsInstalled()
+	installed, err := hooks.IsInstalledAt(settingsPath)
 	if err != nil {
 		fmt.Println("❌ ERROR")
 		return fmt.Errorf("failed to check installation status: %w", err)
 	}
 
 	if installed {
-		fmt.Println("⚠️  Already installed")
-		fmt.Println("Context-extender hooks are already installed.")
-		fmt.Println("Use --remove to uninstall or --status to see details.")
-		return nil
+		missing, err := hooks.MissingHookTypesAt(settingsPath)
+		if err != nil {
+			fmt.Println("❌ ERROR")
+			return fmt.Errorf("failed to check installation status: %w", err)
+		}
+
+		if len(missing) == 0 {
+			fmt.Println("⚠️  Already installed")
+			fmt.Println("Context-extender hooks are already installed.")
+			fmt.Println("Use --remove to uninstall or --status to see details.")
+			warnDuplicateScopes(projectDir)
+			return nil
+		}
+		fmt.Printf("⚠️  Outdated (missing %s), updating\n", strings.Join(missing, ", "))
+	} else {
+		fmt.Println("✅ Ready to install")
 	}
-	fmt.Println("✅ Ready to install")
 
 	// Install hooks
 	fmt.Print("Installing conversation capture hooks... ")
-	if err := hooks.InstallHooks(); err != nil {
+	if err := hooks.InstallHooksAt(settingsPath); err != nil {
 		fmt.Println("❌ FAILED")
 		return fmt.Errorf("failed to install hooks: %w", err)
 	}
@@ -98,7 +156,7 @@ func installHooks() error {
 
 	// Verify installation
 	fmt.Print("Verifying installation... ")
-	installed, err = hooks.IsInstalled()
+	installed, err = hooks.IsInstalledAt(settingsPath)
 	if err != nil {
 		fmt.Println("❌ ERROR")
 		return fmt.Errorf("failed to verify installation: %w", err)
@@ -124,51 +182,129 @@ func installHooks() error {
 	fmt.Println("Claude Code conversations. The following hooks are installed:")
 	fmt.Println("  • SessionStart - Captures session initialization")
 	fmt.Println("  • UserPromptSubmit - Captures your prompts to Claude")
+	fmt.Println("  • PreToolUse/PostToolUse - Captures Claude's tool calls and results")
+	fmt.Println("  • PreCompact - Preserves critical context before compaction")
 	fmt.Println("  • Stop - Captures Claude's responses")
 	fmt.Println("  • SessionEnd - Captures session completion")
-	fmt.Println("\nYour conversations will be automatically captured starting")
-	fmt.Println("with your next Claude Code session.")
+	if scope == config.ScopeUser {
+		fmt.Println("\nYour conversations will be automatically captured starting")
+		fmt.Println("with your next Claude Code session.")
+	} else {
+		fmt.Printf("\nConversations in %s will be automatically captured\n", config.FindProjectRoot(projectDir))
+		fmt.Println("starting with your next Claude Code session there.")
+	}
+
+	warnDuplicateScopes(projectDir)
 
 	return nil
 }
 
-func removeHooks() error {
+// warnDuplicateScopes warns when hooks are installed in more than one
+// scope, which makes Claude Code capture the same events more than once
+func warnDuplicateScopes(projectDir string) {
+	statuses, err := hooks.GetScopeStatuses(projectDir)
+	if err != nil {
+		return
+	}
+
+	var scopes []string
+	for _, status := range statuses {
+		if status.HasAny() {
+			scopes = append(scopes, string(status.Scope))
+		}
+	}
+	if len(hooks.DuplicateHookTypes(statuses)) == 0 {
+		return
+	}
+
+	fmt.Printf("\n⚠️  Hooks are installed in several scopes (%s), so events are captured more than once.\n", strings.Join(scopes, ", "))
+	fmt.Println("   Remove the extra ones with: context-extender configure --remove --scope <scope>")
+}
+
+func removeHooks(scope config.SettingsScope) error {
 	fmt.Println("Removing context-extender hooks from Claude Code...")
 
-	// Check if installed
-	fmt.Print("Checking current installation... ")
-	installed, err := hooks.IsInstalled()
+	projectDir, err := os.Getwd()
 	if err != nil {
-		fmt.Println("❌ ERROR")
-		return fmt.Errorf("failed to check installation status: %w", err)
+		return fmt.Errorf("failed to get working directory: %w", err)
 	}
-
-	if !installed {
-		fmt.Println("⚠️  Not installed")
-		fmt.Println("Context-extender hooks are not currently installed.")
-		return nil
+	settingsPath, err := config.GetScopedSettingsPath(scope, projectDir)
+	if err != nil {
+		return err
 	}
-	fmt.Println("✅ Found hooks")
+	fmt.Printf("Settings scope: %s (%s)\n", scope, settingsPath)
 
 	// Remove hooks
 	fmt.Print("Removing hooks... ")
-	if err := hooks.UninstallHooks(); err != nil {
+	removed, err := hooks.UninstallHooksAt(settingsPath)
+	if err != nil {
 		fmt.Println("❌ FAILED")
 		return fmt.Errorf("failed to remove hooks: %w", err)
 	}
+	if !removed {
+		fmt.Println("⚠️  Not installed")
+		fmt.Printf("Context-extender hooks are not installed in %s settings.\n", scope)
+		return nil
+	}
 	fmt.Println("✅ SUCCESS")
 
 	// Verify removal
 	fmt.Print("Verifying removal... ")
-	installed, err = hooks.IsInstalled()
+	status, err := hooks.GetInstallationStatusAt(settingsPath)
 	if err != nil {
 		fmt.Println("❌ ERROR")
 		return fmt.Errorf("failed to verify removal: %w", err)
 	}
+	for _, present := range status {
+		if present {
+			fmt.Println("❌ FAILED")
+			return fmt.Errorf("removal verification failed")
+		}
+	}
+	fmt.Println("✅ VERIFIED")
 
-	if installed {
+	fmt.Printf("\n✅ Context-extender hooks have been removed from %s settings.\n", scope)
+
+	return nil
+}
+
+func removeHooksFromAllScopes() error {
+	fmt.Println("Removing context-extender hooks from Claude Code...")
+
+	projectDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+
+	// Remove hooks
+	fmt.Print("Removing hooks from every scope... ")
+	cleaned, err := hooks.UninstallAllScopes(projectDir)
+	if err != nil {
 		fmt.Println("❌ FAILED")
-		return fmt.Errorf("removal verification failed")
+		return fmt.Errorf("failed to remove hooks: %w", err)
+	}
+	if len(cleaned) == 0 {
+		fmt.Println("⚠️  Not installed")
+		fmt.Println("Context-extender hooks are not currently installed.")
+		return nil
+	}
+	fmt.Println("✅ SUCCESS")
+	for _, path := range cleaned {
+		fmt.Printf("  • %s\n", path)
+	}
+
+	// Verify removal
+	fmt.Print("Verifying removal... ")
+	statuses, err := hooks.GetScopeStatuses(projectDir)
+	if err != nil {
+		fmt.Println("❌ ERROR")
+		return fmt.Errorf("failed to verify removal: %w", err)
+	}
+	for _, status := range statuses {
+		if status.HasAny() {
+			fmt.Println("❌ FAILED")
+			return fmt.Errorf("removal verification failed for %s settings", status.Scope)
+		}
 	}
 	fmt.Println("✅ VERIFIED")
 
@@ -190,16 +326,20 @@ func showInstallationStatus() error {
 	}
 	fmt.Println("✅ Found and accessible")
 
-	// Get detailed status
-	status, err := hooks.GetInstallationStatus()
+	projectDir, err := os.Getwd()
 	if err != nil {
-		return fmt.Errorf("failed to get installation status: %w", err)
+		return fmt.Errorf("failed to get working directory: %w", err)
 	}
 
-	// Check overall installation
-	installed, err := hooks.IsInstalled()
+	// Get detailed status of every scope
+	statuses, err := hooks.GetScopeStatuses(projectDir)
 	if err != nil {
-		return fmt.Errorf("failed to check installation status: %w", err)
+		return fmt.Errorf("failed to get installation status: %w", err)
+	}
+
+	installed := false
+	for _, status := range statuses {
+		installed = installed || status.Installed
 	}
 
 	fmt.Printf("Overall status: ")
@@ -209,27 +349,121 @@ func showInstallationStatus() error {
 		fmt.Println("❌ Not installed")
 	}
 
+	fmt.Printf("\nScopes (project: %s):\n", config.FindProjectRoot(projectDir))
+	for _, status := range statuses {
+		fmt.Printf("  %-8s ", status.Scope)
+		switch {
+		case status.Installed:
+			fmt.Print("✅ Installed     ")
+		case status.HasAny():
+			fmt.Print("⚠️  Incomplete    ")
+		default:
+			fmt.Print("➖ Not installed ")
+		}
+		fmt.Printf(" %s\n", status.Path)
+	}
+
+	duplicates := hooks.DuplicateHookTypes(statuses)
+
 	fmt.Println("\nHook details:")
-	hookTypes := []string{"SessionStart", "UserPromptSubmit", "Stop", "SessionEnd"}
-	for _, hookType := range hookTypes {
+	for _, hookType := range hooks.HookTypes {
+		var scopes []string
+		for _, status := range statuses {
+			if status.Hooks[hookType] {
+				scopes = append(scopes, string(status.Scope))
+			}
+		}
+
 		fmt.Printf("  %-18s ", hookType+":")
-		if status[hookType] {
-			fmt.Println("✅ Installed")
-		} else {
+		switch {
+		case len(scopes) == 0:
 			fmt.Println("❌ Missing")
+		case duplicates[hookType] != nil:
+			fmt.Printf("⚠️  Installed twice (%s)\n", strings.Join(scopes, ", "))
+		default:
+			fmt.Printf("✅ Installed (%s)\n", scopes[0])
 		}
 	}
 
+	if len(duplicates) > 0 {
+		fmt.Println("\n⚠️  Hooks installed in more than one scope capture every event more than once.")
+		fmt.Println("   Remove the extra ones with: context-extender configure --remove --scope <scope>")
+	}
+
 	if !installed {
-		fmt.Println("\nTo install hooks, run: context-extender configure")
+		fmt.Println("\nTo install hooks, run: context-extender configure [--scope user|project|local]")
+	}
+
+	return nil
+}
+
+// settingsDiffWriter prints settings diffs on their own lines, after the
+// progress message of the step that makes the change
+type settingsDiffWriter struct{}
+
+func (settingsDiffWriter) Write(p []byte) (int, error) {
+	fmt.Println()
+	return os.Stdout.Write(p)
+}
+
+func listSettingsBackups() error {
+	backups, err := config.ListBackups("")
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	if len(backups) == 0 {
+		fmt.Println("No settings backups yet. A backup is made before every settings change.")
+		return nil
 	}
 
-	// Show Claude settings path
-	settingsPath, err := config.GetClaudeSettingsPath()
-	if err == nil {
-		fmt.Printf("\nClaude settings location: %s\n", settingsPath)
+	fmt.Printf("Settings backups (newest first, %d kept per settings file):\n\n", config.MaxSettingsBackups)
+	fmt.Printf("%-20s %-20s %8s  %s\n", "ID", "CREATED", "SIZE", "SETTINGS FILE")
+	for _, backup := range backups {
+		fmt.Printf("%-20s %-20s %8d  %s\n",
+			backup.ID,
+			backup.CreatedAt.Format("2006-01-02 15:04:05"),
+			backup.Size,
+			backup.SettingsPath)
+		if backup.Reason != "" {
+			fmt.Printf("%-20s %s\n", "", backup.Reason)
+		}
 	}
 
+	fmt.Println("\nRestore one with: context-extender configure --restore <id>")
+	return nil
+}
+
+func restoreSettingsBackup(id string) error {
+	backup, data, err := config.GetBackup(id)
+	if err != nil {
+		return err
+	}
+
+	current, err := os.ReadFile(backup.SettingsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read settings file: %w", err)
+	}
+
+	fmt.Printf("Restoring %s from backup %s (%s)\n", backup.SettingsPath, backup.ID, backup.CreatedAt.Format("2006-01-02 15:04:05"))
+
+	diff := config.UnifiedDiff(backup.SettingsPath, "backup "+backup.ID, current, data)
+	if diff == "" {
+		fmt.Println("✅ Settings already match this backup, nothing to restore.")
+		return nil
+	}
+	fmt.Println()
+	fmt.Print(diff)
+	fmt.Println()
+
+	fmt.Print("Restoring settings... ")
+	if _, err := config.RestoreBackup(id); err != nil {
+		fmt.Println("❌ FAILED")
+		return fmt.Errorf("failed to restore backup: %w", err)
+	}
+	fmt.Println("✅ SUCCESS")
+	fmt.Println("The previous settings were backed up too, see: context-extender configure --backups")
+
 	return nil
 }
 
@@ -251,19 +485,14 @@ func initializeDatabase() error {
 	}
 	defer manager.Close()
 
-	// Get backend and create schema
+	// Get backend
 	backend, err := manager.GetBackend()
 	if err != nil {
 		return fmt.Errorf("failed to get backend: %w", err)
 	}
 
-	// Create schema
-	if err := backend.CreateSchema(ctx); err != nil {
-		return fmt.Errorf("failed to create schema: %w", err)
-	}
-
-	// Run migrations
-	if err := backend.MigrateSchema(ctx, 1); err != nil {
+	// Create the schema, or migrate an existing database
+	if err := backend.MigrateSchema(ctx, database.SchemaVersion); err != nil {
 		return fmt.Errorf("failed to run migrations: %w", err)
 	}
 
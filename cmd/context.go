@@ -217,10 +217,11 @@ var contextReinjectCmd = &cobra.Command{
 			return fmt.Errorf("failed to get events: %w", err)
 		}
 
-		// Find latest preservation event
+		// Find latest preservation event, either from `context preserve`
+		// or from the PreCompact hook
 		var latestPreservation *database.Event
 		for _, event := range events {
-			if event.EventType == "context_preservation" {
+			if event.EventType == "context_preservation" || event.EventType == "compression" {
 				latestPreservation = event
 			}
 		}
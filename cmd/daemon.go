@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"context-extender/internal/daemon"
+	"context-extender/internal/database"
+	"context-extender/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+// daemonStartTimeout is how long daemon start waits for the daemon to answer
+const daemonStartTimeout = 5 * time.Second
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Manage the background capture daemon",
+	Long: `Manage an optional background process that keeps the database open for
+capture hooks.
+
+While the daemon is running, capture forwards hook events to it over a Unix
+domain socket in the storage directory instead of opening the database on
+every hook. A hook is answered once its writes are stored, or spooled when
+the database can't take them. When the daemon isn't running capture writes
+to the database directly. The daemon exits on its own after a period without events.
+
+With --no-persist the daemon keeps captured sessions in memory only and
+nothing reaches the database; they are gone once the daemon stops.`,
+}
+
+var daemonStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start the capture daemon in the background",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		idleTimeout, _ := cmd.Flags().GetDuration("idle-timeout")
+		noPersist, _ := cmd.Flags().GetBool("no-persist")
+		return startDaemon(idleTimeout, noPersist)
+	},
+}
+
+var daemonStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the capture daemon",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		socketPath, err := daemonSocketPath()
+		if err != nil {
+			return err
+		}
+
+		status, err := daemon.Stop(socketPath)
+		if errors.Is(err, daemon.ErrUnavailable) {
+			fmt.Println("ℹ️  Daemon is not running")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("✅ Daemon stopped (PID %d)\n", status.PID)
+		return nil
+	},
+}
+
+var daemonStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show capture daemon status",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		socketPath, err := daemonSocketPath()
+		if err != nil {
+			return err
+		}
+
+		status, err := daemon.Ping(socketPath)
+		if errors.Is(err, daemon.ErrUnavailable) {
+			fmt.Println("❌ Daemon is not running")
+			fmt.Println("   Capture writes to the database directly")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("✅ Daemon is running")
+		fmt.Printf("   PID: %d\n", status.PID)
+		fmt.Printf("   Uptime: %s\n", time.Since(status.StartedAt).Round(time.Second))
+		fmt.Printf("   Events Handled: %d\n", status.Requests)
+		if status.Ephemeral {
+			fmt.Println("   Storage: memory only, nothing is written to the database")
+		}
+		if status.IdleTimeout > 0 {
+			fmt.Printf("   Idle Timeout: %s\n", status.IdleTimeout)
+		}
+		fmt.Printf("   Socket: %s\n", status.Socket)
+		return nil
+	},
+}
+
+var daemonRunCmd = &cobra.Command{
+	Use:    "run",
+	Short:  "Run the capture daemon in the foreground",
+	Hidden: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		idleTimeout, _ := cmd.Flags().GetDuration("idle-timeout")
+		noPersist, _ := cmd.Flags().GetBool("no-persist")
+		return runDaemon(idleTimeout, noPersist)
+	},
+}
+
+// daemonSocketPath returns the socket path in the default storage directory
+func daemonSocketPath() (string, error) {
+	storageManager, err := storage.NewStorageManager(nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create storage manager: %w", err)
+	}
+	return daemon.SocketPath(storageManager.GetBaseDir()), nil
+}
+
+// startDaemon launches "daemon run" as a detached process and waits until it
+// answers on its socket
+func startDaemon(idleTimeout time.Duration, noPersist bool) error {
+	storageManager, err := storage.NewStorageManager(nil)
+	if err != nil {
+		return fmt.Errorf("failed to create storage manager: %w", err)
+	}
+	if err := storageManager.EnsureStorageStructure(); err != nil {
+		return fmt.Errorf("failed to create storage directories: %w", err)
+	}
+
+	socketPath := daemon.SocketPath(storageManager.GetBaseDir())
+	if status, err := daemon.Ping(socketPath); err == nil {
+		fmt.Printf("ℹ️  Daemon already running (PID %d)\n", status.PID)
+		return nil
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get executable path: %w", err)
+	}
+
+	logPath := filepath.Join(storageManager.GetLogsDir(), "daemon.log")
+	logFile, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open daemon log: %w", err)
+	}
+	defer logFile.Close()
+
+	args := []string{"daemon", "run", "--idle-timeout", idleTimeout.String()}
+	if noPersist {
+		args = append(args, "--no-persist")
+	}
+	process := exec.Command(executable, args...)
+	process.Stdout = logFile
+	process.Stderr = logFile
+	process.SysProcAttr = daemon.DetachedProcAttr()
+	if err := process.Start(); err != nil {
+		return fmt.Errorf("failed to start daemon: %w", err)
+	}
+	process.Process.Release()
+
+	deadline := time.Now().Add(daemonStartTimeout)
+	for time.Now().Before(deadline) {
+		if status, err := daemon.Ping(socketPath); err == nil {
+			fmt.Printf("✅ Daemon started (PID %d)\n", status.PID)
+			if noPersist {
+				fmt.Println("   Storage: memory only, captured sessions are lost when it stops")
+			}
+			fmt.Printf("   Socket: %s\n", socketPath)
+			fmt.Printf("   Log: %s\n", logPath)
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return fmt.Errorf("daemon did not start within %s, see %s", daemonStartTimeout, logPath)
+}
+
+// runDaemon serves capture requests until stopped, signalled or idle. With
+// noPersist events go to an in-memory backend instead of the database.
+func runDaemon(idleTimeout time.Duration, noPersist bool) error {
+	storageManager, err := storage.NewStorageManager(nil)
+	if err != nil {
+		return fmt.Errorf("failed to create storage manager: %w", err)
+	}
+	if err := storageManager.EnsureStorageStructure(); err != nil {
+		return fmt.Errorf("failed to create storage directories: %w", err)
+	}
+
+	ctx := context.Background()
+	config := database.DefaultDatabaseConfig()
+	if noPersist {
+		config.Backend = database.BackendMemory
+	}
+	backend, closeBackend, err := openCaptureBackendWith(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer closeBackend()
+
+	// Requests take turns on the database, so concurrent hooks never race
+	// each other for the SQLite write lock
+	var mu sync.Mutex
+
+	handler := func(ctx context.Context, req *daemon.Request) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var out bytes.Buffer
+		input := captureInputFromRequest(req)
+		if noPersist {
+			// Neither spool failures for later nor replay the spool into
+			// memory. A failure in memory won't go away on a retry either,
+			// and the client would replay a spooled event into the database.
+			err := dispatchCapture(ctx, backend, input, &out)
+			if err != nil && !isInputError(err) {
+				err = inputError(err)
+			}
+			return out.String(), err
+		}
+		err := captureAndDrain(ctx, backend, input, &out)
+		return out.String(), err
+	}
+
+	baseDir := storageManager.GetBaseDir()
+	server := daemon.NewServer(&daemon.ServerConfig{
+		SocketPath:  daemon.SocketPath(baseDir),
+		PIDPath:     daemon.PIDPath(baseDir),
+		IdleTimeout: idleTimeout,
+		Handler:     handler,
+		Ephemeral:   noPersist,
+	})
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	go func() {
+		select {
+		case <-server.Done():
+		case sig := <-signals:
+			log.Printf("Received %s, shutting down", sig)
+			server.Shutdown()
+		}
+	}()
+
+	log.Printf("Daemon listening on %s (PID %d)", daemon.SocketPath(baseDir), os.Getpid())
+	serveErr := server.Serve()
+	log.Printf("Daemon stopped")
+	return serveErr
+}
+
+func init() {
+	daemonCmd.AddCommand(daemonStartCmd)
+	daemonCmd.AddCommand(daemonStopCmd)
+	daemonCmd.AddCommand(daemonStatusCmd)
+	daemonCmd.AddCommand(daemonRunCmd)
+
+	for _, cmd := range []*cobra.Command{daemonStartCmd, daemonRunCmd} {
+		cmd.Flags().Duration("idle-timeout", daemon.DefaultIdleTimeout, "Exit after this long without events (0 disables)")
+		cmd.Flags().Bool("no-persist", false, "Keep captured sessions in memory only, never write them to the database")
+	}
+
+	rootCmd.AddCommand(daemonCmd)
+}
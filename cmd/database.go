@@ -1,9 +1,11 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"context-extender/internal/database"
 	"github.com/spf13/cobra"
@@ -42,19 +44,14 @@ This creates the database file and runs all necessary migrations.`,
 		}
 		defer manager.Close()
 
-		// Get backend and create schema
+		// Get backend
 		backend, err := manager.GetBackend()
 		if err != nil {
 			return fmt.Errorf("failed to get backend: %w", err)
 		}
 
-		// Create schema
-		if err := backend.CreateSchema(ctx); err != nil {
-			return fmt.Errorf("failed to create schema: %w", err)
-		}
-
-		// Run migrations
-		if err := backend.MigrateSchema(ctx, 1); err != nil {
+		// Create the schema, or migrate an existing database
+		if err := backend.MigrateSchema(ctx, database.SchemaVersion); err != nil {
 			return fmt.Errorf("failed to run migrations: %w", err)
 		}
 
@@ -69,8 +66,22 @@ var migrateDbCmd = &cobra.Command{
 	Short: "Run database migrations",
 	Long: `Run all pending database migrations to update the schema to the latest version.
 
+Databases created by older versions are detected and their tables rewritten
+into the current layout first, keeping all rows. Use --dry-run to see what
+would change without touching the database.
+
+--to migrates to a specific version, reverting newer migrations when it is
+lower than the current one. Reverting drops the tables those migrations
+created, so the database is backed up before every migration.
+
+Migrations are checksummed when applied; a migration that changed since
+refuses to run until the difference is resolved.
+
 This is safe to run multiple times and will only apply new migrations.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		target, _ := cmd.Flags().GetInt("to")
+
 		config := database.DefaultDatabaseConfig()
 		manager := database.NewManager(config)
 
@@ -85,11 +96,148 @@ This is safe to run multiple times and will only apply new migrations.`,
 			return fmt.Errorf("failed to get backend: %w", err)
 		}
 
-		if err := backend.MigrateSchema(ctx, 1); err != nil {
+		plan, err := backend.PlanMigration(ctx, target)
+		if err != nil {
+			return fmt.Errorf("failed to plan migration: %w", err)
+		}
+
+		fmt.Printf("Database: %s\n", config.DatabasePath)
+		printMigrationPlan(plan)
+
+		if len(plan.Modified) > 0 {
+			fmt.Println("\n❌ Applied migrations changed since, see 'context-extender database migrate status'")
+			return fmt.Errorf("%w: version %s", database.ErrMigrationModified, strings.Trim(fmt.Sprint(plan.Modified), "[]"))
+		}
+		if !plan.Required() {
+			fmt.Printf("\n✅ Database schema is at version %d\n", plan.TargetVersion)
+			return nil
+		}
+		if dryRun {
+			fmt.Println("\n🔍 Dry run, nothing was changed")
+			return nil
+		}
+
+		if err := backend.MigrateSchema(ctx, target); err != nil {
 			return fmt.Errorf("failed to run migrations: %w", err)
 		}
 
-		fmt.Println("Database migrations completed successfully")
+		fmt.Printf("\n✅ Database migrated to schema version %d\n", target)
+		if plan.Layout != database.LayoutEmpty {
+			fmt.Printf("💾 The previous database was backed up to %s\n", database.BackupDir(config.DatabasePath))
+		}
+		return nil
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show applied and pending migrations",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config := database.DefaultDatabaseConfig()
+		manager := database.NewManager(config)
+
+		ctx := cmd.Context()
+		if err := manager.Initialize(ctx); err != nil {
+			return fmt.Errorf("failed to initialize database: %w", err)
+		}
+		defer manager.Close()
+
+		backend, err := manager.GetBackend()
+		if err != nil {
+			return fmt.Errorf("failed to get backend: %w", err)
+		}
+
+		states, err := backend.MigrationStatus(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get migration status: %w", err)
+		}
+		version, err := backend.GetSchemaVersion(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get schema version: %w", err)
+		}
+
+		fmt.Printf("Database: %s\n", config.DatabasePath)
+		fmt.Printf("Schema version: %d (latest %d)\n\n", version, database.SchemaVersion)
+		fmt.Printf("%-8s %-36s %-10s %-20s %s\n", "VERSION", "NAME", "STATUS", "APPLIED", "CHECKSUM")
+		for _, state := range states {
+			icon := map[string]string{
+				database.MigrationApplied:  "✅",
+				database.MigrationPending:  "⏳",
+				database.MigrationModified: "⚠️ ",
+				database.MigrationUnknown:  "❓",
+			}[state.Status]
+			checksum := state.Checksum
+			if len(checksum) > 12 {
+				checksum = checksum[:12]
+			}
+			fmt.Printf("%-8d %-36s %s %-8s %-20s %s\n", state.Version, state.Name, icon, state.Status, state.AppliedAt, checksum)
+		}
+		return nil
+	},
+}
+
+// printMigrationPlan shows the rewrites and migrations of a plan
+func printMigrationPlan(plan *database.MigrationPlan) {
+	fmt.Printf("Layout: %s\n", plan.Layout)
+	fmt.Printf("Schema version: %d → %d\n", plan.CurrentVersion, plan.TargetVersion)
+
+	if len(plan.Rewrites) > 0 {
+		fmt.Println("\nTables rewritten into the current layout:")
+		for _, rewrite := range plan.Rewrites {
+			fmt.Printf("  %s (%d rows): %s\n", rewrite.Table, rewrite.Rows, strings.Join(rewrite.Changes, ", "))
+		}
+	}
+	if len(plan.Reverts) > 0 {
+		fmt.Println("\n⚠️  Migrations to revert (their tables and data are dropped):")
+		for _, migration := range plan.Reverts {
+			fmt.Printf("  %d %s\n", migration.Version, migration.Name)
+		}
+	}
+	if len(plan.Pending) > 0 {
+		fmt.Println("\nMigrations to apply:")
+		for _, migration := range plan.Pending {
+			fmt.Printf("  %d %s\n", migration.Version, migration.Name)
+		}
+	}
+}
+
+var repairSequencesCmd = &cobra.Command{
+	Use:   "repair-sequences",
+	Short: "Renumber session events by timestamp",
+	Long: `Renumber the events of every session, or of one session with --session,
+by timestamp starting at 1, and reset the counters new events are numbered
+from.
+
+Events captured by older versions were numbered per hook process, so most
+of them got the same number. Run this once to put their order right.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sessionID, _ := cmd.Flags().GetString("session")
+
+		ctx := cmd.Context()
+		backend, closeBackend, err := openCaptureBackend(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeBackend()
+
+		if sessionID != "" {
+			if _, err := backend.GetSession(ctx, sessionID); err != nil {
+				return fmt.Errorf("failed to get session: %w", err)
+			}
+		}
+
+		renumbered, err := backend.RenumberEvents(ctx, sessionID)
+		if err != nil {
+			return err
+		}
+
+		if renumbered == 0 {
+			fmt.Println("✅ Event sequences are already in order")
+			return nil
+		}
+		fmt.Printf("✅ Renumbered %d events\n", renumbered)
 		return nil
 	},
 }
@@ -220,6 +368,12 @@ Session ID is required. An optional summary can be provided.`,
 	},
 }
 
+// writeRetryReporter is implemented by backends that retry writes finding
+// the database locked
+type writeRetryReporter interface {
+	WriteRetryStats(ctx context.Context) (*database.WriteRetryStats, error)
+}
+
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Show database status",
@@ -266,6 +420,36 @@ var statusCmd = &cobra.Command{
 		fmt.Printf("  Backend: %s\n", backendInfo.Name)
 		fmt.Printf("  Version: %s\n", backendInfo.Version)
 		fmt.Printf("  CGO Required: %v\n", backendInfo.RequiresCGO)
+		if version, err := backend.GetSchemaVersion(ctx); err == nil {
+			fmt.Printf("  Schema Version: %d (latest %d)\n", version, database.SchemaVersion)
+		}
+		if encrypter, ok := backend.(fieldEncrypter); ok {
+			state, err := encrypter.FieldEncryption(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to get encryption state: %w", err)
+			}
+			if state != nil {
+				fmt.Printf("  Encryption: 🔐 %s, key version %d, since %s\n", state.Algorithm, max(state.KeyVersion, 1), state.EnabledAt.Format("2006-01-02 15:04:05"))
+			} else {
+				fmt.Printf("  Encryption: none\n")
+			}
+		}
+		if backuper, ok := backend.(databaseBackuper); ok {
+			printBackupStatus(ctx, backuper, config.DatabasePath)
+		}
+		if reporter, ok := backend.(writeRetryReporter); ok {
+			fmt.Printf("  Busy Timeout: %s\n", config.BusyTimeout)
+			retries, err := reporter.WriteRetryStats(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to get write retry stats: %w", err)
+			}
+			if retries.LastRetryAt != nil {
+				fmt.Printf("  Write Retries: %d (%d recovered, %d failed), last %s\n",
+					retries.Retries, retries.Recovered, retries.Failed, retries.LastRetryAt.Local().Format("2006-01-02 15:04:05"))
+			} else {
+				fmt.Printf("  Write Retries: none\n")
+			}
+		}
 
 		// Test connection
 		if err := backend.Ping(ctx); err != nil {
@@ -284,6 +468,7 @@ var statusCmd = &cobra.Command{
 		fmt.Printf("  Sessions: %d\n", stats.SessionCount)
 		fmt.Printf("  Events: %d\n", stats.EventCount)
 		fmt.Printf("  Conversations: %d\n", stats.ConversationCount)
+		fmt.Printf("  Tool Invocations: %d\n", stats.ToolCount)
 
 		if stats.OldestRecord != nil {
 			fmt.Printf("  Oldest Record: %s\n", stats.OldestRecord.Format("2006-01-02 15:04:05"))
@@ -297,6 +482,12 @@ var statusCmd = &cobra.Command{
 }
 
 func init() {
+	migrateDbCmd.Flags().Bool("dry-run", false, "Show what the migration would change without changing it")
+	migrateDbCmd.Flags().Int("to", database.SchemaVersion, "Schema version to migrate to, lower versions revert migrations")
+	migrateDbCmd.AddCommand(migrateStatusCmd)
+
+	repairSequencesCmd.Flags().String("session", "", "Only renumber the events of this session")
+
 	// Add session-end summary flag
 	sessionEndCmd.Flags().StringP("summary", "s", "", "Optional session summary")
 
@@ -310,6 +501,7 @@ func init() {
 	databaseCmd.AddCommand(migrateDbCmd)
 	databaseCmd.AddCommand(captureCmd)
 	databaseCmd.AddCommand(statusCmd)
+	databaseCmd.AddCommand(repairSequencesCmd)
 
 	rootCmd.AddCommand(databaseCmd)
 }
\ No newline at end of file
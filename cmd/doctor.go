@@ -0,0 +1,579 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"context-extender/internal/config"
+	"context-extender/internal/daemon"
+	"context-extender/internal/database"
+	"context-extender/internal/hooks"
+	"context-extender/internal/spool"
+	"context-extender/internal/storage"
+)
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check that conversation capture works end to end",
+	Long: `Doctor runs a series of health checks on conversation capture:
+
+- Hook commands in every settings scope point to an existing, executable
+  context-extender binary (catches hooks left behind after the binary moved)
+- The database opens and its schema is current
+- Storage directories exist and are writable
+- No events are waiting in the offline spool
+- A simulated session (start → prompt → stop → end) is captured by the
+  installed hook binary, in a scratch session that is removed afterwards
+
+Each problem comes with a suggested fix. With --fix, doctor repairs what it
+can and checks again.
+
+Example:
+  context-extender doctor
+  context-extender doctor --fix`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fix, _ := cmd.Flags().GetBool("fix")
+		if fix {
+			config.SettingsDiffOutput = settingsDiffWriter{}
+		}
+		return runDoctor(fix)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+
+	doctorCmd.Flags().Bool("fix", false, "Repair problems that can be fixed automatically")
+}
+
+// doctorStatus is the outcome of a single check
+type doctorStatus int
+
+const (
+	doctorOK doctorStatus = iota
+	doctorWarn
+	doctorFail
+)
+
+// doctorResult describes the outcome of a check and how to fix it
+type doctorResult struct {
+	Status  doctorStatus
+	Details []string
+	Fix     string       // what the user can do about it
+	Repair  func() error // what --fix does about it, nil when it can't help
+}
+
+// doctorCheck is a single named health check
+type doctorCheck struct {
+	Name string
+	Run  func(ctx context.Context) *doctorResult
+}
+
+// doctorRoundtripTimeout bounds each simulated hook invocation
+const doctorRoundtripTimeout = 30 * time.Second
+
+func runDoctor(fix bool) error {
+	fmt.Println("🩺 Context-Extender Doctor")
+	fmt.Println("==========================")
+	fmt.Println()
+
+	projectDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+
+	checks := []doctorCheck{
+		{Name: "Hook commands", Run: func(ctx context.Context) *doctorResult { return checkHookCommands(projectDir) }},
+		{Name: "Storage", Run: func(ctx context.Context) *doctorResult { return checkStorage() }},
+		{Name: "Database", Run: checkDatabase},
+		{Name: "Offline spool", Run: checkSpool},
+		{Name: "Capture roundtrip", Run: func(ctx context.Context) *doctorResult { return checkRoundtrip(ctx, projectDir) }},
+	}
+
+	ctx := context.Background()
+	failures, warnings := 0, 0
+	for _, check := range checks {
+		result := check.Run(ctx)
+
+		if fix && result.Status != doctorOK && result.Repair != nil {
+			printDoctorResult(check.Name, result)
+			fmt.Print("   🔧 Repairing... ")
+			if err := result.Repair(); err != nil {
+				fmt.Printf("❌ FAILED (%v)\n", err)
+			} else {
+				fmt.Println("✅ DONE")
+			}
+			result = check.Run(ctx)
+		}
+
+		printDoctorResult(check.Name, result)
+		switch result.Status {
+		case doctorFail:
+			failures++
+		case doctorWarn:
+			warnings++
+		}
+	}
+
+	fmt.Println()
+	switch {
+	case failures > 0:
+		fmt.Printf("❌ %d problem(s) found", failures)
+		if warnings > 0 {
+			fmt.Printf(", %d warning(s)", warnings)
+		}
+		fmt.Println()
+		if !fix {
+			fmt.Println("💡 Run 'context-extender doctor --fix' to repair what can be fixed automatically.")
+		}
+		return fmt.Errorf("doctor found %d problem(s)", failures)
+	case warnings > 0:
+		fmt.Printf("⚠️  Capture works, with %d warning(s)\n", warnings)
+	default:
+		fmt.Println("✅ Everything looks good, conversations are being captured.")
+	}
+
+	return nil
+}
+
+func printDoctorResult(name string, result *doctorResult) {
+	icon := map[doctorStatus]string{doctorOK: "✅", doctorWarn: "⚠️ ", doctorFail: "❌"}[result.Status]
+	fmt.Printf("%s %s\n", icon, name)
+	for _, detail := range result.Details {
+		fmt.Printf("   %s\n", detail)
+	}
+	if result.Status != doctorOK && result.Fix != "" {
+		fmt.Printf("   💡 Fix: %s\n", result.Fix)
+	}
+}
+
+// checkHookCommands verifies that installed hooks run an existing binary
+func checkHookCommands(projectDir string) *doctorResult {
+	result := &doctorResult{}
+
+	currentExec, err := os.Executable()
+	if err != nil {
+		return &doctorResult{Status: doctorFail, Details: []string{fmt.Sprintf("Cannot determine executable: %v", err)}}
+	}
+	currentExec = resolvePath(currentExec)
+
+	var staleScopes []string
+	installedScopes := 0
+	for _, scope := range config.SettingsScopes {
+		settingsPath, err := config.GetScopedSettingsPath(scope, projectDir)
+		if err != nil {
+			continue
+		}
+
+		commands, err := hooks.GetInstalledCommands(settingsPath)
+		if err != nil {
+			result.Status = doctorFail
+			result.Details = append(result.Details, fmt.Sprintf("%s: %v", scope, err))
+			continue
+		}
+		if len(commands) == 0 {
+			continue
+		}
+		installedScopes++
+
+		problems := make(map[string]bool)
+		scopeStatus := doctorOK
+		for _, command := range commands {
+			status, problem := checkHookExecutable(command.Executable, currentExec)
+			if status == doctorOK {
+				continue
+			}
+			problems[problem] = true
+			if status > scopeStatus {
+				scopeStatus = status
+			}
+		}
+
+		if scopeStatus == doctorOK {
+			result.Details = append(result.Details, fmt.Sprintf("%s: %d hook commands OK (%s)", scope, len(commands), settingsPath))
+			continue
+		}
+
+		for problem := range problems {
+			result.Details = append(result.Details, fmt.Sprintf("%s: %s", scope, problem))
+		}
+		if scopeStatus > result.Status {
+			result.Status = scopeStatus
+		}
+		staleScopes = append(staleScopes, string(scope))
+	}
+
+	if installedScopes == 0 {
+		return &doctorResult{
+			Status:  doctorFail,
+			Details: []string{"No context-extender hooks are installed in any scope"},
+			Fix:     "context-extender configure [--scope user|project|local]",
+			Repair: func() error {
+				if err := config.ValidateClaudeInstallation(); err != nil {
+					return err
+				}
+				return hooks.InstallHooks()
+			},
+		}
+	}
+
+	if len(staleScopes) > 0 {
+		var commands []string
+		for _, name := range staleScopes {
+			commands = append(commands, "context-extender configure --scope "+name)
+		}
+		result.Fix = "reinstall the hooks with this binary: " + strings.Join(commands, " && ")
+		result.Repair = func() error {
+			for _, name := range staleScopes {
+				settingsPath, err := config.GetScopedSettingsPath(config.SettingsScope(name), projectDir)
+				if err != nil {
+					return err
+				}
+				if err := hooks.InstallHooksAt(settingsPath); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	return result
+}
+
+// checkHookExecutable describes what is wrong with a hook's binary. A
+// binary that works but isn't this one is only a warning.
+func checkHookExecutable(executable, currentExec string) (doctorStatus, string) {
+	if executable == "" {
+		return doctorFail, "hook command has no executable"
+	}
+
+	info, err := os.Stat(executable)
+	if os.IsNotExist(err) {
+		return doctorFail, fmt.Sprintf("hooks run %s, which no longer exists (binary moved or deleted?)", executable)
+	}
+	if err != nil {
+		return doctorFail, fmt.Sprintf("cannot access %s: %v", executable, err)
+	}
+	if info.IsDir() {
+		return doctorFail, fmt.Sprintf("hooks run %s, which is a directory", executable)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0111 == 0 {
+		return doctorFail, fmt.Sprintf("hooks run %s, which is not executable", executable)
+	}
+	if resolvePath(executable) != currentExec {
+		return doctorWarn, fmt.Sprintf("hooks run %s, not this binary (%s)", executable, currentExec)
+	}
+	return doctorOK, ""
+}
+
+// resolvePath returns an absolute path with symlinks resolved
+func resolvePath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return path
+}
+
+// checkStorage verifies the storage directories
+func checkStorage() *doctorResult {
+	storageManager, err := storage.NewStorageManager(nil)
+	if err != nil {
+		return &doctorResult{Status: doctorFail, Details: []string{err.Error()}}
+	}
+
+	if err := storageManager.ValidateStorageAccess(); err != nil {
+		return &doctorResult{
+			Status:  doctorFail,
+			Details: []string{err.Error()},
+			Fix:     fmt.Sprintf("make sure %s is owned and writable by you, then run: context-extender storage init", storageManager.GetBaseDir()),
+			Repair:  storageManager.EnsureStorageStructure,
+		}
+	}
+
+	return &doctorResult{Details: []string{fmt.Sprintf("%s is writable", storageManager.GetBaseDir())}}
+}
+
+// schemaChecker is implemented by backends that can report missing tables
+type schemaChecker interface {
+	MissingTables(ctx context.Context) ([]string, error)
+}
+
+// checkDatabase verifies that the database opens and its schema is current
+func checkDatabase(ctx context.Context) *doctorResult {
+	config := database.DefaultDatabaseConfig()
+	manager := database.NewManager(config)
+
+	if err := manager.Initialize(ctx); err != nil {
+		return &doctorResult{
+			Status:  doctorFail,
+			Details: []string{fmt.Sprintf("Cannot open %s: %v", config.DatabasePath, err)},
+			Fix:     "check that the database file isn't locked by another process or corrupted: context-extender database status",
+		}
+	}
+	defer manager.Close()
+
+	backend, err := manager.GetBackend()
+	if err != nil {
+		return &doctorResult{Status: doctorFail, Details: []string{err.Error()}}
+	}
+
+	result := &doctorResult{Details: []string{fmt.Sprintf("%s opens (%s)", config.DatabasePath, backend.GetBackendInfo().Name)}}
+
+	if version, err := backend.GetSchemaVersion(ctx); err == nil {
+		result.Details = append(result.Details, fmt.Sprintf("Schema version %d", version))
+	}
+
+	if checker, ok := backend.(schemaChecker); ok {
+		missing, err := checker.MissingTables(ctx)
+		if err != nil {
+			result.Status = doctorFail
+			result.Details = append(result.Details, err.Error())
+			return result
+		}
+		if len(missing) > 0 {
+			result.Status = doctorFail
+			result.Details = append(result.Details, fmt.Sprintf("Schema is out of date, missing tables: %s", strings.Join(missing, ", ")))
+			result.Fix = "context-extender database init"
+			result.Repair = func() error {
+				return backend.CreateSchema(context.Background())
+			}
+			return result
+		}
+	}
+
+	plan, err := backend.PlanMigration(ctx, database.SchemaVersion)
+	if err != nil {
+		result.Status = doctorFail
+		result.Details = append(result.Details, err.Error())
+		return result
+	}
+	if plan.Required() {
+		result.Status = doctorFail
+		result.Details = append(result.Details, fmt.Sprintf("Schema needs migrating (%s layout, version %d of %d)", plan.Layout, plan.CurrentVersion, plan.TargetVersion))
+		result.Fix = "context-extender database migrate"
+		result.Repair = func() error {
+			return backend.MigrateSchema(context.Background(), database.SchemaVersion)
+		}
+	}
+
+	return result
+}
+
+// checkSpool reports events waiting in the offline spool
+func checkSpool(ctx context.Context) *doctorResult {
+	captureSpool, err := captureSpool()
+	if err != nil {
+		return &doctorResult{Status: doctorFail, Details: []string{err.Error()}}
+	}
+
+	count, err := captureSpool.Count()
+	if err != nil {
+		return &doctorResult{Status: doctorFail, Details: []string{err.Error()}}
+	}
+	deadLetters, err := captureSpool.DeadLetterCount()
+	if err != nil {
+		return &doctorResult{Status: doctorFail, Details: []string{err.Error()}}
+	}
+	if count == 0 && deadLetters == 0 {
+		return &doctorResult{Details: []string{"No events waiting for replay"}}
+	}
+	if count == 0 {
+		return &doctorResult{
+			Status:  doctorWarn,
+			Details: []string{fmt.Sprintf("%d spooled events failed %d replays and were set aside", deadLetters, spool.MaxAttempts)},
+			Fix:     fmt.Sprintf("inspect or remove the entries in %s", captureSpool.DeadLetterDir()),
+		}
+	}
+
+	details := []string{fmt.Sprintf("%d events were spooled while the database was unavailable", count)}
+	if deadLetters > 0 {
+		details = append(details, fmt.Sprintf("%d spooled events failed %d replays and were set aside in %s", deadLetters, spool.MaxAttempts, captureSpool.DeadLetterDir()))
+	}
+	return &doctorResult{
+		Status:  doctorWarn,
+		Details: details,
+		Fix:     "context-extender capture flush",
+		Repair: func() error {
+			backend, closeBackend, err := openCaptureBackend(ctx)
+			if err != nil {
+				return err
+			}
+			defer closeBackend()
+
+			_, err = drainSpool(ctx, backend, 0)
+			return err
+		},
+	}
+}
+
+// checkRoundtrip runs the installed hook binary through a complete session
+// and checks that it landed in the database
+func checkRoundtrip(ctx context.Context, projectDir string) *doctorResult {
+	executable := hookExecutable(projectDir)
+
+	scratchDir, err := os.MkdirTemp("", "context-extender-doctor")
+	if err != nil {
+		return &doctorResult{Status: doctorFail, Details: []string{err.Error()}}
+	}
+	defer os.RemoveAll(scratchDir)
+
+	sessionID := fmt.Sprintf("doctor-%d", time.Now().UnixNano())
+	transcriptPath := filepath.Join(scratchDir, "transcript.jsonl")
+	prompt := "context-extender doctor roundtrip"
+
+	base := map[string]interface{}{
+		"session_id":      sessionID,
+		"transcript_path": transcriptPath,
+		"cwd":             scratchDir,
+	}
+	payload := func(event string, extra map[string]interface{}) []byte {
+		values := map[string]interface{}{"hook_event_name": event}
+		for key, value := range base {
+			values[key] = value
+		}
+		for key, value := range extra {
+			values[key] = value
+		}
+		data, _ := json.Marshal(values)
+		return data
+	}
+
+	transcript := fmt.Sprintf(`{"type":"user","uuid":"%[1]s-u","sessionId":"%[1]s","timestamp":"%[2]s","message":{"role":"user","content":%[3]q}}
+{"type":"assistant","uuid":"%[1]s-a","parentUuid":"%[1]s-u","sessionId":"%[1]s","timestamp":"%[2]s","message":{"role":"assistant","content":[{"type":"text","text":"Roundtrip OK"}]}}
+`, sessionID, time.Now().UTC().Format(time.RFC3339), prompt)
+
+	steps := []struct {
+		event   string
+		payload []byte
+		before  func() error
+	}{
+		{event: "session-start", payload: payload("SessionStart", map[string]interface{}{"source": "startup"})},
+		{event: "user-prompt", payload: payload("UserPromptSubmit", map[string]interface{}{"prompt": prompt})},
+		{event: "claude-response", payload: payload("Stop", map[string]interface{}{"stop_hook_active": false}), before: func() error {
+			return os.WriteFile(transcriptPath, []byte(transcript), 0600)
+		}},
+		{event: "session-end", payload: payload("SessionEnd", map[string]interface{}{"reason": "other"})},
+	}
+
+	result := &doctorResult{}
+	defer cleanupDoctorSession(sessionID)
+
+	for _, step := range steps {
+		if step.before != nil {
+			if err := step.before(); err != nil {
+				return &doctorResult{Status: doctorFail, Details: []string{err.Error()}}
+			}
+		}
+
+		output, err := runHookCommand(ctx, executable, step.event, step.payload)
+		if err != nil {
+			return &doctorResult{
+				Status:  doctorFail,
+				Details: []string{fmt.Sprintf("%s capture --event=%s failed: %v", executable, step.event, err), strings.TrimSpace(output)},
+				Fix:     "run the failing command by hand to see the full error, or check the database and storage checks above",
+			}
+		}
+	}
+
+	if socketPath, err := daemonSocketPath(); err == nil {
+		if _, err := daemon.Ping(socketPath); err == nil {
+			result.Details = append(result.Details, "Events were handled by the capture daemon")
+		}
+	}
+
+	backend, closeBackend, err := openCaptureBackend(ctx)
+	if err != nil {
+		return &doctorResult{Status: doctorFail, Details: []string{err.Error()}}
+	}
+	defer closeBackend()
+
+	session, err := backend.GetSession(ctx, sessionID)
+	if err != nil {
+		return &doctorResult{
+			Status:  doctorFail,
+			Details: []string{fmt.Sprintf("The scratch session was not stored: %v", err)},
+			Fix:     "check for spooled events (context-extender capture flush) and capture filter rules",
+		}
+	}
+	if session.Status != "completed" {
+		result.Status = doctorFail
+		result.Details = append(result.Details, fmt.Sprintf("Session status is %q after session-end, expected \"completed\"", session.Status))
+	}
+
+	conversations, err := backend.GetConversationsBySession(ctx, sessionID)
+	if err != nil {
+		return &doctorResult{Status: doctorFail, Details: []string{err.Error()}}
+	}
+	if len(conversations) < 2 {
+		result.Status = doctorFail
+		result.Details = append(result.Details, fmt.Sprintf("Expected 2 transcript messages to be captured, found %d", len(conversations)))
+		result.Fix = "check that Claude Code passes transcript_path to the Stop hook"
+		return result
+	}
+
+	result.Details = append(result.Details, fmt.Sprintf("start → prompt → stop → end captured %d messages using %s", len(conversations), executable))
+	return result
+}
+
+// hookExecutable returns the binary the installed hooks run, falling back
+// to this binary when no usable hook is installed
+func hookExecutable(projectDir string) string {
+	for _, scope := range config.SettingsScopes {
+		settingsPath, err := config.GetScopedSettingsPath(scope, projectDir)
+		if err != nil {
+			continue
+		}
+		commands, err := hooks.GetInstalledCommands(settingsPath)
+		if err != nil {
+			continue
+		}
+		for _, command := range commands {
+			if info, err := os.Stat(command.Executable); err == nil && !info.IsDir() {
+				return command.Executable
+			}
+		}
+	}
+
+	executable, _ := os.Executable()
+	return executable
+}
+
+// runHookCommand runs a capture command the way Claude Code does, with the
+// hook payload on stdin
+func runHookCommand(ctx context.Context, executable, event string, payload []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, doctorRoundtripTimeout)
+	defer cancel()
+
+	command := exec.CommandContext(ctx, executable, "capture", "--event="+event)
+	command.Stdin = bytes.NewReader(payload)
+	output, err := command.CombinedOutput()
+	return string(output), err
+}
+
+// cleanupDoctorSession removes the scratch session created by the roundtrip check
+func cleanupDoctorSession(sessionID string) {
+	ctx := context.Background()
+	backend, closeBackend, err := openCaptureBackend(ctx)
+	if err != nil {
+		return
+	}
+	defer closeBackend()
+
+	if err := backend.PurgeSession(ctx, sessionID); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to remove scratch session %s: %v\n", sessionID, err)
+	}
+}
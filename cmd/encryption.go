@@ -0,0 +1,256 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"context-extender/internal/database"
+	"github.com/spf13/cobra"
+)
+
+// fieldEncrypter is implemented by backends that can encrypt their
+// columns in place
+type fieldEncrypter interface {
+	FieldEncryption(ctx context.Context) (*database.FieldEncryptionState, error)
+	EncryptFields(ctx context.Context) (*database.FieldEncryptionResult, error)
+	DecryptFields(ctx context.Context) (*database.FieldEncryptionResult, error)
+	RekeyFields(ctx context.Context, batchSize int, progress func(database.RekeyProgress)) (*database.RekeyResult, error)
+	EncryptionKeyPath() string
+}
+
+var encryptDbCmd = &cobra.Command{
+	Use:   "encrypt",
+	Short: "Encrypt conversation content in the database",
+	Long: `Encrypt the database in place with XChaCha20-Poly1305.
+
+Conversation content, event data, session metadata and tool input and
+output are encrypted; ids, timestamps, tool names and counts stay readable
+so listing and filtering still work. Large tool outputs are kept whole in
+the database instead of being spilled to plaintext files.
+Projects are matched through a keyed hash, and search decrypts as it goes,
+so the full-text index is emptied.
+
+The key is created on first use and kept outside the database, protected by
+a passphrase: CONTEXT_EXTENDER_PASSPHRASE, or one you are asked for. Hooks
+and the daemon unlock it from CONTEXT_EXTENDER_PASSPHRASE or a key file
+named by CONTEXT_EXTENDER_KEY_FILE. Without the key and its passphrase the
+data can't be recovered, so back them up. Running encrypt again finishes
+any rows written in plaintext since.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		backend, closeBackend, err := openFieldEncrypter(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeBackend()
+
+		km := database.NewKeyManager(backend.EncryptionKeyPath())
+		if !km.KeyExists() {
+			if err := createFieldKey(km); err != nil {
+				return err
+			}
+		}
+
+		fmt.Println("🔐 Encrypting database...")
+		result, err := backend.EncryptFields(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt database: %w", err)
+		}
+
+		printFieldEncryptionResult("Encrypted", result)
+		fmt.Printf("🔑 Key: %s\n", backend.EncryptionKeyPath())
+		fmt.Printf("⚠️  Backups in %s were made before encryption and are still plaintext\n", database.BackupDir(database.DefaultDatabaseConfig().DatabasePath))
+		fmt.Println("💡 Restart the daemon so it picks up the key")
+		return nil
+	},
+}
+
+var passwdDbCmd = &cobra.Command{
+	Use:   "passwd",
+	Short: "Change the passphrase protecting the encryption key",
+	Long: `Change the passphrase protecting the encryption key, or set one for a key
+that has none.
+
+Only the key is rewrapped, the data isn't re-encrypted. The current
+passphrase comes from CONTEXT_EXTENDER_PASSPHRASE, the key file or a
+prompt; the new one from CONTEXT_EXTENDER_NEW_PASSPHRASE or a prompt.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kdf, _ := cmd.Flags().GetString("kdf")
+
+		km := database.NewKeyManager("")
+		if !km.KeyExists() {
+			return fmt.Errorf("no encryption key in %s, run 'context-extender database encrypt' first", km.KeyPath())
+		}
+		// Check the current passphrase before asking for a new one
+		if _, err := km.LoadKey(); err != nil {
+			return fmt.Errorf("failed to unlock encryption key: %w", err)
+		}
+
+		passphrase, err := readNewPassphrase(false)
+		if err != nil {
+			return err
+		}
+		if err := km.ChangePassphrase(passphrase, kdf); err != nil {
+			return fmt.Errorf("failed to change passphrase: %w", err)
+		}
+
+		fmt.Printf("✅ Passphrase changed, key protected with %s\n", kdf)
+		fmt.Println("💡 Update CONTEXT_EXTENDER_PASSPHRASE or the key file hooks use, and restart the daemon")
+		return nil
+	},
+}
+
+var rekeyDbCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Rotate the encryption key and re-encrypt the database",
+	Long: `Create a new version of the encryption key and re-encrypt every value with it.
+
+Values record the key version that sealed them, so the database stays
+readable throughout. Re-encryption runs in batches, each in its own
+transaction, and capture can carry on meanwhile; running processes switch
+to the new key for anything they write.
+
+If a rekey is interrupted, running rekey again resumes it instead of
+creating another key. Old key versions are removed from the key ring once
+no value uses them.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		batchSize, _ := cmd.Flags().GetInt("batch-size")
+
+		ctx := cmd.Context()
+		backend, closeBackend, err := openFieldEncrypter(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeBackend()
+
+		fmt.Println("🔄 Re-encrypting database...")
+		lastColumn := ""
+		result, err := backend.RekeyFields(ctx, batchSize, func(progress database.RekeyProgress) {
+			if lastColumn != "" && progress.Column != lastColumn {
+				fmt.Println()
+			}
+			lastColumn = progress.Column
+			fmt.Printf("\r   %-24s %d/%d", progress.Column, progress.Done, progress.Total)
+		})
+		if lastColumn != "" {
+			fmt.Println()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to rekey database (run rekey again to resume): %w", err)
+		}
+
+		if result.Rotated {
+			fmt.Printf("🔑 Created key version %d\n", result.KeyVersion)
+		} else {
+			fmt.Printf("🔑 Resumed moving to key version %d\n", result.KeyVersion)
+		}
+		fmt.Printf("✅ Re-encrypted %d values\n", result.Reencrypted)
+		if len(result.Retired) > 0 {
+			fmt.Printf("🗑️  Retired key versions %s\n", strings.Trim(fmt.Sprint(result.Retired), "[]"))
+		}
+		if result.Remaining > 0 {
+			fmt.Printf("⚠️  %d values written meanwhile still use an older key, run rekey again to finish\n", result.Remaining)
+		}
+		return nil
+	},
+}
+
+var decryptDbCmd = &cobra.Command{
+	Use:   "decrypt",
+	Short: "Decrypt the database",
+	Long: `Decrypt an encrypted database in place and rebuild its search index.
+
+The key is left where it is.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		backend, closeBackend, err := openFieldEncrypter(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeBackend()
+
+		fmt.Println("🔓 Decrypting database...")
+		result, err := backend.DecryptFields(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt database: %w", err)
+		}
+
+		printFieldEncryptionResult("Decrypted", result)
+		fmt.Println("💡 Restart the daemon so it stops encrypting")
+		return nil
+	},
+}
+
+// openFieldEncrypter opens the configured database, which must support
+// field encryption
+func openFieldEncrypter(ctx context.Context) (fieldEncrypter, func(), error) {
+	manager := database.NewManager(database.DefaultDatabaseConfig())
+	if err := manager.Initialize(ctx); err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	backend, err := manager.GetBackend()
+	if err != nil {
+		manager.Close()
+		return nil, nil, fmt.Errorf("failed to get backend: %w", err)
+	}
+	encrypter, ok := backend.(fieldEncrypter)
+	if !ok {
+		manager.Close()
+		return nil, nil, fmt.Errorf("the %s backend doesn't support field encryption", backend.GetBackendInfo().Name)
+	}
+	return encrypter, func() { manager.Close() }, nil
+}
+
+// createFieldKey creates the encryption key, wrapped under a passphrase
+// unless none is given
+func createFieldKey(km *database.KeyManager) error {
+	passphrase, ok := os.LookupEnv(database.PassphraseEnv)
+	if !ok {
+		var err error
+		if passphrase, err = readNewPassphrase(true); err != nil && !errors.Is(err, errNoNewPassphrase) {
+			return err
+		}
+	}
+
+	key, err := km.GenerateKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+	if passphrase == "" {
+		fmt.Println("⚠️  The key is stored without a passphrase, set one with 'context-extender database passwd'")
+		if err := km.SaveKey(key); err != nil {
+			return fmt.Errorf("failed to save encryption key: %w", err)
+		}
+		return nil
+	}
+
+	if err := km.SaveWrappedKey(key, passphrase, database.KDFArgon2id); err != nil {
+		return fmt.Errorf("failed to save encryption key: %w", err)
+	}
+	// Unlock it for the rest of the command without asking again
+	database.SetPassphrase(passphrase)
+	return nil
+}
+
+// printFieldEncryptionResult shows how many values a conversion changed
+func printFieldEncryptionResult(action string, result *database.FieldEncryptionResult) {
+	fmt.Printf("✅ %s %d sessions, %d events, %d conversations and %d tool values\n", action, result.Sessions, result.Events, result.Conversations, result.ToolInvocations)
+}
+
+func init() {
+	rekeyDbCmd.Flags().Int("batch-size", database.DefaultRekeyBatchSize, "Values to re-encrypt per transaction")
+	passwdDbCmd.Flags().String("kdf", database.KDFArgon2id, "Key derivation function, argon2id or scrypt")
+
+	databaseCmd.AddCommand(encryptDbCmd)
+	databaseCmd.AddCommand(decryptDbCmd)
+	databaseCmd.AddCommand(passwdDbCmd)
+	databaseCmd.AddCommand(rekeyDbCmd)
+}
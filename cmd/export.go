@@ -5,8 +5,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"iter"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -60,6 +62,7 @@ var (
 	exportPreview     bool
 	exportStats       bool
 	exportValidate    bool
+	exportTrashed     bool
 )
 
 func init() {
@@ -72,11 +75,12 @@ func init() {
 	// Filtering flags
 	exportCmd.Flags().StringVar(&exportFrom, "from", "", "Filter from date (YYYY-MM-DD)")
 	exportCmd.Flags().StringVar(&exportTo, "to", "", "Filter to date (YYYY-MM-DD)")
-	exportCmd.Flags().StringVar(&exportProject, "project", "", "Filter by project name")
+	exportCmd.Flags().StringVar(&exportProject, "project", "", "Filter by project name, ignoring case")
 	exportCmd.Flags().StringSliceVar(&exportSessions, "sessions", []string{}, "Filter by specific session IDs (comma-separated)")
 	exportCmd.Flags().StringVar(&exportStatus, "status", "", "Filter by session status (active, completed, error)")
 	exportCmd.Flags().StringVar(&exportMinDuration, "min-duration", "", "Filter by minimum session duration (e.g. 5m, 1h, 30s)")
 	exportCmd.Flags().StringVar(&exportMaxDuration, "max-duration", "", "Filter by maximum session duration (e.g. 2h, 45m)")
+	exportCmd.Flags().BoolVar(&exportTrashed, "include-trashed", false, "Also export sessions in the trash")
 
 	// CSV-specific flags
 	exportCmd.Flags().StringSliceVar(&exportColumns, "columns", []string{}, "Custom CSV columns (comma-separated)")
@@ -148,20 +152,12 @@ func handleExport(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
-	// Initialize database manager
-	config := database.DefaultDatabaseConfig()
-	manager := database.NewManager(config)
-
 	ctx := context.Background()
-	if err := manager.Initialize(ctx); err != nil {
-		return fmt.Errorf("failed to initialize database: %w", err)
-	}
-	defer manager.Close()
-
-	backend, err := manager.GetBackend()
+	backend, closeBackend, err := openCaptureBackend(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get database backend: %w", err)
+		return err
 	}
+	defer closeBackend()
 
 	// Build export options
 	options := &export.ExportOptions{
@@ -181,6 +177,7 @@ func handleExport(cmd *cobra.Command, args []string) error {
 		Preview:      exportPreview,
 		ShowStats:    exportStats,
 		Validate:     exportValidate,
+		IncludeTrash: exportTrashed,
 	}
 
 	// Create exporter based on format
@@ -196,28 +193,33 @@ func handleExport(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("unsupported export format: %s", exportFormat)
 	}
 
-	// Get filtered sessions
-	sessions, err := getFilteredSessions(ctx, backend, options)
+	// Get filtered sessions. They are read again as they are exported, so
+	// only the count is kept.
+	sessions, err := filteredSessions(ctx, backend, options)
+	if err != nil {
+		return fmt.Errorf("failed to get sessions: %w", err)
+	}
+	total, err := countSessions(sessions)
 	if err != nil {
 		return fmt.Errorf("failed to get sessions: %w", err)
 	}
 
-	if len(sessions) == 0 {
+	if total == 0 {
 		fmt.Println("📭 No sessions found matching the specified criteria")
 		return nil
 	}
 
 	// Handle preview mode
 	if options.Preview {
-		return handlePreviewMode(ctx, backend, sessions, options)
+		return handlePreviewMode(ctx, backend, sessions, total, options)
 	}
 
-	fmt.Printf("🔄 Exporting %d sessions to %s format...\n", len(sessions), exportFormat)
+	fmt.Printf("🔄 Exporting %d sessions to %s format...\n", total, exportFormat)
 
 	// Perform export with progress if enabled
 	if options.ShowProgress {
 		if csvExporter, ok := exporter.(*export.CSVExporter); ok {
-			if err := csvExporter.ExportWithProgress(ctx, backend, sessions, options); err != nil {
+			if err := csvExporter.ExportWithProgress(ctx, backend, sessions, total, options); err != nil {
 				return fmt.Errorf("export failed: %w", err)
 			}
 		} else {
@@ -241,7 +243,7 @@ func handleExport(cmd *cobra.Command, args []string) error {
 
 	// Perform export validation if requested
 	if options.Validate {
-		if err := validateExport(exportOutput, options, len(sessions)); err != nil {
+		if err := validateExport(exportOutput, options, total); err != nil {
 			fmt.Printf("⚠️  Export validation warning: %v\n", err)
 		} else {
 			fmt.Printf("✅ Export validation passed\n")
@@ -251,8 +253,9 @@ func handleExport(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-// getFilteredSessions retrieves sessions based on export filters
-func getFilteredSessions(ctx context.Context, backend database.DatabaseBackend, options *export.ExportOptions) ([]*database.Session, error) {
+// filteredSessions returns the sessions matching the export filters, read
+// from the database as they are iterated
+func filteredSessions(ctx context.Context, backend database.DatabaseBackend, options *export.ExportOptions) (iter.Seq2[*database.Session, error], error) {
 	// Build session filters
 	filters := &database.SessionFilters{}
 
@@ -275,68 +278,65 @@ func getFilteredSessions(ctx context.Context, backend database.DatabaseBackend,
 		filters.CreatedBefore = &endOfDay
 	}
 
-	// Status filter
+	// Status and project filters
 	if options.Status != "" {
 		filters.Status = options.Status
 	}
+	filters.Project = options.Project
+	filters.IncludeDeleted = options.IncludeTrash
 
-	// Get sessions with database-level filtering
-	allSessions, err := backend.ListSessions(ctx, filters)
-	if err != nil {
-		return nil, err
+	// Duration filters are applied as sessions are read
+	var minDur, maxDur time.Duration
+	if options.MinDuration != "" {
+		var err error
+		if minDur, err = parseDuration(options.MinDuration); err != nil {
+			return nil, fmt.Errorf("invalid min-duration format: %w", err)
+		}
 	}
-
-	var filteredSessions []*database.Session
-
-	for _, session := range allSessions {
-		// Project filter (application-level since it requires metadata parsing)
-		if options.Project != "" {
-			if !matchesProject(session, options.Project) {
-				continue
-			}
+	if options.MaxDuration != "" {
+		var err error
+		if maxDur, err = parseDuration(options.MaxDuration); err != nil {
+			return nil, fmt.Errorf("invalid max-duration format: %w", err)
 		}
+	}
 
-		// Session ID filter (application-level for multiple IDs)
-		if len(options.Sessions) > 0 {
-			found := false
-			for _, sessionID := range options.Sessions {
-				if session.ID == sessionID {
-					found = true
-					break
-				}
+	return func(yield func(*database.Session, error) bool) {
+		for session, err := range backend.IterSessions(ctx, filters) {
+			if err != nil {
+				yield(nil, err)
+				return
 			}
-			if !found {
+
+			// Session ID filter (application-level for multiple IDs)
+			if len(options.Sessions) > 0 && !slices.Contains(options.Sessions, session.ID) {
 				continue
 			}
-		}
 
-		// Duration filters
-		sessionDuration := session.UpdatedAt.Sub(session.CreatedAt)
-
-		if options.MinDuration != "" {
-			minDur, err := parseDuration(options.MinDuration)
-			if err != nil {
-				return nil, fmt.Errorf("invalid min-duration format: %w", err)
+			sessionDuration := session.UpdatedAt.Sub(session.CreatedAt)
+			if options.MinDuration != "" && sessionDuration < minDur {
+				continue
 			}
-			if sessionDuration < minDur {
+			if options.MaxDuration != "" && sessionDuration > maxDur {
 				continue
 			}
-		}
 
-		if options.MaxDuration != "" {
-			maxDur, err := parseDuration(options.MaxDuration)
-			if err != nil {
-				return nil, fmt.Errorf("invalid max-duration format: %w", err)
-			}
-			if sessionDuration > maxDur {
-				continue
+			if !yield(session, nil) {
+				return
 			}
 		}
+	}, nil
+}
 
-		filteredSessions = append(filteredSessions, session)
+// countSessions reads sessions through and counts them
+func countSessions(sessions iter.Seq2[*database.Session, error]) (int, error) {
+	count := 0
+	for _, err := range sessions {
+		if err != nil {
+			return 0, err
+		}
+		count++
 	}
-
-	return filteredSessions, nil
+	return count, nil
 }
 
 // parseDate parses a date string in YYYY-MM-DD format
@@ -363,44 +363,24 @@ func parseDuration(durationStr string) (time.Duration, error) {
 	return time.ParseDuration(durationStr)
 }
 
-// matchesProject checks if a session matches the project filter
-func matchesProject(session *database.Session, projectFilter string) bool {
-	if session.Metadata == "" {
-		return false
-	}
-
-	// Simple string matching in metadata (case-insensitive)
-	metadataLower := strings.ToLower(session.Metadata)
-	projectLower := strings.ToLower(projectFilter)
-
-	// Check if project name appears in metadata
-	if strings.Contains(metadataLower, fmt.Sprintf("\"project\":\"%s\"", projectLower)) {
-		return true
-	}
-
-	// Check for partial matches in working directory or data fields
-	if strings.Contains(metadataLower, projectLower) {
-		return true
-	}
-
-	return false
-}
-
 // handlePreviewMode shows a preview of the export without creating a file
-func handlePreviewMode(ctx context.Context, backend database.DatabaseBackend, sessions []*database.Session, options *export.ExportOptions) error {
-	// Limit to first 5 sessions for preview
-	previewSessions := sessions
-	if len(sessions) > 5 {
-		previewSessions = sessions[:5]
-	}
-
-	// Prepare export data
-	exportData, err := export.PrepareSessionData(ctx, backend, previewSessions)
-	if err != nil {
-		return fmt.Errorf("failed to prepare preview data: %w", err)
+func handlePreviewMode(ctx context.Context, backend database.DatabaseBackend, sessions iter.Seq2[*database.Session, error], total int, options *export.ExportOptions) error {
+	// Summarize the first 5 sessions for preview
+	var exportData []*export.SessionExportData
+	for session, err := range sessions {
+		if err != nil {
+			return fmt.Errorf("failed to prepare preview data: %w", err)
+		}
+		data, err := export.SummarizeSession(ctx, backend, session)
+		if err != nil {
+			return fmt.Errorf("failed to prepare preview data: %w", err)
+		}
+		if exportData = append(exportData, data); len(exportData) == 5 {
+			break
+		}
 	}
 
-	fmt.Printf("📋 Export Preview (showing first %d of %d sessions):\n\n", len(exportData), len(sessions))
+	fmt.Printf("📋 Export Preview (showing first %d of %d sessions):\n\n", len(exportData), total)
 
 	if options.Format == "csv" {
 		// Show CSV preview as a table
@@ -463,10 +443,10 @@ func handlePreviewMode(ctx context.Context, backend database.DatabaseBackend, se
 
 	// Show summary statistics
 	fmt.Printf("\n💡 Full export would include:\n")
-	fmt.Printf("   - %d sessions total\n", len(sessions))
+	fmt.Printf("   - %d sessions total\n", total)
 
 	// Calculate estimated file size
-	estimatedSize := len(sessions) * 100 // rough estimate
+	estimatedSize := total * 100 // rough estimate
 	if options.Format == "json" {
 		estimatedSize *= 10 // JSON is larger
 	}
@@ -7,7 +7,6 @@ import (
 	"strings"
 	"time"
 
-	"context-extender/internal/database"
 	"context-extender/internal/importer"
 	"github.com/spf13/cobra"
 )
@@ -44,16 +43,12 @@ The import process will:
 3. Import messages, sessions, and metadata to the database
 4. Track imported files to avoid duplicates`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// Initialize database
-		config := database.DefaultConfig()
-		if err := database.Initialize(config); err != nil {
-			return fmt.Errorf("failed to initialize database: %w", err)
-		}
-
-		// Run migrations
-		if err := database.RunMigrations(); err != nil {
-			return fmt.Errorf("failed to run migrations: %w", err)
+		ctx := cmd.Context()
+		backend, closeBackend, err := openCaptureBackend(ctx)
+		if err != nil {
+			return err
 		}
+		defer closeBackend()
 
 		fmt.Println("🔍 Searching for Claude conversation files...")
 
@@ -106,11 +101,11 @@ The import process will:
 			SkipExisting: importSkipExisting,
 			MaxFiles:     importMaxFiles,
 		}
-		manager := importer.NewImportManager(options)
+		manager := importer.NewImportManager(backend, options)
 
 		// Perform import
 		fmt.Println("\n📥 Starting import...")
-		result, err := manager.ImportAllClaude()
+		result, err := manager.ImportAllClaude(ctx)
 		if err != nil {
 			return fmt.Errorf("import failed: %w", err)
 		}
@@ -155,15 +150,12 @@ Example:
 			return fmt.Errorf("file not found: %s", filePath)
 		}
 
-		// Initialize database
-		config := database.DefaultConfig()
-		if err := database.Initialize(config); err != nil {
-			return fmt.Errorf("failed to initialize database: %w", err)
-		}
-
-		if err := database.RunMigrations(); err != nil {
-			return fmt.Errorf("failed to run migrations: %w", err)
+		ctx := cmd.Context()
+		backend, closeBackend, err := openCaptureBackend(ctx)
+		if err != nil {
+			return err
 		}
+		defer closeBackend()
 
 		// Create import manager
 		options := importer.ImportOptions{
@@ -171,12 +163,12 @@ Example:
 			DryRun:       importDryRun,
 			SkipExisting: importSkipExisting,
 		}
-		manager := importer.NewImportManager(options)
+		manager := importer.NewImportManager(backend, options)
 
 		fmt.Printf("Importing file: %s\n", filePath)
 
 		// Import the file
-		if err := manager.ImportFile(filePath); err != nil {
+		if err := manager.ImportFile(ctx, filePath); err != nil {
 			return fmt.Errorf("failed to import file: %w", err)
 		}
 
@@ -206,15 +198,12 @@ Example:
 			return fmt.Errorf("directory not found: %s", dirPath)
 		}
 
-		// Initialize database
-		config := database.DefaultConfig()
-		if err := database.Initialize(config); err != nil {
-			return fmt.Errorf("failed to initialize database: %w", err)
-		}
-
-		if err := database.RunMigrations(); err != nil {
-			return fmt.Errorf("failed to run migrations: %w", err)
+		ctx := cmd.Context()
+		backend, closeBackend, err := openCaptureBackend(ctx)
+		if err != nil {
+			return err
 		}
+		defer closeBackend()
 
 		// Create import manager
 		options := importer.ImportOptions{
@@ -223,12 +212,12 @@ Example:
 			SkipExisting: importSkipExisting,
 			MaxFiles:     importMaxFiles,
 		}
-		manager := importer.NewImportManager(options)
+		manager := importer.NewImportManager(backend, options)
 
 		fmt.Printf("Importing from directory: %s\n", dirPath)
 
 		// Import the directory
-		result, err := manager.ImportDirectory(dirPath)
+		result, err := manager.ImportDirectory(ctx, dirPath)
 		if err != nil {
 			return fmt.Errorf("failed to import directory: %w", err)
 		}
@@ -253,14 +242,15 @@ var importHistoryCmd = &cobra.Command{
 	Short: "Show import history",
 	Long:  `Display the history of imported Claude conversation files.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// Initialize database
-		config := database.DefaultConfig()
-		if err := database.Initialize(config); err != nil {
-			return fmt.Errorf("failed to initialize database: %w", err)
+		ctx := cmd.Context()
+		backend, closeBackend, err := openCaptureBackend(ctx)
+		if err != nil {
+			return err
 		}
+		defer closeBackend()
 
 		// Get import history
-		records, err := importer.GetImportHistory()
+		records, err := backend.ListImports(ctx)
 		if err != nil {
 			return fmt.Errorf("failed to get import history: %w", err)
 		}
@@ -274,15 +264,9 @@ var importHistoryCmd = &cobra.Command{
 		fmt.Println(strings.Repeat("-", 80))
 
 		for _, record := range records {
-			// Parse time for better display
-			importTime := record.ImportedAt
-			if t, err := time.Parse(time.RFC3339, record.ImportedAt); err == nil {
-				importTime = t.Format("2006-01-02 15:04:05")
-			}
-
 			fmt.Printf("File: %s\n", filepath.Base(record.FilePath))
 			fmt.Printf("  Path:     %s\n", record.FilePath)
-			fmt.Printf("  Imported: %s\n", importTime)
+			fmt.Printf("  Imported: %s\n", record.ImportedAt.Local().Format("2006-01-02 15:04:05"))
 			fmt.Printf("  Messages: %d\n", record.EventCount)
 			if record.Checksum != "" {
 				fmt.Printf("  Checksum: %s\n", record.Checksum[:12]+"...")
@@ -307,16 +291,13 @@ var importWizardCmd = &cobra.Command{
 
 		// Initialize database
 		fmt.Print("Initializing database... ")
-		config := database.DefaultConfig()
-		if err := database.Initialize(config); err != nil {
-			fmt.Println("❌")
-			return fmt.Errorf("failed to initialize database: %w", err)
-		}
-
-		if err := database.RunMigrations(); err != nil {
+		ctx := cmd.Context()
+		backend, closeBackend, err := openCaptureBackend(ctx)
+		if err != nil {
 			fmt.Println("❌")
-			return fmt.Errorf("failed to run migrations: %w", err)
+			return err
 		}
+		defer closeBackend()
 		fmt.Println("✅")
 
 		// Search for conversations
@@ -345,16 +326,16 @@ var importWizardCmd = &cobra.Command{
 					Verbose:      true,
 					SkipExisting: true,
 				}
-				manager := importer.NewImportManager(options)
+				manager := importer.NewImportManager(backend, options)
 
 				if info, err := os.Stat(customPath); err == nil && info.IsDir() {
-					result, err := manager.ImportDirectory(customPath)
+					result, err := manager.ImportDirectory(ctx, customPath)
 					if err != nil {
 						return fmt.Errorf("import failed: %w", err)
 					}
 					fmt.Printf("\n✅ Imported %d file(s) successfully!\n", result.SuccessfulFiles)
 				} else {
-					if err := manager.ImportFile(customPath); err != nil {
+					if err := manager.ImportFile(ctx, customPath); err != nil {
 						return fmt.Errorf("import failed: %w", err)
 					}
 					fmt.Println("\n✅ File imported successfully!")
@@ -399,12 +380,12 @@ var importWizardCmd = &cobra.Command{
 			Verbose:      true,
 			SkipExisting: true,
 		}
-		manager := importer.NewImportManager(options)
+		manager := importer.NewImportManager(backend, options)
 
 		switch choice {
 		case "1":
 			fmt.Println("\n📥 Importing all conversations...")
-			result, err := manager.ImportAllClaude()
+			result, err := manager.ImportAllClaude(ctx)
 			if err != nil {
 				return fmt.Errorf("import failed: %w", err)
 			}
@@ -432,7 +413,7 @@ var importWizardCmd = &cobra.Command{
 			fmt.Printf("Importing %d file(s) from project...\n", len(matchedFiles))
 			successCount := 0
 			for _, file := range matchedFiles {
-				if err := manager.ImportFile(file); err != nil {
+				if err := manager.ImportFile(ctx, file); err != nil {
 					fmt.Printf("  ❌ Failed: %s\n", filepath.Base(file))
 				} else {
 					successCount++
@@ -444,9 +425,9 @@ var importWizardCmd = &cobra.Command{
 
 		case "3":
 			options.SkipExisting = true
-			manager = importer.NewImportManager(options)
+			manager = importer.NewImportManager(backend, options)
 			fmt.Println("\n📥 Importing new conversations only...")
-			result, err := manager.ImportAllClaude()
+			result, err := manager.ImportAllClaude(ctx)
 			if err != nil {
 				return fmt.Errorf("import failed: %w", err)
 			}
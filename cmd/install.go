@@ -190,13 +190,23 @@ func performInstallation() error {
 	}
 
 	if installed {
-		fmt.Println("⚠️  ALREADY INSTALLED")
-		fmt.Println()
-		fmt.Println("Context-Extender hooks are already installed!")
-		fmt.Println("Installation completed successfully (no changes needed).")
-		return nil
+		missing, err := hooks.MissingHookTypes()
+		if err != nil {
+			fmt.Println("❌ ERROR")
+			return fmt.Errorf("failed to check installation status: %w", err)
+		}
+
+		if len(missing) == 0 {
+			fmt.Println("⚠️  ALREADY INSTALLED")
+			fmt.Println()
+			fmt.Println("Context-Extender hooks are already installed!")
+			fmt.Println("Installation completed successfully (no changes needed).")
+			return nil
+		}
+		fmt.Printf("⚠️  OUTDATED (missing %s)\n", strings.Join(missing, ", "))
+	} else {
+		fmt.Println("✅ READY")
 	}
-	fmt.Println("✅ READY")
 
 	// Install hooks
 	fmt.Print("⚡ Installing conversation capture hooks... ")
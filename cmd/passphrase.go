@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"context-extender/internal/database"
+	"golang.org/x/term"
+)
+
+// newPassphraseEnv sets the new passphrase for non-interactive use
+const newPassphraseEnv = "CONTEXT_EXTENDER_NEW_PASSPHRASE"
+
+// errNoNewPassphrase is returned when there is no terminal to ask for a
+// new passphrase
+var errNoNewPassphrase = errors.New("no terminal to ask for the new passphrase, set " + newPassphraseEnv)
+
+// promptPassphrase reads a passphrase from the terminal without echoing
+// it. Without a terminal it fails rather than read stdin; hooks and the
+// daemon use the environment or a key file.
+func promptPassphrase(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", database.ErrPassphraseRequired
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return string(passphrase), nil
+}
+
+// readNewPassphrase reads a new passphrase from newPassphraseEnv or asks
+// for it twice. With allowEmpty, an empty passphrase is returned as is.
+func readNewPassphrase(allowEmpty bool) (string, error) {
+	if value, ok := os.LookupEnv(newPassphraseEnv); ok {
+		return value, nil
+	}
+
+	prompt := "New passphrase: "
+	if allowEmpty {
+		prompt = "New passphrase (empty to leave the key unprotected): "
+	}
+	passphrase, err := promptPassphrase(prompt)
+	if errors.Is(err, database.ErrPassphraseRequired) {
+		return "", errNoNewPassphrase
+	}
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		if allowEmpty {
+			return "", nil
+		}
+		return "", fmt.Errorf("passphrase can't be empty")
+	}
+
+	confirmation, err := promptPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if confirmation != passphrase {
+		return "", fmt.Errorf("passphrases don't match")
+	}
+	return passphrase, nil
+}
+
+func init() {
+	database.PassphrasePrompt = promptPassphrase
+}
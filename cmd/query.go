@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
@@ -25,7 +26,15 @@ var queryCmd = &cobra.Command{
 var queryListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List completed conversations",
-	Long:  "Display a list of all completed conversations with basic metadata.",
+	Long: `Display a list of all completed conversations with basic metadata.
+
+Filters and the sort run in the database, and results come a page of --limit
+at a time. A page that isn't the last ends with the --cursor continuing it;
+--all lists every page.`,
+	Example: `  context-extender query list --status completed --min-messages 20
+  context-extender query list --working-dir ~/src/api --model claude-opus-4
+  context-extender query list --text "migration" --sort token_count
+  context-extender query list --all --format json`,
 	Run: func(cmd *cobra.Command, args []string) {
 		handleListConversations(cmd, args)
 	},
@@ -44,7 +53,17 @@ var showCmd = &cobra.Command{
 var searchCmd = &cobra.Command{
 	Use:   "search [query]",
 	Short: "Search conversations",
-	Long:  "Search for conversations containing specific terms or matching criteria.",
+	Long: `Search conversation messages, most relevant first, with matches highlighted.
+
+Query syntax:
+  error handling        messages containing both words
+  "error handling"      the exact phrase
+  handl*                words starting with handl
+  sqlite OR postgres    either word
+  cache NOT redis       cache, but not redis
+  (go OR rust) AND wasm grouping
+
+Matching ignores case and accents.`,
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		handleSearchConversations(cmd, args)
@@ -69,6 +88,19 @@ var (
 	queryDateTo     string
 	queryShowEvents bool
 	queryShowSummary bool
+
+	queryStatuses    []string
+	queryWorkingDir  string
+	queryModel       string
+	queryMinMessages int
+	queryMaxMessages int
+	queryMinTokens   int
+	queryMaxTokens   int
+	queryText        string
+	querySort        string
+	queryAscending   bool
+	queryCursor      string
+	queryAll         bool
 )
 
 func init() {
@@ -81,49 +113,70 @@ func init() {
 	// Global query flags
 	queryCmd.PersistentFlags().StringVar(&queryFormat, "format", "table", "Output format (table, json)")
 	queryCmd.PersistentFlags().IntVar(&queryLimit, "limit", 10, "Limit number of results")
-	queryCmd.PersistentFlags().StringVar(&queryProject, "project", "", "Filter by project name")
+	queryCmd.PersistentFlags().StringVar(&queryProject, "project", "", "Filter by project name, ignoring case")
 	queryCmd.PersistentFlags().StringVar(&queryDateFrom, "from", "", "Filter from date (YYYY-MM-DD)")
 	queryCmd.PersistentFlags().StringVar(&queryDateTo, "to", "", "Filter to date (YYYY-MM-DD)")
 
+	// Session filters of the list and stats commands
+	for _, cmd := range []*cobra.Command{queryListCmd, statsCmd} {
+		cmd.Flags().StringSliceVar(&queryStatuses, "status", nil, "Filter by status, comma-separated for any of several")
+		cmd.Flags().StringVar(&queryWorkingDir, "working-dir", "", "Filter by working directory, including directories below it")
+		cmd.Flags().StringVar(&queryModel, "model", "", "Only sessions with a reply from this model")
+		cmd.Flags().IntVar(&queryMinMessages, "min-messages", 0, "Only sessions with at least this many messages")
+		cmd.Flags().IntVar(&queryMaxMessages, "max-messages", 0, "Only sessions with at most this many messages")
+		cmd.Flags().IntVar(&queryMinTokens, "min-tokens", 0, "Only sessions with at least this many tokens")
+		cmd.Flags().IntVar(&queryMaxTokens, "max-tokens", 0, "Only sessions with at most this many tokens")
+		cmd.Flags().StringVar(&queryText, "text", "", "Only sessions with a message matching this search query")
+	}
+
+	// List command specific flags
+	queryListCmd.Flags().StringVar(&querySort, "sort", "created_at", "Sort by created_at, updated_at, status, message_count, token_count or id")
+	queryListCmd.Flags().BoolVar(&queryAscending, "asc", false, "Sort ascending instead of newest or largest first")
+	queryListCmd.Flags().StringVar(&queryCursor, "cursor", "", "Continue a listing from the cursor its previous page ended with")
+	queryListCmd.Flags().BoolVar(&queryAll, "all", false, "List every page instead of the first")
+
 	// Show command specific flags
 	showCmd.Flags().BoolVar(&queryShowEvents, "events", false, "Show all conversation events")
 	showCmd.Flags().BoolVar(&queryShowSummary, "summary", true, "Show conversation summary")
+
+	// Search command specific flags
+	searchCmd.Flags().String("session", "", "Only search this session")
 }
 
 func handleListConversations(cmd *cobra.Command, args []string) {
-	// Initialize database manager
-	config := database.DefaultDatabaseConfig()
-	manager := database.NewManager(config)
-
 	ctx := context.Background()
-	if err := manager.Initialize(ctx); err != nil {
-		fmt.Printf("❌ Failed to initialize database: %v\n", err)
-		os.Exit(1)
-	}
-	defer manager.Close()
-
-	backend, err := manager.GetBackend()
+	backend, closeBackend, err := openCaptureBackend(ctx)
 	if err != nil {
-		fmt.Printf("❌ Failed to get database backend: %v\n", err)
+		fmt.Printf("❌ %v\n", err)
 		os.Exit(1)
 	}
+	defer closeBackend()
 
-	// Get all sessions
-	sessions, err := backend.ListSessions(ctx, &database.SessionFilters{})
+	filters, err := querySessionFilters()
 	if err != nil {
-		fmt.Printf("❌ Failed to list sessions: %v\n", err)
+		fmt.Printf("❌ %v\n", err)
 		os.Exit(1)
 	}
+	filters.SortBy = querySort
+	if !queryAscending {
+		filters.SortOrder = "DESC"
+	}
+	filters.Limit = queryLimit
+	filters.Cursor = queryCursor
 
-	// Convert to conversation metadata format
-	conversations := convertSessionsToMetadata(ctx, backend, sessions)
-
-	// Apply filters
-	conversations = applyFilters(conversations)
+	var conversations []ConversationMetadata
+	for {
+		page, err := backend.ListSessionPage(ctx, filters)
+		if err != nil {
+			fmt.Printf("❌ Failed to list sessions: %v\n", err)
+			os.Exit(1)
+		}
+		conversations = append(conversations, convertSessionsToMetadata(ctx, backend, page.Sessions)...)
 
-	// Apply limit
-	if queryLimit > 0 && len(conversations) > queryLimit {
-		conversations = conversations[:queryLimit]
+		filters.Cursor = page.NextCursor
+		if !queryAll || page.NextCursor == "" {
+			break
+		}
 	}
 
 	// Output results
@@ -132,6 +185,10 @@ func handleListConversations(cmd *cobra.Command, args []string) {
 	} else {
 		outputTable(conversations)
 	}
+	if filters.Cursor != "" {
+		// JSON consumers read stdout, so the cursor goes to stderr
+		fmt.Fprintf(os.Stderr, "➡️  More sessions follow: --cursor %s\n", filters.Cursor)
+	}
 }
 
 func handleShowConversation(cmd *cobra.Command, args []string) {
@@ -194,8 +251,6 @@ func handleShowConversation(cmd *cobra.Command, args []string) {
 }
 
 func handleSearchConversations(cmd *cobra.Command, args []string) {
-	searchQuery := strings.ToLower(args[0])
-
 	// Initialize database manager
 	config := database.DefaultDatabaseConfig()
 	manager := database.NewManager(config)
@@ -213,44 +268,94 @@ func handleSearchConversations(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	// Search conversations using database search
-	conversations, err := backend.SearchConversations(ctx, searchQuery, queryLimit)
+	// Databases from older versions get their search index built here
+	if err := backend.CreateSchema(ctx); err != nil {
+		fmt.Printf("❌ Failed to prepare search index: %v\n", err)
+		os.Exit(1)
+	}
+
+	opts := &database.SearchOptions{
+		Query:   args[0],
+		Limit:   queryLimit,
+		Project: queryProject,
+	}
+	opts.SessionID, _ = cmd.Flags().GetString("session")
+	if queryDateFrom != "" {
+		if opts.From, err = time.ParseInLocation("2006-01-02", queryDateFrom, time.Local); err != nil {
+			fmt.Printf("❌ Invalid --from date: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if queryDateTo != "" {
+		to, err := time.ParseInLocation("2006-01-02", queryDateTo, time.Local)
+		if err != nil {
+			fmt.Printf("❌ Invalid --to date: %v\n", err)
+			os.Exit(1)
+		}
+		opts.To = to.Add(24 * time.Hour)
+	}
+
+	hits, err := backend.SearchConversationHits(ctx, opts)
 	if err != nil {
 		fmt.Printf("❌ Failed to search conversations: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Get unique sessions from search results
-	sessionIDs := make(map[string]bool)
-	for _, conv := range conversations {
-		sessionIDs[conv.SessionID] = true
+	if queryFormat == "json" {
+		outputJSON(hits)
+		return
 	}
 
-	// Get session details for matching conversations
-	var matchingSessions []*database.Session
-	for sessionID := range sessionIDs {
-		session, err := backend.GetSession(ctx, sessionID)
-		if err == nil {
-			matchingSessions = append(matchingSessions, session)
-		}
+	if len(hits) == 0 {
+		fmt.Printf("📭 No messages match '%s'\n", args[0])
+		return
 	}
 
-	// Convert to conversation metadata
-	matchingConversations := convertSessionsToMetadata(ctx, backend, matchingSessions)
+	fmt.Printf("🔍 Found %d messages matching '%s' (best first)\n\n", len(hits), args[0])
 
-	// Apply filters
-	matchingConversations = applyFilters(matchingConversations)
-
-	fmt.Printf("🔍 Found %d conversations matching '%s'\n\n", len(matchingConversations), args[0])
+	highlightStart, highlightEnd := "[", "]"
+	if isTerminal(os.Stdout) {
+		highlightStart, highlightEnd = "\033[1;33m", "\033[0m"
+	}
 
-	// Output results
-	if queryFormat == "json" {
-		outputJSON(matchingConversations)
-	} else {
-		outputTable(matchingConversations)
+	for i, hit := range hits {
+		conv := hit.Conversation
+		fmt.Printf("%2d. %s  %-9s  session %s  score %.2f  (%d matches)\n",
+			i+1,
+			conv.Timestamp.Format("2006-01-02 15:04"),
+			conv.MessageType,
+			conv.SessionID,
+			hit.Score,
+			len(hit.Matches))
+		snippet := highlightMatches(hit.Snippet, hit.SnippetMatches, highlightStart, highlightEnd)
+		fmt.Printf("    %s\n\n", strings.ReplaceAll(snippet, "\n", " "))
 	}
 }
 
+// highlightMatches wraps the matched ranges of text in start and end markers
+func highlightMatches(text string, matches []database.SearchMatch, start, end string) string {
+	var b strings.Builder
+	last := 0
+	for _, match := range matches {
+		if match.Start < last || match.End > len(text) {
+			continue
+		}
+		b.WriteString(text[last:match.Start])
+		b.WriteString(start)
+		b.WriteString(text[match.Start:match.End])
+		b.WriteString(end)
+		last = match.End
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// isTerminal reports whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
 func handleConversationStats(cmd *cobra.Command, args []string) {
 	// Initialize database manager
 	config := database.DefaultDatabaseConfig()
@@ -268,6 +373,10 @@ func handleConversationStats(cmd *cobra.Command, args []string) {
 		fmt.Printf("❌ Failed to get database backend: %v\n", err)
 		os.Exit(1)
 	}
+	if err := backend.CreateSchema(ctx); err != nil {
+		fmt.Printf("❌ Failed to migrate database: %v\n", err)
+		os.Exit(1)
+	}
 
 	// Get database statistics
 	dbStats, err := backend.GetDatabaseStats(ctx)
@@ -276,16 +385,18 @@ func handleConversationStats(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	// Get all sessions for detailed stats
-	sessions, err := backend.ListSessions(ctx, &database.SessionFilters{})
+	// Get the matching sessions for detailed stats
+	filters, err := querySessionFilters()
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	sessions, err := backend.ListSessions(ctx, filters)
 	if err != nil {
 		fmt.Printf("❌ Failed to list sessions: %v\n", err)
 		os.Exit(1)
 	}
-
-	// Convert to conversation metadata and apply filters
 	conversations := convertSessionsToMetadata(ctx, backend, sessions)
-	conversations = applyFilters(conversations)
 
 	// Calculate aggregate statistics
 	stats := calculateAggregateStats(conversations, dbStats)
@@ -305,34 +416,44 @@ func handleConversationStats(cmd *cobra.Command, args []string) {
 
 // Helper functions
 
-func applyFilters(conversations []ConversationMetadata) []ConversationMetadata {
-	var filtered []ConversationMetadata
-
-	for _, conv := range conversations {
-		// Project filter
-		if queryProject != "" && !strings.Contains(strings.ToLower(conv.ProjectName), strings.ToLower(queryProject)) {
-			continue
+// querySessionFilters builds the session filters of the query flags
+func querySessionFilters() (*database.SessionFilters, error) {
+	filters := &database.SessionFilters{
+		Project:     queryProject,
+		Statuses:    queryStatuses,
+		WorkingDir:  queryWorkingDir,
+		Model:       queryModel,
+		MinMessages: queryMinMessages,
+		MaxMessages: queryMaxMessages,
+		MinTokens:   queryMinTokens,
+		MaxTokens:   queryMaxTokens,
+		Text:        queryText,
+	}
+	if queryWorkingDir != "" {
+		dir, err := filepath.Abs(queryWorkingDir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --working-dir: %w", err)
 		}
+		filters.WorkingDir = dir
+	}
 
-		// Date filters
-		if queryDateFrom != "" {
-			fromDate, err := time.Parse("2006-01-02", queryDateFrom)
-			if err == nil && conv.StartTime.Before(fromDate) {
-				continue
-			}
+	if queryDateFrom != "" {
+		from, err := time.Parse("2006-01-02", queryDateFrom)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --from date: %w", err)
 		}
-
-		if queryDateTo != "" {
-			toDate, err := time.Parse("2006-01-02", queryDateTo)
-			if err == nil && conv.StartTime.After(toDate.Add(24*time.Hour)) {
-				continue
-			}
+		from = from.Add(-time.Nanosecond)
+		filters.CreatedAfter = &from
+	}
+	if queryDateTo != "" {
+		to, err := time.Parse("2006-01-02", queryDateTo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --to date: %w", err)
 		}
-
-		filtered = append(filtered, conv)
+		to = to.Add(24 * time.Hour)
+		filters.CreatedBefore = &to
 	}
-
-	return filtered
+	return filters, nil
 }
 
 // matchesSearch function removed - now using database backend SearchConversations
@@ -400,6 +521,9 @@ func outputSessionDetails(session *database.Session, conversations []*database.C
 	fmt.Printf("   Duration:       %s\n", session.UpdatedAt.Sub(session.CreatedAt).String())
 	fmt.Printf("   Start Time:     %s\n", session.CreatedAt.Format("2006-01-02 15:04:05"))
 	fmt.Printf("   End Time:       %s\n", session.UpdatedAt.Format("2006-01-02 15:04:05"))
+	if session.DeletedAt != nil {
+		fmt.Printf("   🗑️  In Trash:    since %s\n", session.DeletedAt.Local().Format("2006-01-02 15:04:05"))
+	}
 	fmt.Printf("\n")
 
 	// Summary
@@ -531,11 +655,6 @@ func convertSessionsToMetadata(ctx context.Context, backend database.DatabaseBac
 		})
 	}
 
-	// Sort by start time (newest first)
-	sort.Slice(conversations, func(i, j int) bool {
-		return conversations[i].StartTime.After(conversations[j].StartTime)
-	})
-
 	return conversations
 }
 
@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"context-extender/internal/retention"
+	"github.com/spf13/cobra"
+)
+
+// exampleRetentionPolicy is shown when no retention policy exists
+const exampleRetentionPolicy = `{
+  "max_age": "90d",
+  "max_sessions_per_project": 50,
+  "keep_starred": true,
+  "keep_tags": ["keep"]
+}`
+
+var purgeDbCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Delete sessions the retention policy doesn't keep",
+	Long: `Delete old sessions according to the retention policy in
+` + retention.PolicyFileName + ` (see 'configure retention'), together with their events,
+conversations, tool invocations and tags.
+
+A session is deleted when it has been inactive for longer than max_age, or
+when its project has more than max_sessions_per_project newer sessions.
+Starred sessions are kept unless keep_starred is false, as are sessions
+with a tag listed in keep_tags, or any tag with keep_tagged. The flags
+override the policy file.
+
+Use --dry-run to see what would be deleted first. Afterwards the freed
+space is returned to the file system with an incremental vacuum; the first
+purge converts the database to incremental vacuuming, which rewrites it
+once.`,
+	Example: `  context-extender database purge --dry-run
+  context-extender database purge --max-age 180d --keep-tag keep
+  context-extender database purge --max-per-project 20 --force`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		policy, err := retention.LoadPolicy()
+		if err != nil {
+			return err
+		}
+		if err := applyPolicyFlags(cmd, policy); err != nil {
+			return err
+		}
+		if !policy.HasLimits() {
+			fmt.Println("➖ The retention policy has no limits, nothing to purge.")
+			fmt.Println("💡 Set max_age or max_sessions_per_project, see 'context-extender configure retention'")
+			return nil
+		}
+
+		ctx := cmd.Context()
+		backend, closeBackend, err := openSessionManager(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeBackend()
+
+		sessions, err := backend.SessionFootprints(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list sessions: %w", err)
+		}
+		candidates := policy.Evaluate(sessions, time.Now())
+
+		fmt.Printf("🧹 Retention policy: %s\n", describePolicy(policy))
+		if len(candidates) == 0 {
+			fmt.Printf("✅ All %d sessions are kept\n", len(sessions))
+			return nil
+		}
+
+		var rows, size int64
+		ids := make([]string, 0, len(candidates))
+		fmt.Printf("\n%-36s  %-20s  %-16s  %8s  %9s  %s\n", "SESSION", "PROJECT", "LAST ACTIVITY", "ROWS", "SIZE", "REASON")
+		for _, candidate := range candidates {
+			session := candidate.Session
+			fmt.Printf("%-36s  %-20s  %-16s  %8d  %9s  %s\n", session.ID, shortProject(session.Project),
+				session.LastActivity.Local().Format("2006-01-02 15:04"), session.Rows, formatBytes(session.Bytes), candidate.Reason)
+			rows += session.Rows
+			size += session.Bytes
+			ids = append(ids, session.ID)
+		}
+		fmt.Printf("\n%d of %d sessions, %d rows, about %s of data\n", len(candidates), len(sessions), rows, formatBytes(size))
+
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if dryRun {
+			fmt.Println("🔍 Dry run, nothing was deleted")
+			return nil
+		}
+
+		force, _ := cmd.Flags().GetBool("force")
+		if !force {
+			fmt.Println("💡 'context-extender database backup' keeps a copy to restore from")
+			if !promptContinue(fmt.Sprintf("⚠️  Delete %d sessions?", len(candidates))) {
+				fmt.Println("Purge cancelled")
+				return nil
+			}
+		}
+
+		fmt.Println("🗑️  Purging sessions...")
+		result, err := backend.PurgeSessions(ctx, ids)
+		if err != nil {
+			return fmt.Errorf("failed to purge sessions: %w", err)
+		}
+		fmt.Printf("✅ Deleted %d sessions and %d rows\n", result.Sessions, result.Rows)
+		fmt.Printf("💾 Database %s → %s, reclaimed %s\n", formatBytes(result.BytesBefore), formatBytes(result.BytesAfter), formatBytes(result.Reclaimed()))
+		return nil
+	},
+}
+
+// configureRetentionCmd represents the configure retention command
+var configureRetentionCmd = &cobra.Command{
+	Use:   "retention",
+	Short: "Show the retention policy",
+	Long: `The retention policy decides which sessions 'database purge' deletes. It
+lives in ` + retention.PolicyFileName + ` in the context-extender config directory:
+  max_age                   delete sessions inactive for longer, e.g. 90d,
+                            12w or 720h
+  max_sessions_per_project  keep only this many of the newest sessions in
+                            each project
+  keep_starred              never delete starred sessions (default true)
+  keep_tagged               never delete sessions with any tag
+  keep_tags                 never delete sessions with one of these tags
+
+Star and tag sessions with 'context-extender session star' and
+'context-extender session tag'.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		policyPath, err := retention.GetPolicyPath()
+		if err != nil {
+			return fmt.Errorf("failed to get retention policy path: %w", err)
+		}
+
+		policy, err := retention.LoadPolicyFrom(policyPath)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Policy file: %s\n", policyPath)
+		if policy.Path == "" {
+			fmt.Println("\n➖ No retention policy, 'database purge' deletes nothing.")
+			fmt.Printf("\nCreate %s to purge old sessions, for example:\n\n%s\n", policyPath, exampleRetentionPolicy)
+			return nil
+		}
+		fmt.Printf("Policy: %s\n", describePolicy(policy))
+		return nil
+	},
+}
+
+// applyPolicyFlags overrides the policy with the purge flags
+func applyPolicyFlags(cmd *cobra.Command, policy *retention.Policy) error {
+	flags := cmd.Flags()
+	if flags.Changed("max-age") {
+		policy.MaxAge, _ = flags.GetString("max-age")
+	}
+	if flags.Changed("max-per-project") {
+		policy.MaxSessionsPerProject, _ = flags.GetInt("max-per-project")
+	}
+	if flags.Changed("keep-starred") {
+		policy.KeepStarred, _ = flags.GetBool("keep-starred")
+	}
+	if flags.Changed("keep-tagged") {
+		policy.KeepTagged, _ = flags.GetBool("keep-tagged")
+	}
+	if flags.Changed("keep-tag") {
+		tags, _ := flags.GetStringSlice("keep-tag")
+		policy.KeepTags = append(policy.KeepTags, tags...)
+	}
+	return policy.Validate()
+}
+
+// describePolicy summarizes a retention policy in one line
+func describePolicy(policy *retention.Policy) string {
+	var parts []string
+	if policy.MaxAge != "" {
+		parts = append(parts, "inactive for more than "+policy.MaxAge)
+	}
+	if policy.MaxSessionsPerProject > 0 {
+		parts = append(parts, fmt.Sprintf("beyond %d sessions per project", policy.MaxSessionsPerProject))
+	}
+	description := "delete nothing"
+	if len(parts) > 0 {
+		description = "delete sessions " + strings.Join(parts, " or ")
+	}
+
+	var keep []string
+	if policy.KeepStarred {
+		keep = append(keep, "starred")
+	}
+	if policy.KeepTagged {
+		keep = append(keep, "tagged")
+	} else if len(policy.KeepTags) > 0 {
+		keep = append(keep, "tagged "+strings.Join(policy.KeepTags, ", "))
+	}
+	if len(keep) > 0 {
+		description += ", keep " + strings.Join(keep, " and ")
+	}
+	return description
+}
+
+// shortProject fits a project name into the purge table
+func shortProject(project string) string {
+	if len(project) > 20 {
+		return project[:17] + "..."
+	}
+	return project
+}
+
+func init() {
+	purgeDbCmd.Flags().Bool("dry-run", false, "Show what would be deleted without deleting it")
+	purgeDbCmd.Flags().BoolP("force", "f", false, "Skip the confirmation prompt")
+	purgeDbCmd.Flags().String("max-age", "", "Delete sessions inactive for longer than this, e.g. 90d")
+	purgeDbCmd.Flags().Int("max-per-project", 0, "Keep only this many of the newest sessions per project")
+	purgeDbCmd.Flags().Bool("keep-starred", true, "Keep starred sessions")
+	purgeDbCmd.Flags().Bool("keep-tagged", false, "Keep sessions with any tag")
+	purgeDbCmd.Flags().StringSlice("keep-tag", nil, "Keep sessions with this tag (repeatable)")
+
+	databaseCmd.AddCommand(purgeDbCmd)
+	configureCmd.AddCommand(configureRetentionCmd)
+}
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"context-extender/internal/filter"
+)
+
+// exampleRules is shown when no rules file exists
+const exampleRules = `{
+  "default": "include",
+  "rules": [
+    {"name": "client work", "action": "exclude", "cwd": "~/clients/**"},
+    {"action": "exclude", "git_remote": "github.com/acme-corp/*"},
+    {"action": "exclude", "project": "scratch-*"},
+    {"name": "secrets", "action": "exclude", "prompt": "(?i)\\b(password|api[_ ]key)\\b"}
+  ]
+}`
+
+// configureRulesCmd represents the configure rules command
+var configureRulesCmd = &cobra.Command{
+	Use:   "rules",
+	Short: "Show the capture filter rules",
+	Long: `Capture filter rules keep directories, repositories and prompts out of the
+database. They live in capture-rules.json in the context-extender config
+directory and are evaluated by 'capture' before anything is written.
+
+Each rule has an action (include or exclude) and one or more conditions, all
+of which must match:
+  cwd         glob matching the working directory or any of its parents
+  git_remote  glob matching a git remote, normalized to host/owner/repo so
+              SSH and HTTPS remotes look the same
+  project     glob matching the project directory name
+  prompt      regular expression matching prompt text
+
+In globs, * matches within a path segment, ** across segments and ~ is your
+home directory.
+
+Rules without a prompt condition decide whether a directory is captured at
+all; the first matching rule wins, and "default" applies when none matches.
+Rules with a prompt condition then decide, again first match wins, whether a
+prompt is stored. Excluded prompts are dropped together with Claude's
+response to them.
+
+A ` + filter.IgnoreMarker + ` file turns off capture in its directory and
+everything below it, whatever the rules say.
+
+Example:
+  context-extender configure rules
+  context-extender configure rules test ~/clients/acme/api
+  context-extender configure rules test . --prompt "here is my api key"`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return showCaptureRules()
+	},
+}
+
+// configureRulesTestCmd represents the configure rules test command
+var configureRulesTestCmd = &cobra.Command{
+	Use:   "test <path>",
+	Short: "Show which capture rule applies to a directory",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		prompt, _ := cmd.Flags().GetString("prompt")
+		return testCaptureRules(args[0], prompt, cmd.Flags().Changed("prompt"))
+	},
+}
+
+func init() {
+	configureCmd.AddCommand(configureRulesCmd)
+	configureRulesCmd.AddCommand(configureRulesTestCmd)
+
+	configureRulesTestCmd.Flags().String("prompt", "", "Also test a prompt against the prompt rules")
+}
+
+func showCaptureRules() error {
+	rulesPath, err := filter.GetRulesPath()
+	if err != nil {
+		return fmt.Errorf("failed to get rules path: %w", err)
+	}
+
+	rules, err := filter.LoadRulesFrom(rulesPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Rules file: %s\n", rulesPath)
+	if rules.Path == "" {
+		fmt.Println("\n➖ No rules file, everything is captured.")
+		fmt.Printf("\nCreate %s to filter captures, for example:\n\n%s\n", rulesPath, exampleRules)
+		return nil
+	}
+
+	fmt.Printf("Default: %s\n\n", rules.Default)
+	if len(rules.Rules) == 0 {
+		fmt.Println("No rules defined.")
+		return nil
+	}
+
+	for i := range rules.Rules {
+		rule := &rules.Rules[i]
+		icon := "✅"
+		if rule.Action == filter.ActionExclude {
+			icon = "🚫"
+		}
+
+		fmt.Printf("%2d. %s %s", i+1, icon, rule.Action)
+		if rule.Name != "" {
+			fmt.Printf(" (%s)", rule.Name)
+		}
+		fmt.Println()
+		printRuleCondition("cwd", rule.CWD)
+		printRuleCondition("git_remote", rule.GitRemote)
+		printRuleCondition("project", rule.Project)
+		printRuleCondition("prompt", rule.Prompt)
+	}
+
+	return nil
+}
+
+func printRuleCondition(name, value string) {
+	if value != "" {
+		fmt.Printf("      %-11s %s\n", name+":", value)
+	}
+}
+
+func testCaptureRules(path, prompt string, testPrompt bool) error {
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve path: %w", err)
+	}
+	if info, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("failed to access %s: %w", dir, err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	rules, err := filter.LoadRules()
+	if err != nil {
+		return err
+	}
+
+	location := filter.ResolveLocation(dir)
+
+	fmt.Printf("Directory:     %s\n", location.Dir)
+	fmt.Printf("Project:       %s (%s)\n", location.Project, location.ProjectRoot)
+	if len(location.GitRemotes) > 0 {
+		fmt.Printf("Git remotes:   %s\n", strings.Join(location.GitRemotes, ", "))
+	} else {
+		fmt.Println("Git remotes:   none")
+	}
+	if location.IgnoreMarker != "" {
+		fmt.Printf("Ignore marker: %s\n", location.IgnoreMarker)
+	}
+	if rules.Path == "" {
+		fmt.Println("Rules file:    none")
+	} else {
+		fmt.Printf("Rules file:    %s\n", rules.Path)
+	}
+	fmt.Println()
+
+	printCaptureDecision("Sessions here are", rules.Evaluate(location))
+	if testPrompt {
+		printCaptureDecision("The prompt is", rules.EvaluatePrompt(location, prompt))
+	}
+
+	return nil
+}
+
+func printCaptureDecision(subject string, decision filter.Decision) {
+	if decision.Capture {
+		fmt.Printf("✅ %s captured: %s\n", subject, decision.Reason)
+	} else {
+		fmt.Printf("🚫 %s not captured: %s\n", subject, decision.Reason)
+	}
+}
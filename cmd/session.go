@@ -0,0 +1,302 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"context-extender/internal/database"
+	"context-extender/internal/retention"
+	"github.com/spf13/cobra"
+)
+
+// sessionManager is implemented by backends that can star, tag and purge
+// sessions
+type sessionManager interface {
+	SessionFootprints(ctx context.Context) ([]*database.SessionFootprint, error)
+	SetSessionStarred(ctx context.Context, id string, starred bool) error
+	TagSession(ctx context.Context, id string, tags []string) error
+	UntagSession(ctx context.Context, id string, tags []string) error
+	PurgeSessions(ctx context.Context, ids []string) (*database.PurgeResult, error)
+}
+
+var sessionCmd = &cobra.Command{
+	Use:   "session",
+	Short: "Manage captured sessions",
+	Long: `Star, tag and delete sessions. Retention policies can keep starred and
+tagged sessions when 'database purge' deletes old ones.
+
+Deleted sessions go to the trash, where they are left out of listings,
+search and exports until they are restored or the trash is emptied.`,
+}
+
+var sessionDeleteCmd = &cobra.Command{
+	Use:   "delete <session-id>...",
+	Short: "Move sessions to the trash",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		backend, closeBackend, err := openCaptureBackend(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeBackend()
+
+		for _, id := range args {
+			session, err := backend.GetSession(ctx, id)
+			if err != nil {
+				return sessionError(id, err)
+			}
+			if session.DeletedAt != nil {
+				fmt.Printf("➖ %s is already in the trash\n", id)
+				continue
+			}
+			if err := backend.DeleteSession(ctx, id); err != nil {
+				return sessionError(id, err)
+			}
+			fmt.Printf("🗑️  Moved %s to the trash\n", id)
+		}
+		fmt.Println("💡 Undo with 'context-extender session restore <session-id>'")
+		return nil
+	},
+}
+
+var sessionRestoreCmd = &cobra.Command{
+	Use:   "restore <session-id>...",
+	Short: "Take sessions back out of the trash",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		backend, closeBackend, err := openCaptureBackend(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeBackend()
+
+		for _, id := range args {
+			if err := backend.RestoreSession(ctx, id); err != nil {
+				if errors.Is(err, database.ErrSessionNotFound) {
+					return fmt.Errorf("session %s is not in the trash", id)
+				}
+				return sessionError(id, err)
+			}
+			fmt.Printf("♻️  Restored %s\n", id)
+		}
+		return nil
+	},
+}
+
+var sessionTrashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "Manage deleted sessions",
+}
+
+var sessionTrashListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the sessions in the trash",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		backend, closeBackend, err := openCaptureBackend(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeBackend()
+
+		trash, err := backend.ListTrash(ctx)
+		if err != nil {
+			return err
+		}
+		if len(trash) == 0 {
+			fmt.Println("🗑️  The trash is empty")
+			return nil
+		}
+
+		fmt.Printf("%-36s  %-20s  %-16s  %s\n", "SESSION", "PROJECT", "CREATED", "DELETED")
+		for _, session := range trash {
+			fmt.Printf("%-36s  %-20s  %-16s  %s\n", session.ID, shortProject(metadataProject(session.Metadata)),
+				session.CreatedAt.Local().Format("2006-01-02 15:04"), session.DeletedAt.Local().Format("2006-01-02 15:04"))
+		}
+		fmt.Printf("\n%d sessions in the trash\n", len(trash))
+		return nil
+	},
+}
+
+var sessionTrashEmptyCmd = &cobra.Command{
+	Use:   "empty",
+	Short: "Delete the sessions in the trash for good",
+	Long: `Delete the sessions in the trash, with everything recorded for them, and
+reclaim their space. With --older-than only sessions deleted longer ago than
+that are removed.`,
+	Example: `  context-extender session trash empty --older-than 30d`,
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		deletedBefore := time.Now()
+		if olderThan, _ := cmd.Flags().GetString("older-than"); olderThan != "" {
+			age, err := retention.ParseAge(olderThan)
+			if err != nil {
+				return fmt.Errorf("invalid --older-than: %w", err)
+			}
+			deletedBefore = deletedBefore.Add(-age)
+		}
+
+		force, _ := cmd.Flags().GetBool("force")
+		if !force && !promptContinue("⚠️  Delete the sessions in the trash for good?") {
+			fmt.Println("Cancelled")
+			return nil
+		}
+
+		ctx := cmd.Context()
+		backend, closeBackend, err := openCaptureBackend(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeBackend()
+
+		result, err := backend.EmptyTrash(ctx, deletedBefore)
+		if err != nil {
+			return fmt.Errorf("failed to empty trash: %w", err)
+		}
+		if result.Sessions == 0 {
+			fmt.Println("➖ No sessions in the trash were old enough")
+			return nil
+		}
+		fmt.Printf("✅ Deleted %d sessions and %d rows\n", result.Sessions, result.Rows)
+		if reclaimed := result.Reclaimed(); reclaimed > 0 {
+			fmt.Printf("💾 Reclaimed %s\n", formatBytes(reclaimed))
+		}
+		return nil
+	},
+}
+
+var sessionStarCmd = &cobra.Command{
+	Use:   "star <session-id>...",
+	Short: "Star sessions",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setSessionsStarred(cmd.Context(), args, true)
+	},
+}
+
+var sessionUnstarCmd = &cobra.Command{
+	Use:   "unstar <session-id>...",
+	Short: "Remove the star from sessions",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setSessionsStarred(cmd.Context(), args, false)
+	},
+}
+
+var sessionTagCmd = &cobra.Command{
+	Use:     "tag <session-id> <tag>...",
+	Short:   "Tag a session",
+	Example: `  context-extender session tag 3f2a9c keep design-review`,
+	Args:    cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		backend, closeBackend, err := openSessionManager(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeBackend()
+
+		if err := backend.TagSession(ctx, args[0], args[1:]); err != nil {
+			return sessionError(args[0], err)
+		}
+		fmt.Printf("🏷️  Tagged %s: %s\n", args[0], strings.Join(args[1:], ", "))
+		return nil
+	},
+}
+
+var sessionUntagCmd = &cobra.Command{
+	Use:   "untag <session-id> <tag>...",
+	Short: "Remove tags from a session",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		backend, closeBackend, err := openSessionManager(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeBackend()
+
+		if err := backend.UntagSession(ctx, args[0], args[1:]); err != nil {
+			return sessionError(args[0], err)
+		}
+		fmt.Printf("✅ Removed %s from %s\n", strings.Join(args[1:], ", "), args[0])
+		return nil
+	},
+}
+
+// setSessionsStarred stars or unstars sessions
+func setSessionsStarred(ctx context.Context, ids []string, starred bool) error {
+	backend, closeBackend, err := openSessionManager(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeBackend()
+
+	for _, id := range ids {
+		if err := backend.SetSessionStarred(ctx, id, starred); err != nil {
+			return sessionError(id, err)
+		}
+		if starred {
+			fmt.Printf("⭐ Starred %s\n", id)
+		} else {
+			fmt.Printf("✅ Unstarred %s\n", id)
+		}
+	}
+	return nil
+}
+
+// metadataProject returns the project recorded in session metadata
+func metadataProject(metadata string) string {
+	var fields map[string]interface{}
+	if json.Unmarshal([]byte(metadata), &fields) != nil {
+		return ""
+	}
+	project, _ := fields["project"].(string)
+	return project
+}
+
+// sessionError describes a failed session update
+func sessionError(id string, err error) error {
+	if errors.Is(err, database.ErrSessionNotFound) {
+		return fmt.Errorf("session %s not found", id)
+	}
+	return fmt.Errorf("failed to update session %s: %w", id, err)
+}
+
+// openSessionManager opens and migrates the configured database, which
+// must support managing sessions
+func openSessionManager(ctx context.Context) (sessionManager, func(), error) {
+	backend, closeBackend, err := openCaptureBackend(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	sessions, ok := backend.(sessionManager)
+	if !ok {
+		closeBackend()
+		return nil, nil, fmt.Errorf("the %s backend doesn't support managing sessions", backend.GetBackendInfo().Name)
+	}
+	return sessions, closeBackend, nil
+}
+
+func init() {
+	sessionTrashEmptyCmd.Flags().String("older-than", "", "Only delete sessions deleted longer ago than this, e.g. 30d")
+	sessionTrashEmptyCmd.Flags().BoolP("force", "f", false, "Skip the confirmation prompt")
+
+	sessionTrashCmd.AddCommand(sessionTrashListCmd)
+	sessionTrashCmd.AddCommand(sessionTrashEmptyCmd)
+	sessionCmd.AddCommand(sessionDeleteCmd)
+	sessionCmd.AddCommand(sessionRestoreCmd)
+	sessionCmd.AddCommand(sessionTrashCmd)
+	sessionCmd.AddCommand(sessionStarCmd)
+	sessionCmd.AddCommand(sessionUnstarCmd)
+	sessionCmd.AddCommand(sessionTagCmd)
+	sessionCmd.AddCommand(sessionUntagCmd)
+	rootCmd.AddCommand(sessionCmd)
+}
@@ -134,33 +134,42 @@ func confirmUninstall(keepData bool) bool {
 func uninstallHooks() error {
 	fmt.Print("🔗 Removing Claude Code hooks... ")
 
-	// Check if hooks are installed
-	installed, err := hooks.IsInstalled()
+	projectDir, err := os.Getwd()
 	if err != nil {
 		fmt.Println("❌ ERROR")
-		return fmt.Errorf("failed to check hook status: %w", err)
+		return fmt.Errorf("failed to get working directory: %w", err)
 	}
 
-	if !installed {
+	// Clean every scope, hooks may be installed per project as well
+	cleaned, err := hooks.UninstallAllScopes(projectDir)
+	if err != nil {
+		fmt.Println("❌ FAILED")
+		return err
+	}
+
+	if len(cleaned) == 0 {
 		fmt.Println("⚠️  Not installed")
 		fmt.Println("   Claude Code hooks were not found (already removed)")
 		return nil
 	}
 
-	// Remove hooks
-	if err := hooks.UninstallHooks(); err != nil {
-		fmt.Println("❌ FAILED")
-		return err
-	}
-
 	// Verify removal
-	installed, err = hooks.IsInstalled()
-	if err != nil || installed {
+	statuses, err := hooks.GetScopeStatuses(projectDir)
+	if err != nil {
 		fmt.Println("❌ VERIFICATION FAILED")
-		return fmt.Errorf("hook removal verification failed")
+		return fmt.Errorf("hook removal verification failed: %w", err)
+	}
+	for _, status := range statuses {
+		if status.HasAny() {
+			fmt.Println("❌ VERIFICATION FAILED")
+			return fmt.Errorf("hook removal verification failed")
+		}
 	}
 
 	fmt.Println("✅ SUCCESS")
+	for _, path := range cleaned {
+		fmt.Printf("   Cleaned: %s\n", path)
+	}
 	return nil
 }
 
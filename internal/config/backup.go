@@ -0,0 +1,273 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// MaxSettingsBackups is how many backups are kept for each settings file
+const MaxSettingsBackups = 20
+
+// backupManifestName is the manifest file in the backups directory
+const backupManifestName = "manifest.json"
+
+// SettingsBackup describes a saved copy of a Claude Code settings file
+type SettingsBackup struct {
+	ID           string    `json:"id"`
+	SettingsPath string    `json:"settings_path"`
+	File         string    `json:"file"`
+	CreatedAt    time.Time `json:"created_at"`
+	Reason       string    `json:"reason,omitempty"`
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256"`
+}
+
+// backupManifest lists every backup, oldest first
+type backupManifest struct {
+	Backups []SettingsBackup `json:"backups"`
+}
+
+// GetBackupsDir returns the directory settings backups are kept in
+func GetBackupsDir() (string, error) {
+	configPath, err := GetContextExtenderConfigPath()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configPath, "backups"), nil
+}
+
+// CreateBackup saves a copy of the settings file and rotates old backups.
+// It returns nil when there is no file to back up, or when the latest
+// backup already has the same content.
+func CreateBackup(settingsPath, reason string) (*SettingsBackup, error) {
+	data, err := os.ReadFile(settingsPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settings file: %w", err)
+	}
+
+	backupsDir, err := GetBackupsDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backups directory: %w", err)
+	}
+	if err := os.MkdirAll(backupsDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create backups directory: %w", err)
+	}
+
+	manifest, err := readBackupManifest(backupsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	settingsPath, _ = filepath.Abs(settingsPath)
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	if latest := manifest.latest(settingsPath); latest != nil && latest.SHA256 == checksum {
+		return nil, nil
+	}
+
+	now := time.Now()
+	backup := SettingsBackup{
+		ID:           manifest.newID(now),
+		SettingsPath: settingsPath,
+		CreatedAt:    now,
+		Reason:       reason,
+		Size:         int64(len(data)),
+		SHA256:       checksum,
+	}
+	backup.File = backup.ID + ".json"
+
+	if err := WriteFileAtomic(filepath.Join(backupsDir, backup.File), data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	manifest.Backups = append(manifest.Backups, backup)
+	removed := manifest.rotate(settingsPath, MaxSettingsBackups)
+
+	if err := writeBackupManifest(backupsDir, manifest); err != nil {
+		return nil, err
+	}
+
+	// Only delete rotated files once the manifest no longer refers to them
+	for _, old := range removed {
+		os.Remove(filepath.Join(backupsDir, old.File))
+	}
+
+	return &backup, nil
+}
+
+// ListBackups returns the backups of settingsPath, newest first. An empty
+// settingsPath lists the backups of every settings file.
+func ListBackups(settingsPath string) ([]SettingsBackup, error) {
+	backupsDir, err := GetBackupsDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backups directory: %w", err)
+	}
+
+	manifest, err := readBackupManifest(backupsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if settingsPath != "" {
+		settingsPath, _ = filepath.Abs(settingsPath)
+	}
+
+	var backups []SettingsBackup
+	for i := len(manifest.Backups) - 1; i >= 0; i-- {
+		if settingsPath == "" || manifest.Backups[i].SettingsPath == settingsPath {
+			backups = append(backups, manifest.Backups[i])
+		}
+	}
+	return backups, nil
+}
+
+// GetBackup looks up a backup by ID and returns its content
+func GetBackup(id string) (*SettingsBackup, []byte, error) {
+	backupsDir, err := GetBackupsDir()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get backups directory: %w", err)
+	}
+
+	manifest, err := readBackupManifest(backupsDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range manifest.Backups {
+		backup := manifest.Backups[i]
+		if backup.ID != id {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(backupsDir, backup.File))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read backup %s: %w", id, err)
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != backup.SHA256 {
+			return nil, nil, fmt.Errorf("backup %s is corrupted (checksum mismatch)", id)
+		}
+		return &backup, data, nil
+	}
+
+	return nil, nil, fmt.Errorf("backup %s not found", id)
+}
+
+// RestoreBackup writes a backup back to the settings file it was taken
+// from. The current settings are backed up first so the restore can be
+// undone.
+func RestoreBackup(id string) (*SettingsBackup, error) {
+	backup, data, err := GetBackup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var settings ClaudeSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("backup %s is not valid settings JSON: %w", id, err)
+	}
+
+	if _, err := CreateBackup(backup.SettingsPath, "before restore of "+id); err != nil {
+		return nil, fmt.Errorf("failed to back up current settings: %w", err)
+	}
+
+	if err := EnsureDirectoryExists(filepath.Dir(backup.SettingsPath)); err != nil {
+		return nil, fmt.Errorf("failed to create settings directory: %w", err)
+	}
+	if err := WriteFileAtomic(backup.SettingsPath, data, settingsFileMode(backup.SettingsPath)); err != nil {
+		return nil, fmt.Errorf("failed to restore settings: %w", err)
+	}
+
+	return backup, nil
+}
+
+// latest returns the newest backup of settingsPath
+func (m *backupManifest) latest(settingsPath string) *SettingsBackup {
+	for i := len(m.Backups) - 1; i >= 0; i-- {
+		if m.Backups[i].SettingsPath == settingsPath {
+			return &m.Backups[i]
+		}
+	}
+	return nil
+}
+
+// newID returns a timestamp ID that no existing backup uses
+func (m *backupManifest) newID(now time.Time) string {
+	base := now.Format("20060102-150405")
+	used := make(map[string]bool, len(m.Backups))
+	for _, backup := range m.Backups {
+		used[backup.ID] = true
+	}
+
+	id := base
+	for n := 2; used[id]; n++ {
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+	return id
+}
+
+// rotate drops all but the newest keep backups of settingsPath and returns
+// the dropped ones
+func (m *backupManifest) rotate(settingsPath string, keep int) []SettingsBackup {
+	count := 0
+	for _, backup := range m.Backups {
+		if backup.SettingsPath == settingsPath {
+			count++
+		}
+	}
+
+	var kept, removed []SettingsBackup
+	for _, backup := range m.Backups {
+		if backup.SettingsPath == settingsPath && count > keep {
+			removed = append(removed, backup)
+			count--
+			continue
+		}
+		kept = append(kept, backup)
+	}
+	m.Backups = kept
+	return removed
+}
+
+func readBackupManifest(backupsDir string) (*backupManifest, error) {
+	data, err := os.ReadFile(filepath.Join(backupsDir, backupManifestName))
+	if os.IsNotExist(err) {
+		return &backupManifest{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup manifest: %w", err)
+	}
+
+	var manifest backupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse backup manifest: %w", err)
+	}
+
+	sort.SliceStable(manifest.Backups, func(i, j int) bool {
+		return manifest.Backups[i].CreatedAt.Before(manifest.Backups[j].CreatedAt)
+	})
+	return &manifest, nil
+}
+
+func writeBackupManifest(backupsDir string, manifest *backupManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal backup manifest: %w", err)
+	}
+
+	if err := WriteFileAtomic(filepath.Join(backupsDir, backupManifestName), data, 0600); err != nil {
+		return fmt.Errorf("failed to write backup manifest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupBackups isolates the config directory and returns a settings path
+func setupBackups(t *testing.T) string {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("APPDATA", filepath.Join(home, "AppData"))
+
+	return filepath.Join(home, "settings.json")
+}
+
+func TestWriteSettingsBacksUpAndRestores(t *testing.T) {
+	settingsPath := setupBackups(t)
+
+	original := []byte(`{"model":"opus"}`)
+	if err := os.WriteFile(settingsPath, original, 0600); err != nil {
+		t.Fatalf("Failed to write settings: %v", err)
+	}
+
+	settings, err := ReadClaudeSettingsAt(settingsPath)
+	if err != nil {
+		t.Fatalf("Failed to read settings: %v", err)
+	}
+	settings.Hooks = map[string][]HookEntry{"Stop": {{Hooks: []HookConfig{{Type: "command", Command: "true"}}}}}
+	if err := WriteClaudeSettingsAt(settingsPath, settings); err != nil {
+		t.Fatalf("Failed to write settings: %v", err)
+	}
+
+	info, err := os.Stat(settingsPath)
+	if err != nil {
+		t.Fatalf("Failed to stat settings: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected file mode 0600 to be preserved, got %v", info.Mode().Perm())
+	}
+
+	backups, err := ListBackups(settingsPath)
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("Expected 1 backup, got %d", len(backups))
+	}
+
+	// Writing the same settings again changes nothing and makes no backup
+	if err := WriteClaudeSettingsAt(settingsPath, settings); err != nil {
+		t.Fatalf("Failed to write settings: %v", err)
+	}
+	if backups, _ := ListBackups(settingsPath); len(backups) != 1 {
+		t.Errorf("Expected no new backup for unchanged settings, got %d", len(backups))
+	}
+
+	if _, err := RestoreBackup(backups[0].ID); err != nil {
+		t.Fatalf("Failed to restore backup: %v", err)
+	}
+	restored, err := os.ReadFile(settingsPath)
+	if err != nil {
+		t.Fatalf("Failed to read restored settings: %v", err)
+	}
+	if string(restored) != string(original) {
+		t.Errorf("Expected original settings after restore, got %s", restored)
+	}
+
+	// The settings replaced by the restore were backed up too
+	if backups, _ := ListBackups(settingsPath); len(backups) != 2 {
+		t.Errorf("Expected 2 backups after restore, got %d", len(backups))
+	}
+}
+
+func TestBackupRotation(t *testing.T) {
+	settingsPath := setupBackups(t)
+
+	for i := 0; i < MaxSettingsBackups+5; i++ {
+		if err := os.WriteFile(settingsPath, []byte(strings.Repeat("x", i+1)), 0644); err != nil {
+			t.Fatalf("Failed to write settings: %v", err)
+		}
+		if _, err := CreateBackup(settingsPath, "test"); err != nil {
+			t.Fatalf("Failed to create backup: %v", err)
+		}
+	}
+
+	backups, err := ListBackups(settingsPath)
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(backups) != MaxSettingsBackups {
+		t.Fatalf("Expected %d backups, got %d", MaxSettingsBackups, len(backups))
+	}
+	if backups[0].Size != int64(MaxSettingsBackups+5) {
+		t.Errorf("Expected the newest backup first, got size %d", backups[0].Size)
+	}
+
+	backupsDir, _ := GetBackupsDir()
+	files, _ := filepath.Glob(filepath.Join(backupsDir, "*.json"))
+	if len(files) != MaxSettingsBackups+1 { // plus the manifest
+		t.Errorf("Expected rotated backup files to be deleted, found %d files", len(files))
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	from := []byte("a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n")
+	to := []byte("a\nb\nC\nd\ne\nf\ng\nh\ni\nj\nk\n")
+
+	// Changes more than 6 lines apart get separate hunks, as with diff -u
+	expected := `--- old
++++ new
+@@ -1,6 +1,6 @@
+ a
+ b
+-c
++C
+ d
+ e
+ f
+@@ -8,3 +8,4 @@
+ h
+ i
+ j
++k
+`
+	if diff := UnifiedDiff("old", "new", from, to); diff != expected {
+		t.Errorf("Unexpected diff:\n%s", diff)
+	}
+
+	if diff := UnifiedDiff("old", "new", from, from); diff != "" {
+		t.Errorf("Expected no diff for identical input, got:\n%s", diff)
+	}
+}
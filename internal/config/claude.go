@@ -6,7 +6,6 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"time"
 )
 
 // HookConfig represents a single hook configuration
@@ -78,13 +77,18 @@ func (cs *ClaudeSettings) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-// ReadClaudeSettings reads and parses Claude Code's settings.json
+// ReadClaudeSettings reads and parses Claude Code's user settings.json
 func ReadClaudeSettings() (*ClaudeSettings, error) {
 	settingsPath, err := GetClaudeSettingsPath()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get Claude settings path: %w", err)
 	}
 
+	return ReadClaudeSettingsAt(settingsPath)
+}
+
+// ReadClaudeSettingsAt reads and parses the Claude Code settings file at settingsPath
+func ReadClaudeSettingsAt(settingsPath string) (*ClaudeSettings, error) {
 	// Check if file exists
 	if _, err := os.Stat(settingsPath); os.IsNotExist(err) {
 		// Return empty settings if file doesn't exist
@@ -124,15 +128,45 @@ func ReadClaudeSettings() (*ClaudeSettings, error) {
 	return &settings, nil
 }
 
-// WriteClaudeSettings writes Claude Code settings with backup
+// WriteClaudeSettings writes Claude Code user settings with backup
 func WriteClaudeSettings(settings *ClaudeSettings) error {
 	settingsPath, err := GetClaudeSettingsPath()
 	if err != nil {
 		return fmt.Errorf("failed to get Claude settings path: %w", err)
 	}
 
+	return WriteClaudeSettingsAt(settingsPath, settings)
+}
+
+// SettingsDiffOutput receives a unified diff of every settings change
+// before it is written. It is nil, and changes are written silently, unless
+// a command sets it.
+var SettingsDiffOutput io.Writer
+
+// WriteClaudeSettingsAt writes the Claude Code settings file at settingsPath,
+// backing up the previous version first
+func WriteClaudeSettingsAt(settingsPath string, settings *ClaudeSettings) error {
+	// Marshal settings to JSON with pretty printing
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal settings: %w", err)
+	}
+	data = append(data, '\n')
+
+	current, err := os.ReadFile(settingsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read settings file: %w", err)
+	}
+	if string(current) == string(data) {
+		return nil
+	}
+
+	if SettingsDiffOutput != nil {
+		fmt.Fprint(SettingsDiffOutput, UnifiedDiff(settingsPath, settingsPath, current, data))
+	}
+
 	// Create backup before writing
-	if err := CreateBackup(settingsPath); err != nil {
+	if _, err := CreateBackup(settingsPath, "before settings update"); err != nil {
 		return fmt.Errorf("failed to create backup: %w", err)
 	}
 
@@ -141,60 +175,68 @@ func WriteClaudeSettings(settings *ClaudeSettings) error {
 		return fmt.Errorf("failed to create settings directory: %w", err)
 	}
 
-	// Marshal settings to JSON with pretty printing
-	data, err := json.MarshalIndent(settings, "", "  ")
+	if err := WriteFileAtomic(settingsPath, data, settingsFileMode(settingsPath)); err != nil {
+		return fmt.Errorf("failed to write settings file: %w", err)
+	}
+
+	return nil
+}
+
+// WriteFileAtomic writes data to path so that readers see either the old or
+// the new content, never a truncated file: the data is written and synced to
+// a temporary file in the same directory, which is then renamed over path.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	temp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
 	if err != nil {
-		return fmt.Errorf("failed to marshal settings: %w", err)
+		return fmt.Errorf("failed to create temporary file: %w", err)
 	}
+	tempPath := temp.Name()
+
+	// Clean up temp file on failure
+	committed := false
+	defer func() {
+		if !committed {
+			temp.Close()
+			os.Remove(tempPath)
+		}
+	}()
 
-	// Write atomically using a temporary file
-	tempPath := settingsPath + ".tmp"
-	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+	if _, err := temp.Write(data); err != nil {
 		return fmt.Errorf("failed to write temporary file: %w", err)
 	}
+	if err := temp.Chmod(perm); err != nil {
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+	if err := temp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync temporary file: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
 
 	// Atomic rename
-	if err := os.Rename(tempPath, settingsPath); err != nil {
-		// Clean up temp file on failure
-		os.Remove(tempPath)
+	if err := os.Rename(tempPath, path); err != nil {
 		return fmt.Errorf("failed to rename temporary file: %w", err)
 	}
+	committed = true
 
-	return nil
-}
-
-// CreateBackup creates a timestamped backup of the settings file
-func CreateBackup(settingsPath string) error {
-	// Check if original file exists
-	if _, err := os.Stat(settingsPath); os.IsNotExist(err) {
-		// No file to backup
-		return nil
+	// Persist the rename; not supported on every platform, so best effort
+	if dirFile, err := os.Open(dir); err == nil {
+		dirFile.Sync()
+		dirFile.Close()
 	}
 
-	// Create backup filename with timestamp
-	timestamp := time.Now().Format("20060102-150405")
-	backupPath := fmt.Sprintf("%s.backup.%s", settingsPath, timestamp)
-
-	// Copy file
-	return copyFile(settingsPath, backupPath)
+	return nil
 }
 
-// copyFile copies a file from src to dst
-func copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer sourceFile.Close()
-
-	destFile, err := os.Create(dst)
-	if err != nil {
-		return err
+// settingsFileMode returns the permissions of an existing settings file, so
+// rewriting it doesn't change them
+func settingsFileMode(settingsPath string) os.FileMode {
+	if info, err := os.Stat(settingsPath); err == nil {
+		return info.Mode().Perm()
 	}
-	defer destFile.Close()
-
-	_, err = io.Copy(destFile, sourceFile)
-	return err
+	return 0644
 }
 
 // ValidateClaudeInstallation checks if Claude Code appears to be installed
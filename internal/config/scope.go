@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SettingsScope identifies which Claude Code settings file hooks live in
+type SettingsScope string
+
+const (
+	// ScopeUser is the user's settings file, applying to every project
+	ScopeUser SettingsScope = "user"
+	// ScopeProject is <repo>/.claude/settings.json, usually checked in
+	ScopeProject SettingsScope = "project"
+	// ScopeLocal is <repo>/.claude/settings.local.json, usually git-ignored
+	ScopeLocal SettingsScope = "local"
+)
+
+// SettingsScopes lists every scope, from broadest to narrowest
+var SettingsScopes = []SettingsScope{ScopeUser, ScopeProject, ScopeLocal}
+
+// ParseSettingsScope validates a scope name
+func ParseSettingsScope(name string) (SettingsScope, error) {
+	for _, scope := range SettingsScopes {
+		if string(scope) == name {
+			return scope, nil
+		}
+	}
+	return "", fmt.Errorf("invalid scope %q (expected user, project or local)", name)
+}
+
+// GetScopedSettingsPath returns the settings file for a scope. Project and
+// local scopes are resolved relative to the project containing projectDir.
+func GetScopedSettingsPath(scope SettingsScope, projectDir string) (string, error) {
+	switch scope {
+	case ScopeUser:
+		return GetClaudeSettingsPath()
+	case ScopeProject:
+		return filepath.Join(FindProjectRoot(projectDir), ".claude", "settings.json"), nil
+	case ScopeLocal:
+		return filepath.Join(FindProjectRoot(projectDir), ".claude", "settings.local.json"), nil
+	default:
+		return "", fmt.Errorf("invalid scope %q", scope)
+	}
+}
+
+// FindProjectRoot returns the closest directory at or above dir that
+// contains a .git or .claude directory, or dir itself when there is none
+func FindProjectRoot(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return dir
+	}
+
+	home, _ := os.UserHomeDir()
+	for current := dir; ; {
+		if _, err := os.Stat(filepath.Join(current, ".git")); err == nil {
+			return current
+		}
+		// ~/.claude holds user settings, not project settings
+		if current != home {
+			if info, err := os.Stat(filepath.Join(current, ".claude")); err == nil && info.IsDir() {
+				return current
+			}
+		}
+
+		parent := filepath.Dir(current)
+		if parent == current {
+			return dir
+		}
+		current = parent
+	}
+}
@@ -0,0 +1,69 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ErrUnavailable is returned when no daemon accepted the connection. Nothing
+// was sent, so the caller can safely handle the request itself.
+var ErrUnavailable = errors.New("daemon not running")
+
+// dialTimeout keeps the capture fast path cheap when no daemon is running
+const dialTimeout = 200 * time.Millisecond
+
+// requestTimeout stays below the hook timeout so a stuck daemon can't hang Claude
+const requestTimeout = 25 * time.Second
+
+// Forward sends a request to the daemon and returns its response. Errors
+// wrapping ErrUnavailable mean the request never reached a daemon.
+func Forward(socketPath string, req *Request) (*Response, error) {
+	conn, err := net.DialTimeout("unix", socketPath, dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode daemon request: %w", err)
+	}
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to send daemon request: %w", err)
+	}
+
+	var response Response
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to read daemon response: %w", err)
+	}
+
+	return &response, nil
+}
+
+// Ping returns the status of the daemon listening on socketPath
+func Ping(socketPath string) (*Status, error) {
+	return statusRequest(socketPath, OpPing)
+}
+
+// Stop asks the daemon listening on socketPath to shut down
+func Stop(socketPath string) (*Status, error) {
+	return statusRequest(socketPath, OpStop)
+}
+
+// statusRequest sends an operation that answers with the daemon status
+func statusRequest(socketPath, op string) (*Status, error) {
+	response, err := Forward(socketPath, &Request{Op: op})
+	if err != nil {
+		return nil, err
+	}
+	if !response.OK {
+		return nil, fmt.Errorf("daemon %s failed: %s", op, response.Error)
+	}
+	return response.Status, nil
+}
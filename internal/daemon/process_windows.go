@@ -0,0 +1,16 @@
+//go:build windows
+
+package daemon
+
+import "syscall"
+
+const (
+	detachedProcess       = 0x00000008
+	createNewProcessGroup = 0x00000200
+)
+
+// DetachedProcAttr returns process attributes that detach the daemon from
+// the console that started it
+func DetachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: detachedProcess | createNewProcessGroup}
+}
@@ -0,0 +1,67 @@
+package daemon
+
+import (
+	"errors"
+	"path/filepath"
+	"time"
+)
+
+// Request operations
+const (
+	OpCapture = "capture"
+	OpPing    = "ping"
+	OpStop    = "stop"
+)
+
+// SocketName is the name of the daemon socket in the storage directory
+const SocketName = "daemon.sock"
+
+// PIDFileName is the name of the daemon PID file in the storage directory
+const PIDFileName = "daemon.pid"
+
+// ErrInvalidInput marks handler errors caused by the request itself.
+// Retrying them can't succeed, so the client mustn't spool them.
+var ErrInvalidInput = errors.New("invalid capture input")
+
+// Request is a single request sent to the daemon. Each connection carries
+// one newline-terminated JSON request followed by one response.
+type Request struct {
+	Op             string    `json:"op"`
+	Event          string    `json:"event,omitempty"`
+	Data           string    `json:"data,omitempty"`
+	Payload        []byte    `json:"payload,omitempty"`
+	SessionID      string    `json:"session_id,omitempty"`
+	CWD            string    `json:"cwd,omitempty"`
+	TranscriptPath string    `json:"transcript_path,omitempty"`
+	PID            int       `json:"pid,omitempty"`
+	CapturedAt     time.Time `json:"captured_at,omitempty"`
+}
+
+// Response is the daemon's answer to a request
+type Response struct {
+	OK         bool    `json:"ok"`
+	Output     string  `json:"output,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	InputError bool    `json:"input_error,omitempty"` // the request can't succeed when retried
+	Status     *Status `json:"status,omitempty"`
+}
+
+// Status describes a running daemon
+type Status struct {
+	PID         int           `json:"pid"`
+	StartedAt   time.Time     `json:"started_at"`
+	Requests    int64         `json:"requests"`
+	IdleTimeout time.Duration `json:"idle_timeout"`
+	Socket      string        `json:"socket"`
+	Ephemeral   bool          `json:"ephemeral,omitempty"` // events are kept in memory only
+}
+
+// SocketPath returns the daemon socket path for a storage directory
+func SocketPath(baseDir string) string {
+	return filepath.Join(baseDir, SocketName)
+}
+
+// PIDPath returns the daemon PID file path for a storage directory
+func PIDPath(baseDir string) string {
+	return filepath.Join(baseDir, PIDFileName)
+}
@@ -0,0 +1,246 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultIdleTimeout is how long the daemon waits without requests before exiting
+const DefaultIdleTimeout = 10 * time.Minute
+
+// connectionTimeout bounds how long a single connection may stay open
+const connectionTimeout = time.Minute
+
+// Handler processes a capture request and returns the hook output
+type Handler func(ctx context.Context, req *Request) (string, error)
+
+// ServerConfig holds configuration for the daemon server
+type ServerConfig struct {
+	SocketPath  string
+	PIDPath     string
+	IdleTimeout time.Duration // zero disables idle auto-exit
+	Handler     Handler
+	Ephemeral   bool // the handler stores events in memory only
+}
+
+// Server accepts capture requests on a Unix domain socket
+type Server struct {
+	config    *ServerConfig
+	listener  net.Listener
+	startedAt time.Time
+
+	requests   int64
+	active     int64
+	lastActive int64 // unix nanoseconds
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewServer creates a new daemon server
+func NewServer(config *ServerConfig) *Server {
+	return &Server{
+		config: config,
+		done:   make(chan struct{}),
+	}
+}
+
+// Serve listens on the socket and handles requests until Shutdown is called
+// or the idle timeout expires. The socket and PID file are removed on return.
+func (s *Server) Serve() error {
+	if err := removeStaleSocket(s.config.SocketPath); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("unix", s.config.SocketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.config.SocketPath, err)
+	}
+	defer os.Remove(s.config.SocketPath)
+
+	// Hooks run as the same user; nobody else gets to write events
+	if err := os.Chmod(s.config.SocketPath, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+
+	if s.config.PIDPath != "" {
+		if err := os.WriteFile(s.config.PIDPath, []byte(strconv.Itoa(os.Getpid())), 0600); err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to write PID file: %w", err)
+		}
+		defer os.Remove(s.config.PIDPath)
+	}
+
+	s.listener = listener
+	s.startedAt = time.Now()
+	s.touch()
+
+	if s.config.IdleTimeout > 0 {
+		go s.watchIdle()
+	}
+
+	go func() {
+		<-s.done
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				s.wg.Wait()
+				return nil
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			s.Shutdown()
+			s.wg.Wait()
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+
+		atomic.AddInt64(&s.active, 1)
+		s.wg.Add(1)
+		go s.handleConnection(conn)
+	}
+}
+
+// Shutdown stops accepting connections. Requests in flight are completed
+// before Serve returns.
+func (s *Server) Shutdown() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// Done is closed when the server starts shutting down
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// Status returns the current daemon status
+func (s *Server) Status() *Status {
+	return &Status{
+		PID:         os.Getpid(),
+		StartedAt:   s.startedAt,
+		Requests:    atomic.LoadInt64(&s.requests),
+		IdleTimeout: s.config.IdleTimeout,
+		Socket:      s.config.SocketPath,
+		Ephemeral:   s.config.Ephemeral,
+	}
+}
+
+// handleConnection serves the single request carried by a connection
+func (s *Server) handleConnection(conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+	defer func() {
+		s.touch()
+		atomic.AddInt64(&s.active, -1)
+	}()
+
+	conn.SetDeadline(time.Now().Add(connectionTimeout))
+
+	var req Request
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		writeResponse(conn, &Response{Error: fmt.Sprintf("invalid request: %v", err), InputError: true})
+		return
+	}
+
+	response := s.process(&req)
+	if err := writeResponse(conn, response); err != nil {
+		log.Printf("Warning: failed to write daemon response: %v", err)
+	}
+
+	if req.Op == OpStop {
+		s.Shutdown()
+	}
+}
+
+// process runs a request and builds its response
+func (s *Server) process(req *Request) *Response {
+	switch req.Op {
+	case OpPing, OpStop:
+		return &Response{OK: true, Status: s.Status()}
+	case OpCapture:
+		atomic.AddInt64(&s.requests, 1)
+		output, err := s.config.Handler(context.Background(), req)
+		response := &Response{OK: err == nil, Output: output}
+		if err != nil {
+			response.Error = err.Error()
+			response.InputError = errors.Is(err, ErrInvalidInput)
+		}
+		return response
+	default:
+		return &Response{Error: fmt.Sprintf("unknown operation: %s", req.Op), InputError: true}
+	}
+}
+
+// touch records activity for the idle timer
+func (s *Server) touch() {
+	atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+}
+
+// watchIdle shuts the server down once no request arrived for IdleTimeout
+func (s *Server) watchIdle() {
+	interval := s.config.IdleTimeout / 4
+	if interval > time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if atomic.LoadInt64(&s.active) > 0 {
+				continue
+			}
+			idle := time.Since(time.Unix(0, atomic.LoadInt64(&s.lastActive)))
+			if idle >= s.config.IdleTimeout {
+				log.Printf("Daemon idle for %s, shutting down", idle.Round(time.Second))
+				s.Shutdown()
+				return
+			}
+		}
+	}
+}
+
+// writeResponse writes a newline-terminated JSON response
+func writeResponse(conn net.Conn, response *Response) error {
+	return json.NewEncoder(conn).Encode(response)
+}
+
+// removeStaleSocket removes a socket left behind by a daemon that didn't
+// shut down cleanly, and refuses to start when a daemon is still listening
+func removeStaleSocket(socketPath string) error {
+	if _, err := os.Stat(socketPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	if conn, err := net.DialTimeout("unix", socketPath, dialTimeout); err == nil {
+		conn.Close()
+		return fmt.Errorf("daemon already running on %s", socketPath)
+	}
+
+	if err := os.Remove(socketPath); err != nil {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	return nil
+}
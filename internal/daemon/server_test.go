@@ -0,0 +1,151 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startTestServer runs a server on a temporary socket until the test ends
+func startTestServer(t *testing.T, config *ServerConfig) (*Server, <-chan error) {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "ced")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	config.SocketPath = SocketPath(dir)
+	config.PIDPath = PIDPath(dir)
+	server := NewServer(config)
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- server.Serve() }()
+	t.Cleanup(server.Shutdown)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := Ping(config.SocketPath); err == nil {
+			return server, errCh
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Server did not start")
+	return nil, nil
+}
+
+func TestForwardCapture(t *testing.T) {
+	handler := func(ctx context.Context, req *Request) (string, error) {
+		switch req.Event {
+		case "fail":
+			return "partial", errors.New("handler failed")
+		case "invalid":
+			return "", fmt.Errorf("%w: no session", ErrInvalidInput)
+		}
+		return "captured " + req.SessionID + " " + string(req.Payload), nil
+	}
+	server, _ := startTestServer(t, &ServerConfig{Handler: handler})
+	socketPath := server.config.SocketPath
+
+	response, err := Forward(socketPath, &Request{Op: OpCapture, Event: "user-prompt", SessionID: "s1", Payload: []byte(`{"a":1}`)})
+	if err != nil {
+		t.Fatalf("Failed to forward: %v", err)
+	}
+	if !response.OK || response.Output != `captured s1 {"a":1}` {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+
+	response, err = Forward(socketPath, &Request{Op: OpCapture, Event: "fail"})
+	if err != nil {
+		t.Fatalf("Failed to forward: %v", err)
+	}
+	if response.OK || response.Error != "handler failed" || response.Output != "partial" || response.InputError {
+		t.Errorf("Expected handler error to be returned, got: %+v", response)
+	}
+
+	// Input errors are flagged so the client doesn't spool them
+	response, err = Forward(socketPath, &Request{Op: OpCapture, Event: "invalid"})
+	if err != nil {
+		t.Fatalf("Failed to forward: %v", err)
+	}
+	if response.OK || !response.InputError {
+		t.Errorf("Expected an input error, got: %+v", response)
+	}
+
+	status, err := Ping(socketPath)
+	if err != nil {
+		t.Fatalf("Failed to ping: %v", err)
+	}
+	if status.Requests != 3 || status.PID != os.Getpid() {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+
+	pid, err := os.ReadFile(server.config.PIDPath)
+	if err != nil || len(pid) == 0 {
+		t.Errorf("Expected PID file, got %q (%v)", pid, err)
+	}
+}
+
+func TestStopRemovesSocket(t *testing.T) {
+	server, errCh := startTestServer(t, &ServerConfig{})
+	socketPath := server.config.SocketPath
+
+	if _, err := Stop(socketPath); err != nil {
+		t.Fatalf("Failed to stop: %v", err)
+	}
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("Serve returned error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Server did not stop")
+	}
+
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("Expected socket to be removed, got: %v", err)
+	}
+	if _, err := Ping(socketPath); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable after stop, got: %v", err)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	_, errCh := startTestServer(t, &ServerConfig{IdleTimeout: 100 * time.Millisecond})
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("Serve returned error: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Server did not exit after idle timeout")
+	}
+}
+
+func TestStaleSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "ced")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A socket file nobody listens on, as left behind by a crash
+	socketPath := filepath.Join(dir, SocketName)
+	if err := os.WriteFile(socketPath, nil, 0600); err != nil {
+		t.Fatalf("Failed to create stale socket: %v", err)
+	}
+
+	if err := removeStaleSocket(socketPath); err != nil {
+		t.Fatalf("Failed to remove stale socket: %v", err)
+	}
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("Expected stale socket to be removed")
+	}
+}
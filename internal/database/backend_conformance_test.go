@@ -0,0 +1,894 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runBackendConformance checks the behaviour every DatabaseBackend must
+// share. newBackend returns an initialized backend with the schema created.
+func runBackendConformance(t *testing.T, newBackend func(t *testing.T) DatabaseBackend) {
+	ctx := context.Background()
+	base := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Schema", func(t *testing.T) {
+		backend := newBackend(t)
+
+		version, err := backend.GetSchemaVersion(ctx)
+		if err != nil || version != SchemaVersion {
+			t.Fatalf("Expected schema version %d, got %d (%v)", SchemaVersion, version, err)
+		}
+		plan, err := backend.PlanMigration(ctx, SchemaVersion)
+		if err != nil {
+			t.Fatalf("Failed to plan migration: %v", err)
+		}
+		if plan.Required() {
+			t.Errorf("Expected an up to date schema to need no migration, got %+v", plan)
+		}
+		states, err := backend.MigrationStatus(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get migration status: %v", err)
+		}
+		if len(states) != len(migrations) {
+			t.Fatalf("Expected %d migrations, got %d", len(migrations), len(states))
+		}
+		for _, state := range states {
+			if state.Status != MigrationApplied {
+				t.Errorf("Expected migration %d to be applied, got %s", state.Version, state.Status)
+			}
+		}
+		if _, err := backend.PlanMigration(ctx, SchemaVersion+1); err == nil {
+			t.Error("Expected an error planning an unknown version")
+		}
+		if err := backend.Ping(ctx); err != nil {
+			t.Errorf("Ping failed: %v", err)
+		}
+	})
+
+	t.Run("Sessions", func(t *testing.T) {
+		backend := newBackend(t)
+
+		for i, status := range []string{"active", "completed", "active", "completed"} {
+			created := base.Add(time.Duration(i) * time.Hour)
+			session := &Session{ID: fmt.Sprintf("s%d", i), CreatedAt: created, UpdatedAt: created, Status: status, Metadata: "{}"}
+			if err := backend.CreateSession(ctx, session); err != nil {
+				t.Fatalf("Failed to create session: %v", err)
+			}
+		}
+
+		err := backend.CreateSession(ctx, &Session{ID: "s0", CreatedAt: base, UpdatedAt: base, Status: "active"})
+		if !IsUniqueViolation(err) {
+			t.Errorf("Expected a unique violation for a duplicate session, got %v", err)
+		}
+		if _, err := backend.GetSession(ctx, "missing"); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("Expected ErrSessionNotFound, got %v", err)
+		}
+
+		updated := base.Add(24 * time.Hour)
+		if err := backend.UpdateSession(ctx, &Session{ID: "s1", UpdatedAt: updated, Status: "archived", Metadata: `{"project":"demo"}`}); err != nil {
+			t.Fatalf("Failed to update session: %v", err)
+		}
+		session, err := backend.GetSession(ctx, "s1")
+		if err != nil {
+			t.Fatalf("Failed to get session: %v", err)
+		}
+		if session.Status != "archived" || session.Metadata != `{"project":"demo"}` || !session.UpdatedAt.Equal(updated) || !session.CreatedAt.Equal(base.Add(time.Hour)) {
+			t.Errorf("Unexpected updated session: %+v", session)
+		}
+		if err := backend.UpdateSession(ctx, &Session{ID: "missing", Status: "archived"}); err != nil {
+			t.Errorf("Expected updating a missing session to be a no-op, got %v", err)
+		}
+
+		after := base.Add(30 * time.Minute)
+		for name, test := range map[string]struct {
+			filters  *SessionFilters
+			expected []string
+		}{
+			"status":         {&SessionFilters{Status: "active", SortBy: "created_at"}, []string{"s0", "s2"}},
+			"created after":  {&SessionFilters{CreatedAfter: &after, SortBy: "created_at"}, []string{"s1", "s2", "s3"}},
+			"created before": {&SessionFilters{CreatedBefore: &after, SortBy: "created_at"}, []string{"s0"}},
+			"newest first":   {&SessionFilters{SortBy: "created_at", SortOrder: "DESC"}, []string{"s3", "s2", "s1", "s0"}},
+			"page":           {&SessionFilters{SortBy: "created_at", Limit: 2, Offset: 1}, []string{"s1", "s2"}},
+			"past the end":   {&SessionFilters{SortBy: "created_at", Limit: 2, Offset: 10}, nil},
+			"project":        {&SessionFilters{Project: "DEMO"}, []string{"s1"}},
+			"other project":  {&SessionFilters{Project: "dem"}, nil},
+		} {
+			sessions, err := backend.ListSessions(ctx, test.filters)
+			if err != nil {
+				t.Errorf("%s: failed to list sessions: %v", name, err)
+				continue
+			}
+			if got := sessionIDs(sessions); strings.Join(got, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("%s: expected %v, got %v", name, test.expected, got)
+			}
+		}
+		if sessions, err := backend.ListSessions(ctx, nil); err != nil || len(sessions) != 4 {
+			t.Errorf("Expected all 4 sessions without filters, got %d (%v)", len(sessions), err)
+		}
+	})
+
+	t.Run("SessionQueries", func(t *testing.T) {
+		backend := newBackend(t)
+
+		dirs := []string{"/work/api", "/work/api/cmd", "/work/apiary", "/home/notes"}
+		for i, dir := range dirs {
+			created := base.Add(time.Duration(i) * time.Hour)
+			session := &Session{ID: fmt.Sprintf("s%d", i), CreatedAt: created, UpdatedAt: created, Status: []string{"active", "completed"}[i%2],
+				Metadata: fmt.Sprintf(`{"project":"p%d","working_directory":%q}`, i%2, dir)}
+			if err := backend.CreateSession(ctx, session); err != nil {
+				t.Fatalf("Failed to create session: %v", err)
+			}
+			// s0 has one message, s3 four
+			for j := 0; j <= i; j++ {
+				conv := &Conversation{ID: fmt.Sprintf("c%d-%d", i, j), SessionID: session.ID, MessageType: "assistant", Content: fmt.Sprintf("message %d", j),
+					Timestamp: created, Metadata: "{}", TokenCount: 100, Model: []string{"opus", "sonnet"}[j%2]}
+				if err := backend.CreateConversation(ctx, conv); err != nil {
+					t.Fatalf("Failed to create conversation: %v", err)
+				}
+			}
+		}
+		createTestConversation(t, backend, "s2", "needle", "the deployment pipeline broke")
+
+		session, err := backend.GetSession(ctx, "s3")
+		if err != nil || session.MessageCount != 4 || session.TokenCount != 400 {
+			t.Errorf("Expected s3 to count 4 messages and 400 tokens, got %+v (%v)", session, err)
+		}
+
+		for name, test := range map[string]struct {
+			filters  *SessionFilters
+			expected []string
+		}{
+			"statuses":       {&SessionFilters{Statuses: []string{"completed", "archived"}}, []string{"s1", "s3"}},
+			"working dir":    {&SessionFilters{WorkingDir: "/work/api/"}, []string{"s0", "s1"}},
+			"model":          {&SessionFilters{Model: "sonnet"}, []string{"s1", "s2", "s3"}},
+			"min messages":   {&SessionFilters{MinMessages: 3}, []string{"s2", "s3"}},
+			"message range":  {&SessionFilters{MinMessages: 2, MaxMessages: 2}, []string{"s1"}},
+			"token range":    {&SessionFilters{MinTokens: 150, MaxTokens: 300}, []string{"s1", "s2"}},
+			"text":           {&SessionFilters{Text: "deployment"}, []string{"s2"}},
+			"combined":       {&SessionFilters{Project: "p0", Model: "sonnet", MinTokens: 300}, []string{"s2"}},
+			"most messages":  {&SessionFilters{SortBy: "message_count", SortOrder: "DESC", Limit: 2}, []string{"s3", "s2"}},
+			"by status, id":  {&SessionFilters{SortBy: "status"}, []string{"s0", "s2", "s1", "s3"}},
+			"nothing passes": {&SessionFilters{MaxMessages: 1, Model: "sonnet"}, nil},
+		} {
+			sessions, err := backend.ListSessions(ctx, test.filters)
+			if err != nil {
+				t.Errorf("%s: failed to list sessions: %v", name, err)
+				continue
+			}
+			if got := sessionIDs(sessions); strings.Join(got, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("%s: expected %v, got %v", name, test.expected, got)
+			}
+		}
+		if _, err := backend.ListSessions(ctx, &SessionFilters{SortBy: "metadata; DROP TABLE sessions"}); err == nil {
+			t.Error("Expected an error sorting by an unknown column")
+		}
+
+		// Paging with the cursor visits every session once, in order
+		for _, sortOrder := range []string{"ASC", "DESC"} {
+			filters := &SessionFilters{SortBy: "status", SortOrder: sortOrder, Limit: 3}
+			var got []string
+			for pages := 0; ; pages++ {
+				page, err := backend.ListSessionPage(ctx, filters)
+				if err != nil || pages > 2 {
+					t.Fatalf("Failed to page through sessions: %v", err)
+				}
+				got = append(got, sessionIDs(page.Sessions)...)
+				if page.NextCursor == "" {
+					break
+				}
+				filters.Cursor = page.NextCursor
+			}
+			expected := "s0,s2,s1,s3"
+			if sortOrder == "DESC" {
+				expected = "s3,s1,s2,s0"
+			}
+			if strings.Join(got, ",") != expected {
+				t.Errorf("%s: expected %s across pages, got %v", sortOrder, expected, got)
+			}
+		}
+
+		// Sessions gaining messages between pages don't move the cursor
+		first, err := backend.ListSessionPage(ctx, &SessionFilters{SortBy: "message_count", SortOrder: "DESC", Limit: 2})
+		if err != nil || strings.Join(sessionIDs(first.Sessions), ",") != "s3,s2" {
+			t.Fatalf("Expected s3 and s2 first, got %+v (%v)", first, err)
+		}
+		createTestConversation(t, backend, "s2", "late-1", "captured while paging")
+		createTestConversation(t, backend, "s2", "late-2", "captured while paging")
+		rest, err := backend.ListSessionPage(ctx, &SessionFilters{SortBy: "message_count", SortOrder: "DESC", Limit: 2, Cursor: first.NextCursor})
+		if err != nil || strings.Join(sessionIDs(rest.Sessions), ",") != "s1,s0" {
+			t.Errorf("Expected s1 and s0 next, got %+v (%v)", rest, err)
+		}
+
+		page, err := backend.ListSessionPage(ctx, &SessionFilters{Limit: 2})
+		if err != nil || page.NextCursor == "" {
+			t.Fatalf("Expected a next page, got %+v (%v)", page, err)
+		}
+		if _, err := backend.ListSessionPage(ctx, &SessionFilters{Limit: 2, SortBy: "id", Cursor: page.NextCursor}); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Expected a cursor of another sort to be rejected, got %v", err)
+		}
+		if _, err := backend.ListSessionPage(ctx, &SessionFilters{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Expected ErrInvalidCursor, got %v", err)
+		}
+		if err := backend.PurgeSession(ctx, "s1"); err != nil {
+			t.Fatalf("Failed to purge session: %v", err)
+		}
+		next, err := backend.ListSessionPage(ctx, &SessionFilters{Limit: 2, Cursor: page.NextCursor})
+		if err != nil || strings.Join(sessionIDs(next.Sessions), ",") != "s2,s3" {
+			t.Errorf("Expected the cursor of a purged session to continue after it, got %+v (%v)", next, err)
+		}
+	})
+
+	t.Run("Events", func(t *testing.T) {
+		backend := newBackend(t)
+		createTestSession(t, backend, "session-1")
+		createTestSession(t, backend, "session-2")
+
+		for _, seq := range []int{3, 1, 2} {
+			event := &Event{ID: fmt.Sprintf("e%d", seq), SessionID: "session-1", EventType: "user_prompt", Timestamp: base, SequenceNum: seq, Data: "{}"}
+			if err := backend.CreateEvent(ctx, event); err != nil {
+				t.Fatalf("Failed to create event: %v", err)
+			}
+		}
+		err := backend.CreateEvent(ctx, &Event{ID: "e1", SessionID: "session-1", Timestamp: base})
+		if !IsUniqueViolation(err) {
+			t.Errorf("Expected a unique violation for a duplicate event, got %v", err)
+		}
+		if err := backend.CreateEvent(ctx, &Event{ID: "orphan", SessionID: "missing", Timestamp: base}); err == nil {
+			t.Error("Expected an error for an event of a missing session")
+		}
+
+		events, err := backend.GetEventsBySession(ctx, "session-1")
+		if err != nil {
+			t.Fatalf("Failed to get events: %v", err)
+		}
+		if len(events) != 3 || events[0].ID != "e1" || events[1].ID != "e2" || events[2].ID != "e3" {
+			t.Errorf("Expected events by sequence number, got %v", eventIDs(events))
+		}
+
+		// A batch is stored completely or not at all
+		batch := []*Event{
+			{ID: "b1", SessionID: "session-2", Timestamp: base, SequenceNum: 1},
+			{ID: "b2", SessionID: "session-2", Timestamp: base, SequenceNum: 2},
+			{ID: "b1", SessionID: "session-2", Timestamp: base, SequenceNum: 3},
+		}
+		if err := backend.CreateEventBatch(ctx, batch); err == nil {
+			t.Error("Expected a batch with a duplicate event to fail")
+		}
+		if events, _ := backend.GetEventsBySession(ctx, "session-2"); len(events) != 0 {
+			t.Errorf("Expected a failed batch to store nothing, got %v", eventIDs(events))
+		}
+		if err := backend.CreateEventBatch(ctx, batch[:2]); err != nil {
+			t.Fatalf("Failed to create event batch: %v", err)
+		}
+		if events, _ := backend.GetEventsBySession(ctx, "session-2"); len(events) != 2 {
+			t.Errorf("Expected 2 batched events, got %v", eventIDs(events))
+		}
+	})
+
+	t.Run("EventSequences", func(t *testing.T) {
+		backend := newBackend(t)
+		createTestSession(t, backend, "session-1")
+		createTestSession(t, backend, "session-2")
+
+		// Events are numbered per session, and a duplicate takes no number
+		for i, id := range []string{"e1", "e2", "e1", "e3"} {
+			err := backend.CreateEvent(ctx, &Event{ID: id, SessionID: "session-1", Timestamp: base.Add(-time.Duration(i) * time.Second), Data: "{}"})
+			if err != nil && !IsUniqueViolation(err) {
+				t.Fatalf("Failed to create event: %v", err)
+			}
+		}
+		if err := backend.CreateEventBatch(ctx, []*Event{{ID: "f1", SessionID: "session-2", Timestamp: base, Data: "{}"}}); err != nil {
+			t.Fatalf("Failed to create event batch: %v", err)
+		}
+		events, err := backend.GetEventsBySession(ctx, "session-1")
+		if err != nil {
+			t.Fatalf("Failed to get events: %v", err)
+		}
+		if strings.Join(eventIDs(events), ",") != "e1,e2,e3" || events[2].SequenceNum != 3 {
+			t.Errorf("Expected e1,e2,e3 numbered 1 to 3, got %v", eventIDs(events))
+		}
+		if events, _ := backend.GetEventsBySession(ctx, "session-2"); events[0].SequenceNum != 1 {
+			t.Errorf("Expected the other session to start at 1, got %d", events[0].SequenceNum)
+		}
+
+		err = backend.CreateEvent(ctx, &Event{ID: "e4", SessionID: "session-1", Timestamp: base, SequenceNum: 2, Data: "{}"})
+		if !IsUniqueViolation(err) {
+			t.Errorf("Expected a unique violation for a taken number, got %v", err)
+		}
+
+		// Renumbering orders events by timestamp
+		renumbered, err := backend.RenumberEvents(ctx, "session-1")
+		if err != nil {
+			t.Fatalf("Failed to renumber events: %v", err)
+		}
+		events, _ = backend.GetEventsBySession(ctx, "session-1")
+		if renumbered != 2 || strings.Join(eventIDs(events), ",") != "e3,e2,e1" {
+			t.Errorf("Expected 2 events renumbered to e3,e2,e1, got %d %v", renumbered, eventIDs(events))
+		}
+		if renumbered, _ := backend.RenumberEvents(ctx, ""); renumbered != 0 {
+			t.Errorf("Expected nothing left to renumber, got %d", renumbered)
+		}
+		if err := backend.CreateEvent(ctx, &Event{ID: "e5", SessionID: "session-1", Timestamp: base, Data: "{}"}); err != nil {
+			t.Fatalf("Failed to create event: %v", err)
+		}
+		if events, _ := backend.GetEventsBySession(ctx, "session-1"); events[3].ID != "e5" || events[3].SequenceNum != 4 {
+			t.Errorf("Expected e5 to be number 4, got %v", eventIDs(events))
+		}
+	})
+
+	t.Run("Conversations", func(t *testing.T) {
+		backend := newBackend(t)
+		createTestSession(t, backend, "session-1")
+
+		for _, offset := range []int{2, 0, 1} {
+			conv := &Conversation{
+				ID:          fmt.Sprintf("c%d", offset),
+				SessionID:   "session-1",
+				MessageType: "user",
+				Content:     "message",
+				Timestamp:   base.Add(time.Duration(offset) * time.Minute),
+				Metadata:    "{}",
+				TokenCount:  offset,
+				Model:       "model",
+			}
+			if err := backend.CreateConversation(ctx, conv); err != nil {
+				t.Fatalf("Failed to create conversation: %v", err)
+			}
+		}
+		err := backend.CreateConversation(ctx, &Conversation{ID: "c0", SessionID: "session-1", Timestamp: base})
+		if !IsUniqueViolation(err) {
+			t.Errorf("Expected a unique violation for a duplicate conversation, got %v", err)
+		}
+
+		conversations, err := backend.GetConversationsBySession(ctx, "session-1")
+		if err != nil {
+			t.Fatalf("Failed to get conversations: %v", err)
+		}
+		if len(conversations) != 3 || conversations[0].ID != "c0" || conversations[2].ID != "c2" {
+			t.Fatalf("Expected conversations by timestamp, got %d", len(conversations))
+		}
+		if c := conversations[1]; c.TokenCount != 1 || c.Model != "model" || c.MessageType != "user" || !c.Timestamp.Equal(base.Add(time.Minute)) {
+			t.Errorf("Unexpected conversation: %+v", c)
+		}
+
+		// Streaming yields the same rows and can stop early
+		var streamed []string
+		for conv, err := range backend.IterConversationsBySession(ctx, "session-1") {
+			if err != nil {
+				t.Fatalf("Failed to stream conversations: %v", err)
+			}
+			streamed = append(streamed, conv.ID)
+			if len(streamed) == 2 {
+				break
+			}
+		}
+		if strings.Join(streamed, ",") != "c0,c1" {
+			t.Errorf("Expected c0,c1 streamed, got %v", streamed)
+		}
+	})
+
+	t.Run("IterSessions", func(t *testing.T) {
+		backend := newBackend(t)
+		for _, id := range []string{"s1", "s2", "s3"} {
+			createTestSession(t, backend, id)
+			backend.CreateEvent(ctx, &Event{ID: id + "-e", SessionID: id, EventType: "user_prompt", Timestamp: base, Data: "{}"})
+		}
+
+		var ids []string
+		for session, err := range backend.IterSessions(ctx, &SessionFilters{SortBy: "id", SortOrder: "DESC", Limit: 2}) {
+			if err != nil {
+				t.Fatalf("Failed to stream sessions: %v", err)
+			}
+			ids = append(ids, session.ID)
+			for event, err := range backend.IterEventsBySession(ctx, session.ID) {
+				if err != nil || event.SessionID != session.ID {
+					t.Errorf("Unexpected event of %s: %+v (%v)", session.ID, event, err)
+				}
+			}
+		}
+		if strings.Join(ids, ",") != "s3,s2" {
+			t.Errorf("Expected s3,s2, got %v", ids)
+		}
+		for _, err := range backend.IterSessions(ctx, &SessionFilters{SortBy: "nope"}) {
+			if err == nil {
+				t.Error("Expected the sort error to be yielded")
+			}
+		}
+	})
+
+	t.Run("ToolInvocations", func(t *testing.T) {
+		backend := newBackend(t)
+		createTestSession(t, backend, "session-1")
+
+		for i, name := range []string{"Bash", "Read", "Bash"} {
+			inv := &ToolInvocation{
+				ID:        fmt.Sprintf("t%d", i),
+				SessionID: "session-1",
+				ToolUseID: "use-1",
+				ToolName:  name,
+				Status:    ToolStatusRunning,
+				StartedAt: base.Add(time.Duration(i) * time.Second),
+			}
+			if err := backend.CreateToolInvocation(ctx, inv); err != nil {
+				t.Fatalf("Failed to create tool invocation: %v", err)
+			}
+		}
+
+		running, err := backend.GetRunningToolInvocation(ctx, "session-1", "use-1")
+		if err != nil || running.ID != "t2" {
+			t.Fatalf("Expected the latest running invocation t2, got %v (%v)", running, err)
+		}
+		ended := base.Add(5 * time.Second)
+		running.Status, running.Success, running.Output, running.EndedAt, running.DurationMs = ToolStatusCompleted, true, "ok", &ended, 3000
+		if err := backend.UpdateToolInvocation(ctx, running); err != nil {
+			t.Fatalf("Failed to update tool invocation: %v", err)
+		}
+		if running, _ := backend.GetRunningToolInvocation(ctx, "session-1", "use-1"); running == nil || running.ID != "t1" {
+			t.Errorf("Expected t1 to be running after t2 completed, got %v", running)
+		}
+		if _, err := backend.GetRunningToolInvocation(ctx, "session-1", "missing"); !errors.Is(err, ErrToolInvocationNotFound) {
+			t.Errorf("Expected ErrToolInvocationNotFound, got %v", err)
+		}
+		if found, err := backend.GetToolInvocation(ctx, "session-1", "use-1"); err != nil || found.ID != "t1" {
+			t.Errorf("Expected the running invocation t1 ahead of the later completed t2, got %v (%v)", found, err)
+		}
+		err = backend.WithTx(ctx, func(tx BackendTx) error {
+			for _, id := range []string{"t0", "t1"} {
+				inv := &ToolInvocation{ID: id, Status: ToolStatusCompleted, EndedAt: &ended}
+				if err := tx.UpdateToolInvocation(ctx, inv); err != nil {
+					return err
+				}
+			}
+			found, err := tx.GetToolInvocation(ctx, "session-1", "use-1")
+			if err == nil && found.ID != "t2" {
+				t.Errorf("Expected the latest invocation t2 once none is running, got %v", found)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Failed to complete invocations: %v", err)
+		}
+		if _, err := backend.GetToolInvocation(ctx, "session-1", "missing"); !errors.Is(err, ErrToolInvocationNotFound) {
+			t.Errorf("Expected ErrToolInvocationNotFound, got %v", err)
+		}
+
+		invocations, err := backend.GetToolInvocationsBySession(ctx, "session-1")
+		if err != nil || len(invocations) != 3 {
+			t.Fatalf("Expected 3 invocations, got %d (%v)", len(invocations), err)
+		}
+		completed := invocations[2]
+		if completed.ID != "t2" || completed.Status != ToolStatusCompleted || !completed.Success || completed.Output != "ok" ||
+			completed.EndedAt == nil || !completed.EndedAt.Equal(ended) || completed.DurationMs != 3000 {
+			t.Errorf("Unexpected completed invocation: %+v", completed)
+		}
+
+		counts, err := backend.GetToolUsageCounts(ctx, "")
+		if err != nil || counts["Bash"] != 2 || counts["Read"] != 1 {
+			t.Errorf("Unexpected tool usage counts: %v (%v)", counts, err)
+		}
+	})
+
+	t.Run("TranscriptCursor", func(t *testing.T) {
+		backend := newBackend(t)
+		createTestSession(t, backend, "session-1")
+
+		cursor, err := backend.GetTranscriptCursor(ctx, "session-1")
+		if err != nil || cursor.SessionID != "session-1" || cursor.ByteOffset != 0 {
+			t.Fatalf("Expected an empty cursor, got %+v (%v)", cursor, err)
+		}
+		for _, offset := range []int64{100, 250} {
+			cursor := &TranscriptCursor{SessionID: "session-1", TranscriptPath: "/tmp/t.jsonl", ByteOffset: offset, LastUUID: "u", UpdatedAt: base}
+			if err := backend.SaveTranscriptCursor(ctx, cursor); err != nil {
+				t.Fatalf("Failed to save cursor: %v", err)
+			}
+		}
+		if cursor, _ := backend.GetTranscriptCursor(ctx, "session-1"); cursor.ByteOffset != 250 || cursor.LastUUID != "u" {
+			t.Errorf("Expected the cursor to be updated, got %+v", cursor)
+		}
+	})
+
+	t.Run("PurgeSession", func(t *testing.T) {
+		backend := newBackend(t)
+		createTestSession(t, backend, "session-1")
+		createTestSession(t, backend, "session-2")
+		outputDir := filepath.Join(t.TempDir(), ToolOutputDir)
+		for _, id := range []string{"session-1", "session-2"} {
+			if err := backend.CreateEvent(ctx, &Event{ID: id + "-e", SessionID: id, Timestamp: base}); err != nil {
+				t.Fatalf("Failed to create event: %v", err)
+			}
+			createTestConversation(t, backend, id, id+"-c", "hello world")
+			outputPath := filepath.Join(outputDir, id, id+"-t.txt")
+			if err := os.MkdirAll(filepath.Dir(outputPath), 0700); err != nil {
+				t.Fatalf("Failed to create tool output directory: %v", err)
+			}
+			if err := os.WriteFile(outputPath, []byte("full output"), 0600); err != nil {
+				t.Fatalf("Failed to write tool output: %v", err)
+			}
+			if err := backend.CreateToolInvocation(ctx, &ToolInvocation{ID: id + "-t", SessionID: id, ToolName: "Bash", OutputTruncated: true, OutputPath: outputPath, StartedAt: base}); err != nil {
+				t.Fatalf("Failed to create tool invocation: %v", err)
+			}
+			if err := backend.SaveTranscriptCursor(ctx, &TranscriptCursor{SessionID: id, TranscriptPath: "p", ByteOffset: 10, UpdatedAt: base}); err != nil {
+				t.Fatalf("Failed to save cursor: %v", err)
+			}
+		}
+
+		if err := backend.PurgeSession(ctx, "session-1"); err != nil {
+			t.Fatalf("Failed to purge session: %v", err)
+		}
+		if err := backend.PurgeSession(ctx, "missing"); err != nil {
+			t.Errorf("Expected purging a missing session to be a no-op, got %v", err)
+		}
+
+		stats, err := backend.GetDatabaseStats(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get stats: %v", err)
+		}
+		if stats.SessionCount != 1 || stats.EventCount != 1 || stats.ConversationCount != 1 || stats.ToolCount != 1 {
+			t.Errorf("Expected only session-2's records to remain, got %+v", stats)
+		}
+		if cursor, _ := backend.GetTranscriptCursor(ctx, "session-1"); cursor.ByteOffset != 0 {
+			t.Errorf("Expected the deleted session's cursor to be gone, got %+v", cursor)
+		}
+		if hits, _ := backend.SearchConversationHits(ctx, &SearchOptions{Query: "hello"}); len(hits) != 1 || hits[0].Conversation.SessionID != "session-2" {
+			t.Errorf("Expected the deleted session's messages to leave the search index, got %d hits", len(hits))
+		}
+		if _, err := os.Stat(filepath.Join(outputDir, "session-1")); !os.IsNotExist(err) {
+			t.Errorf("Expected the deleted session's tool outputs to be removed, got %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(outputDir, "session-2", "session-2-t.txt")); err != nil {
+			t.Errorf("Expected the other session's tool outputs to be kept, got %v", err)
+		}
+	})
+
+	t.Run("Trash", func(t *testing.T) {
+		backend := newBackend(t)
+		for _, id := range []string{"session-1", "session-2"} {
+			createTestSession(t, backend, id)
+			createTestConversation(t, backend, id, id+"-c", "hello world")
+		}
+
+		if err := backend.DeleteSession(ctx, "session-1"); err != nil {
+			t.Fatalf("Failed to delete session: %v", err)
+		}
+		if err := backend.DeleteSession(ctx, "missing"); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("Expected ErrSessionNotFound for a missing session, got %v", err)
+		}
+		if err := backend.DeleteSession(ctx, "session-1"); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("Expected ErrSessionNotFound for a session already in the trash, got %v", err)
+		}
+
+		// Trashed sessions are left out unless asked for
+		if sessions, _ := backend.ListSessions(ctx, nil); len(sessions) != 1 || sessions[0].ID != "session-2" {
+			t.Errorf("Expected the trashed session to be hidden, got %v", sessions)
+		}
+		if sessions, _ := backend.ListSessions(ctx, &SessionFilters{IncludeDeleted: true}); len(sessions) != 2 {
+			t.Errorf("Expected both sessions with IncludeDeleted, got %v", sessions)
+		}
+		if hits, _ := backend.SearchConversationHits(ctx, &SearchOptions{Query: "hello"}); len(hits) != 1 || hits[0].Conversation.SessionID != "session-2" {
+			t.Errorf("Expected trashed messages to be left out of search, got %d hits", len(hits))
+		}
+		if conversations, _ := backend.SearchConversations(ctx, "hello", 10); len(conversations) != 1 {
+			t.Errorf("Expected trashed messages to be left out of search, got %d", len(conversations))
+		}
+		if session, err := backend.GetSession(ctx, "session-1"); err != nil || session.DeletedAt == nil {
+			t.Errorf("Expected the trashed session to be marked deleted, got %+v (%v)", session, err)
+		}
+
+		trash, err := backend.ListTrash(ctx)
+		if err != nil || len(trash) != 1 || trash[0].ID != "session-1" {
+			t.Fatalf("Expected session-1 in the trash, got %v (%v)", trash, err)
+		}
+		if err := backend.RestoreSession(ctx, "session-1"); err != nil {
+			t.Fatalf("Failed to restore session: %v", err)
+		}
+		if err := backend.RestoreSession(ctx, "session-2"); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("Expected ErrSessionNotFound for a session outside the trash, got %v", err)
+		}
+		if hits, _ := backend.SearchConversationHits(ctx, &SearchOptions{Query: "hello"}); len(hits) != 2 {
+			t.Errorf("Expected restored messages to be found again, got %d hits", len(hits))
+		}
+
+		// Emptying the trash only deletes what was deleted before the cutoff
+		backend.DeleteSession(ctx, "session-1")
+		if result, err := backend.EmptyTrash(ctx, time.Now().Add(-time.Hour)); err != nil || result.Sessions != 0 {
+			t.Errorf("Expected nothing old enough to empty, got %+v (%v)", result, err)
+		}
+		result, err := backend.EmptyTrash(ctx, time.Now().Add(time.Second))
+		if err != nil || result.Sessions != 1 || result.Rows == 0 {
+			t.Fatalf("Expected the trashed session to be purged, got %+v (%v)", result, err)
+		}
+		if _, err := backend.GetSession(ctx, "session-1"); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("Expected the purged session to be gone, got %v", err)
+		}
+		if trash, _ := backend.ListTrash(ctx); len(trash) != 0 {
+			t.Errorf("Expected an empty trash, got %v", trash)
+		}
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		backend := newBackend(t)
+		for i, project := range []string{"/work/Alpha", "/work/beta"} {
+			session := &Session{ID: fmt.Sprintf("session-%d", i+1), CreatedAt: base, UpdatedAt: base, Status: "active", Metadata: fmt.Sprintf(`{"project":%q}`, project)}
+			if err := backend.CreateSession(ctx, session); err != nil {
+				t.Fatalf("Failed to create session: %v", err)
+			}
+		}
+		for i, content := range []string{
+			"The database migration failed with a locking error",
+			"Database, database, database: the migration guide",
+			"Café au lait",
+			"Nothing relevant here",
+		} {
+			conv := &Conversation{ID: fmt.Sprintf("c%d", i+1), SessionID: "session-1", MessageType: "user", Content: content, Timestamp: base.Add(time.Duration(i) * time.Minute), Metadata: "{}"}
+			if err := backend.CreateConversation(ctx, conv); err != nil {
+				t.Fatalf("Failed to create conversation: %v", err)
+			}
+		}
+		long := strings.Repeat("filler words go here ", 20) + "the needle is here " + strings.Repeat("more filler text ", 20)
+		later := &Conversation{ID: "c5", SessionID: "session-2", MessageType: "assistant", Content: long, Timestamp: base.Add(time.Hour), Metadata: "{}"}
+		if err := backend.CreateConversation(ctx, later); err != nil {
+			t.Fatalf("Failed to create conversation: %v", err)
+		}
+
+		hits, err := backend.SearchConversationHits(ctx, &SearchOptions{Query: "database"})
+		if err != nil {
+			t.Fatalf("Failed to search: %v", err)
+		}
+		if len(hits) != 2 || hits[0].Conversation.ID != "c2" || hits[0].Score <= hits[1].Score {
+			t.Fatalf("Expected c2 to outrank c1, got %v", hitIDs(hits))
+		}
+		for _, hit := range hits {
+			for _, match := range hit.Matches {
+				if got := strings.ToLower(hit.Conversation.Content[match.Start:match.End]); got != "database" {
+					t.Errorf("Expected matches to cover 'database', got %q", got)
+				}
+			}
+			for _, match := range hit.SnippetMatches {
+				if got := strings.ToLower(hit.Snippet[match.Start:match.End]); got != "database" {
+					t.Errorf("Expected snippet matches to cover 'database', got %q", got)
+				}
+			}
+		}
+		if len(hits[0].Matches) != 3 || len(hits[1].Matches) != 1 {
+			t.Errorf("Expected 3 and 1 matches, got %d and %d", len(hits[0].Matches), len(hits[1].Matches))
+		}
+
+		for query, expected := range map[string]int{
+			`"migration failed"`:      1,
+			`"failed migration"`:      0,
+			`migr*`:                   2,
+			`locking OR guide`:        2,
+			`database NOT locking`:    1,
+			`cafe`:                    1,
+			`DATABASE migration`:      2,
+			`(locking OR guide) lait`: 0,
+			`guide OR lait NOT cafe`:  1,
+		} {
+			hits, err := backend.SearchConversationHits(ctx, &SearchOptions{Query: query})
+			if err != nil {
+				t.Errorf("Search %q failed: %v", query, err)
+				continue
+			}
+			if len(hits) != expected {
+				t.Errorf("Search %q: expected %d hits, got %d", query, expected, len(hits))
+			}
+		}
+
+		for name, test := range map[string]struct {
+			opts     *SearchOptions
+			expected []string
+		}{
+			"session": {&SearchOptions{Query: "migration OR needle", SessionID: "session-2"}, []string{"c5"}},
+			"project": {&SearchOptions{Query: "migration OR needle", Project: "alpha"}, []string{"c2", "c1"}},
+			"from":    {&SearchOptions{Query: "database", From: base.Add(time.Minute)}, []string{"c2"}},
+			"to":      {&SearchOptions{Query: "database", To: base.Add(time.Minute)}, []string{"c1"}},
+			"limit":   {&SearchOptions{Query: "database", Limit: 1}, []string{"c2"}},
+			"offset":  {&SearchOptions{Query: "database", Limit: 1, Offset: 1}, []string{"c1"}},
+		} {
+			hits, err := backend.SearchConversationHits(ctx, test.opts)
+			if err != nil {
+				t.Errorf("%s: search failed: %v", name, err)
+				continue
+			}
+			if got := hitIDs(hits); strings.Join(got, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("%s: expected %v, got %v", name, test.expected, got)
+			}
+		}
+
+		// Long messages are cut down to a snippet around the match
+		hits, err = backend.SearchConversationHits(ctx, &SearchOptions{Query: "needle", SnippetTokens: 8})
+		if err != nil || len(hits) != 1 {
+			t.Fatalf("Expected 1 hit for needle, got %d (%v)", len(hits), err)
+		}
+		hit := hits[0]
+		if len(hit.Snippet) >= len(long) || !strings.HasPrefix(hit.Snippet, "…") || !strings.HasSuffix(hit.Snippet, "…") {
+			t.Errorf("Expected a shortened snippet, got %q", hit.Snippet)
+		}
+		if len(hit.SnippetMatches) != 1 || hit.Snippet[hit.SnippetMatches[0].Start:hit.SnippetMatches[0].End] != "needle" {
+			t.Errorf("Unexpected snippet matches: %q %v", hit.Snippet, hit.SnippetMatches)
+		}
+
+		if _, err := backend.SearchConversationHits(ctx, &SearchOptions{Query: "++"}); !errors.Is(err, ErrInvalidSearchQuery) {
+			t.Errorf("Expected ErrInvalidSearchQuery, got %v", err)
+		}
+		// Queries without words fall back to a substring match
+		if conversations, err := backend.SearchConversations(ctx, ",", 10); err != nil || len(conversations) != 1 {
+			t.Errorf("Expected the substring fallback to find 1 message, got %d (%v)", len(conversations), err)
+		}
+		if conversations, err := backend.SearchConversations(ctx, "migration", 10); err != nil || len(conversations) != 2 {
+			t.Errorf("Expected 2 messages, got %d (%v)", len(conversations), err)
+		}
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		backend := newBackend(t)
+		conv := func(id string) *Conversation {
+			return &Conversation{ID: id, SessionID: "session-1", MessageType: "user", Content: id, Timestamp: base, Metadata: "{}"}
+		}
+
+		// Writes are committed together
+		err := backend.WithTx(ctx, func(tx BackendTx) error {
+			if err := tx.CreateSession(ctx, &Session{ID: "session-1", CreatedAt: base, UpdatedAt: base, Status: "active", Metadata: "{}"}); err != nil {
+				return err
+			}
+			if _, err := tx.GetSession(ctx, "session-1"); err != nil {
+				return err
+			}
+			return tx.CreateConversationBatch(ctx, []*Conversation{conv("c1"), conv("c2")})
+		})
+		if err != nil {
+			t.Fatalf("Transaction failed: %v", err)
+		}
+		if conversations, _ := backend.GetConversationsBySession(ctx, "session-1"); len(conversations) != 2 {
+			t.Errorf("Expected 2 committed conversations, got %d", len(conversations))
+		}
+
+		// And rolled back together
+		failure := errors.New("failure")
+		err = backend.WithTx(ctx, func(tx BackendTx) error {
+			if err := tx.CreateConversation(ctx, conv("c3")); err != nil {
+				return err
+			}
+			return failure
+		})
+		if !errors.Is(err, failure) {
+			t.Errorf("Expected the transaction's error, got %v", err)
+		}
+		if conversations, _ := backend.GetConversationsBySession(ctx, "session-1"); len(conversations) != 2 {
+			t.Errorf("Expected a rolled back conversation not to be stored, got %d", len(conversations))
+		}
+
+		// A failed batch undoes only itself
+		err = backend.WithTx(ctx, func(tx BackendTx) error {
+			if err := tx.CreateConversation(ctx, conv("c4")); err != nil {
+				return err
+			}
+			if err := tx.CreateConversationBatch(ctx, []*Conversation{conv("c5"), conv("c1")}); !IsUniqueViolation(err) {
+				t.Errorf("Expected a unique violation for a batch with a duplicate, got %v", err)
+			}
+			return tx.CreateConversation(ctx, conv("c6"))
+		})
+		if err != nil {
+			t.Fatalf("Transaction failed: %v", err)
+		}
+		conversations, _ := backend.GetConversationsBySession(ctx, "session-1")
+		var ids []string
+		for _, c := range conversations {
+			ids = append(ids, c.ID)
+		}
+		slices.Sort(ids)
+		if strings.Join(ids, ",") != "c1,c2,c4,c6" {
+			t.Errorf("Expected c1,c2,c4,c6, got %v", ids)
+		}
+
+		if err := backend.CreateConversationBatch(ctx, []*Conversation{conv("c7"), conv("c2")}); !IsUniqueViolation(err) {
+			t.Errorf("Expected a unique violation for a batch with a duplicate, got %v", err)
+		}
+		if conversations, _ := backend.GetConversationsBySession(ctx, "session-1"); len(conversations) != 4 {
+			t.Errorf("Expected a failed batch to store nothing, got %d", len(conversations))
+		}
+	})
+
+	t.Run("ImportHistory", func(t *testing.T) {
+		backend := newBackend(t)
+
+		if imported, err := backend.IsFileImported(ctx, "/a.jsonl"); err != nil || imported {
+			t.Fatalf("Expected no imports, got %v (%v)", imported, err)
+		}
+		for i, path := range []string{"/a.jsonl", "/b.jsonl", "/a.jsonl"} {
+			record := &ImportRecord{FilePath: path, ImportedAt: base.Add(time.Duration(i) * time.Hour), SessionCount: 1, EventCount: i, Checksum: "abc"}
+			if err := backend.RecordImport(ctx, record); err != nil {
+				t.Fatalf("Failed to record import: %v", err)
+			}
+		}
+
+		if imported, err := backend.IsFileImported(ctx, "/a.jsonl"); err != nil || !imported {
+			t.Errorf("Expected /a.jsonl to be imported, got %v (%v)", imported, err)
+		}
+		records, err := backend.ListImports(ctx)
+		if err != nil {
+			t.Fatalf("Failed to list imports: %v", err)
+		}
+		if len(records) != 2 || records[0].FilePath != "/a.jsonl" || records[1].FilePath != "/b.jsonl" {
+			t.Fatalf("Expected a re-import to replace its record, got %+v", records)
+		}
+		if r := records[0]; r.EventCount != 2 || r.Checksum != "abc" || !r.ImportedAt.Equal(base.Add(2*time.Hour)) {
+			t.Errorf("Unexpected import record: %+v", r)
+		}
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		backend := newBackend(t)
+		createTestSession(t, backend, "session-1")
+		createTestConversation(t, backend, "session-1", "c1", "hello")
+		if err := backend.CreateEventBatch(ctx, []*Event{
+			{ID: "e1", SessionID: "session-1", Timestamp: base, SequenceNum: 1},
+			{ID: "e2", SessionID: "session-1", Timestamp: base, SequenceNum: 2},
+		}); err != nil {
+			t.Fatalf("Failed to create events: %v", err)
+		}
+
+		stats, err := backend.GetDatabaseStats(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get stats: %v", err)
+		}
+		if stats.SessionCount != 1 || stats.EventCount != 2 || stats.ConversationCount != 1 || stats.ToolCount != 0 {
+			t.Errorf("Unexpected stats: %+v", stats)
+		}
+		if stats.OldestRecord != nil && stats.NewestRecord != nil && stats.OldestRecord.After(*stats.NewestRecord) {
+			t.Errorf("Expected the oldest record before the newest, got %v and %v", stats.OldestRecord, stats.NewestRecord)
+		}
+	})
+}
+
+func sessionIDs(sessions []*Session) []string {
+	var ids []string
+	for _, session := range sessions {
+		ids = append(ids, session.ID)
+	}
+	return ids
+}
+
+func eventIDs(events []*Event) []string {
+	var ids []string
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func hitIDs(hits []*SearchHit) []string {
+	var ids []string
+	for _, hit := range hits {
+		ids = append(ids, hit.Conversation.ID)
+	}
+	return ids
+}
+
+func TestPureGoSQLiteConformance(t *testing.T) {
+	runBackendConformance(t, func(t *testing.T) DatabaseBackend {
+		return newTestBackend(t)
+	})
+}
+
+func TestMemoryConformance(t *testing.T) {
+	runBackendConformance(t, func(t *testing.T) DatabaseBackend {
+		backend := NewMemoryBackend()
+		ctx := context.Background()
+		if err := backend.Initialize(ctx, &DatabaseConfig{Backend: BackendMemory}); err != nil {
+			t.Fatalf("Failed to initialize backend: %v", err)
+		}
+		t.Cleanup(func() { backend.Close() })
+		if err := backend.CreateSchema(ctx); err != nil {
+			t.Fatalf("Failed to create schema: %v", err)
+		}
+		return backend
+	})
+}
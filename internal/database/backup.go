@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// MaxMigrationBackups is how many pre-migration backups are kept
+const MaxMigrationBackups = 5
+
+// migrationBackupPrefix starts the file names of pre-migration backups
+const migrationBackupPrefix = "pre-migrate-"
+
+// onlineBackuper is implemented by modernc.org/sqlite connections
+type onlineBackuper interface {
+	NewBackup(dstUri string) (*sqlite.Backup, error)
+}
+
+// BackupDatabase copies a live database to destPath using SQLite's online
+// backup API. Pages are copied as they are, so rowids and with them the
+// search index stay valid in the copy.
+func BackupDatabase(ctx context.Context, db *sql.DB, destPath string) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		backuper, ok := driverConn.(onlineBackuper)
+		if !ok {
+			return fmt.Errorf("database driver doesn't support online backups")
+		}
+
+		backup, err := backuper.NewBackup(destPath)
+		if err != nil {
+			return fmt.Errorf("failed to start backup: %w", err)
+		}
+		for more := true; more; {
+			if more, err = backup.Step(-1); err != nil {
+				backup.Finish()
+				return fmt.Errorf("failed to copy database: %w", err)
+			}
+		}
+		if err := backup.Finish(); err != nil {
+			return fmt.Errorf("failed to finish backup: %w", err)
+		}
+		return nil
+	})
+}
+
+// BackupDir returns where backups of a database file are kept, empty for
+// in-memory databases
+func BackupDir(databasePath string) string {
+	if databasePath == "" || strings.Contains(databasePath, ":memory:") || strings.Contains(databasePath, "mode=memory") {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(databasePath), "backups")
+}
+
+// backupBeforeMigration copies the database before a migration changes it
+// and removes all but the newest MaxMigrationBackups of these copies
+func backupBeforeMigration(ctx context.Context, db *sql.DB, backupDir string, plan *MigrationPlan) (string, error) {
+	if err := os.MkdirAll(backupDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	name := fmt.Sprintf("%sv%d-to-v%d-%s.db", migrationBackupPrefix, plan.CurrentVersion, plan.TargetVersion, time.Now().Format("20060102-150405.000"))
+	backupPath := filepath.Join(backupDir, name)
+	if err := BackupDatabase(ctx, db, backupPath); err != nil {
+		os.Remove(backupPath)
+		return "", fmt.Errorf("failed to back up database before migrating: %w", err)
+	}
+
+	keepNewestFiles(filepath.Join(backupDir, migrationBackupPrefix+"*.db"), MaxMigrationBackups)
+	return backupPath, nil
+}
+
+// keepNewestFiles removes all but the newest keep files matching pattern
+func keepNewestFiles(pattern string, keep int) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil || len(matches) <= keep {
+		return
+	}
+
+	modTimes := make(map[string]time.Time, len(matches))
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil {
+			modTimes[match] = info.ModTime()
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return modTimes[matches[i]].Before(modTimes[matches[j]])
+	})
+	for _, old := range matches[:len(matches)-keep] {
+		os.Remove(old)
+	}
+}
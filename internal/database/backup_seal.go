@@ -0,0 +1,256 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// A sealed backup starts with sealedBackupMagic and a JSON header line
+// holding the key derivation parameters, followed by chunks of at most
+// sealedChunkSize bytes. Each chunk is stored as its length and its
+// XChaCha20-Poly1305 ciphertext, sealed with the header and a final flag as
+// associated data, so reordered, swapped or truncated chunks don't open.
+
+// sealedBackupMagic starts backups sealed under a passphrase
+const sealedBackupMagic = "CONTEXT-EXTENDER-BACKUP 1\n"
+
+// sealedChunkSize is how much plaintext each chunk of a sealed backup holds
+const sealedChunkSize = 64 * 1024
+
+// ErrBackupCorrupt is returned for backups that fail to open or verify
+var ErrBackupCorrupt = errors.New("backup is corrupt")
+
+// sealedBackupHeader describes how the key of a sealed backup is derived
+type sealedBackupHeader struct {
+	KDF         string `json:"kdf"`
+	Iterations  int    `json:"iterations"`
+	Memory      uint32 `json:"memory,omitempty"`
+	Parallelism uint8  `json:"parallelism"`
+	BlockSize   int    `json:"block_size,omitempty"`
+	Salt        string `json:"salt"`
+	Nonce       string `json:"nonce"`
+}
+
+// keyInfo returns the derivation parameters as KeyInfo
+func (h *sealedBackupHeader) keyInfo() *KeyInfo {
+	return &KeyInfo{Algorithm: h.KDF, Iterations: h.Iterations, Memory: h.Memory, Parallelism: h.Parallelism, BlockSize: h.BlockSize, Salt: h.Salt}
+}
+
+// chunkSealer seals or opens the chunks of one backup
+type chunkSealer struct {
+	aead    cipher.AEAD
+	nonce   []byte
+	header  []byte
+	counter uint64
+}
+
+// newChunkSealer derives the backup key from the passphrase
+func newChunkSealer(header *sealedBackupHeader, headerLine []byte, passphrase string) (*chunkSealer, error) {
+	nonce, err := base64.StdEncoding.DecodeString(header.Nonce)
+	if err != nil || len(nonce) != chacha20poly1305.NonceSizeX {
+		return nil, fmt.Errorf("%w: invalid nonce", ErrBackupCorrupt)
+	}
+	key, err := header.keyInfo().deriveWrappingKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return &chunkSealer{aead: aead, nonce: nonce, header: headerLine}, nil
+}
+
+// next returns the nonce and associated data of the next chunk
+func (s *chunkSealer) next(final bool) ([]byte, []byte) {
+	nonce := bytes.Clone(s.nonce)
+	counter := binary.BigEndian.Uint64(nonce[len(nonce)-8:]) ^ s.counter
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+
+	ad := append(bytes.Clone(s.header), 0)
+	if final {
+		ad[len(ad)-1] = 1
+	}
+	return nonce, ad
+}
+
+// sealWriter encrypts what is written to it into a sealed backup
+type sealWriter struct {
+	w      io.Writer
+	sealer *chunkSealer
+	buf    []byte
+}
+
+// newSealWriter writes the header of a sealed backup to w, with the key
+// derived from passphrase by argon2id
+func newSealWriter(w io.Writer, passphrase string) (*sealWriter, error) {
+	info := &KeyInfo{}
+	if err := info.setKDF(KDFArgon2id); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	header := &sealedBackupHeader{
+		KDF:         info.Algorithm,
+		Iterations:  info.Iterations,
+		Memory:      info.Memory,
+		Parallelism: info.Parallelism,
+		BlockSize:   info.BlockSize,
+		Salt:        info.Salt,
+		Nonce:       base64.StdEncoding.EncodeToString(nonce),
+	}
+	headerLine, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal backup header: %w", err)
+	}
+	sealer, err := newChunkSealer(header, headerLine, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.WriteString(w, sealedBackupMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(headerLine, '\n')); err != nil {
+		return nil, err
+	}
+	return &sealWriter{w: w, sealer: sealer}, nil
+}
+
+// Write seals full chunks and buffers the rest. A full chunk is only
+// sealed once more follows, so Close always has a final chunk to mark.
+func (sw *sealWriter) Write(p []byte) (int, error) {
+	sw.buf = append(sw.buf, p...)
+	for len(sw.buf) > sealedChunkSize {
+		if err := sw.sealChunk(sw.buf[:sealedChunkSize], false); err != nil {
+			return 0, err
+		}
+		sw.buf = sw.buf[sealedChunkSize:]
+	}
+	return len(p), nil
+}
+
+// Close seals the final chunk, it doesn't close the underlying writer
+func (sw *sealWriter) Close() error {
+	return sw.sealChunk(sw.buf, true)
+}
+
+// sealChunk writes one sealed chunk
+func (sw *sealWriter) sealChunk(chunk []byte, final bool) error {
+	nonce, ad := sw.sealer.next(final)
+	sealed := sw.sealer.aead.Seal(nil, nonce, chunk, ad)
+	sw.sealer.counter++
+
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(sealed)))
+	if _, err := sw.w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := sw.w.Write(sealed)
+	return err
+}
+
+// openReader decrypts a sealed backup
+type openReader struct {
+	r      *bufio.Reader
+	sealer *chunkSealer
+	buf    []byte
+	done   bool
+	err    error // kept, as the stream can't continue after a failed chunk
+}
+
+// isSealedBackup reports whether r starts a sealed backup
+func isSealedBackup(r *bufio.Reader) bool {
+	magic, err := r.Peek(len(sealedBackupMagic))
+	return err == nil && string(magic) == sealedBackupMagic
+}
+
+// newOpenReader reads the header of a sealed backup and derives its key
+func newOpenReader(r *bufio.Reader, passphrase string) (*openReader, error) {
+	if _, err := r.Discard(len(sealedBackupMagic)); err != nil {
+		return nil, err
+	}
+	headerLine, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBackupCorrupt, err)
+	}
+	headerLine = headerLine[:len(headerLine)-1]
+
+	var header sealedBackupHeader
+	if err := json.Unmarshal(headerLine, &header); err != nil {
+		return nil, fmt.Errorf("%w: invalid header: %v", ErrBackupCorrupt, err)
+	}
+	sealer, err := newChunkSealer(&header, headerLine, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return &openReader{r: r, sealer: sealer}, nil
+}
+
+// Read returns the decrypted chunks in order
+func (or *openReader) Read(p []byte) (int, error) {
+	for len(or.buf) == 0 {
+		if or.done {
+			return 0, io.EOF
+		}
+		if or.err != nil {
+			return 0, or.err
+		}
+		if or.err = or.openChunk(); or.err != nil {
+			return 0, or.err
+		}
+	}
+	n := copy(p, or.buf)
+	or.buf = or.buf[n:]
+	return n, nil
+}
+
+// openChunk reads and decrypts the next chunk
+func (or *openReader) openChunk() error {
+	var length [4]byte
+	if _, err := io.ReadFull(or.r, length[:]); err != nil {
+		return fmt.Errorf("%w: backup is truncated", ErrBackupCorrupt)
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size > sealedChunkSize+uint32(or.sealer.aead.Overhead()) {
+		return fmt.Errorf("%w: invalid chunk length", ErrBackupCorrupt)
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(or.r, sealed); err != nil {
+		return fmt.Errorf("%w: backup is truncated", ErrBackupCorrupt)
+	}
+
+	for _, final := range []bool{false, true} {
+		nonce, ad := or.sealer.next(final)
+		chunk, err := or.sealer.aead.Open(nil, nonce, sealed, ad)
+		if err != nil {
+			continue
+		}
+		or.sealer.counter++
+		or.buf, or.done = chunk, final
+		if final {
+			if _, err := or.r.ReadByte(); err != io.EOF {
+				return fmt.Errorf("%w: data after the final chunk", ErrBackupCorrupt)
+			}
+		}
+		return nil
+	}
+
+	if or.sealer.counter == 0 {
+		return ErrWrongPassphrase
+	}
+	return fmt.Errorf("%w: chunk %d doesn't decrypt", ErrBackupCorrupt, or.sealer.counter)
+}
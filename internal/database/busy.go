@@ -0,0 +1,219 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// BusyTimeoutEnv holds how long a connection waits for a locked database,
+// as a Go duration such as "10s"
+const BusyTimeoutEnv = "CONTEXT_EXTENDER_BUSY_TIMEOUT"
+
+// DefaultBusyTimeout is how long a connection waits for a locked database
+// unless configured otherwise
+const DefaultBusyTimeout = 5 * time.Second
+
+// Writes still locked after the busy timeout are retried with exponential
+// backoff, a few times
+const (
+	writeAttempts      = 5
+	writeRetryDelay    = 50 * time.Millisecond
+	maxWriteRetryDelay = time.Second
+)
+
+// writeRetryStatsSetting is the settings key write retry counts are kept
+// under, so all processes writing the database add to them
+const writeRetryStatsSetting = "write_retry_stats"
+
+// WriteRetryStats counts writes that found the database locked
+type WriteRetryStats struct {
+	Retries     int64      `json:"retries"`   // attempts repeated after a busy error
+	Recovered   int64      `json:"recovered"` // writes that succeeded on a retry
+	Failed      int64      `json:"failed"`    // writes given up on while still locked
+	LastRetryAt *time.Time `json:"last_retry_at,omitempty"`
+}
+
+// writeRetryCounter counts the write retries of a backend
+type writeRetryCounter struct {
+	retries   atomic.Int64
+	recovered atomic.Int64
+	failed    atomic.Int64
+	last      atomic.Int64 // unix nanoseconds of the last retry
+}
+
+// busyTimeoutFromEnv returns the busy timeout set in BusyTimeoutEnv, or the
+// default
+func busyTimeoutFromEnv() time.Duration {
+	value := os.Getenv(BusyTimeoutEnv)
+	if value == "" {
+		return DefaultBusyTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		log.Printf("Warning: ignoring invalid %s %q", BusyTimeoutEnv, value)
+		return DefaultBusyTimeout
+	}
+	return timeout
+}
+
+// sqliteDSN returns the data source name opening path with the busy timeout
+// and foreign keys set on every connection. Writer connections start their
+// transactions with the write lock taken, so a transaction never fails
+// half way through upgrading its lock. Other connections are query only,
+// so a write that bypasses the writer fails instead of contending for the
+// lock.
+func sqliteDSN(path string, busyTimeout time.Duration, writer bool) string {
+	params := url.Values{}
+	params.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", busyTimeout.Milliseconds()))
+	params.Add("_pragma", "foreign_keys(1)")
+	if writer {
+		params.Set("_txlock", "immediate")
+	} else {
+		params.Add("_pragma", "query_only(1)")
+	}
+
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return path + separator + params.Encode()
+}
+
+// isBusy reports whether err is SQLite finding the database locked
+func isBusy(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	// Extended result codes carry the primary code in the low byte
+	switch sqliteErr.Code() & 0xff {
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return true
+	}
+	return false
+}
+
+// retryBusy runs the write fn, running it again with backoff while it finds
+// the database locked
+func (b *PureGoSQLiteBackend) retryBusy(ctx context.Context, fn func() error) error {
+	delay := writeRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if !isBusy(err) {
+			if err == nil && attempt > 1 {
+				b.writeRetries.recovered.Add(1)
+			}
+			return err
+		}
+		if attempt == writeAttempts {
+			b.writeRetries.failed.Add(1)
+			return err
+		}
+
+		b.writeRetries.retries.Add(1)
+		b.writeRetries.last.Store(time.Now().UnixNano())
+		// Jitter keeps processes that collided from retrying in step
+		wait := delay/2 + rand.N(delay/2)
+		select {
+		case <-ctx.Done():
+			b.writeRetries.failed.Add(1)
+			return err
+		case <-time.After(wait):
+		}
+		delay = min(delay*2, maxWriteRetryDelay)
+	}
+}
+
+// execWrite runs a write statement on the writer connection
+func (b *PureGoSQLiteBackend) execWrite(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	var result sql.Result
+	err := b.retryBusy(ctx, func() error {
+		var err error
+		result, err = b.writeDB.ExecContext(ctx, query, args...)
+		return err
+	})
+	return result, err
+}
+
+// WriteRetryStats returns the write retries counted by all processes using
+// the database
+func (b *PureGoSQLiteBackend) WriteRetryStats(ctx context.Context) (*WriteRetryStats, error) {
+	if b.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	stats := &WriteRetryStats{}
+	if _, err := readSetting(ctx, b.db, writeRetryStatsSetting, stats); err != nil {
+		return nil, err
+	}
+	stats.add(b.writeRetries.stats())
+	return stats, nil
+}
+
+// saveWriteRetryStats adds the retries counted since the last save to the
+// stored counts
+func (b *PureGoSQLiteBackend) saveWriteRetryStats(ctx context.Context) error {
+	counted := b.writeRetries.stats()
+	if counted.Retries == 0 && counted.Failed == 0 {
+		return nil
+	}
+
+	err := b.withTx(ctx, nil, func(w *sqliteWriter) error {
+		if exists, err := tableExists(ctx, w.tx, "settings"); err != nil || !exists {
+			return err
+		}
+		stats := &WriteRetryStats{}
+		if _, err := readSetting(ctx, w.tx, writeRetryStatsSetting, stats); err != nil {
+			return err
+		}
+		stats.add(counted)
+		return writeSetting(ctx, w.tx, writeRetryStatsSetting, stats)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save write retry stats: %w", err)
+	}
+	b.writeRetries.subtract(counted)
+	return nil
+}
+
+// stats returns the counts as WriteRetryStats
+func (c *writeRetryCounter) stats() *WriteRetryStats {
+	stats := &WriteRetryStats{
+		Retries:   c.retries.Load(),
+		Recovered: c.recovered.Load(),
+		Failed:    c.failed.Load(),
+	}
+	if last := c.last.Load(); last != 0 {
+		lastRetryAt := time.Unix(0, last).UTC()
+		stats.LastRetryAt = &lastRetryAt
+	}
+	return stats
+}
+
+// subtract takes saved counts off the counter
+func (c *writeRetryCounter) subtract(saved *WriteRetryStats) {
+	c.retries.Add(-saved.Retries)
+	c.recovered.Add(-saved.Recovered)
+	c.failed.Add(-saved.Failed)
+}
+
+// add adds other's counts and keeps the later last retry
+func (s *WriteRetryStats) add(other *WriteRetryStats) {
+	s.Retries += other.Retries
+	s.Recovered += other.Recovered
+	s.Failed += other.Failed
+	if other.LastRetryAt != nil && (s.LastRetryAt == nil || other.LastRetryAt.After(*s.LastRetryAt)) {
+		s.LastRetryAt = other.LastRetryAt
+	}
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestWriteRetriesWhileLocked(t *testing.T) {
+	t.Setenv(BusyTimeoutEnv, "1ms")
+	backend := newTestBackend(t)
+	ctx := context.Background()
+
+	// Another process holds the write lock for a while
+	other, err := sql.Open("sqlite", backend.config.DatabasePath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer other.Close()
+	conn, err := other.Conn(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		t.Fatalf("Failed to lock database: %v", err)
+	}
+	released := make(chan struct{})
+	go func() {
+		defer close(released)
+		time.Sleep(100 * time.Millisecond)
+		conn.ExecContext(ctx, "ROLLBACK")
+	}()
+
+	createTestSession(t, backend, "session-1")
+	<-released
+
+	stats, err := backend.WriteRetryStats(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get write retry stats: %v", err)
+	}
+	if stats.Retries == 0 || stats.Recovered != 1 || stats.Failed != 0 || stats.LastRetryAt == nil {
+		t.Errorf("Unexpected write retry stats: %+v", stats)
+	}
+
+	// The counts are kept for the other processes using the database
+	if err := backend.Close(); err != nil {
+		t.Fatalf("Failed to close backend: %v", err)
+	}
+	reopened := NewPureGoSQLiteBackend()
+	if err := reopened.Initialize(ctx, backend.config); err != nil {
+		t.Fatalf("Failed to reopen backend: %v", err)
+	}
+	defer reopened.Close()
+	saved, err := reopened.WriteRetryStats(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get write retry stats: %v", err)
+	}
+	if saved.Retries != stats.Retries || saved.Recovered != 1 {
+		t.Errorf("Expected saved stats %+v, got %+v", stats, saved)
+	}
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
@@ -11,8 +12,11 @@ import (
 )
 
 var (
-	db   *sql.DB
-	once sync.Once
+	db     *sql.DB
+	dbPath string
+	once   sync.Once
+	// globalCipher is set when the database has field encryption enabled
+	globalCipher *FieldCipher
 )
 
 type Config struct {
@@ -67,6 +71,7 @@ func initializeDatabase(config *Config) error {
 		return fmt.Errorf("failed to open database: %w", err)
 	}
 
+	dbPath = config.DatabasePath
 	db.SetMaxOpenConns(config.MaxOpenConns)
 	db.SetMaxIdleConns(config.MaxIdleConns)
 
@@ -75,7 +80,12 @@ func initializeDatabase(config *Config) error {
 		return fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return enablePragmas()
+	if err = enablePragmas(); err != nil {
+		return err
+	}
+
+	globalCipher, err = openFieldCipher(context.Background(), db, "")
+	return err
 }
 
 func enablePragmas() error {
@@ -0,0 +1,738 @@
+package database
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Field encryption protects the free-text columns of the pure Go backend:
+// conversation content, event data, session metadata and tool input and
+// output. Values are sealed
+// with XChaCha20-Poly1305 under a key from KeyManager, bound to their column
+// so they can't be moved between columns. Because ciphertext is randomized,
+// equality lookups go through blind indexes, keyed hashes stored next to
+// the ciphertext.
+//
+// Every value records the version of the key that sealed it, so the key
+// can be rotated while old values are re-encrypted in the background.
+
+// Encrypted columns, also used as the AEAD associated data
+const (
+	fieldSessionMetadata     = "sessions.metadata"
+	fieldEventData           = "events.data"
+	fieldConversationContent = "conversations.content"
+	fieldToolInput           = "tool_invocations.input"
+	fieldToolOutput          = "tool_invocations.output"
+)
+
+// Blind indexes
+const indexSessionProject = "sessions.project"
+
+// Sealed values are encryptedValuePrefix, the key version, a colon and
+// base64 of the nonce and ciphertext. Values with legacyEncryptedPrefix
+// predate key versions and were sealed with key version 1.
+const (
+	encryptedValuePrefix  = "enc:v2:"
+	legacyEncryptedPrefix = "enc:v1:"
+)
+
+// fieldEncryptionSetting is the settings key recording that the database
+// is encrypted
+const fieldEncryptionSetting = "field_encryption"
+
+// FieldEncryptionAlgorithm names the AEAD used for encrypted fields
+const FieldEncryptionAlgorithm = "XChaCha20-Poly1305"
+
+var (
+	// ErrEncryptionKeyMissing is returned when a database is encrypted but
+	// its key can't be found
+	ErrEncryptionKeyMissing = errors.New("database is encrypted but the encryption key is missing")
+	// ErrWrongEncryptionKey is returned when a key doesn't belong to the database
+	ErrWrongEncryptionKey = errors.New("encryption key does not match the database")
+	// ErrFieldDecryption is returned for encrypted values that fail authentication
+	ErrFieldDecryption = errors.New("failed to decrypt field")
+	// ErrKeyVersionMissing is returned for values sealed with a key version
+	// that isn't loaded
+	ErrKeyVersionMissing = errors.New("encryption key version is missing")
+)
+
+// FieldCipher encrypts and decrypts column values and computes blind indexes
+type FieldCipher struct {
+	current int
+	keys    map[int]*fieldKey
+}
+
+// fieldKey holds the keys derived from one data key version
+type fieldKey struct {
+	aead     cipher.AEAD
+	indexKey []byte
+	checkKey []byte
+}
+
+// NewFieldCipher creates a cipher with a single 256-bit data key as
+// version 1
+func NewFieldCipher(key []byte) (*FieldCipher, error) {
+	return NewFieldCipherFromRing(&KeyRing{Keys: map[int][]byte{1: key}})
+}
+
+// NewFieldCipherFromRing creates a cipher that encrypts with the ring's
+// current key and decrypts with any of its keys
+func NewFieldCipherFromRing(ring *KeyRing) (*FieldCipher, error) {
+	fieldCipher := &FieldCipher{current: ring.Current(), keys: make(map[int]*fieldKey)}
+	for version, key := range ring.Keys {
+		derived, err := newFieldKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("key version %d: %w", version, err)
+		}
+		fieldCipher.keys[version] = derived
+	}
+	return fieldCipher, nil
+}
+
+// newFieldKey derives the encryption and blind index keys from a data key
+func newFieldKey(key []byte) (*fieldKey, error) {
+	if len(key) != chacha20poly1305.KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", chacha20poly1305.KeySize, len(key))
+	}
+
+	derive := func(purpose string) ([]byte, error) {
+		derived := make([]byte, 32)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte("context-extender "+purpose)), derived); err != nil {
+			return nil, fmt.Errorf("failed to derive %s key: %w", purpose, err)
+		}
+		return derived, nil
+	}
+
+	encryptionKey, err := derive("field encryption")
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	indexKey, err := derive("blind index")
+	if err != nil {
+		return nil, err
+	}
+	checkKey, err := derive("key check")
+	if err != nil {
+		return nil, err
+	}
+
+	return &fieldKey{aead: aead, indexKey: indexKey, checkKey: checkKey}, nil
+}
+
+// IsEncryptedValue reports whether a column value is sealed
+func IsEncryptedValue(value string) bool {
+	return strings.HasPrefix(value, encryptedValuePrefix) || strings.HasPrefix(value, legacyEncryptedPrefix)
+}
+
+// valueKeyVersion returns the key version that sealed a value, 0 when it
+// isn't sealed
+func valueKeyVersion(value string) int {
+	if strings.HasPrefix(value, legacyEncryptedPrefix) {
+		return 1
+	}
+	version, _, err := splitSealedValue(value)
+	if err != nil {
+		return 0
+	}
+	return version
+}
+
+// splitSealedValue returns the key version and payload of a sealed value
+func splitSealedValue(value string) (int, string, error) {
+	if strings.HasPrefix(value, legacyEncryptedPrefix) {
+		return 1, value[len(legacyEncryptedPrefix):], nil
+	}
+	rest, ok := strings.CutPrefix(value, encryptedValuePrefix)
+	if !ok {
+		return 0, "", fmt.Errorf("value isn't sealed")
+	}
+	versionText, payload, ok := strings.Cut(rest, ":")
+	version, err := strconv.Atoi(versionText)
+	if !ok || err != nil || version < 1 {
+		return 0, "", fmt.Errorf("value has no key version")
+	}
+	return version, payload, nil
+}
+
+// sealedValuePrefix returns the prefix of values sealed with a key version
+func sealedValuePrefix(version int) string {
+	return fmt.Sprintf("%s%d:", encryptedValuePrefix, version)
+}
+
+// KeyVersion returns the key version new values are sealed with
+func (c *FieldCipher) KeyVersion() int {
+	return c.current
+}
+
+// Encrypt seals a value of a column with the current key. Empty values are
+// returned as they are. Values that look sealed are sealed again, as they
+// may be text quoting a sealed value.
+func (c *FieldCipher) Encrypt(field, plaintext string) (string, error) {
+	if plaintext == "" {
+		return plaintext, nil
+	}
+
+	key := c.keys[c.current]
+	nonce := make([]byte, key.aead.NonceSize(), key.aead.NonceSize()+len(plaintext)+key.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := key.aead.Seal(nonce, nonce, []byte(plaintext), []byte(field))
+	return sealedValuePrefix(c.current) + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a sealed value of a column with the key version that
+// sealed it. Values that aren't sealed, e.g. written before the database
+// was encrypted, are returned as they are.
+func (c *FieldCipher) Decrypt(field, value string) (string, error) {
+	if !IsEncryptedValue(value) {
+		return value, nil
+	}
+
+	version, payload, err := splitSealedValue(value)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s is malformed", ErrFieldDecryption, field)
+	}
+	key, ok := c.keys[version]
+	if !ok {
+		return "", fmt.Errorf("%w: %s needs key version %d", ErrKeyVersionMissing, field, version)
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(payload)
+	if err != nil || len(sealed) < key.aead.NonceSize() {
+		return "", fmt.Errorf("%w: %s is malformed", ErrFieldDecryption, field)
+	}
+	nonce, ciphertext := sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():]
+	plaintext, err := key.aead.Open(nil, nonce, ciphertext, []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrFieldDecryption, field)
+	}
+	return string(plaintext), nil
+}
+
+// opens reports whether a value is sealed and opens under one of the
+// cipher's key versions
+func (c *FieldCipher) opens(field, value string) bool {
+	if !IsEncryptedValue(value) {
+		return false
+	}
+	_, err := c.Decrypt(field, value)
+	return err == nil
+}
+
+// BlindIndex returns a deterministic keyed hash of a value under the
+// current key, so equal values can be found without decrypting. Values are
+// compared case-insensitively.
+func (c *FieldCipher) BlindIndex(index, value string) string {
+	return c.keys[c.current].blindIndex(index, value)
+}
+
+// BlindIndexes returns the blind indexes of a value under every key
+// version, to find rows a rekey hasn't reached yet
+func (c *FieldCipher) BlindIndexes(index, value string) []string {
+	indexes := make([]string, 0, len(c.keys))
+	for _, key := range c.keys {
+		indexes = append(indexes, key.blindIndex(index, value))
+	}
+	return indexes
+}
+
+// blindIndex computes a blind index with this key's index key
+func (k *fieldKey) blindIndex(index, value string) string {
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(index))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strings.ToLower(value)))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// keyCheck identifies the current key without revealing it
+func (c *FieldCipher) keyCheck() string {
+	return c.keys[c.current].keyCheck()
+}
+
+// keyCheck identifies this key without revealing it
+func (k *fieldKey) keyCheck() string {
+	mac := hmac.New(sha256.New, k.checkKey)
+	mac.Write([]byte(fieldEncryptionSetting))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// matchesState reports whether the cipher has the key the database
+// records as its current one
+func (c *FieldCipher) matchesState(state *FieldEncryptionState) bool {
+	key, ok := c.keys[state.keyVersion()]
+	return ok && hmac.Equal([]byte(key.keyCheck()), []byte(state.KeyCheck))
+}
+
+// projectBlindIndex returns the blind index of a session's project, NULL
+// when the metadata names none
+func (c *FieldCipher) projectBlindIndex(metadata string) interface{} {
+	project := sessionProject(metadata)
+	if c == nil || project == "" {
+		return nil
+	}
+	return c.BlindIndex(indexSessionProject, project)
+}
+
+// sessionProject returns the project recorded in session metadata
+func sessionProject(metadata string) string {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(metadata), &fields); err != nil {
+		return ""
+	}
+	project, _ := fields["project"].(string)
+	return project
+}
+
+// FieldEncryptionState is what the settings table records about encryption
+type FieldEncryptionState struct {
+	Algorithm  string    `json:"algorithm"`
+	KeyVersion int       `json:"key_version,omitempty"` // the key new values are sealed with
+	KeyCheck   string    `json:"key_check"`             // identifies that key
+	EnabledAt  time.Time `json:"enabled_at"`
+}
+
+// keyVersion returns the current key version, 1 for states written before
+// keys had versions
+func (s *FieldEncryptionState) keyVersion() int {
+	if s.KeyVersion == 0 {
+		return 1
+	}
+	return s.KeyVersion
+}
+
+// readFieldEncryption returns the database's encryption state, nil when it
+// isn't encrypted
+func readFieldEncryption(ctx context.Context, q queryer) (*FieldEncryptionState, error) {
+	var state FieldEncryptionState
+	found, err := readSetting(ctx, q, fieldEncryptionSetting, &state)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// writeFieldEncryption records the encryption state, nil removes it
+func writeFieldEncryption(ctx context.Context, q queryer, state *FieldEncryptionState) error {
+	if state == nil {
+		return writeSetting(ctx, q, fieldEncryptionSetting, nil)
+	}
+	return writeSetting(ctx, q, fieldEncryptionSetting, state)
+}
+
+// loadFieldKeyRing loads the data keys from a KeyManager directory,
+// creating a key when create is set and there is none
+func loadFieldKeyRing(keyDir string, create bool) (*KeyRing, error) {
+	km := NewKeyManager(keyDir)
+	if !km.KeyExists() {
+		if !create {
+			return nil, fmt.Errorf("%w: no key in %s", ErrEncryptionKeyMissing, km.keyPath)
+		}
+		key, err := km.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		if err := km.SaveKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	ring, err := km.LoadKeyRing()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load encryption key: %w", err)
+	}
+	return ring, nil
+}
+
+// openFieldCipher returns the cipher of an encrypted database, nil when the
+// database isn't encrypted
+func openFieldCipher(ctx context.Context, q queryer, keyDir string) (*FieldCipher, error) {
+	state, err := readFieldEncryption(ctx, q)
+	if err != nil || state == nil {
+		return nil, err
+	}
+
+	ring, err := loadFieldKeyRing(keyDir, false)
+	if err != nil {
+		return nil, err
+	}
+	fieldCipher, err := NewFieldCipherFromRing(ring)
+	if err != nil {
+		return nil, err
+	}
+	if !fieldCipher.matchesState(state) {
+		return nil, ErrWrongEncryptionKey
+	}
+	return fieldCipher, nil
+}
+
+// sealField encrypts a value when a cipher is set
+func (c *FieldCipher) sealField(field, value string) (string, error) {
+	if c == nil {
+		return value, nil
+	}
+	return c.Encrypt(field, value)
+}
+
+// openField decrypts a value when a cipher is set
+func (c *FieldCipher) openField(field, value string) (string, error) {
+	if c == nil {
+		return value, nil
+	}
+	return c.Decrypt(field, value)
+}
+
+// sealToolInvocation returns the input and output of an invocation,
+// encrypted when a cipher is set
+func (c *FieldCipher) sealToolInvocation(inv *ToolInvocation) (string, string, error) {
+	input, err := c.sealField(fieldToolInput, inv.Input)
+	if err != nil {
+		return "", "", err
+	}
+	output, err := c.sealField(fieldToolOutput, inv.Output)
+	if err != nil {
+		return "", "", err
+	}
+	return input, output, nil
+}
+
+// fieldCipher returns the cipher to decrypt with, nil when the database
+// isn't encrypted
+func (b *PureGoSQLiteBackend) fieldCipher() *FieldCipher {
+	b.cipherMu.RLock()
+	defer b.cipherMu.RUnlock()
+	return b.cipher
+}
+
+// setFieldCipher replaces the cipher
+func (b *PureGoSQLiteBackend) setFieldCipher(fieldCipher *FieldCipher) {
+	b.cipherMu.Lock()
+	defer b.cipherMu.Unlock()
+	b.cipher = fieldCipher
+}
+
+// reloadFieldCipher reloads the keys and the encryption state read from q,
+// which another process may have changed
+func (b *PureGoSQLiteBackend) reloadFieldCipher(ctx context.Context, q queryer) (*FieldCipher, error) {
+	fieldCipher, err := openFieldCipher(ctx, q, b.keyDir())
+	if err != nil {
+		return nil, err
+	}
+	b.setFieldCipher(fieldCipher)
+	return fieldCipher, nil
+}
+
+// writeCipher returns the cipher to seal new values with, nil when the
+// database isn't encrypted. The database records which key is current, so
+// after a rekey or decrypt in another process the keys are reloaded. The
+// state is read in the write transaction tx, so a rekey can't move to a new
+// key and retire the old one before the values sealed with it are stored.
+func (b *PureGoSQLiteBackend) writeCipher(ctx context.Context, tx *sql.Tx) (*FieldCipher, error) {
+	state, err := readFieldEncryption(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	fieldCipher := b.fieldCipher()
+	switch {
+	case state == nil && fieldCipher == nil:
+		return nil, nil
+	case state != nil && fieldCipher != nil && state.keyVersion() == fieldCipher.KeyVersion():
+		return fieldCipher, nil
+	}
+	return b.reloadFieldCipher(ctx, tx)
+}
+
+// openField decrypts a value, reloading the keys when it was sealed with a
+// key version added since they were loaded
+func (b *PureGoSQLiteBackend) openField(ctx context.Context, field, value string) (string, error) {
+	plaintext, err := b.fieldCipher().openField(field, value)
+	if !errors.Is(err, ErrKeyVersionMissing) {
+		return plaintext, err
+	}
+
+	fieldCipher, reloadErr := b.reloadFieldCipher(ctx, b.db)
+	if reloadErr != nil {
+		return "", reloadErr
+	}
+	return fieldCipher.openField(field, value)
+}
+
+// openToolInvocation decrypts the input and output of an invocation
+func (b *PureGoSQLiteBackend) openToolInvocation(ctx context.Context, inv *ToolInvocation) error {
+	var err error
+	if inv.Input, err = b.openField(ctx, fieldToolInput, inv.Input); err != nil {
+		return err
+	}
+	inv.Output, err = b.openField(ctx, fieldToolOutput, inv.Output)
+	return err
+}
+
+// ErrNotEncrypted is returned when decrypting a database that isn't encrypted
+var ErrNotEncrypted = errors.New("database is not encrypted")
+
+// FieldEncryptionResult counts the values an encryption conversion changed
+type FieldEncryptionResult struct {
+	Sessions        int `json:"sessions"`
+	Events          int `json:"events"`
+	Conversations   int `json:"conversations"`
+	ToolInvocations int `json:"tool_invocations"` // input and output values
+}
+
+// encryptedColumn is a column covered by field encryption
+type encryptedColumn struct {
+	table, column, field string
+}
+
+// encryptedColumns lists the columns field encryption covers
+var encryptedColumns = []encryptedColumn{
+	{"sessions", "metadata", fieldSessionMetadata},
+	{"events", "data", fieldEventData},
+	{"conversations", "content", fieldConversationContent},
+	{"tool_invocations", "input", fieldToolInput},
+	{"tool_invocations", "output", fieldToolOutput},
+}
+
+// FieldEncryption returns the database's encryption state, nil when it
+// isn't encrypted
+func (b *PureGoSQLiteBackend) FieldEncryption(ctx context.Context) (*FieldEncryptionState, error) {
+	if b.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	return readFieldEncryption(ctx, b.db)
+}
+
+// EncryptFields encrypts the database in place, creating the key if there
+// is none. Values that open under one of the keys are left alone, so it
+// also finishes a database that got plaintext rows from a process that
+// opened it before it was encrypted. The full-text index is emptied since
+// it would otherwise keep the plaintext.
+func (b *PureGoSQLiteBackend) EncryptFields(ctx context.Context) (*FieldEncryptionResult, error) {
+	if err := b.CreateSchema(ctx); err != nil {
+		return nil, err
+	}
+
+	ring, err := loadFieldKeyRing(b.keyDir(), true)
+	if err != nil {
+		return nil, err
+	}
+	fieldCipher, err := NewFieldCipherFromRing(ring)
+	if err != nil {
+		return nil, err
+	}
+	state, err := readFieldEncryption(ctx, b.db)
+	if err != nil {
+		return nil, err
+	}
+	if state != nil && !fieldCipher.matchesState(state) {
+		return nil, ErrWrongEncryptionKey
+	}
+	if state == nil {
+		state = &FieldEncryptionState{Algorithm: FieldEncryptionAlgorithm, EnabledAt: time.Now().UTC()}
+	}
+	state.KeyVersion, state.KeyCheck = fieldCipher.KeyVersion(), fieldCipher.keyCheck()
+
+	var result *FieldEncryptionResult
+	err = b.withTx(ctx, nil, func(w *sqliteWriter) error {
+		if err := clearSearchIndex(ctx, w.tx); err != nil {
+			return err
+		}
+
+		var err error
+		result, err = convertEncryptedColumns(ctx, w.tx, func(column encryptedColumn, value string) (string, error) {
+			if fieldCipher.opens(column.field, value) {
+				return value, nil
+			}
+			return fieldCipher.Encrypt(column.field, value)
+		})
+		if err != nil {
+			return err
+		}
+		if err := updateProjectIndexes(ctx, w.tx, fieldCipher); err != nil {
+			return err
+		}
+		return writeFieldEncryption(ctx, w.tx, state)
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.setFieldCipher(fieldCipher)
+
+	// Rewrite the file so freed pages and the WAL don't keep the plaintext
+	if _, err := b.execWrite(ctx, "VACUUM"); err != nil {
+		return result, fmt.Errorf("encrypted, but failed to vacuum the database: %w", err)
+	}
+	if _, err := b.execWrite(ctx, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return result, fmt.Errorf("encrypted, but failed to checkpoint the database: %w", err)
+	}
+	return result, nil
+}
+
+// DecryptFields decrypts the database in place and rebuilds the full-text
+// index. The key is kept.
+func (b *PureGoSQLiteBackend) DecryptFields(ctx context.Context) (*FieldEncryptionResult, error) {
+	if b.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	fieldCipher := b.fieldCipher()
+	if fieldCipher == nil {
+		return nil, ErrNotEncrypted
+	}
+
+	var result *FieldEncryptionResult
+	err := b.withTx(ctx, nil, func(w *sqliteWriter) error {
+		var err error
+		result, err = convertEncryptedColumns(ctx, w.tx, func(column encryptedColumn, value string) (string, error) {
+			return fieldCipher.Decrypt(column.field, value)
+		})
+		if err != nil {
+			return err
+		}
+		if err := updateProjectIndexes(ctx, w.tx, nil); err != nil {
+			return err
+		}
+		if err := restoreSearchIndex(ctx, w.tx); err != nil {
+			return err
+		}
+		return writeFieldEncryption(ctx, w.tx, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.setFieldCipher(nil)
+	return result, nil
+}
+
+// convertEncryptedColumns rewrites every value of the encrypted columns
+// with convert and counts the values that changed
+func convertEncryptedColumns(ctx context.Context, tx *sql.Tx, convert func(column encryptedColumn, value string) (string, error)) (*FieldEncryptionResult, error) {
+	result := &FieldEncryptionResult{}
+	counts := []*int{&result.Sessions, &result.Events, &result.Conversations, &result.ToolInvocations, &result.ToolInvocations}
+
+	for i, column := range encryptedColumns {
+		values, err := readColumn(ctx, tx, column.table, column.column)
+		if err != nil {
+			return nil, err
+		}
+
+		update := fmt.Sprintf("UPDATE %s SET %s = ? WHERE rowid = ?", column.table, column.column)
+		for rowid, value := range values {
+			converted, err := convert(column, value)
+			if err != nil {
+				return nil, err
+			}
+			if converted == value {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, update, converted, rowid); err != nil {
+				return nil, fmt.Errorf("failed to update %s.%s: %w", column.table, column.column, err)
+			}
+			*counts[i]++
+		}
+	}
+	return result, nil
+}
+
+// readColumn returns the non-NULL values of a column by rowid
+func readColumn(ctx context.Context, tx *sql.Tx, table, column string) (map[int64]string, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT rowid, %s FROM %s WHERE %s IS NOT NULL", column, table, column))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s.%s: %w", table, column, err)
+	}
+	defer rows.Close()
+
+	values := make(map[int64]string)
+	for rows.Next() {
+		var rowid int64
+		var value string
+		if err := rows.Scan(&rowid, &value); err != nil {
+			return nil, fmt.Errorf("failed to read %s.%s: %w", table, column, err)
+		}
+		values[rowid] = value
+	}
+	return values, rows.Err()
+}
+
+// updateProjectIndexes sets the project blind index of every session, or
+// clears them all without a cipher
+func updateProjectIndexes(ctx context.Context, tx *sql.Tx, fieldCipher *FieldCipher) error {
+	if fieldCipher == nil {
+		if _, err := tx.ExecContext(ctx, "UPDATE sessions SET project_bidx = NULL"); err != nil {
+			return fmt.Errorf("failed to clear project index: %w", err)
+		}
+		return nil
+	}
+
+	metadata, err := readColumn(ctx, tx, "sessions", "metadata")
+	if err != nil {
+		return err
+	}
+	for rowid, value := range metadata {
+		plaintext, err := fieldCipher.Decrypt(fieldSessionMetadata, value)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE sessions SET project_bidx = ? WHERE rowid = ?", fieldCipher.projectBlindIndex(plaintext), rowid); err != nil {
+			return fmt.Errorf("failed to update project index: %w", err)
+		}
+	}
+	return nil
+}
+
+// clearSearchIndex stops indexing conversations and empties the index
+func clearSearchIndex(ctx context.Context, tx *sql.Tx) error {
+	exists, err := tableExists(ctx, tx, "conversations_fts")
+	if err != nil || !exists {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		DROP TRIGGER IF EXISTS conversations_fts_insert;
+		DROP TRIGGER IF EXISTS conversations_fts_delete;
+		DROP TRIGGER IF EXISTS conversations_fts_update;
+		INSERT INTO conversations_fts (conversations_fts) VALUES ('delete-all');
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to clear search index: %w", err)
+	}
+	return nil
+}
+
+// restoreSearchIndex recreates the index triggers and rebuilds the index
+func restoreSearchIndex(ctx context.Context, tx *sql.Tx) error {
+	exists, err := tableExists(ctx, tx, "conversations_fts")
+	if err != nil || !exists {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, searchIndexSchema+`INSERT INTO conversations_fts (conversations_fts) VALUES ('rebuild');`); err != nil {
+		return fmt.Errorf("failed to rebuild search index: %w", err)
+	}
+	return nil
+}
+
+// EncryptionKeyPath returns the directory the field encryption key is kept in
+func (b *PureGoSQLiteBackend) EncryptionKeyPath() string {
+	return NewKeyManager(b.keyDir()).KeyPath()
+}
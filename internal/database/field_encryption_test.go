@@ -0,0 +1,266 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newEncryptedTestBackend creates an encrypted pure Go SQLite backend with
+// its key in a temp directory
+func newEncryptedTestBackend(t *testing.T, dir, keyDir string) *PureGoSQLiteBackend {
+	t.Helper()
+
+	config := DefaultDatabaseConfig()
+	config.DatabasePath = filepath.Join(dir, "test.db")
+	config.BackendOptions = map[string]interface{}{"key_path": keyDir}
+
+	backend := NewPureGoSQLiteBackend()
+	ctx := context.Background()
+	if err := backend.Initialize(ctx, config); err != nil {
+		t.Fatalf("Failed to initialize backend: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+
+	if _, err := backend.EncryptFields(ctx); err != nil {
+		t.Fatalf("Failed to encrypt database: %v", err)
+	}
+	return backend
+}
+
+func TestFieldCipher(t *testing.T) {
+	fieldCipher, err := NewFieldCipher(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("Failed to create cipher: %v", err)
+	}
+
+	sealed, err := fieldCipher.Encrypt(fieldConversationContent, "hello world")
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	if !IsEncryptedValue(sealed) || strings.Contains(sealed, "hello") {
+		t.Fatalf("Expected a sealed value, got %q", sealed)
+	}
+	again, _ := fieldCipher.Encrypt(fieldConversationContent, "hello world")
+	if again == sealed {
+		t.Error("Expected encryption to be randomized")
+	}
+	// Text quoting a sealed value is sealed like any other
+	resealed, err := fieldCipher.Encrypt(fieldConversationContent, sealed)
+	if err != nil || resealed == sealed {
+		t.Fatalf("Expected sealed-looking values to be sealed again, got %q (%v)", resealed, err)
+	}
+	if plaintext, err := fieldCipher.Decrypt(fieldConversationContent, resealed); err != nil || plaintext != sealed {
+		t.Errorf("Expected the quoted value back, got %q (%v)", plaintext, err)
+	}
+
+	if plaintext, err := fieldCipher.Decrypt(fieldConversationContent, sealed); err != nil || plaintext != "hello world" {
+		t.Errorf("Expected the plaintext back, got %q (%v)", plaintext, err)
+	}
+	if plaintext, err := fieldCipher.Decrypt(fieldConversationContent, "not sealed"); err != nil || plaintext != "not sealed" {
+		t.Errorf("Expected plaintext values to pass through, got %q (%v)", plaintext, err)
+	}
+	if _, err := fieldCipher.Decrypt(fieldEventData, sealed); !errors.Is(err, ErrFieldDecryption) {
+		t.Errorf("Expected a value moved to another column to fail, got %v", err)
+	}
+
+	other, _ := NewFieldCipher(bytes.Repeat([]byte{8}, 32))
+	if _, err := other.Decrypt(fieldConversationContent, sealed); !errors.Is(err, ErrFieldDecryption) {
+		t.Errorf("Expected the wrong key to fail, got %v", err)
+	}
+
+	if fieldCipher.BlindIndex(indexSessionProject, "Demo") != fieldCipher.BlindIndex(indexSessionProject, "demo") {
+		t.Error("Expected blind indexes to ignore case")
+	}
+	if fieldCipher.BlindIndex(indexSessionProject, "demo") == other.BlindIndex(indexSessionProject, "demo") {
+		t.Error("Expected blind indexes to depend on the key")
+	}
+
+	if _, err := NewFieldCipher([]byte("short")); err == nil {
+		t.Error("Expected an error for a short key")
+	}
+}
+
+func TestEncryptDecryptFields(t *testing.T) {
+	ctx := context.Background()
+	dir, keyDir := t.TempDir(), t.TempDir()
+
+	config := DefaultDatabaseConfig()
+	config.DatabasePath = filepath.Join(dir, "test.db")
+	config.BackendOptions = map[string]interface{}{"key_path": keyDir}
+	backend := NewPureGoSQLiteBackend()
+	if err := backend.Initialize(ctx, config); err != nil {
+		t.Fatalf("Failed to initialize backend: %v", err)
+	}
+	defer backend.Close()
+	if err := backend.CreateSchema(ctx); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+
+	now := time.Now()
+	session := &Session{ID: "session-1", CreatedAt: now, UpdatedAt: now, Status: "active", Metadata: `{"project":"Secret"}`}
+	if err := backend.CreateSession(ctx, session); err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	createTestConversation(t, backend, "session-1", "c1", "the plaintext password is swordfish")
+	if err := backend.CreateEvent(ctx, &Event{ID: "e1", SessionID: "session-1", EventType: "user_prompt", Data: `{"prompt":"swordfish"}`, Timestamp: now}); err != nil {
+		t.Fatalf("Failed to create event: %v", err)
+	}
+	if err := backend.CreateToolInvocation(ctx, &ToolInvocation{ID: "t1", SessionID: "session-1", ToolName: "Bash", Input: `{"command":"echo swordfish"}`, Status: ToolStatusRunning, StartedAt: now}); err != nil {
+		t.Fatalf("Failed to create tool invocation: %v", err)
+	}
+
+	result, err := backend.EncryptFields(ctx)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	if result.Sessions != 1 || result.Events != 1 || result.Conversations != 1 || result.ToolInvocations != 1 {
+		t.Errorf("Unexpected encryption result: %+v", result)
+	}
+
+	// Rows written after encrypting are sealed too
+	createTestConversation(t, backend, "session-1", "c2", "another swordfish")
+	running, err := backend.GetRunningToolInvocation(ctx, "session-1", "")
+	if err != nil || running.Input != `{"command":"echo swordfish"}` {
+		t.Fatalf("Expected the decrypted tool input, got %+v (%v)", running, err)
+	}
+	running.Output, running.Status = "swordfish", ToolStatusCompleted
+	if err := backend.UpdateToolInvocation(ctx, running); err != nil {
+		t.Fatalf("Failed to update tool invocation: %v", err)
+	}
+
+	for _, query := range []string{
+		"SELECT content FROM conversations",
+		"SELECT data FROM events",
+		"SELECT metadata FROM sessions",
+		"SELECT input FROM tool_invocations",
+		"SELECT output FROM tool_invocations",
+	} {
+		rows, err := backend.db.QueryContext(ctx, query)
+		if err != nil {
+			t.Fatalf("Failed to query: %v", err)
+		}
+		for rows.Next() {
+			var value string
+			rows.Scan(&value)
+			if !IsEncryptedValue(value) {
+				t.Errorf("%s: expected an encrypted value, got %q", query, value)
+			}
+		}
+		rows.Close()
+	}
+
+	conversations, err := backend.GetConversationsBySession(ctx, "session-1")
+	if err != nil || len(conversations) != 2 || conversations[0].Content != "the plaintext password is swordfish" {
+		t.Fatalf("Expected decrypted conversations, got %v (%v)", conversations, err)
+	}
+	hits, err := backend.SearchConversationHits(ctx, &SearchOptions{Query: "swordfish", Project: "secret"})
+	if err != nil || len(hits) != 2 {
+		t.Errorf("Expected search to find both conversations, got %d (%v)", len(hits), err)
+	}
+	sessions, err := backend.ListSessions(ctx, &SessionFilters{Project: "SECRET"})
+	if err != nil || len(sessions) != 1 || sessions[0].Metadata != `{"project":"Secret"}` {
+		t.Errorf("Expected the project filter to use the blind index, got %v (%v)", sessions, err)
+	}
+
+	// Reopening loads the key again
+	backend.Close()
+	reopened := NewPureGoSQLiteBackend()
+	if err := reopened.Initialize(ctx, config); err != nil {
+		t.Fatalf("Failed to reopen backend: %v", err)
+	}
+	defer reopened.Close()
+	events, err := reopened.GetEventsBySession(ctx, "session-1")
+	if err != nil || len(events) != 1 || events[0].Data != `{"prompt":"swordfish"}` {
+		t.Fatalf("Expected decrypted events after reopening, got %v (%v)", events, err)
+	}
+
+	result, err = reopened.DecryptFields(ctx)
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	if result.Sessions != 1 || result.Events != 1 || result.Conversations != 2 || result.ToolInvocations != 2 {
+		t.Errorf("Unexpected decryption result: %+v", result)
+	}
+	if state, err := reopened.FieldEncryption(ctx); err != nil || state != nil {
+		t.Errorf("Expected the database to be unencrypted, got %+v (%v)", state, err)
+	}
+	var content string
+	if err := reopened.db.QueryRowContext(ctx, "SELECT content FROM conversations WHERE id = 'c1'").Scan(&content); err != nil || content != "the plaintext password is swordfish" {
+		t.Errorf("Expected plaintext content, got %q (%v)", content, err)
+	}
+	var indexed int
+	if err := reopened.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM conversations_fts WHERE conversations_fts MATCH 'swordfish'").Scan(&indexed); err != nil || indexed != 2 {
+		t.Errorf("Expected the search index to be rebuilt, got %d (%v)", indexed, err)
+	}
+	if _, err := reopened.DecryptFields(ctx); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("Expected ErrNotEncrypted, got %v", err)
+	}
+}
+
+func TestEncryptSealsQuotedValues(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBackend(t)
+	backend.config.BackendOptions = map[string]interface{}{"key_path": t.TempDir()}
+	createTestSession(t, backend, "session-1")
+	createTestConversation(t, backend, "session-1", "c1", "enc:v1:not really sealed")
+
+	result, err := backend.EncryptFields(ctx)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	if result.Conversations != 1 {
+		t.Errorf("Expected the quoted value to be encrypted, got %+v", result)
+	}
+	createTestConversation(t, backend, "session-1", "c2", "enc:v2:1:also quoted")
+
+	conversations, err := backend.GetConversationsBySession(ctx, "session-1")
+	if err != nil || len(conversations) != 2 {
+		t.Fatalf("Expected both conversations, got %v (%v)", conversations, err)
+	}
+	want := map[string]string{"c1": "enc:v1:not really sealed", "c2": "enc:v2:1:also quoted"}
+	for _, conv := range conversations {
+		if conv.Content != want[conv.ID] {
+			t.Errorf("Expected %q for %s, got %q", want[conv.ID], conv.ID, conv.Content)
+		}
+	}
+
+	// Encrypting again leaves the sealed values alone
+	if result, err := backend.EncryptFields(ctx); err != nil || result.Conversations != 0 {
+		t.Errorf("Expected nothing to encrypt, got %+v (%v)", result, err)
+	}
+}
+
+func TestEncryptedDatabaseKeyErrors(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	backend := newEncryptedTestBackend(t, dir, t.TempDir())
+	backend.Close()
+
+	config := DefaultDatabaseConfig()
+	config.DatabasePath = filepath.Join(dir, "test.db")
+
+	config.BackendOptions = map[string]interface{}{"key_path": t.TempDir()}
+	if err := NewPureGoSQLiteBackend().Initialize(ctx, config); !errors.Is(err, ErrEncryptionKeyMissing) {
+		t.Errorf("Expected ErrEncryptionKeyMissing, got %v", err)
+	}
+
+	otherKeys := t.TempDir()
+	if _, err := loadFieldKeyRing(otherKeys, true); err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	config.BackendOptions = map[string]interface{}{"key_path": otherKeys}
+	if err := NewPureGoSQLiteBackend().Initialize(ctx, config); !errors.Is(err, ErrWrongEncryptionKey) {
+		t.Errorf("Expected ErrWrongEncryptionKey, got %v", err)
+	}
+}
+
+func TestEncryptedPureGoSQLiteConformance(t *testing.T) {
+	runBackendConformance(t, func(t *testing.T) DatabaseBackend {
+		return newEncryptedTestBackend(t, t.TempDir(), t.TempDir())
+	})
+}
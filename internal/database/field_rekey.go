@@ -0,0 +1,264 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// DefaultRekeyBatchSize is how many values a rekey re-encrypts per transaction
+const DefaultRekeyBatchSize = 500
+
+// RekeyProgress reports how far a rekey got through a column
+type RekeyProgress struct {
+	Column string `json:"column"`
+	Done   int    `json:"done"`
+	Total  int    `json:"total"`
+}
+
+// RekeyResult summarizes a rekey
+type RekeyResult struct {
+	KeyVersion  int   `json:"key_version"`
+	Rotated     bool  `json:"rotated"` // false when an interrupted rekey was resumed
+	Reencrypted int   `json:"reencrypted"`
+	Retired     []int `json:"retired,omitempty"`
+	Remaining   int   `json:"remaining"` // values still sealed with older keys
+}
+
+// RekeyFields moves every encrypted value to the newest key version. When
+// no value uses an older key a new version is created first; otherwise an
+// interrupted rekey is resumed. Values are re-encrypted in
+// batches, each in its own transaction, so capture carries on meanwhile.
+// Key versions no value uses anymore are retired from the key ring.
+func (b *PureGoSQLiteBackend) RekeyFields(ctx context.Context, batchSize int, progress func(RekeyProgress)) (*RekeyResult, error) {
+	if b.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultRekeyBatchSize
+	}
+
+	state, err := readFieldEncryption(ctx, b.db)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, ErrNotEncrypted
+	}
+	fieldCipher, err := b.reloadFieldCipher(ctx, b.db)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RekeyResult{}
+	if state.keyVersion() == fieldCipher.KeyVersion() {
+		older, err := countOlderKeyValues(ctx, b.db, fieldCipher)
+		if err != nil {
+			return nil, err
+		}
+		if older == 0 {
+			km := NewKeyManager(b.keyDir())
+			if _, _, err := km.RotateKey(); err != nil {
+				return nil, fmt.Errorf("failed to rotate key: %w", err)
+			}
+			ring, err := km.LoadKeyRing()
+			if err != nil {
+				return nil, err
+			}
+			if fieldCipher, err = NewFieldCipherFromRing(ring); err != nil {
+				return nil, err
+			}
+			result.Rotated = true
+		}
+	}
+
+	// Writers pick the new key up from here on
+	state.KeyVersion, state.KeyCheck = fieldCipher.KeyVersion(), fieldCipher.keyCheck()
+	err = b.retryBusy(ctx, func() error {
+		return writeFieldEncryption(ctx, b.writeDB, state)
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.setFieldCipher(fieldCipher)
+	result.KeyVersion = fieldCipher.KeyVersion()
+
+	for _, column := range encryptedColumns {
+		count, err := b.rekeyColumn(ctx, fieldCipher, column, batchSize, progress)
+		if err != nil {
+			return result, err
+		}
+		result.Reencrypted += count
+	}
+
+	if err := b.retireKeyVersions(ctx, fieldCipher, result); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// staleCondition matches values of a column not sealed with a key version
+func staleCondition(column encryptedColumn) string {
+	return fmt.Sprintf("%[1]s IS NOT NULL AND %[1]s != '' AND substr(%[1]s, 1, ?) != ?", column.column)
+}
+
+// countOlderKeyValues counts the values sealed with key versions older
+// than the cipher's current one
+func countOlderKeyValues(ctx context.Context, q queryer, fieldCipher *FieldCipher) (int, error) {
+	total := 0
+	for version := range fieldCipher.keys {
+		if version == fieldCipher.KeyVersion() {
+			continue
+		}
+		count, err := countKeyVersionValues(ctx, q, version)
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// rekeyColumn re-encrypts the values of a column that aren't sealed with
+// the cipher's current key, a batch per transaction
+func (b *PureGoSQLiteBackend) rekeyColumn(ctx context.Context, fieldCipher *FieldCipher, column encryptedColumn, batchSize int, progress func(RekeyProgress)) (int, error) {
+	prefix := sealedValuePrefix(fieldCipher.KeyVersion())
+	name := column.table + "." + column.column
+
+	var total int
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", column.table, staleCondition(column))
+	if err := b.db.QueryRowContext(ctx, countQuery, len(prefix), prefix).Scan(&total); err != nil {
+		return 0, fmt.Errorf("failed to count %s: %w", name, err)
+	}
+	if total == 0 {
+		return 0, nil
+	}
+
+	selectQuery := fmt.Sprintf("SELECT rowid, %s FROM %s WHERE rowid > ? AND %s ORDER BY rowid LIMIT ?", column.column, column.table, staleCondition(column))
+	// Rows changed since they were read are left for the next batch or run
+	updateQuery := fmt.Sprintf("UPDATE %s SET %s = ? WHERE rowid = ? AND %s = ?", column.table, column.column, column.column)
+	if column.table == "sessions" {
+		updateQuery = "UPDATE sessions SET metadata = ?, project_bidx = ? WHERE rowid = ? AND metadata = ?"
+	}
+
+	done := 0
+	var lastRowid int64
+	for {
+		batch, err := readStaleBatch(ctx, b.db, selectQuery, lastRowid, len(prefix), prefix, batchSize)
+		if err != nil {
+			return done, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		err = b.withTx(ctx, nil, func(w *sqliteWriter) error {
+			for _, row := range batch {
+				plaintext, err := fieldCipher.Decrypt(column.field, row.value)
+				if err != nil {
+					return err
+				}
+				sealed, err := fieldCipher.Encrypt(column.field, plaintext)
+				if err != nil {
+					return err
+				}
+
+				args := []interface{}{sealed, row.rowid, row.value}
+				if column.table == "sessions" {
+					args = []interface{}{sealed, fieldCipher.projectBlindIndex(plaintext), row.rowid, row.value}
+				}
+				if _, err := w.tx.ExecContext(ctx, updateQuery, args...); err != nil {
+					return fmt.Errorf("failed to update %s: %w", name, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return done, err
+		}
+
+		done += len(batch)
+		lastRowid = batch[len(batch)-1].rowid
+		if progress != nil {
+			progress(RekeyProgress{Column: name, Done: done, Total: max(total, done)})
+		}
+	}
+	return done, nil
+}
+
+// staleValue is a value waiting to be re-encrypted
+type staleValue struct {
+	rowid int64
+	value string
+}
+
+// readStaleBatch reads the next batch of values to re-encrypt
+func readStaleBatch(ctx context.Context, db *sql.DB, query string, after int64, prefixLength int, prefix string, limit int) ([]staleValue, error) {
+	rows, err := db.QueryContext(ctx, query, after, prefixLength, prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []staleValue
+	for rows.Next() {
+		var row staleValue
+		if err := rows.Scan(&row.rowid, &row.value); err != nil {
+			return nil, err
+		}
+		batch = append(batch, row)
+	}
+	return batch, rows.Err()
+}
+
+// retireKeyVersions removes the old key versions no value uses anymore and
+// counts the values still on the others
+func (b *PureGoSQLiteBackend) retireKeyVersions(ctx context.Context, fieldCipher *FieldCipher, result *RekeyResult) error {
+	km := NewKeyManager(b.keyDir())
+	for version := range fieldCipher.keys {
+		if version == fieldCipher.KeyVersion() {
+			continue
+		}
+
+		count, err := countKeyVersionValues(ctx, b.db, version)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			result.Remaining += count
+			continue
+		}
+		if err := km.RetireKeyVersion(version); err != nil {
+			return fmt.Errorf("failed to retire key version %d: %w", version, err)
+		}
+		result.Retired = append(result.Retired, version)
+	}
+
+	if len(result.Retired) > 0 {
+		if _, err := b.reloadFieldCipher(ctx, b.db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countKeyVersionValues counts the values sealed with a key version
+func countKeyVersionValues(ctx context.Context, q queryer, version int) (int, error) {
+	prefixes := []string{sealedValuePrefix(version)}
+	if version == 1 {
+		prefixes = append(prefixes, legacyEncryptedPrefix)
+	}
+
+	total := 0
+	for _, column := range encryptedColumns {
+		for _, prefix := range prefixes {
+			var count int
+			query := fmt.Sprintf("SELECT COUNT(*) FROM %[1]s WHERE substr(%[2]s, 1, ?) = ?", column.table, column.column)
+			if err := q.QueryRowContext(ctx, query, len(prefix), prefix).Scan(&count); err != nil {
+				return 0, fmt.Errorf("failed to count %s.%s: %w", column.table, column.column, err)
+			}
+			total += count
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyRing(t *testing.T) {
+	legacy := hex.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	ring, err := parseKeyRing(legacy, 1)
+	if err != nil || ring.Current() != 1 || ring.String() != legacy {
+		t.Fatalf("Expected a lone key to round-trip as version 1, got %v (%v)", ring, err)
+	}
+
+	ring.Keys[3] = bytes.Repeat([]byte{3}, 32)
+	parsed, err := parseKeyRing(ring.String(), 1)
+	if err != nil {
+		t.Fatalf("Failed to parse key ring: %v", err)
+	}
+	if parsed.Current() != 3 || len(parsed.Versions()) != 2 || !bytes.Equal(parsed.Keys[1], ring.Keys[1]) {
+		t.Errorf("Expected versions 1 and 3, got %v", parsed.Versions())
+	}
+
+	for _, text := range []string{"", "x:" + legacy, "1:abcd", "1:" + legacy + "\n1:" + legacy} {
+		if _, err := parseKeyRing(text, 1); err == nil {
+			t.Errorf("Expected an error for %q", text)
+		}
+	}
+}
+
+func TestRotateAndRetireKey(t *testing.T) {
+	useFastKDF(t)
+	km := NewKeyManager(t.TempDir())
+	key, err := km.GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if err := km.SaveWrappedKey(key, "correct horse", KDFScrypt); err != nil {
+		t.Fatalf("Failed to save wrapped key: %v", err)
+	}
+	SetPassphrase("correct horse")
+
+	oldKey, newKey, err := km.RotateKey()
+	if err != nil || oldKey != key || newKey == key {
+		t.Fatalf("Failed to rotate key: %v", err)
+	}
+	ring, err := NewKeyManager(km.KeyPath()).LoadKeyRing()
+	if err != nil || ring.Current() != 2 || len(ring.Keys) != 2 {
+		t.Fatalf("Expected two key versions, got %v (%v)", ring, err)
+	}
+	if !km.IsWrapped() {
+		t.Error("Expected the rotated ring to stay wrapped")
+	}
+
+	if err := km.RetireKeyVersion(2); err == nil {
+		t.Error("Expected retiring the current key to fail")
+	}
+	if err := km.RetireKeyVersion(1); err != nil {
+		t.Fatalf("Failed to retire key version: %v", err)
+	}
+	if loaded, err := NewKeyManager(km.KeyPath()).LoadKey(); err != nil || loaded != newKey {
+		t.Errorf("Expected only the new key to be left, got %v", err)
+	}
+}
+
+func TestRekeyFields(t *testing.T) {
+	ctx := context.Background()
+	dir, keyDir := t.TempDir(), t.TempDir()
+	backend := newEncryptedTestBackend(t, dir, keyDir)
+
+	now := time.Now()
+	if err := backend.CreateSession(ctx, &Session{ID: "session-1", CreatedAt: now, UpdatedAt: now, Status: "active", Metadata: `{"project":"demo"}`}); err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		createTestConversation(t, backend, "session-1", "c"+string(rune('0'+i)), "rotate me please")
+	}
+	// Values written before keys had versions still decrypt
+	legacy, err := backend.fieldCipher().Encrypt(fieldConversationContent, "legacy value")
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	legacy = legacyEncryptedPrefix + strings.TrimPrefix(legacy, sealedValuePrefix(1))
+	if _, err := backend.writeDB.ExecContext(ctx, "UPDATE conversations SET content = ? WHERE id = 'c4'", legacy); err != nil {
+		t.Fatalf("Failed to write legacy value: %v", err)
+	}
+
+	// A second process keeps writing while the key rotates
+	config := DefaultDatabaseConfig()
+	config.DatabasePath = filepath.Join(dir, "test.db")
+	config.BackendOptions = map[string]interface{}{"key_path": keyDir}
+	writer := NewPureGoSQLiteBackend()
+	if err := writer.Initialize(ctx, config); err != nil {
+		t.Fatalf("Failed to open second backend: %v", err)
+	}
+	defer writer.Close()
+
+	// Interrupt the first run after one batch
+	interrupted, cancel := context.WithCancel(ctx)
+	result, err := backend.RekeyFields(interrupted, 2, func(RekeyProgress) { cancel() })
+	if err == nil || !result.Rotated || result.KeyVersion != 2 {
+		t.Fatalf("Expected an interrupted rotation to version 2, got %+v (%v)", result, err)
+	}
+
+	createTestConversation(t, writer, "session-1", "c9", "written meanwhile")
+	var content string
+	backend.db.QueryRowContext(ctx, "SELECT content FROM conversations WHERE id = 'c9'").Scan(&content)
+	if !strings.HasPrefix(content, sealedValuePrefix(2)) {
+		t.Errorf("Expected the writer to switch to the new key, got %q", content)
+	}
+
+	result, err = backend.RekeyFields(ctx, 2, nil)
+	if err != nil {
+		t.Fatalf("Failed to resume rekey: %v", err)
+	}
+	if result.Rotated || result.KeyVersion != 2 || result.Remaining != 0 || len(result.Retired) != 1 || result.Retired[0] != 1 {
+		t.Errorf("Expected the rekey to resume and retire version 1, got %+v", result)
+	}
+	var stale int
+	backend.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM conversations WHERE content NOT LIKE 'enc:v2:2:%'").Scan(&stale)
+	if stale != 0 {
+		t.Errorf("Expected every value on key version 2, %d left", stale)
+	}
+
+	// The writer still holds version 1 and reloads the ring to read
+	conversations, err := writer.GetConversationsBySession(ctx, "session-1")
+	if err != nil || len(conversations) != 6 {
+		t.Fatalf("Expected the second backend to read re-encrypted values, got %d (%v)", len(conversations), err)
+	}
+	for _, conv := range conversations {
+		if conv.ID == "c4" && conv.Content != "legacy value" {
+			t.Errorf("Expected the legacy value to be re-encrypted, got %q", conv.Content)
+		}
+	}
+	sessions, err := writer.ListSessions(ctx, &SessionFilters{Project: "demo"})
+	if err != nil || len(sessions) != 1 {
+		t.Errorf("Expected the project filter to use the new blind index, got %v (%v)", sessions, err)
+	}
+
+	// With nothing left to move, the next run rotates again
+	result, err = backend.RekeyFields(ctx, 0, nil)
+	if err != nil || !result.Rotated || result.KeyVersion != 3 {
+		t.Errorf("Expected a rotation to version 3, got %+v (%v)", result, err)
+	}
+	if state, err := backend.FieldEncryption(ctx); err != nil || state.KeyVersion != 3 {
+		t.Errorf("Expected the state to record key version 3, got %+v (%v)", state, err)
+	}
+}
+
+func TestRekeyUnencryptedDatabase(t *testing.T) {
+	backend := newTestBackend(t)
+	if _, err := backend.RekeyFields(context.Background(), 0, nil); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("Expected ErrNotEncrypted, got %v", err)
+	}
+}
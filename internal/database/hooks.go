@@ -9,10 +9,9 @@ import (
 	"github.com/google/uuid"
 )
 
-type HookHandler struct {
-	sessionSequence map[string]int
-	mu              sync.RWMutex
-}
+// HookHandler records hook events. Each hook runs as its own process, so
+// events are numbered by the database.
+type HookHandler struct{}
 
 type SessionStartData struct {
 	SessionID string            `json:"session_id"`
@@ -45,9 +44,7 @@ var handlerOnce sync.Once
 
 func GetHookHandler() *HookHandler {
 	handlerOnce.Do(func() {
-		defaultHandler = &HookHandler{
-			sessionSequence: make(map[string]int),
-		}
+		defaultHandler = &HookHandler{}
 	})
 	return defaultHandler
 }
@@ -58,10 +55,6 @@ func (h *HookHandler) HandleSessionStart(data SessionStartData) error {
 		RecordHookExecution("session_start", time.Since(start))
 	}()
 
-	h.mu.Lock()
-	h.sessionSequence[data.SessionID] = 0
-	h.mu.Unlock()
-
 	metadataJSON, err := json.Marshal(data.Metadata)
 	if err != nil {
 		return fmt.Errorf("failed to marshal metadata: %w", err)
@@ -86,12 +79,11 @@ func (h *HookHandler) HandleSessionStart(data SessionStartData) error {
 	}
 
 	event := &Event{
-		ID:          uuid.New().String(),
-		SessionID:   data.SessionID,
-		EventType:   "session_start",
-		Data:        string(eventData),
-		Timestamp:   data.Timestamp,
-		SequenceNum: h.getNextSequence(data.SessionID),
+		ID:        uuid.New().String(),
+		SessionID: data.SessionID,
+		EventType: "session_start",
+		Data:      string(eventData),
+		Timestamp: data.Timestamp,
 	}
 
 	err = CreateEvent(event)
@@ -113,12 +105,11 @@ func (h *HookHandler) HandleUserPrompt(data UserPromptData) error {
 	}
 
 	event := &Event{
-		ID:          uuid.New().String(),
-		SessionID:   data.SessionID,
-		EventType:   "user_prompt",
-		Data:        string(eventData),
-		Timestamp:   data.Timestamp,
-		SequenceNum: h.getNextSequence(data.SessionID),
+		ID:        uuid.New().String(),
+		SessionID: data.SessionID,
+		EventType: "user_prompt",
+		Data:      string(eventData),
+		Timestamp: data.Timestamp,
 	}
 
 	if err := CreateEvent(event); err != nil {
@@ -149,12 +140,11 @@ func (h *HookHandler) HandleClaudeResponse(data ClaudeResponseData) error {
 	}
 
 	event := &Event{
-		ID:          uuid.New().String(),
-		SessionID:   data.SessionID,
-		EventType:   "claude_response",
-		Data:        string(eventData),
-		Timestamp:   data.Timestamp,
-		SequenceNum: h.getNextSequence(data.SessionID),
+		ID:        uuid.New().String(),
+		SessionID: data.SessionID,
+		EventType: "claude_response",
+		Data:      string(eventData),
+		Timestamp: data.Timestamp,
 	}
 
 	if err := CreateEvent(event); err != nil {
@@ -203,33 +193,20 @@ func (h *HookHandler) HandleSessionEnd(data SessionEndData) error {
 	}
 
 	event := &Event{
-		ID:          uuid.New().String(),
-		SessionID:   data.SessionID,
-		EventType:   "session_end",
-		Data:        string(eventData),
-		Timestamp:   data.Timestamp,
-		SequenceNum: h.getNextSequence(data.SessionID),
+		ID:        uuid.New().String(),
+		SessionID: data.SessionID,
+		EventType: "session_end",
+		Data:      string(eventData),
+		Timestamp: data.Timestamp,
 	}
 
 	if err := CreateEvent(event); err != nil {
 		return fmt.Errorf("failed to create session end event: %w", err)
 	}
 
-	h.mu.Lock()
-	delete(h.sessionSequence, data.SessionID)
-	h.mu.Unlock()
-
 	return nil
 }
 
-func (h *HookHandler) getNextSequence(sessionID string) int {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-
-	h.sessionSequence[sessionID]++
-	return h.sessionSequence[sessionID]
-}
-
 func HandleSessionStartHook(sessionID string) error {
 	if sessionID == "" {
 		sessionID = uuid.New().String()
@@ -12,6 +12,7 @@ type BackendType string
 const (
 	BackendPureGoSQLite BackendType = "pure_go_sqlite"
 	BackendCGOSQLite    BackendType = "cgo_sqlite"
+	BackendMemory       BackendType = "memory" // nothing persisted, never auto-selected
 	BackendAuto         BackendType = "auto"
 )
 
@@ -21,6 +22,7 @@ type DatabaseConfig struct {
 	DatabasePath      string                 `json:"database_path"`
 	ConnectionTimeout time.Duration          `json:"connection_timeout"`
 	QueryTimeout      time.Duration          `json:"query_timeout"`
+	BusyTimeout       time.Duration          `json:"busy_timeout"` // wait for a locked database, 0 for the default
 	BackendOptions    map[string]interface{} `json:"backend_options,omitempty"`
 }
 
@@ -33,6 +35,7 @@ func DefaultDatabaseConfig() *DatabaseConfig {
 		DatabasePath:      getDefaultDatabasePath(),
 		ConnectionTimeout: 30 * time.Second,
 		QueryTimeout:      30 * time.Second,
+		BusyTimeout:       busyTimeoutFromEnv(),
 	}
 }
 
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"iter"
+)
+
+// sessionPageSize is how many sessions IterSessions reads at a time
+const sessionPageSize = 500
+
+// iterSessionPages yields the sessions matching filters a page at a time,
+// following the cursor of each page. Limit caps the sessions yielded
+// overall, as it does for ListSessions.
+func iterSessionPages(ctx context.Context, listPage func(context.Context, *SessionFilters) (*SessionPage, error), filters *SessionFilters) iter.Seq2[*Session, error] {
+	return func(yield func(*Session, error) bool) {
+		page := SessionFilters{}
+		if filters != nil {
+			page = *filters
+		}
+		remaining := page.Limit
+
+		for {
+			page.Limit = sessionPageSize
+			if remaining > 0 {
+				page.Limit = min(remaining, sessionPageSize)
+			}
+			result, err := listPage(ctx, &page)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, session := range result.Sessions {
+				if !yield(session, nil) {
+					return
+				}
+			}
+
+			if remaining > 0 {
+				if remaining -= len(result.Sessions); remaining <= 0 {
+					return
+				}
+			}
+			if result.NextCursor == "" {
+				return
+			}
+			// The cursor continues after the offset already skipped
+			page.Cursor, page.Offset = result.NextCursor, 0
+		}
+	}
+}
+
+// iterSlice yields the items of a slice, or err
+func iterSlice[T any](items []T, err error) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		if err != nil {
+			var zero T
+			yield(zero, err)
+			return
+		}
+		for _, item := range items {
+			if !yield(item, nil) {
+				return
+			}
+		}
+	}
+}
+
+// collect reads a sequence into a slice, stopping at the first error
+func collect[T any](seq iter.Seq2[T, error]) ([]T, error) {
+	var items []T
+	for item, err := range seq {
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestIterSessionPages(t *testing.T) {
+	ctx := context.Background()
+
+	// Pages of at most two, to cross pages
+	var requests []SessionFilters
+	listPage := func(ctx context.Context, filters *SessionFilters) (*SessionPage, error) {
+		requests = append(requests, *filters)
+		start := 0
+		if filters.Cursor != "" {
+			fmt.Sscanf(filters.Cursor, "after-%d", &start)
+		}
+		start += filters.Offset
+		end := min(start+min(filters.Limit, 2), 5)
+		page := &SessionPage{}
+		for i := start; i < end; i++ {
+			page.Sessions = append(page.Sessions, &Session{ID: fmt.Sprintf("s%d", i)})
+		}
+		if end < 5 {
+			page.NextCursor = fmt.Sprintf("after-%d", end)
+		}
+		return page, nil
+	}
+
+	ids := func(filters *SessionFilters, stopAfter int) string {
+		requests = nil
+		var got []string
+		for session, err := range iterSessionPages(ctx, listPage, filters) {
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			got = append(got, session.ID)
+			if len(got) == stopAfter {
+				break
+			}
+		}
+		return strings.Join(got, ",")
+	}
+
+	if got := ids(nil, 0); got != "s0,s1,s2,s3,s4" || len(requests) != 3 {
+		t.Errorf("Expected every session over 3 pages, got %s over %d", got, len(requests))
+	}
+	if got := ids(&SessionFilters{Offset: 1}, 0); got != "s1,s2,s3,s4" || requests[1].Offset != 0 {
+		t.Errorf("Expected the offset to apply to the first page only, got %s", got)
+	}
+	if got := ids(&SessionFilters{Limit: 3}, 0); got != "s0,s1,s2" || requests[0].Limit != 3 {
+		t.Errorf("Expected the limit to cap the sessions, got %s", got)
+	}
+	if got := ids(nil, 1); got != "s0" || len(requests) != 1 {
+		t.Errorf("Expected breaking to stop reading pages, got %s after %d", got, len(requests))
+	}
+
+	failing := func(context.Context, *SessionFilters) (*SessionPage, error) { return nil, ErrInvalidCursor }
+	for session, err := range iterSessionPages(ctx, failing, nil) {
+		if session != nil || !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Expected the error yielded, got %v, %v", session, err)
+		}
+	}
+}
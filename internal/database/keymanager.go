@@ -4,18 +4,21 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"time"
 
+	"context-extender/internal/config"
 )
 
 // KeyManager handles encryption key generation and storage
 type KeyManager struct {
-	keyPath   string
-	keyInfo   *KeyInfo
+	keyPath string
+	keyInfo *KeyInfo
 }
 
 // KeyInfo stores metadata about the encryption key
@@ -27,7 +30,11 @@ type KeyInfo struct {
 	Algorithm     string    `json:"algorithm"`
 	Iterations    int       `json:"iterations"`
 	Salt          string    `json:"salt"`
-	KeyHash       string    `json:"key_hash"` // For verification, not the actual key
+	KeyHash       string    `json:"key_hash"`              // For verification, not the actual key
+	Memory        uint32    `json:"memory,omitempty"`      // argon2id memory in KiB
+	Parallelism   uint8     `json:"parallelism,omitempty"` // argon2id threads, scrypt p
+	BlockSize     int       `json:"block_size,omitempty"`  // scrypt r
+	WrappedKey    string    `json:"wrapped_key,omitempty"` // The key sealed under the passphrase
 }
 
 // NewKeyManager creates a new key manager
@@ -42,6 +49,11 @@ func NewKeyManager(keyPath string) *KeyManager {
 	}
 }
 
+// KeyPath returns the directory the key is kept in
+func (km *KeyManager) KeyPath() string {
+	return km.keyPath
+}
+
 // GenerateKey generates a new database encryption key
 func (km *KeyManager) GenerateKey() (string, error) {
 	// Generate random bytes for key
@@ -59,8 +71,10 @@ func (km *KeyManager) GenerateKey() (string, error) {
 	// For now, just use the raw key bytes
 	// In production, would use PBKDF2 from golang.org/x/crypto/pbkdf2
 	derivedKey := keyBytes
+	// Convert to hex string for SQLCipher
+	hexKey := fmt.Sprintf("%x", derivedKey)
 
-	// Create key info
+	// Create key info, hashing the key as it is saved so LoadKey can verify it
 	km.keyInfo = &KeyInfo{
 		Version:       1,
 		CreatedAt:     time.Now(),
@@ -69,11 +83,10 @@ func (km *KeyManager) GenerateKey() (string, error) {
 		Algorithm:     "PBKDF2-SHA256",
 		Iterations:    256000,
 		Salt:          base64.StdEncoding.EncodeToString(salt),
-		KeyHash:       km.hashKey(derivedKey),
+		KeyHash:       km.hashKey([]byte(hexKey)),
 	}
 
-	// Convert to hex string for SQLCipher
-	return fmt.Sprintf("%x", derivedKey), nil
+	return hexKey, nil
 }
 
 // SaveKey saves the key and its metadata
@@ -83,9 +96,10 @@ func (km *KeyManager) SaveKey(key string) error {
 		return fmt.Errorf("failed to create key directory: %w", err)
 	}
 
-	// Save the actual key (encrypted in production)
+	// Save the actual key (encrypted in production). It may be the only
+	// copy, so it is replaced atomically rather than overwritten.
 	keyFile := filepath.Join(km.keyPath, "db.key")
-	if err := os.WriteFile(keyFile, []byte(key), 0600); err != nil {
+	if err := config.WriteFileAtomic(keyFile, []byte(key), 0600); err != nil {
 		return fmt.Errorf("failed to write key file: %w", err)
 	}
 
@@ -97,7 +111,7 @@ func (km *KeyManager) SaveKey(key string) error {
 			return fmt.Errorf("failed to marshal key info: %w", err)
 		}
 
-		if err := os.WriteFile(metadataFile, data, 0600); err != nil {
+		if err := config.WriteFileAtomic(metadataFile, data, 0600); err != nil {
 			return fmt.Errorf("failed to write key metadata: %w", err)
 		}
 	}
@@ -105,8 +119,76 @@ func (km *KeyManager) SaveKey(key string) error {
 	return nil
 }
 
-// LoadKey loads the encryption key
+// SaveWrappedKey saves the key, or a key ring, sealed under a passphrase
+// derived with kdf, and removes any unprotected copy
+func (km *KeyManager) SaveWrappedKey(key, passphrase, kdf string) error {
+	if passphrase == "" {
+		return fmt.Errorf("passphrase can't be empty")
+	}
+	if _, err := parseKeyRing(key, 1); err != nil {
+		return err
+	}
+
+	info := &KeyInfo{Version: 1, CreatedAt: time.Now(), LastRotated: time.Now()}
+	if km.keyInfo != nil {
+		copied := *km.keyInfo
+		info = &copied
+	}
+	info.KeyHash = km.hashKey([]byte(key))
+	if err := info.wrap([]byte(key), passphrase, kdf); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(km.keyPath, 0700); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal key info: %w", err)
+	}
+	if err := config.WriteFileAtomic(filepath.Join(km.keyPath, "key.json"), data, 0600); err != nil {
+		return fmt.Errorf("failed to write key metadata: %w", err)
+	}
+	if err := os.Remove(filepath.Join(km.keyPath, "db.key")); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove unprotected key: %w", err)
+	}
+
+	km.keyInfo = info
+	return nil
+}
+
+// ChangePassphrase rewraps the key under a new passphrase. An unprotected
+// key becomes protected; the keys themselves stay the same.
+func (km *KeyManager) ChangePassphrase(newPassphrase, kdf string) error {
+	key, err := km.loadKeyText()
+	if err != nil {
+		return err
+	}
+	return km.SaveWrappedKey(key, newPassphrase, kdf)
+}
+
+// IsWrapped reports whether the key is protected by a passphrase
+func (km *KeyManager) IsWrapped() bool {
+	info, err := km.GetKeyInfo()
+	return err == nil && info.WrappedKey != ""
+}
+
+// LoadKey loads the current encryption key, unwrapping it with the
+// passphrase from ResolvePassphrase when it is protected
 func (km *KeyManager) LoadKey() (string, error) {
+	ring, err := km.LoadKeyRing()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(ring.Keys[ring.Current()]), nil
+}
+
+// loadKeyText loads the key as stored, a single key or a key ring
+func (km *KeyManager) loadKeyText() (string, error) {
+	if km.IsWrapped() {
+		return km.unwrapKey()
+	}
+
 	keyFile := filepath.Join(km.keyPath, "db.key")
 
 	// Check if key exists
@@ -134,52 +216,89 @@ func (km *KeyManager) LoadKey() (string, error) {
 	return string(keyData), nil
 }
 
-// RotateKey generates a new key and updates metadata
-func (km *KeyManager) RotateKey() (oldKey, newKey string, error error) {
-	// Load current key
-	oldKey, err := km.LoadKey()
+// unwrapKey opens a wrapped key
+func (km *KeyManager) unwrapKey() (string, error) {
+	passphrase, err := ResolvePassphrase(fmt.Sprintf("Passphrase for %s: ", km.keyPath))
 	if err != nil {
-		return "", "", fmt.Errorf("failed to load current key: %w", err)
+		return "", err
 	}
 
-	// Generate new key
-	newKey, err = km.GenerateKey()
+	key, err := km.keyInfo.unwrap(passphrase)
+	if errors.Is(err, ErrWrongPassphrase) {
+		forgetPassphrase()
+	}
 	if err != nil {
-		return "", "", fmt.Errorf("failed to generate new key: %w", err)
+		return "", err
 	}
 
-	// Update metadata
-	if km.keyInfo != nil {
-		km.keyInfo.LastRotated = time.Now()
-		km.keyInfo.RotationCount++
-		km.keyInfo.KeyHash = km.hashKey([]byte(newKey))
+	// Keys wrapped before key rings were wrapped as raw bytes
+	text := string(key)
+	if len(key) == 32 {
+		text = hex.EncodeToString(key)
+	}
+	if km.keyInfo.KeyHash != "" && km.keyInfo.KeyHash != km.hashKey([]byte(text)) {
+		return "", fmt.Errorf("key verification failed: hash mismatch")
+	}
+	return text, nil
+}
+
+// saveKeyText stores the key as it was stored before, wrapped under the
+// same passphrase or not wrapped
+func (km *KeyManager) saveKeyText(key string) error {
+	if !km.IsWrapped() {
+		if km.keyInfo != nil {
+			km.keyInfo.KeyHash = km.hashKey([]byte(key))
+		}
+		return km.SaveKey(key)
 	}
 
-	// Backup old key
-	backupDir := filepath.Join(km.keyPath, "backup")
-	if err := os.MkdirAll(backupDir, 0700); err != nil {
-		return "", "", fmt.Errorf("failed to create backup directory: %w", err)
+	passphrase, err := ResolvePassphrase(fmt.Sprintf("Passphrase for %s: ", km.keyPath))
+	if err != nil {
+		return err
 	}
+	return km.SaveWrappedKey(key, passphrase, km.keyInfo.Algorithm)
+}
 
-	timestamp := time.Now().Format("20060102-150405")
-	backupFile := filepath.Join(backupDir, fmt.Sprintf("db.key.%s", timestamp))
-	if err := os.WriteFile(backupFile, []byte(oldKey), 0600); err != nil {
-		return "", "", fmt.Errorf("failed to backup old key: %w", err)
+// RotateKey adds a new key version, which becomes the current key. The old
+// versions stay in the key ring until they are retired. The ring is on disk
+// when it returns, so nothing is sealed with a key that could be lost.
+func (km *KeyManager) RotateKey() (oldKey, newKey string, error error) {
+	ring, err := km.LoadKeyRing()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load current key: %w", err)
 	}
+	current := ring.Current()
 
-	// Save new key
-	if err := km.SaveKey(newKey); err != nil {
-		return "", "", fmt.Errorf("failed to save new key: %w", err)
+	keyBytes := make([]byte, 32)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return "", "", fmt.Errorf("failed to generate random key: %w", err)
+	}
+	ring.Keys[current+1] = keyBytes
+
+	// The metadata only changes once the new ring is saved
+	previous := km.keyInfo
+	info := &KeyInfo{CreatedAt: time.Now()}
+	if previous != nil {
+		copied := *previous
+		info = &copied
 	}
+	info.Version = current + 1
+	info.LastRotated = time.Now()
+	info.RotationCount++
 
-	return oldKey, newKey, nil
+	km.keyInfo = info
+	if err := km.saveKeyText(ring.String()); err != nil {
+		km.keyInfo = previous
+		return "", "", fmt.Errorf("failed to save new key: %w", err)
+	}
+	return hex.EncodeToString(ring.Keys[current]), hex.EncodeToString(keyBytes), nil
 }
 
-// KeyExists checks if an encryption key exists
+// KeyExists checks if an encryption key exists, wrapped or not
 func (km *KeyManager) KeyExists() bool {
 	keyFile := filepath.Join(km.keyPath, "db.key")
 	_, err := os.Stat(keyFile)
-	return err == nil
+	return err == nil || km.IsWrapped()
 }
 
 // DeleteKey removes the encryption key (dangerous!)
@@ -225,34 +344,3 @@ func (km *KeyManager) hashKey(key []byte) string {
 	hash := sha256.Sum256(key)
 	return fmt.Sprintf("%x", hash)
 }
-
-// DeriveKeyFromPassword derives an encryption key from a password
-func DeriveKeyFromPassword(password string, iterations int) (string, string, error) {
-	// Generate salt
-	salt := make([]byte, 16)
-	if _, err := rand.Read(salt); err != nil {
-		return "", "", fmt.Errorf("failed to generate salt: %w", err)
-	}
-
-	// Simple SHA256 hash for now
-	// In production, would use PBKDF2
-	hash := sha256.Sum256(append([]byte(password), salt...))
-	key := hash[:]
-
-	// Return key and salt as hex strings
-	return fmt.Sprintf("%x", key), fmt.Sprintf("%x", salt), nil
-}
-
-// VerifyPassword verifies a password against stored salt
-func VerifyPassword(password, salt string, expectedKey string) bool {
-	saltBytes, err := base64.StdEncoding.DecodeString(salt)
-	if err != nil {
-		return false
-	}
-
-	// Simple SHA256 hash for verification
-	hash := sha256.Sum256(append([]byte(password), saltBytes...))
-	derivedKeyHex := fmt.Sprintf("%x", hash[:])
-
-	return derivedKeyHex == expectedKey
-}
\ No newline at end of file
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// KeyRing holds every key version still needed to decrypt data. The
+// highest version is the current key, the one new data is encrypted with.
+// A ring with only version 1 is stored as that key in hex, the format used
+// before keys had versions; otherwise each line is "version:hex".
+type KeyRing struct {
+	Keys map[int][]byte
+}
+
+// Current returns the current key version
+func (r *KeyRing) Current() int {
+	current := 0
+	for version := range r.Keys {
+		if version > current {
+			current = version
+		}
+	}
+	return current
+}
+
+// Versions returns the key versions, oldest first
+func (r *KeyRing) Versions() []int {
+	versions := make([]int, 0, len(r.Keys))
+	for version := range r.Keys {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// String returns the ring as stored
+func (r *KeyRing) String() string {
+	if len(r.Keys) == 1 && r.Keys[1] != nil {
+		return hex.EncodeToString(r.Keys[1])
+	}
+
+	lines := make([]string, 0, len(r.Keys))
+	for _, version := range r.Versions() {
+		lines = append(lines, fmt.Sprintf("%d:%s", version, hex.EncodeToString(r.Keys[version])))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseKeyRing parses a stored key ring, taking a lone key to be version
+func parseKeyRing(text string, version int) (*KeyRing, error) {
+	ring := &KeyRing{Keys: make(map[int][]byte)}
+	for _, line := range strings.Fields(text) {
+		keyVersion, keyHex := version, line
+		if before, after, found := strings.Cut(line, ":"); found {
+			parsed, err := strconv.Atoi(before)
+			if err != nil || parsed < 1 {
+				return nil, fmt.Errorf("invalid key version %q", before)
+			}
+			keyVersion, keyHex = parsed, after
+		}
+
+		key, err := hex.DecodeString(keyHex)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("invalid key for version %d", keyVersion)
+		}
+		if _, ok := ring.Keys[keyVersion]; ok {
+			return nil, fmt.Errorf("duplicate key version %d", keyVersion)
+		}
+		ring.Keys[keyVersion] = key
+	}
+	if len(ring.Keys) == 0 {
+		return nil, fmt.Errorf("key file is empty")
+	}
+	return ring, nil
+}
+
+// LoadKeyRing loads every key version
+func (km *KeyManager) LoadKeyRing() (*KeyRing, error) {
+	text, err := km.loadKeyText()
+	if err != nil {
+		return nil, err
+	}
+
+	version := 1
+	if km.keyInfo != nil && km.keyInfo.Version > 0 {
+		version = km.keyInfo.Version
+	}
+	return parseKeyRing(text, version)
+}
+
+// RetireKeyVersion removes a key version that no data uses anymore. The
+// current version can't be retired.
+func (km *KeyManager) RetireKeyVersion(version int) error {
+	ring, err := km.LoadKeyRing()
+	if err != nil {
+		return err
+	}
+	if version == ring.Current() {
+		return fmt.Errorf("key version %d is the current key", version)
+	}
+	if _, ok := ring.Keys[version]; !ok {
+		return nil
+	}
+
+	delete(ring.Keys, version)
+	return km.saveKeyText(ring.String())
+}
@@ -0,0 +1,336 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// SchemaLayout identifies which schema an existing database file has
+type SchemaLayout string
+
+const (
+	// LayoutEmpty is a database without tables
+	LayoutEmpty SchemaLayout = "empty"
+	// LayoutLegacyImport is the layout RunMigrations created before schema
+	// versioning: integer ids, events.event_data, events.sequence_number,
+	// conversations.model_info and TEXT timestamps
+	LayoutLegacyImport SchemaLayout = "legacy-import"
+	// LayoutUnversioned is the current layout created by CreateSchema before
+	// schema versioning, possibly missing the newer tables
+	LayoutUnversioned SchemaLayout = "unversioned"
+	// LayoutVersioned is a database tracked in schema_migrations
+	LayoutVersioned SchemaLayout = "versioned"
+)
+
+// legacyMigrationNames are the migrations RunMigrations recorded in
+// schema_migrations before schema versioning
+var legacyMigrationNames = []string{
+	"create_sessions_table",
+	"create_events_table",
+	"create_conversations_table",
+	"create_import_history_table",
+	"create_settings_table",
+	"create_schema_migrations_table",
+}
+
+// queryer is satisfied by *sql.DB, *sql.Conn and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// TableRewrite describes a legacy table copied into the current layout
+type TableRewrite struct {
+	Table   string   `json:"table"`
+	Rows    int      `json:"rows"`
+	Changes []string `json:"changes"`
+
+	spec    *tableSpec
+	columns map[string]string // existing column name to declared type
+}
+
+// columnSpec describes a column of the current layout
+type columnSpec struct {
+	Name    string
+	Type    string
+	Legacy  string // the column's name in the legacy layout
+	Default string // SQL used when the legacy value is missing or NULL
+}
+
+// tableSpec describes a core table of the current layout
+type tableSpec struct {
+	Name    string
+	Schema  string
+	Columns []columnSpec
+}
+
+// coreTables are the tables whose layout differs between legacy databases
+// and the current schema
+var coreTables = []*tableSpec{
+	{
+		Name:   "sessions",
+		Schema: sessionsTableSchema,
+		Columns: []columnSpec{
+			{Name: "id", Type: "TEXT"},
+			{Name: "created_at", Type: "DATETIME", Default: "CURRENT_TIMESTAMP"},
+			{Name: "updated_at", Type: "DATETIME", Default: "CURRENT_TIMESTAMP"},
+			{Name: "status", Type: "TEXT", Default: "'active'"},
+			{Name: "metadata", Type: "TEXT", Default: "'{}'"},
+		},
+	},
+	{
+		Name:   "events",
+		Schema: eventsTableSchema,
+		Columns: []columnSpec{
+			{Name: "id", Type: "TEXT"},
+			{Name: "session_id", Type: "TEXT"},
+			{Name: "event_type", Type: "TEXT", Default: "''"},
+			{Name: "timestamp", Type: "DATETIME", Default: "CURRENT_TIMESTAMP"},
+			{Name: "sequence_num", Type: "INTEGER", Legacy: "sequence_number", Default: "0"},
+			{Name: "data", Type: "TEXT", Legacy: "event_data", Default: "''"},
+		},
+	},
+	{
+		Name:   "conversations",
+		Schema: conversationsTableSchema,
+		Columns: []columnSpec{
+			{Name: "id", Type: "TEXT"},
+			{Name: "session_id", Type: "TEXT"},
+			{Name: "message_type", Type: "TEXT", Default: "''"},
+			{Name: "content", Type: "TEXT", Default: "''"},
+			{Name: "timestamp", Type: "DATETIME", Default: "CURRENT_TIMESTAMP"},
+			{Name: "metadata", Type: "TEXT", Default: "'{}'"},
+			{Name: "token_count", Type: "INTEGER", Default: "0"},
+			{Name: "model", Type: "TEXT", Legacy: "model_info", Default: "''"},
+		},
+	},
+}
+
+// detectSchemaLayout inspects the core tables and returns the layout along
+// with the tables that have to be rewritten
+func detectSchemaLayout(ctx context.Context, q queryer) (SchemaLayout, []*TableRewrite, error) {
+	version, err := schemaVersion(ctx, q)
+	if err != nil {
+		return "", nil, err
+	}
+	legacyVersions, err := countLegacyMigrations(ctx, q)
+	if err != nil {
+		return "", nil, err
+	}
+	// Versioned databases only ever had the current layout; later
+	// migrations may change the core tables, so they aren't compared
+	if version > 0 && legacyVersions == 0 {
+		return LayoutVersioned, nil, nil
+	}
+
+	var rewrites []*TableRewrite
+	found := false
+	for _, spec := range coreTables {
+		columns, err := tableColumns(ctx, q, spec.Name)
+		if err != nil {
+			return "", nil, err
+		}
+		if columns == nil {
+			continue
+		}
+		found = true
+
+		rewrite, err := planTableRewrite(ctx, q, spec, columns)
+		if err != nil {
+			return "", nil, err
+		}
+		if rewrite != nil {
+			rewrites = append(rewrites, rewrite)
+		}
+	}
+
+	switch {
+	case len(rewrites) > 0 || legacyVersions > 0:
+		return LayoutLegacyImport, rewrites, nil
+	case found:
+		return LayoutUnversioned, nil, nil
+	default:
+		return LayoutEmpty, nil, nil
+	}
+}
+
+// tableColumns returns a table's columns and their declared types, nil if
+// the table doesn't exist
+func tableColumns(ctx context.Context, q queryer, table string) (map[string]string, error) {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	var columns map[string]string
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, declType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &declType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, fmt.Errorf("failed to inspect table %s: %w", table, err)
+		}
+		if columns == nil {
+			columns = make(map[string]string)
+		}
+		columns[name] = strings.ToUpper(declType)
+	}
+	return columns, rows.Err()
+}
+
+// planTableRewrite compares a table with the current layout and returns the
+// rewrite it needs, nil when it already has the current layout. Columns the
+// current layout has no place for are an error rather than being dropped.
+func planTableRewrite(ctx context.Context, q queryer, spec *tableSpec, columns map[string]string) (*TableRewrite, error) {
+	rewrite := &TableRewrite{Table: spec.Name, spec: spec, columns: columns}
+	known := make(map[string]bool)
+
+	for _, column := range spec.Columns {
+		known[column.Name] = true
+		if column.Legacy != "" {
+			known[column.Legacy] = true
+		}
+
+		source, declType := column.Name, columns[column.Name]
+		if _, ok := columns[column.Name]; !ok {
+			if _, ok := columns[column.Legacy]; column.Legacy != "" && ok {
+				source, declType = column.Legacy, columns[column.Legacy]
+				rewrite.Changes = append(rewrite.Changes, fmt.Sprintf("%s → %s", column.Legacy, column.Name))
+			} else {
+				rewrite.Changes = append(rewrite.Changes, fmt.Sprintf("%s added", column.Name))
+				continue
+			}
+		}
+		if declType != column.Type {
+			rewrite.Changes = append(rewrite.Changes, fmt.Sprintf("%s %s → %s", source, declType, column.Type))
+		}
+	}
+
+	if len(rewrite.Changes) == 0 {
+		return nil, nil
+	}
+	for name := range columns {
+		if !known[name] {
+			return nil, fmt.Errorf("table %s has a column %s the current schema has no place for, refusing to rewrite it", spec.Name, name)
+		}
+	}
+
+	if err := q.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", spec.Name)).Scan(&rewrite.Rows); err != nil {
+		return nil, fmt.Errorf("failed to count rows in %s: %w", spec.Name, err)
+	}
+	return rewrite, nil
+}
+
+// copyExpression returns the SQL reading a current column from the legacy table
+func (r *TableRewrite) copyExpression(column columnSpec) string {
+	source := ""
+	if _, ok := r.columns[column.Name]; ok {
+		source = column.Name
+	} else if _, ok := r.columns[column.Legacy]; column.Legacy != "" && ok {
+		source = column.Legacy
+	}
+
+	switch {
+	case source == "" && column.Default == "":
+		return "NULL"
+	case source == "":
+		return column.Default
+	case column.Name == "id":
+		return fmt.Sprintf("CAST(%s AS TEXT)", source)
+	case column.Default == "":
+		return source
+	default:
+		return fmt.Sprintf("COALESCE(%s, %s)", source, column.Default)
+	}
+}
+
+// rewriteLegacyTables copies legacy tables into the current layout inside
+// the caller's transaction. Foreign keys must be off.
+func rewriteLegacyTables(ctx context.Context, conn *sql.Conn, rewrites []*TableRewrite) error {
+	if len(rewrites) == 0 {
+		return nil
+	}
+
+	// Keep references from other tables pointing at the table name, which
+	// the rewritten table takes over
+	if _, err := conn.ExecContext(ctx, "PRAGMA legacy_alter_table = ON"); err != nil {
+		return fmt.Errorf("failed to prepare table rewrite: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "PRAGMA legacy_alter_table = OFF")
+
+	rewroteConversations := false
+	for _, rewrite := range rewrites {
+		table := rewrite.Table
+		legacy := table + "_legacy"
+
+		columns := make([]string, len(rewrite.spec.Columns))
+		expressions := make([]string, len(rewrite.spec.Columns))
+		for i, column := range rewrite.spec.Columns {
+			columns[i] = column.Name
+			expressions[i] = rewrite.copyExpression(column)
+		}
+
+		statements := []string{
+			fmt.Sprintf("ALTER TABLE %s RENAME TO %s", table, legacy),
+			rewrite.spec.Schema,
+			fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", table, strings.Join(columns, ", "), strings.Join(expressions, ", "), legacy),
+		}
+		for _, statement := range statements {
+			if _, err := conn.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("failed to rewrite table %s: %w", table, err)
+			}
+		}
+
+		var copied int
+		if err := conn.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Scan(&copied); err != nil {
+			return fmt.Errorf("failed to verify table %s: %w", table, err)
+		}
+		if copied != rewrite.Rows {
+			return fmt.Errorf("failed to rewrite table %s: copied %d of %d rows", table, copied, rewrite.Rows)
+		}
+
+		// Dropping the legacy table also drops its indexes and triggers;
+		// the migrations recreate them for the new table
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("DROP TABLE %s", legacy)); err != nil {
+			return fmt.Errorf("failed to drop legacy table %s: %w", legacy, err)
+		}
+		if table == "conversations" {
+			rewroteConversations = true
+		}
+	}
+
+	// The search index refers to conversations by rowid, which changed
+	if rewroteConversations {
+		exists, err := tableExists(ctx, conn, "conversations_fts")
+		if err != nil {
+			return err
+		}
+		if exists {
+			if _, err := conn.ExecContext(ctx, searchIndexSchema+`INSERT INTO conversations_fts (conversations_fts) VALUES ('rebuild');`); err != nil {
+				return fmt.Errorf("failed to rebuild search index: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// countLegacyMigrations counts the versions RunMigrations recorded before
+// schema versioning
+func countLegacyMigrations(ctx context.Context, q queryer) (int, error) {
+	exists, err := tableExists(ctx, q, "schema_migrations")
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var count int
+	query := `SELECT COUNT(*) FROM schema_migrations WHERE name IN (` + legacyMigrationList() + `)`
+	if err := q.QueryRowContext(ctx, query).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to read schema migrations: %w", err)
+	}
+	return count, nil
+}
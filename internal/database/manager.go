@@ -38,6 +38,9 @@ func (m *Manager) registerBackends() {
 	// Register Pure Go SQLite backend
 	m.registry[BackendPureGoSQLite] = &PureGoSQLiteFactory{}
 
+	// Register the in-memory backend, only used when asked for explicitly
+	m.registry[BackendMemory] = &MemoryFactory{}
+
 	// Register CGO SQLite backend if available (legacy support)
 	if m.isCGOAvailable() {
 		m.registry[BackendCGOSQLite] = &CGOSQLiteFactory{}
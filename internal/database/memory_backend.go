@@ -0,0 +1,1225 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryBackend implements DatabaseBackend in process memory. Nothing is
+// written to disk and everything is gone once it is closed, which suits
+// ephemeral capture and unit tests. It behaves like the SQLite backends,
+// including ordering, constraint errors and full-text search ranking.
+type MemoryBackend struct {
+	mu     sync.RWMutex
+	config *DatabaseConfig
+	open   bool
+
+	sessions      map[string]*Session
+	sessionOrder  []string
+	events        map[string]*Event
+	eventOrder    []string
+	conversations map[string]*Conversation
+	convOrder     []string
+	tools         map[string]*ToolInvocation
+	toolOrder     []string
+	cursors       map[string]*TranscriptCursor
+	imports       map[string]*ImportRecord
+	importOrder   []string
+
+	applied map[int]time.Time // schema versions and when they were applied
+
+	txMu sync.Mutex // runs WithTx calls one at a time
+}
+
+// NewMemoryBackend creates a new in-memory backend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{}
+}
+
+// Initialize prepares empty storage
+func (b *MemoryBackend) Initialize(ctx context.Context, config *DatabaseConfig) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.config = config
+	b.open = true
+	b.sessions = make(map[string]*Session)
+	b.sessionOrder = nil
+	b.events = make(map[string]*Event)
+	b.eventOrder = nil
+	b.conversations = make(map[string]*Conversation)
+	b.convOrder = nil
+	b.tools = make(map[string]*ToolInvocation)
+	b.toolOrder = nil
+	b.cursors = make(map[string]*TranscriptCursor)
+	b.imports = make(map[string]*ImportRecord)
+	b.importOrder = nil
+	b.applied = make(map[int]time.Time)
+	return nil
+}
+
+// Close discards all data
+func (b *MemoryBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.open = false
+	b.sessions = nil
+	b.events = nil
+	b.conversations = nil
+	b.tools = nil
+	b.cursors = nil
+	b.imports = nil
+	return nil
+}
+
+// Ping reports whether the backend is open
+func (b *MemoryBackend) Ping(ctx context.Context) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.check(ctx)
+}
+
+// check returns an error when the backend can't serve a request. Callers
+// hold the lock.
+func (b *MemoryBackend) check(ctx context.Context) error {
+	if !b.open {
+		return fmt.Errorf("database not initialized")
+	}
+	return ctx.Err()
+}
+
+// GetBackendInfo returns backend information
+func (b *MemoryBackend) GetBackendInfo() *BackendInfo {
+	return &BackendInfo{
+		Name:        "In-Memory",
+		Version:     "1",
+		RequiresCGO: false,
+		Features:    []string{"No persistence", "Full-text search"},
+		Capabilities: map[string]bool{
+			"encryption":   false,
+			"full_text":    true,
+			"transactions": false,
+			"cgo":          false,
+			"persistent":   false,
+		},
+	}
+}
+
+// CreateSchema applies all migrations
+func (b *MemoryBackend) CreateSchema(ctx context.Context) error {
+	return b.MigrateSchema(ctx, SchemaVersion)
+}
+
+// GetSchemaVersion returns the highest applied migration
+func (b *MemoryBackend) GetSchemaVersion(ctx context.Context) (int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if err := b.check(ctx); err != nil {
+		return 0, err
+	}
+	return b.version(), nil
+}
+
+// version returns the highest applied migration. Callers hold the lock.
+func (b *MemoryBackend) version() int {
+	version := 0
+	for applied := range b.applied {
+		version = max(version, applied)
+	}
+	return version
+}
+
+// MigrateSchema applies or reverts migrations to reach the target version.
+// Reverting a migration drops the data of the tables it created.
+func (b *MemoryBackend) MigrateSchema(ctx context.Context, targetVersion int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	plan, err := b.planMigration(ctx, targetVersion)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range plan.Reverts {
+		switch migration.Name {
+		case "create_core_tables":
+			b.sessions, b.sessionOrder = make(map[string]*Session), nil
+			b.events, b.eventOrder = make(map[string]*Event), nil
+			b.conversations, b.convOrder = make(map[string]*Conversation), nil
+		case "create_tool_invocations":
+			b.tools, b.toolOrder = make(map[string]*ToolInvocation), nil
+		case "create_transcript_cursors":
+			b.cursors = make(map[string]*TranscriptCursor)
+		}
+		delete(b.applied, migration.Version)
+	}
+	now := time.Now().UTC()
+	for _, migration := range plan.Pending {
+		b.applied[migration.Version] = now
+	}
+	return nil
+}
+
+// PlanMigration returns what MigrateSchema would do without changing anything
+func (b *MemoryBackend) PlanMigration(ctx context.Context, targetVersion int) (*MigrationPlan, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.planMigration(ctx, targetVersion)
+}
+
+// planMigration works out the migrations between the current and the
+// target version. Callers hold the lock.
+func (b *MemoryBackend) planMigration(ctx context.Context, target int) (*MigrationPlan, error) {
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+	if target < 0 || target > SchemaVersion {
+		return nil, fmt.Errorf("unknown schema version %d, this build supports 0 to %d", target, SchemaVersion)
+	}
+
+	current := b.version()
+	plan := &MigrationPlan{Layout: LayoutVersioned, CurrentVersion: current, TargetVersion: target}
+	if current == 0 {
+		plan.Layout = LayoutEmpty
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if _, ok := b.applied[migrations[i].Version]; ok && migrations[i].Version > target {
+			plan.Reverts = append(plan.Reverts, migrations[i])
+		}
+	}
+	for _, migration := range migrations {
+		if _, ok := b.applied[migration.Version]; !ok && migration.Version <= target {
+			plan.Pending = append(plan.Pending, migration)
+		}
+	}
+	return plan, nil
+}
+
+// MigrationStatus lists the known migrations and whether they are applied
+func (b *MemoryBackend) MigrationStatus(ctx context.Context) ([]*MigrationState, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+
+	var states []*MigrationState
+	for i := range migrations {
+		migration := &migrations[i]
+		state := &MigrationState{Version: migration.Version, Name: migration.Name, Status: MigrationPending}
+		if appliedAt, ok := b.applied[migration.Version]; ok {
+			state.Status = MigrationApplied
+			state.AppliedAt = appliedAt.Format("2006-01-02 15:04:05")
+			state.Checksum = migration.Checksum()
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// uniqueViolation returns the error SQLite reports for a duplicate key
+func uniqueViolation(column string) error {
+	return fmt.Errorf("UNIQUE constraint failed: %s", column)
+}
+
+// errForeignKey is returned for rows referring to a session that doesn't exist
+var errForeignKey = errors.New("FOREIGN KEY constraint failed")
+
+// checkSessionRef enforces the session_id foreign key. Callers hold the lock.
+func (b *MemoryBackend) checkSessionRef(sessionID string) error {
+	if _, ok := b.sessions[sessionID]; !ok {
+		return errForeignKey
+	}
+	return nil
+}
+
+// CreateSession creates a new session
+func (b *MemoryBackend) CreateSession(ctx context.Context, session *Session) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	if _, ok := b.sessions[session.ID]; ok {
+		return uniqueViolation("sessions.id")
+	}
+
+	stored := *session
+	b.sessions[session.ID] = &stored
+	b.sessionOrder = append(b.sessionOrder, session.ID)
+	return nil
+}
+
+// GetSession retrieves a session by ID
+func (b *MemoryBackend) GetSession(ctx context.Context, id string) (*Session, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+	session, ok := b.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return b.conversationCounts().session(session), nil
+}
+
+// UpdateSession updates an existing session; unknown sessions are ignored
+func (b *MemoryBackend) UpdateSession(ctx context.Context, session *Session) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	if stored, ok := b.sessions[session.ID]; ok {
+		stored.UpdatedAt = session.UpdatedAt
+		stored.Status = session.Status
+		stored.Metadata = session.Metadata
+	}
+	return nil
+}
+
+// DeleteSession moves a session to the trash
+func (b *MemoryBackend) DeleteSession(ctx context.Context, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	session, ok := b.sessions[id]
+	if !ok || session.DeletedAt != nil {
+		return ErrSessionNotFound
+	}
+	deletedAt := time.Now().UTC()
+	session.DeletedAt = &deletedAt
+	return nil
+}
+
+// RestoreSession takes a session back out of the trash
+func (b *MemoryBackend) RestoreSession(ctx context.Context, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	session, ok := b.sessions[id]
+	if !ok || session.DeletedAt == nil {
+		return ErrSessionNotFound
+	}
+	session.DeletedAt = nil
+	return nil
+}
+
+// PurgeSession deletes a session and everything recorded for it, whether
+// it is in the trash or not
+func (b *MemoryBackend) PurgeSession(ctx context.Context, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	_, spilled := b.purgeSession(id)
+	return removeSpilledOutputs(spilled)
+}
+
+// ListTrash returns the sessions in the trash, most recently deleted first
+func (b *MemoryBackend) ListTrash(ctx context.Context) ([]*Session, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+
+	var trash []*Session
+	for _, id := range b.sessionOrder {
+		if session := b.sessions[id]; session.DeletedAt != nil {
+			found := *session
+			trash = append(trash, &found)
+		}
+	}
+	sort.SliceStable(trash, func(i, j int) bool {
+		return trash[i].DeletedAt.After(*trash[j].DeletedAt)
+	})
+	return trash, nil
+}
+
+// EmptyTrash deletes the sessions moved to the trash before deletedBefore
+func (b *MemoryBackend) EmptyTrash(ctx context.Context, deletedBefore time.Time) (*PurgeResult, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+
+	result := &PurgeResult{}
+	var spilled []string
+	for _, id := range slices.Clone(b.sessionOrder) {
+		if session := b.sessions[id]; session.DeletedAt != nil && session.DeletedAt.Before(deletedBefore) {
+			rows, paths := b.purgeSession(id)
+			result.Sessions++
+			result.Rows += rows
+			spilled = append(spilled, paths...)
+		}
+	}
+	if err := removeSpilledOutputs(spilled); err != nil {
+		return result, fmt.Errorf("purged, but %w", err)
+	}
+	return result, nil
+}
+
+// purgeSession deletes a session and its records, returning how many
+// records were deleted and the files its tool outputs were spilled to.
+// Callers hold the lock.
+func (b *MemoryBackend) purgeSession(id string) (int64, []string) {
+	var spilled []string
+	before := len(b.eventOrder) + len(b.convOrder) + len(b.toolOrder)
+	if _, ok := b.cursors[id]; ok {
+		before++
+	}
+
+	b.eventOrder = removeWhere(b.eventOrder, func(key string) bool {
+		if b.events[key].SessionID != id {
+			return false
+		}
+		delete(b.events, key)
+		return true
+	})
+	b.convOrder = removeWhere(b.convOrder, func(key string) bool {
+		if b.conversations[key].SessionID != id {
+			return false
+		}
+		delete(b.conversations, key)
+		return true
+	})
+	b.toolOrder = removeWhere(b.toolOrder, func(key string) bool {
+		if b.tools[key].SessionID != id {
+			return false
+		}
+		if path := b.tools[key].OutputPath; path != "" {
+			spilled = append(spilled, path)
+		}
+		delete(b.tools, key)
+		return true
+	})
+	delete(b.cursors, id)
+
+	if _, ok := b.sessions[id]; ok {
+		delete(b.sessions, id)
+		b.sessionOrder = removeWhere(b.sessionOrder, func(key string) bool { return key == id })
+	}
+	return int64(before - len(b.eventOrder) - len(b.convOrder) - len(b.toolOrder)), spilled
+}
+
+// removeWhere removes the keys remove returns true for, keeping the order
+func removeWhere(keys []string, remove func(string) bool) []string {
+	kept := keys[:0]
+	for _, key := range keys {
+		if !remove(key) {
+			kept = append(kept, key)
+		}
+	}
+	return kept
+}
+
+// sessionSortKeys are the columns ListSessions can sort by
+var sessionSortKeys = map[string]func(a, b *Session) int{
+	"id":            func(a, b *Session) int { return strings.Compare(a.ID, b.ID) },
+	"created_at":    func(a, b *Session) int { return a.CreatedAt.Compare(b.CreatedAt) },
+	"updated_at":    func(a, b *Session) int { return a.UpdatedAt.Compare(b.UpdatedAt) },
+	"status":        func(a, b *Session) int { return strings.Compare(a.Status, b.Status) },
+	"message_count": func(a, b *Session) int { return a.MessageCount - b.MessageCount },
+	"token_count":   func(a, b *Session) int { return a.TokenCount - b.TokenCount },
+}
+
+// ListSessions returns sessions based on filters
+func (b *MemoryBackend) ListSessions(ctx context.Context, filters *SessionFilters) ([]*Session, error) {
+	page, err := b.ListSessionPage(ctx, filters)
+	if err != nil {
+		return nil, err
+	}
+	return page.Sessions, nil
+}
+
+// ListSessionPage returns a page of the sessions matching filters
+func (b *MemoryBackend) ListSessionPage(ctx context.Context, filters *SessionFilters) (*SessionPage, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+	if filters == nil {
+		filters = &SessionFilters{}
+	}
+	order, err := newSessionOrder(filters)
+	if err != nil {
+		return nil, err
+	}
+	compare := sessionSortKeys[order.column]
+	byOrder := func(a, b *Session) int {
+		result := compare(a, b)
+		if result == 0 {
+			result = strings.Compare(a.ID, b.ID)
+		}
+		if order.desc {
+			return -result
+		}
+		return result
+	}
+
+	counts := b.conversationCounts()
+	models := make(map[string]bool)
+	for _, conv := range b.conversations {
+		if conv.Model == filters.Model {
+			models[conv.SessionID] = true
+		}
+	}
+	var matching map[string]bool
+	if filters.Text != "" {
+		hits, err := scanSearch(b.searchableConversations(), &SearchOptions{Query: filters.Text}, func(string) string { return "" })
+		if err != nil {
+			return nil, err
+		}
+		matching = make(map[string]bool)
+		for _, hit := range hits {
+			matching[hit.Conversation.SessionID] = true
+		}
+	}
+
+	var sessions []*Session
+	for _, id := range b.sessionOrder {
+		session := counts.session(b.sessions[id])
+		switch {
+		case session.DeletedAt != nil && !filters.IncludeDeleted,
+			filters.Status != "" && session.Status != filters.Status,
+			len(filters.Statuses) > 0 && !slices.Contains(filters.Statuses, session.Status),
+			filters.Project != "" && !strings.EqualFold(sessionProject(session.Metadata), filters.Project),
+			filters.WorkingDir != "" && !underDir(sessionWorkingDir(session.Metadata), filters.WorkingDir),
+			filters.Model != "" && !models[session.ID],
+			matching != nil && !matching[session.ID],
+			filters.MinMessages > 0 && session.MessageCount < filters.MinMessages,
+			filters.MaxMessages > 0 && session.MessageCount > filters.MaxMessages,
+			filters.MinTokens > 0 && session.TokenCount < filters.MinTokens,
+			filters.MaxTokens > 0 && session.TokenCount > filters.MaxTokens,
+			filters.CreatedAfter != nil && !session.CreatedAt.After(*filters.CreatedAfter),
+			filters.CreatedBefore != nil && !session.CreatedAt.Before(*filters.CreatedBefore),
+			order.after != nil && byOrder(session, order.after) <= 0:
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	slices.SortStableFunc(sessions, byOrder)
+
+	limit := filters.Limit
+	if limit > 0 {
+		limit++
+	}
+	return order.page(paginate(sessions, limit, filters.Offset), filters.Limit), nil
+}
+
+// IterSessions yields the sessions matching filters
+func (b *MemoryBackend) IterSessions(ctx context.Context, filters *SessionFilters) iter.Seq2[*Session, error] {
+	return iterSessionPages(ctx, b.ListSessionPage, filters)
+}
+
+// sessionCounts are the messages and tokens of each session
+type sessionCounts map[string][2]int
+
+// conversationCounts counts the messages and tokens of every session.
+// Callers hold the lock.
+func (b *MemoryBackend) conversationCounts() sessionCounts {
+	counts := make(sessionCounts)
+	for _, conv := range b.conversations {
+		count := counts[conv.SessionID]
+		counts[conv.SessionID] = [2]int{count[0] + 1, count[1] + conv.TokenCount}
+	}
+	return counts
+}
+
+// session returns a copy of a session with its counts
+func (c sessionCounts) session(session *Session) *Session {
+	counted := *session
+	counted.MessageCount, counted.TokenCount = c[session.ID][0], c[session.ID][1]
+	return &counted
+}
+
+// paginate applies LIMIT and OFFSET; a limit of zero or less means no limit
+func paginate[T any](items []T, limit, offset int) []T {
+	if offset > 0 {
+		if offset >= len(items) {
+			return nil
+		}
+		items = items[offset:]
+	}
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+// CreateEvent creates a new event
+func (b *MemoryBackend) CreateEvent(ctx context.Context, event *Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	return b.insertEvent(event)
+}
+
+// insertEvent stores an event. Callers hold the lock.
+func (b *MemoryBackend) insertEvent(event *Event) error {
+	if _, ok := b.events[event.ID]; ok {
+		return uniqueViolation("events.id")
+	}
+	if err := b.checkSessionRef(event.SessionID); err != nil {
+		return err
+	}
+
+	last := 0
+	for _, id := range b.eventOrder {
+		if e := b.events[id]; e.SessionID == event.SessionID {
+			if event.SequenceNum > 0 && e.SequenceNum == event.SequenceNum {
+				return uniqueViolation("events.session_id, events.sequence_num")
+			}
+			last = max(last, e.SequenceNum)
+		}
+	}
+
+	stored := *event
+	if stored.SequenceNum == 0 {
+		stored.SequenceNum = last + 1
+	}
+	b.events[event.ID] = &stored
+	b.eventOrder = append(b.eventOrder, event.ID)
+	return nil
+}
+
+// RenumberEvents numbers the events of a session, or of all sessions when
+// sessionID is empty, by timestamp starting at 1. It returns how many
+// events got a new number.
+func (b *MemoryBackend) RenumberEvents(ctx context.Context, sessionID string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.check(ctx); err != nil {
+		return 0, err
+	}
+
+	bySession := make(map[string][]*Event)
+	for _, id := range b.eventOrder {
+		if e := b.events[id]; sessionID == "" || e.SessionID == sessionID {
+			bySession[e.SessionID] = append(bySession[e.SessionID], e)
+		}
+	}
+
+	renumbered := 0
+	for _, events := range bySession {
+		slices.SortFunc(events, func(x, y *Event) int {
+			if c := x.Timestamp.Compare(y.Timestamp); c != 0 {
+				return c
+			}
+			if c := x.SequenceNum - y.SequenceNum; c != 0 {
+				return c
+			}
+			return strings.Compare(x.ID, y.ID)
+		})
+		for i, e := range events {
+			if e.SequenceNum != i+1 {
+				e.SequenceNum = i + 1
+				renumbered++
+			}
+		}
+	}
+	return renumbered, nil
+}
+
+// CreateEventBatch creates multiple events; either all of them are stored
+// or none
+func (b *MemoryBackend) CreateEventBatch(ctx context.Context, events []*Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+
+	count := len(b.eventOrder)
+	for _, event := range events {
+		if err := b.insertEvent(event); err != nil {
+			for _, id := range b.eventOrder[count:] {
+				delete(b.events, id)
+			}
+			b.eventOrder = b.eventOrder[:count]
+			return err
+		}
+	}
+	return nil
+}
+
+// GetEventsBySession returns all events for a session by sequence number
+func (b *MemoryBackend) GetEventsBySession(ctx context.Context, sessionID string) ([]*Event, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+
+	var events []*Event
+	for _, id := range b.eventOrder {
+		if event := b.events[id]; event.SessionID == sessionID {
+			found := *event
+			events = append(events, &found)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].SequenceNum < events[j].SequenceNum
+	})
+	return events, nil
+}
+
+// IterEventsBySession yields a session's events in sequence order
+func (b *MemoryBackend) IterEventsBySession(ctx context.Context, sessionID string) iter.Seq2[*Event, error] {
+	return iterSlice(b.GetEventsBySession(ctx, sessionID))
+}
+
+// CreateConversation creates a new conversation entry
+func (b *MemoryBackend) CreateConversation(ctx context.Context, conv *Conversation) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	return b.insertConversation(conv)
+}
+
+// insertConversation stores a conversation. Callers hold the lock.
+func (b *MemoryBackend) insertConversation(conv *Conversation) error {
+	if _, ok := b.conversations[conv.ID]; ok {
+		return uniqueViolation("conversations.id")
+	}
+	if err := b.checkSessionRef(conv.SessionID); err != nil {
+		return err
+	}
+
+	stored := *conv
+	b.conversations[conv.ID] = &stored
+	b.convOrder = append(b.convOrder, conv.ID)
+	return nil
+}
+
+// CreateConversationBatch creates multiple conversations; either all of
+// them are stored or none
+func (b *MemoryBackend) CreateConversationBatch(ctx context.Context, convs []*Conversation) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+
+	count := len(b.convOrder)
+	for _, conv := range convs {
+		if err := b.insertConversation(conv); err != nil {
+			for _, id := range b.convOrder[count:] {
+				delete(b.conversations, id)
+			}
+			b.convOrder = b.convOrder[:count]
+			return err
+		}
+	}
+	return nil
+}
+
+// GetConversationsBySession returns all conversations for a session by timestamp
+func (b *MemoryBackend) GetConversationsBySession(ctx context.Context, sessionID string) ([]*Conversation, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+
+	var conversations []*Conversation
+	for _, id := range b.convOrder {
+		if conv := b.conversations[id]; conv.SessionID == sessionID {
+			found := *conv
+			conversations = append(conversations, &found)
+		}
+	}
+	sort.SliceStable(conversations, func(i, j int) bool {
+		return conversations[i].Timestamp.Before(conversations[j].Timestamp)
+	})
+	return conversations, nil
+}
+
+// IterConversationsBySession yields a session's conversations in time order
+func (b *MemoryBackend) IterConversationsBySession(ctx context.Context, sessionID string) iter.Seq2[*Conversation, error] {
+	return iterSlice(b.GetConversationsBySession(ctx, sessionID))
+}
+
+// SearchConversations searches conversations by content, most relevant first
+func (b *MemoryBackend) SearchConversations(ctx context.Context, query string, limit int) ([]*Conversation, error) {
+	hits, err := b.SearchConversationHits(ctx, &SearchOptions{Query: query, Limit: limit})
+	if errors.Is(err, ErrInvalidSearchQuery) {
+		// Queries without words, e.g. "++", can still be matched literally
+		return b.searchConversationsLike(ctx, query, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conversations := make([]*Conversation, len(hits))
+	for i, hit := range hits {
+		conversations[i] = hit.Conversation
+	}
+	return conversations, nil
+}
+
+// searchConversationsLike searches conversations by case-insensitive
+// substring, newest first
+func (b *MemoryBackend) searchConversationsLike(ctx context.Context, query string, limit int) ([]*Conversation, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+	return scanConversationsLike(b.searchableConversations(), query, limit), nil
+}
+
+// SearchConversationHits runs a ranked full-text search over conversation
+// content
+func (b *MemoryBackend) SearchConversationHits(ctx context.Context, opts *SearchOptions) ([]*SearchHit, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+	return scanSearch(b.searchableConversations(), opts, func(sessionID string) string {
+		if session, ok := b.sessions[sessionID]; ok {
+			return sessionProject(session.Metadata)
+		}
+		return ""
+	})
+}
+
+// searchableConversations returns copies of the conversations outside the
+// trash in insertion order. Callers hold the lock.
+func (b *MemoryBackend) searchableConversations() []*Conversation {
+	var conversations []*Conversation
+	for _, id := range b.convOrder {
+		found := *b.conversations[id]
+		if session, ok := b.sessions[found.SessionID]; ok && session.DeletedAt != nil {
+			continue
+		}
+		conversations = append(conversations, &found)
+	}
+	return conversations
+}
+
+// CreateToolInvocation records a tool invocation
+func (b *MemoryBackend) CreateToolInvocation(ctx context.Context, inv *ToolInvocation) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	if _, ok := b.tools[inv.ID]; ok {
+		return uniqueViolation("tool_invocations.id")
+	}
+	if err := b.checkSessionRef(inv.SessionID); err != nil {
+		return err
+	}
+
+	b.tools[inv.ID] = copyToolInvocation(inv)
+	b.toolOrder = append(b.toolOrder, inv.ID)
+	return nil
+}
+
+// UpdateToolInvocation records the result of a tool invocation
+func (b *MemoryBackend) UpdateToolInvocation(ctx context.Context, inv *ToolInvocation) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	stored, ok := b.tools[inv.ID]
+	if !ok {
+		return nil
+	}
+
+	updated := copyToolInvocation(inv)
+	stored.Input = updated.Input
+	stored.Output = updated.Output
+	stored.OutputTruncated = updated.OutputTruncated
+	stored.OutputPath = updated.OutputPath
+	stored.Status = updated.Status
+	stored.Success = updated.Success
+	stored.EndedAt = updated.EndedAt
+	stored.DurationMs = updated.DurationMs
+	return nil
+}
+
+// copyToolInvocation copies an invocation so callers can't change stored data
+func copyToolInvocation(inv *ToolInvocation) *ToolInvocation {
+	copied := *inv
+	if inv.EndedAt != nil {
+		endedAt := *inv.EndedAt
+		copied.EndedAt = &endedAt
+	}
+	return &copied
+}
+
+// GetRunningToolInvocation returns the most recent invocation with the given
+// tool use ID that has not received its result yet
+func (b *MemoryBackend) GetRunningToolInvocation(ctx context.Context, sessionID, toolUseID string) (*ToolInvocation, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+
+	var running *ToolInvocation
+	for _, id := range b.toolOrder {
+		inv := b.tools[id]
+		if inv.SessionID != sessionID || inv.ToolUseID != toolUseID || inv.Status != ToolStatusRunning {
+			continue
+		}
+		if running == nil || inv.StartedAt.After(running.StartedAt) {
+			running = inv
+		}
+	}
+	if running == nil {
+		return nil, ErrToolInvocationNotFound
+	}
+	return copyToolInvocation(running), nil
+}
+
+// GetToolInvocation returns the invocation with the given tool use ID: the
+// most recent one still running, or else the most recent one
+func (b *MemoryBackend) GetToolInvocation(ctx context.Context, sessionID, toolUseID string) (*ToolInvocation, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+
+	var found *ToolInvocation
+	for _, id := range b.toolOrder {
+		inv := b.tools[id]
+		if inv.SessionID != sessionID || inv.ToolUseID != toolUseID {
+			continue
+		}
+		if found == nil {
+			found = inv
+			continue
+		}
+		running, foundRunning := inv.Status == ToolStatusRunning, found.Status == ToolStatusRunning
+		if running != foundRunning {
+			if running {
+				found = inv
+			}
+		} else if inv.StartedAt.After(found.StartedAt) {
+			found = inv
+		}
+	}
+	if found == nil {
+		return nil, ErrToolInvocationNotFound
+	}
+	return copyToolInvocation(found), nil
+}
+
+// GetToolInvocationsBySession returns all tool invocations for a session in call order
+func (b *MemoryBackend) GetToolInvocationsBySession(ctx context.Context, sessionID string) ([]*ToolInvocation, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+
+	var invocations []*ToolInvocation
+	for _, id := range b.toolOrder {
+		if inv := b.tools[id]; inv.SessionID == sessionID {
+			invocations = append(invocations, copyToolInvocation(inv))
+		}
+	}
+	sort.SliceStable(invocations, func(i, j int) bool {
+		return invocations[i].StartedAt.Before(invocations[j].StartedAt)
+	})
+	return invocations, nil
+}
+
+// GetToolUsageCounts returns the number of invocations per tool name.
+// An empty sessionID counts invocations across all sessions.
+func (b *MemoryBackend) GetToolUsageCounts(ctx context.Context, sessionID string) (map[string]int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, inv := range b.tools {
+		if sessionID == "" || inv.SessionID == sessionID {
+			counts[inv.ToolName]++
+		}
+	}
+	return counts, nil
+}
+
+// GetTranscriptCursor returns the ingestion cursor for a session.
+// A session that has not been ingested yet gets a cursor at offset 0.
+func (b *MemoryBackend) GetTranscriptCursor(ctx context.Context, sessionID string) (*TranscriptCursor, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+	cursor, ok := b.cursors[sessionID]
+	if !ok {
+		return &TranscriptCursor{SessionID: sessionID}, nil
+	}
+	found := *cursor
+	return &found, nil
+}
+
+// SaveTranscriptCursor creates or updates the ingestion cursor for a session
+func (b *MemoryBackend) SaveTranscriptCursor(ctx context.Context, cursor *TranscriptCursor) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	if err := b.checkSessionRef(cursor.SessionID); err != nil {
+		return err
+	}
+
+	stored := *cursor
+	b.cursors[cursor.SessionID] = &stored
+	return nil
+}
+
+// RecordImport records an imported file, replacing an earlier record of it
+func (b *MemoryBackend) RecordImport(ctx context.Context, record *ImportRecord) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+
+	// Stored with the precision SQLite keeps
+	stored := *record
+	stored.ImportedAt = record.ImportedAt.Truncate(time.Second)
+	b.imports[record.FilePath] = &stored
+	b.importOrder = append(removeWhere(b.importOrder, func(path string) bool {
+		return path == record.FilePath
+	}), record.FilePath)
+	return nil
+}
+
+// IsFileImported reports whether a file was imported before
+func (b *MemoryBackend) IsFileImported(ctx context.Context, filePath string) (bool, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if err := b.check(ctx); err != nil {
+		return false, err
+	}
+	_, ok := b.imports[filePath]
+	return ok, nil
+}
+
+// ListImports returns the import history, most recent first
+func (b *MemoryBackend) ListImports(ctx context.Context) ([]*ImportRecord, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+
+	var records []*ImportRecord
+	for _, path := range slices.Backward(b.importOrder) {
+		found := *b.imports[path]
+		records = append(records, &found)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].ImportedAt.After(records[j].ImportedAt)
+	})
+	return records, nil
+}
+
+// WithTx runs fn against the backend and puts the data back as it was when
+// fn fails. Transactions run one at a time, but unlike SQLite, writes made
+// outside them meanwhile are undone by a rollback too.
+func (b *MemoryBackend) WithTx(ctx context.Context, fn func(tx BackendTx) error) error {
+	b.txMu.Lock()
+	defer b.txMu.Unlock()
+
+	b.mu.Lock()
+	if err := b.check(ctx); err != nil {
+		b.mu.Unlock()
+		return err
+	}
+	state := b.snapshot()
+	b.mu.Unlock()
+
+	err := fn(b)
+	if err == nil {
+		// A cancelled transaction doesn't commit
+		err = ctx.Err()
+	}
+	if err != nil {
+		b.mu.Lock()
+		if b.open {
+			b.restore(state)
+		}
+		b.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// memoryState is a copy of the stored data
+type memoryState struct {
+	sessions      map[string]*Session
+	sessionOrder  []string
+	events        map[string]*Event
+	eventOrder    []string
+	conversations map[string]*Conversation
+	convOrder     []string
+	tools         map[string]*ToolInvocation
+	toolOrder     []string
+	cursors       map[string]*TranscriptCursor
+	imports       map[string]*ImportRecord
+	importOrder   []string
+}
+
+// snapshot copies the stored data. Callers hold the lock.
+func (b *MemoryBackend) snapshot() *memoryState {
+	return &memoryState{
+		sessions:      copyValues(b.sessions),
+		sessionOrder:  slices.Clone(b.sessionOrder),
+		events:        copyValues(b.events),
+		eventOrder:    slices.Clone(b.eventOrder),
+		conversations: copyValues(b.conversations),
+		convOrder:     slices.Clone(b.convOrder),
+		tools:         copyValues(b.tools),
+		toolOrder:     slices.Clone(b.toolOrder),
+		cursors:       copyValues(b.cursors),
+		imports:       copyValues(b.imports),
+		importOrder:   slices.Clone(b.importOrder),
+	}
+}
+
+// restore puts back the data of a snapshot. Callers hold the lock.
+func (b *MemoryBackend) restore(state *memoryState) {
+	b.sessions, b.sessionOrder = state.sessions, state.sessionOrder
+	b.events, b.eventOrder = state.events, state.eventOrder
+	b.conversations, b.convOrder = state.conversations, state.convOrder
+	b.tools, b.toolOrder = state.tools, state.toolOrder
+	b.cursors = state.cursors
+	b.imports, b.importOrder = state.imports, state.importOrder
+}
+
+// copyValues copies a map along with the values it points to
+func copyValues[T any](m map[string]*T) map[string]*T {
+	copied := make(map[string]*T, len(m))
+	for key, value := range m {
+		v := *value
+		copied[key] = &v
+	}
+	return copied
+}
+
+// GetDatabaseStats returns record counts and the time range they cover
+func (b *MemoryBackend) GetDatabaseStats(ctx context.Context) (*DatabaseStats, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+
+	stats := &DatabaseStats{
+		SessionCount:      len(b.sessions),
+		EventCount:        len(b.events),
+		ConversationCount: len(b.conversations),
+		ToolCount:         len(b.tools),
+	}
+
+	record := func(t time.Time) {
+		if stats.OldestRecord == nil || t.Before(*stats.OldestRecord) {
+			oldest := t
+			stats.OldestRecord = &oldest
+		}
+		if stats.NewestRecord == nil || t.After(*stats.NewestRecord) {
+			newest := t
+			stats.NewestRecord = &newest
+		}
+	}
+	for _, session := range b.sessions {
+		record(session.CreatedAt)
+	}
+	for _, event := range b.events {
+		record(event.Timestamp)
+	}
+	for _, conv := range b.conversations {
+		record(conv.Timestamp)
+	}
+
+	return stats, nil
+}
+
+// MemoryFactory creates in-memory backend instances
+type MemoryFactory struct{}
+
+func (f *MemoryFactory) CreateBackend(config *DatabaseConfig) (DatabaseBackend, error) {
+	return NewMemoryBackend(), nil
+}
+
+func (f *MemoryFactory) IsAvailable() bool {
+	return true
+}
+
+func (f *MemoryFactory) GetCapabilities() *BackendCapabilities {
+	return &BackendCapabilities{
+		SupportsEncryption:   false,
+		SupportsFullText:     true,
+		SupportsTransactions: false,
+		RequiresCGO:          false,
+		PlatformSupport:      []string{"windows", "linux", "darwin"},
+	}
+}
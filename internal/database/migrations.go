@@ -1,77 +1,150 @@
 package database
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"strings"
 )
 
+// SchemaVersion is the schema version this build creates and reads
+const SchemaVersion = 10
+
+// ErrMigrationModified is returned when a migration was changed after it
+// was applied to the database
+var ErrMigrationModified = errors.New("migration changed after it was applied")
+
+// MigrationFunc is a migration step written in Go. It runs inside the
+// migration's transaction.
+type MigrationFunc func(ctx context.Context, conn *sql.Conn) error
+
+// Migration is one reversible step of the versioned schema. The first five
+// Up migrations only use IF NOT EXISTS statements, so they can be applied
+// over tables a legacy layout already created in the current shape. Later
+// ones add columns with ALTER TABLE, which fails if the column exists, so
+// they rely on the recorded version to run only once.
 type Migration struct {
-	Version int
-	Name    string
-	SQL     string
+	Version  int
+	Name     string
+	Up       string        // SQL applying the migration
+	Down     string        // SQL reverting it
+	UpFunc   MigrationFunc // runs after Up, for steps SQL can't express
+	DownFunc MigrationFunc // runs before Down
+	// Revision must be bumped when UpFunc or DownFunc change, as the
+	// checksum can't cover Go code
+	Revision int
 }
 
-var migrations = []Migration{
-	{
-		Version: 1,
-		Name:    "create_sessions_table",
-		SQL: `
+// Checksum identifies the migration's content. It is recorded when the
+// migration is applied, so later edits to an applied migration are noticed.
+func (m *Migration) Checksum() string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d\x00%s\x00%s\x00%s\x00%d", m.Version, m.Name, m.Up, m.Down, m.Revision)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Core tables, shared by the first migration and the legacy table rewrite
+const (
+	sessionsTableSchema = `
 CREATE TABLE IF NOT EXISTS sessions (
     id TEXT PRIMARY KEY,
-    created_at TEXT NOT NULL,
-    updated_at TEXT NOT NULL,
-    status TEXT NOT NULL DEFAULT 'active',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    status TEXT,
     metadata TEXT
-);
+);`
+
+	eventsTableSchema = `
+CREATE TABLE IF NOT EXISTS events (
+    id TEXT PRIMARY KEY,
+    session_id TEXT,
+    event_type TEXT,
+    timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+    sequence_num INTEGER,
+    data TEXT,
+    FOREIGN KEY (session_id) REFERENCES sessions(id)
+);`
 
+	conversationsTableSchema = `
+CREATE TABLE IF NOT EXISTS conversations (
+    id TEXT PRIMARY KEY,
+    session_id TEXT,
+    message_type TEXT,
+    content TEXT,
+    timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+    metadata TEXT,
+    token_count INTEGER,
+    model TEXT,
+    FOREIGN KEY (session_id) REFERENCES sessions(id)
+);`
+)
+
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_core_tables",
+		Up: sessionsTableSchema + eventsTableSchema + conversationsTableSchema + `
 CREATE INDEX IF NOT EXISTS idx_sessions_created_at ON sessions(created_at);
-CREATE INDEX IF NOT EXISTS idx_sessions_status ON sessions(status);
+CREATE INDEX IF NOT EXISTS idx_events_session_id ON events(session_id);
+CREATE INDEX IF NOT EXISTS idx_events_timestamp ON events(timestamp);
+CREATE INDEX IF NOT EXISTS idx_conversations_session_id ON conversations(session_id);
+CREATE INDEX IF NOT EXISTS idx_conversations_timestamp ON conversations(timestamp);
+		`,
+		Down: `
+DROP TABLE IF EXISTS conversations;
+DROP TABLE IF EXISTS events;
+DROP TABLE IF EXISTS sessions;
 		`,
 	},
 	{
 		Version: 2,
-		Name:    "create_events_table",
-		SQL: `
-CREATE TABLE IF NOT EXISTS events (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    session_id TEXT NOT NULL,
-    event_type TEXT NOT NULL,
-    event_data TEXT NOT NULL,
-    timestamp TEXT NOT NULL,
-    sequence_number INTEGER NOT NULL,
-    FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE
+		Name:    "create_tool_invocations",
+		Up: `
+CREATE TABLE IF NOT EXISTS tool_invocations (
+    id TEXT PRIMARY KEY,
+    session_id TEXT,
+    tool_use_id TEXT,
+    tool_name TEXT NOT NULL,
+    input TEXT,
+    output TEXT,
+    output_truncated BOOLEAN DEFAULT FALSE,
+    output_path TEXT,
+    status TEXT,
+    success BOOLEAN DEFAULT FALSE,
+    started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    ended_at DATETIME,
+    duration_ms INTEGER DEFAULT 0,
+    FOREIGN KEY (session_id) REFERENCES sessions(id)
 );
 
-CREATE INDEX IF NOT EXISTS idx_events_session_id ON events(session_id);
-CREATE INDEX IF NOT EXISTS idx_events_timestamp ON events(timestamp);
-CREATE INDEX IF NOT EXISTS idx_events_type ON events(event_type);
-CREATE INDEX IF NOT EXISTS idx_events_sequence ON events(session_id, sequence_number);
+CREATE INDEX IF NOT EXISTS idx_tool_invocations_session_id ON tool_invocations(session_id);
+CREATE INDEX IF NOT EXISTS idx_tool_invocations_tool_use_id ON tool_invocations(session_id, tool_use_id);
+CREATE INDEX IF NOT EXISTS idx_tool_invocations_tool_name ON tool_invocations(tool_name);
 		`,
+		Down: `DROP TABLE IF EXISTS tool_invocations;`,
 	},
 	{
 		Version: 3,
-		Name:    "create_conversations_table",
-		SQL: `
-CREATE TABLE IF NOT EXISTS conversations (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    session_id TEXT NOT NULL,
-    message_type TEXT NOT NULL,
-    content TEXT NOT NULL,
-    timestamp TEXT NOT NULL,
-    token_count INTEGER,
-    model_info TEXT,
-    FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE
+		Name:    "create_transcript_cursors",
+		Up: `
+CREATE TABLE IF NOT EXISTS transcript_cursors (
+    session_id TEXT PRIMARY KEY,
+    transcript_path TEXT NOT NULL,
+    byte_offset INTEGER NOT NULL DEFAULT 0,
+    last_uuid TEXT,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (session_id) REFERENCES sessions(id)
 );
-
-CREATE INDEX IF NOT EXISTS idx_conversations_session_id ON conversations(session_id);
-CREATE INDEX IF NOT EXISTS idx_conversations_timestamp ON conversations(timestamp);
-CREATE INDEX IF NOT EXISTS idx_conversations_type ON conversations(message_type);
 		`,
+		Down: `DROP TABLE IF EXISTS transcript_cursors;`,
 	},
 	{
 		Version: 4,
-		Name:    "create_import_history_table",
-		SQL: `
+		Name:    "create_import_history_and_settings",
+		Up: `
 CREATE TABLE IF NOT EXISTS import_history (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     file_path TEXT NOT NULL UNIQUE,
@@ -81,14 +154,8 @@ CREATE TABLE IF NOT EXISTS import_history (
     checksum TEXT
 );
 
-CREATE INDEX IF NOT EXISTS idx_import_history_file_path ON import_history(file_path);
 CREATE INDEX IF NOT EXISTS idx_import_history_imported_at ON import_history(imported_at);
-		`,
-	},
-	{
-		Version: 5,
-		Name:    "create_settings_table",
-		SQL: `
+
 CREATE TABLE IF NOT EXISTS settings (
     key TEXT PRIMARY KEY,
     value TEXT NOT NULL,
@@ -96,95 +163,558 @@ CREATE TABLE IF NOT EXISTS settings (
     updated_at TEXT NOT NULL
 );
 		`,
+		Down: `
+DROP TABLE IF EXISTS settings;
+DROP TABLE IF EXISTS import_history;
+		`,
+	},
+	{
+		Version: 5,
+		Name:    "create_search_index",
+		// Rebuilding backfills the index from the existing conversations
+		Up: searchIndexSchema + `
+INSERT INTO conversations_fts (conversations_fts) VALUES ('rebuild');
+		`,
+		Down: `
+DROP TRIGGER IF EXISTS conversations_fts_insert;
+DROP TRIGGER IF EXISTS conversations_fts_delete;
+DROP TRIGGER IF EXISTS conversations_fts_update;
+DROP TABLE IF EXISTS conversations_fts;
+		`,
 	},
 	{
 		Version: 6,
-		Name:    "create_schema_migrations_table",
-		SQL: `
-CREATE TABLE IF NOT EXISTS schema_migrations (
-    version INTEGER PRIMARY KEY,
-    name TEXT NOT NULL,
-    applied_at TEXT NOT NULL
+		Name:    "add_session_project_index",
+		// Blind index of the project, filled while field encryption is on
+		Up: `
+ALTER TABLE sessions ADD COLUMN project_bidx TEXT;
+CREATE INDEX IF NOT EXISTS idx_sessions_project_bidx ON sessions(project_bidx);
+		`,
+		Down: `
+DROP INDEX IF EXISTS idx_sessions_project_bidx;
+ALTER TABLE sessions DROP COLUMN project_bidx;
+		`,
+	},
+	{
+		Version: 7,
+		Name:    "add_session_stars_and_tags",
+		// Starred and tagged sessions can be kept by retention policies
+		Up: `
+ALTER TABLE sessions ADD COLUMN starred BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS session_tags (
+    session_id TEXT NOT NULL,
+    tag TEXT NOT NULL,
+    PRIMARY KEY (session_id, tag),
+    FOREIGN KEY (session_id) REFERENCES sessions(id)
 );
+
+CREATE INDEX IF NOT EXISTS idx_session_tags_tag ON session_tags(tag);
+		`,
+		Down: `
+DROP TABLE IF EXISTS session_tags;
+ALTER TABLE sessions DROP COLUMN starred;
+		`,
+	},
+	{
+		Version: 8,
+		Name:    "add_session_trash",
+		// Deleted sessions stay in the trash until it is emptied
+		Up: `
+ALTER TABLE sessions ADD COLUMN deleted_at DATETIME;
+
+CREATE INDEX IF NOT EXISTS idx_sessions_deleted_at ON sessions(deleted_at);
+		`,
+		Down: `
+DROP INDEX IF EXISTS idx_sessions_deleted_at;
+ALTER TABLE sessions DROP COLUMN deleted_at;
+		`,
+	},
+	{
+		Version: 9,
+		Name:    "add_session_counts_and_list_indexes",
+		// Message and token counts are kept on the session by triggers, so
+		// listings filter and sort on them without reading conversations.
+		// Each sort column is indexed with id, the keyset tie-break. The
+		// trash index only covers trashed sessions, or the planner picks it
+		// for the deleted_at IS NULL of every listing over the sort index.
+		Up: `
+ALTER TABLE sessions ADD COLUMN message_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sessions ADD COLUMN token_count INTEGER NOT NULL DEFAULT 0;
+
+UPDATE sessions SET
+    message_count = (SELECT COUNT(*) FROM conversations WHERE session_id = sessions.id),
+    token_count = (SELECT COALESCE(SUM(token_count), 0) FROM conversations WHERE session_id = sessions.id);
+
+CREATE TRIGGER IF NOT EXISTS conversations_count_insert AFTER INSERT ON conversations BEGIN
+    UPDATE sessions SET message_count = message_count + 1, token_count = token_count + COALESCE(new.token_count, 0)
+    WHERE id = new.session_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS conversations_count_delete AFTER DELETE ON conversations BEGIN
+    UPDATE sessions SET message_count = message_count - 1, token_count = token_count - COALESCE(old.token_count, 0)
+    WHERE id = old.session_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS conversations_count_update AFTER UPDATE OF session_id, token_count ON conversations BEGIN
+    UPDATE sessions SET message_count = message_count - 1, token_count = token_count - COALESCE(old.token_count, 0)
+    WHERE id = old.session_id;
+    UPDATE sessions SET message_count = message_count + 1, token_count = token_count + COALESCE(new.token_count, 0)
+    WHERE id = new.session_id;
+END;
+
+CREATE INDEX IF NOT EXISTS idx_sessions_list_created_at ON sessions(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_sessions_list_updated_at ON sessions(updated_at, id);
+CREATE INDEX IF NOT EXISTS idx_sessions_list_status ON sessions(status, id);
+CREATE INDEX IF NOT EXISTS idx_sessions_list_message_count ON sessions(message_count, id);
+CREATE INDEX IF NOT EXISTS idx_sessions_list_token_count ON sessions(token_count, id);
+CREATE INDEX IF NOT EXISTS idx_conversations_model ON conversations(model, session_id);
+
+DROP INDEX IF EXISTS idx_sessions_deleted_at;
+CREATE INDEX IF NOT EXISTS idx_sessions_trash ON sessions(deleted_at) WHERE deleted_at IS NOT NULL;
+		`,
+		Down: `
+DROP INDEX IF EXISTS idx_sessions_trash;
+CREATE INDEX IF NOT EXISTS idx_sessions_deleted_at ON sessions(deleted_at);
+DROP INDEX IF EXISTS idx_conversations_model;
+DROP INDEX IF EXISTS idx_sessions_list_token_count;
+DROP INDEX IF EXISTS idx_sessions_list_message_count;
+DROP INDEX IF EXISTS idx_sessions_list_status;
+DROP INDEX IF EXISTS idx_sessions_list_updated_at;
+DROP INDEX IF EXISTS idx_sessions_list_created_at;
+DROP TRIGGER IF EXISTS conversations_count_update;
+DROP TRIGGER IF EXISTS conversations_count_delete;
+DROP TRIGGER IF EXISTS conversations_count_insert;
+ALTER TABLE sessions DROP COLUMN token_count;
+ALTER TABLE sessions DROP COLUMN message_count;
+		`,
+	},
+	{
+		Version: 10,
+		Name:    "add_event_sequences",
+		// Event numbers come from a per-session counter updated with the
+		// insert. Sessions numbered by the old per-process counters repeat
+		// numbers, so those are renumbered by timestamp first.
+		Up: `
+CREATE TABLE IF NOT EXISTS session_sequences (
+    session_id TEXT PRIMARY KEY,
+    last_sequence INTEGER NOT NULL,
+    FOREIGN KEY (session_id) REFERENCES sessions(id)
+);
+
+UPDATE events SET sequence_num = numbered.seq
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY session_id ORDER BY timestamp, sequence_num, id) AS seq
+    FROM events
+    WHERE session_id IN (
+        SELECT session_id FROM events GROUP BY session_id
+        HAVING COUNT(*) != COUNT(DISTINCT sequence_num) OR MIN(COALESCE(sequence_num, 0)) < 1
+    )
+) AS numbered
+WHERE events.id = numbered.id;
+
+INSERT OR REPLACE INTO session_sequences (session_id, last_sequence)
+SELECT session_id, MAX(sequence_num) FROM events
+WHERE session_id IN (SELECT id FROM sessions)
+GROUP BY session_id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_events_session_sequence ON events(session_id, sequence_num);
+		`,
+		Down: `
+DROP INDEX IF EXISTS idx_events_session_sequence;
+DROP TABLE IF EXISTS session_sequences;
 		`,
 	},
 }
 
+// MigrationPlan describes what migrating a database to a version involves
+type MigrationPlan struct {
+	Layout         SchemaLayout    `json:"layout"`
+	CurrentVersion int             `json:"current_version"`
+	TargetVersion  int             `json:"target_version"`
+	Rewrites       []*TableRewrite `json:"rewrites,omitempty"` // legacy tables copied into the current layout
+	Pending        []Migration     `json:"pending,omitempty"`  // migrations applied after the rewrites
+	Reverts        []Migration     `json:"reverts,omitempty"`  // migrations reverted, newest first
+	Modified       []int           `json:"modified,omitempty"` // applied migrations whose checksum changed
+	BackupPath     string          `json:"backup_path,omitempty"`
+}
+
+// Required reports whether the plan changes anything
+func (p *MigrationPlan) Required() bool {
+	return len(p.Rewrites) > 0 || len(p.Pending) > 0 || len(p.Reverts) > 0
+}
+
+// Migration states reported by MigrationStatus
+const (
+	MigrationApplied  = "applied"
+	MigrationPending  = "pending"
+	MigrationModified = "modified" // applied, but changed since
+	MigrationUnknown  = "unknown"  // applied by a newer build
+)
+
+// MigrationState is a migration and whether the database has it
+type MigrationState struct {
+	Version   int    `json:"version"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	AppliedAt string `json:"applied_at,omitempty"`
+	Checksum  string `json:"checksum,omitempty"` // recorded checksum, empty if applied before checksums
+}
+
+// appliedMigration is a row of schema_migrations
+type appliedMigration struct {
+	Version   int
+	Name      string
+	AppliedAt string
+	Checksum  string
+}
+
+// RunMigrations brings the database opened with Initialize to the current
+// schema version
 func RunMigrations() error {
 	db, err := GetConnection()
 	if err != nil {
 		return fmt.Errorf("failed to get database connection: %w", err)
 	}
 
-	if err := createMigrationsTable(db); err != nil {
-		return fmt.Errorf("failed to create migrations table: %w", err)
+	_, err = migrateSchema(context.Background(), db, SchemaVersion, false, BackupDir(dbPath))
+	return err
+}
+
+// schemaVersion returns the highest migration applied to the database, 0
+// for empty and unversioned databases
+func schemaVersion(ctx context.Context, q queryer) (int, error) {
+	exists, err := tableExists(ctx, q, "schema_migrations")
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	query := `SELECT MAX(version) FROM schema_migrations WHERE name NOT IN (` + legacyMigrationList() + `)`
+	if err := q.QueryRowContext(ctx, query).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// appliedMigrations returns the migrations recorded in schema_migrations,
+// oldest first
+func appliedMigrations(ctx context.Context, q queryer) ([]appliedMigration, error) {
+	columns, err := tableColumns(ctx, q, "schema_migrations")
+	if err != nil || columns == nil {
+		return nil, err
 	}
 
-	appliedMigrations, err := getAppliedMigrations(db)
+	checksum := "NULL"
+	if _, ok := columns["checksum"]; ok {
+		checksum = "checksum"
+	}
+	query := `SELECT version, name, applied_at, ` + checksum + ` FROM schema_migrations
+		WHERE name NOT IN (` + legacyMigrationList() + `) ORDER BY version`
+	rows, err := q.QueryContext(ctx, query)
 	if err != nil {
-		return fmt.Errorf("failed to get applied migrations: %w", err)
+		return nil, fmt.Errorf("failed to read schema migrations: %w", err)
 	}
+	defer rows.Close()
 
-	for _, migration := range migrations {
-		if appliedMigrations[migration.Version] {
-			continue
+	var applied []appliedMigration
+	for rows.Next() {
+		var migration appliedMigration
+		var sum sql.NullString
+		if err := rows.Scan(&migration.Version, &migration.Name, &migration.AppliedAt, &sum); err != nil {
+			return nil, fmt.Errorf("failed to read schema migrations: %w", err)
 		}
+		migration.Checksum = sum.String
+		applied = append(applied, migration)
+	}
+	return applied, rows.Err()
+}
 
-		if err := applyMigration(db, migration); err != nil {
-			return fmt.Errorf("failed to apply migration %d (%s): %w", migration.Version, migration.Name, err)
+// findMigration returns the migration with a version, nil if this build
+// doesn't know it
+func findMigration(version int) *Migration {
+	for i := range migrations {
+		if migrations[i].Version == version {
+			return &migrations[i]
 		}
 	}
-
 	return nil
 }
 
-func createMigrationsTable(db *sql.DB) error {
-	query := `
-	CREATE TABLE IF NOT EXISTS schema_migrations (
-		version INTEGER PRIMARY KEY,
-		name TEXT NOT NULL,
-		applied_at TEXT NOT NULL
-	);`
+// migrationStatus lists every known or applied migration with its state
+func migrationStatus(ctx context.Context, q queryer) ([]*MigrationState, error) {
+	applied, err := appliedMigrations(ctx, q)
+	if err != nil {
+		return nil, err
+	}
 
-	_, err := db.Exec(query)
-	return err
+	byVersion := make(map[int]appliedMigration)
+	for _, migration := range applied {
+		byVersion[migration.Version] = migration
+	}
+
+	var states []*MigrationState
+	for i := range migrations {
+		migration := &migrations[i]
+		state := &MigrationState{Version: migration.Version, Name: migration.Name, Status: MigrationPending}
+		if record, ok := byVersion[migration.Version]; ok {
+			state.Status = MigrationApplied
+			state.AppliedAt = record.AppliedAt
+			state.Checksum = record.Checksum
+			if record.Checksum != "" && record.Checksum != migration.Checksum() {
+				state.Status = MigrationModified
+			}
+			delete(byVersion, migration.Version)
+		}
+		states = append(states, state)
+	}
+	for _, record := range applied {
+		if _, ok := byVersion[record.Version]; ok {
+			states = append(states, &MigrationState{
+				Version:   record.Version,
+				Name:      record.Name,
+				Status:    MigrationUnknown,
+				AppliedAt: record.AppliedAt,
+				Checksum:  record.Checksum,
+			})
+		}
+	}
+	return states, nil
 }
 
-func getAppliedMigrations(db *sql.DB) (map[int]bool, error) {
-	query := "SELECT version FROM schema_migrations"
-	rows, err := db.Query(query)
+// planMigration works out the rewrites and migrations that bring a database
+// to the target version
+func planMigration(ctx context.Context, q queryer, target int) (*MigrationPlan, error) {
+	if target < 0 || target > SchemaVersion {
+		return nil, fmt.Errorf("unknown schema version %d, this build supports 0 to %d", target, SchemaVersion)
+	}
+
+	states, err := migrationStatus(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	current, err := schemaVersion(ctx, q)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	applied := make(map[int]bool)
-	for rows.Next() {
-		var version int
-		if err := rows.Scan(&version); err != nil {
+	layout, rewrites, err := detectSchemaLayout(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &MigrationPlan{
+		Layout:         layout,
+		CurrentVersion: current,
+		TargetVersion:  target,
+		Rewrites:       rewrites,
+	}
+	for _, state := range states {
+		switch {
+		case state.Status == MigrationUnknown:
+			return nil, fmt.Errorf("database has migration %d (%s) which this build doesn't know, it was migrated by a newer version", state.Version, state.Name)
+		case state.Status == MigrationModified:
+			plan.Modified = append(plan.Modified, state.Version)
+		case state.Status == MigrationPending && state.Version <= target:
+			plan.Pending = append(plan.Pending, *findMigration(state.Version))
+		}
+	}
+	for i := len(states) - 1; i >= 0; i-- {
+		if states[i].Status != MigrationPending && states[i].Version > target {
+			plan.Reverts = append(plan.Reverts, *findMigration(states[i].Version))
+		}
+	}
+	return plan, nil
+}
+
+// migrateSchema brings a database to the target version, rewriting legacy
+// tables first. Unless the database is empty, it is copied to backupDir
+// before anything changes. With dryRun set it only returns the plan.
+func migrateSchema(ctx context.Context, db *sql.DB, target int, dryRun bool, backupDir string) (*MigrationPlan, error) {
+	// Fast path for the common case of an up to date database, where every
+	// migration up to the target has been applied
+	if current, err := schemaVersion(ctx, db); err == nil && current == target && !dryRun {
+		if applied, err := appliedMigrations(ctx, db); err == nil && len(applied) == target {
+			return &MigrationPlan{Layout: LayoutVersioned, CurrentVersion: current, TargetVersion: target}, nil
+		}
+	}
+
+	plan, err := planMigration(ctx, db, target)
+	if err != nil || dryRun || !plan.Required() {
+		return plan, err
+	}
+	if err := plan.checkModified(); err != nil {
+		return nil, err
+	}
+
+	var backupPath string
+	if backupDir != "" && plan.Layout != LayoutEmpty {
+		if backupPath, err = backupBeforeMigration(ctx, db, backupDir, plan); err != nil {
 			return nil, err
 		}
-		applied[version] = true
 	}
 
-	return applied, rows.Err()
-}
+	// Foreign keys can only be switched off outside a transaction, so the
+	// migration runs on a dedicated connection
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return nil, fmt.Errorf("failed to disable foreign keys: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "PRAGMA foreign_keys = ON")
+
+	// BEGIN IMMEDIATE keeps two processes from migrating at the same time
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return nil, fmt.Errorf("failed to begin migration: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			conn.ExecContext(context.Background(), "ROLLBACK")
+		}
+	}()
 
-func applyMigration(db *sql.DB, migration Migration) error {
-	tx, err := db.Begin()
+	// Another process may have migrated while we waited for the lock
+	plan, err = planMigration(ctx, conn, target)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := plan.checkModified(); err != nil {
+		return nil, err
+	}
+	plan.BackupPath = backupPath
+
+	if err := applyMigrationPlan(ctx, conn, plan); err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		return nil, fmt.Errorf("failed to commit migration: %w", err)
+	}
+	committed = true
+
+	return plan, nil
+}
+
+// checkModified refuses to migrate a database whose applied migrations no
+// longer match this build
+func (p *MigrationPlan) checkModified() error {
+	if len(p.Modified) == 0 {
+		return nil
 	}
-	defer tx.Rollback()
+	versions := make([]string, len(p.Modified))
+	for i, version := range p.Modified {
+		versions[i] = fmt.Sprint(version)
+	}
+	return fmt.Errorf("%w: version %s", ErrMigrationModified, strings.Join(versions, ", "))
+}
 
-	if _, err := tx.Exec(migration.SQL); err != nil {
+// applyMigrationPlan runs a plan inside the caller's transaction
+func applyMigrationPlan(ctx context.Context, conn *sql.Conn, plan *MigrationPlan) error {
+	if err := ensureMigrationsTable(ctx, conn); err != nil {
 		return err
 	}
 
-	insertQuery := "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, datetime('now'))"
-	if _, err := tx.Exec(insertQuery, migration.Version, migration.Name); err != nil {
+	if plan.Layout != LayoutVersioned && plan.Layout != LayoutEmpty {
+		if err := rewriteLegacyTables(ctx, conn, plan.Rewrites); err != nil {
+			return err
+		}
+		// The versions recorded by the legacy migrations don't describe
+		// this schema
+		query := `DELETE FROM schema_migrations WHERE name IN (` + legacyMigrationList() + `)`
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to clear legacy migrations: %w", err)
+		}
+	}
+
+	for _, migration := range plan.Reverts {
+		if migration.DownFunc != nil {
+			if err := migration.DownFunc(ctx, conn); err != nil {
+				return fmt.Errorf("failed to revert migration %d (%s): %w", migration.Version, migration.Name, err)
+			}
+		}
+		if _, err := conn.ExecContext(ctx, migration.Down); err != nil {
+			return fmt.Errorf("failed to revert migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		if _, err := conn.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", migration.Version); err != nil {
+			return fmt.Errorf("failed to record revert of migration %d: %w", migration.Version, err)
+		}
+	}
+
+	for _, migration := range plan.Pending {
+		if _, err := conn.ExecContext(ctx, migration.Up); err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		if migration.UpFunc != nil {
+			if err := migration.UpFunc(ctx, conn); err != nil {
+				return fmt.Errorf("failed to apply migration %d (%s): %w", migration.Version, migration.Name, err)
+			}
+		}
+		insertQuery := "INSERT INTO schema_migrations (version, name, applied_at, checksum) VALUES (?, ?, datetime('now'), ?)"
+		if _, err := conn.ExecContext(ctx, insertQuery, migration.Version, migration.Name, migration.Checksum()); err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", migration.Version, err)
+		}
+	}
+
+	return nil
+}
+
+// ensureMigrationsTable creates schema_migrations, adds the checksum column
+// to databases migrated before checksums and records the checksums of
+// their migrations
+func ensureMigrationsTable(ctx context.Context, conn *sql.Conn) error {
+	if _, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TEXT NOT NULL,
+			checksum TEXT
+		)`); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	columns, err := tableColumns(ctx, conn, "schema_migrations")
+	if err != nil {
 		return err
 	}
+	if _, ok := columns["checksum"]; !ok {
+		if _, err := conn.ExecContext(ctx, "ALTER TABLE schema_migrations ADD COLUMN checksum TEXT"); err != nil {
+			return fmt.Errorf("failed to add migration checksums: %w", err)
+		}
+	}
 
-	return tx.Commit()
-}
\ No newline at end of file
+	for i := range migrations {
+		migration := &migrations[i]
+		query := "UPDATE schema_migrations SET checksum = ? WHERE version = ? AND name = ? AND checksum IS NULL"
+		if _, err := conn.ExecContext(ctx, query, migration.Checksum(), migration.Version, migration.Name); err != nil {
+			return fmt.Errorf("failed to record migration checksums: %w", err)
+		}
+	}
+	return nil
+}
+
+// tableExists reports whether a table exists
+func tableExists(ctx context.Context, q queryer, table string) (bool, error) {
+	var name string
+	err := q.QueryRowContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&name)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check table %s: %w", table, err)
+	}
+	return true, nil
+}
+
+// legacyMigrationList returns the quoted legacy migration names for an IN clause
+func legacyMigrationList() string {
+	quoted := make([]string, len(legacyMigrationNames))
+	for i, name := range legacyMigrationNames {
+		quoted[i] = "'" + name + "'"
+	}
+	return strings.Join(quoted, ", ")
+}
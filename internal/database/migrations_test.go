@@ -1,9 +1,14 @@
 package database
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestRunMigrations(t *testing.T) {
@@ -102,4 +107,333 @@ func TestRunMigrationsIdempotent(t *testing.T) {
 	if migrationCount != len(migrations) {
 		t.Errorf("Expected %d migrations, got %d after running twice", len(migrations), migrationCount)
 	}
-}
\ No newline at end of file
+}
+
+// legacyImportSchema is the schema RunMigrations created before schema versioning
+const legacyImportSchema = `
+CREATE TABLE sessions (
+    id TEXT PRIMARY KEY,
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'active',
+    metadata TEXT
+);
+CREATE TABLE events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    session_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    event_data TEXT NOT NULL,
+    timestamp TEXT NOT NULL,
+    sequence_number INTEGER NOT NULL,
+    FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_events_session_id ON events(session_id);
+CREATE TABLE conversations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    session_id TEXT NOT NULL,
+    message_type TEXT NOT NULL,
+    content TEXT NOT NULL,
+    timestamp TEXT NOT NULL,
+    token_count INTEGER,
+    model_info TEXT,
+    FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE
+);
+CREATE TABLE schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TEXT NOT NULL
+);
+INSERT INTO schema_migrations VALUES (1, 'create_sessions_table', datetime('now'));
+INSERT INTO schema_migrations VALUES (2, 'create_events_table', datetime('now'));
+INSERT INTO schema_migrations VALUES (3, 'create_conversations_table', datetime('now'));
+
+INSERT INTO sessions VALUES ('s1', '2024-03-01T10:00:00Z', '2024-03-01T11:00:00Z', 'imported', NULL);
+INSERT INTO events (session_id, event_type, event_data, timestamp, sequence_number)
+    VALUES ('s1', 'session_start', 'started', '2024-03-01T10:00:00Z', 1);
+INSERT INTO events (session_id, event_type, event_data, timestamp, sequence_number)
+    VALUES ('s1', 'session_end', 'ended', '2024-03-01T11:00:00Z', 2);
+INSERT INTO conversations (session_id, message_type, content, timestamp, token_count, model_info)
+    VALUES ('s1', 'user', 'migrate the legacy database', '2024-03-01T10:01:00Z', 12, 'claude-3');
+INSERT INTO conversations (session_id, message_type, content, timestamp, token_count, model_info)
+    VALUES ('s1', 'assistant', 'done', '2024-03-01T10:02:00Z', NULL, NULL);
+`
+
+// newLegacyBackend opens a backend over a database created with schema
+func newLegacyBackend(t *testing.T, schema string) *PureGoSQLiteBackend {
+	t.Helper()
+
+	config := DefaultDatabaseConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "legacy.db")
+
+	backend := NewPureGoSQLiteBackend()
+	ctx := context.Background()
+	if err := backend.Initialize(ctx, config); err != nil {
+		t.Fatalf("Failed to initialize backend: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+
+	if _, err := backend.ExecuteExec(ctx, schema); err != nil {
+		t.Fatalf("Failed to create legacy schema: %v", err)
+	}
+	return backend
+}
+
+func TestMigrateLegacyImportSchema(t *testing.T) {
+	backend := newLegacyBackend(t, legacyImportSchema)
+	ctx := context.Background()
+
+	plan, err := backend.PlanMigration(ctx, SchemaVersion)
+	if err != nil {
+		t.Fatalf("Failed to plan migration: %v", err)
+	}
+	if plan.Layout != LayoutLegacyImport || plan.CurrentVersion != 0 {
+		t.Errorf("Expected legacy layout at version 0, got %s at %d", plan.Layout, plan.CurrentVersion)
+	}
+	if len(plan.Rewrites) != 3 || len(plan.Pending) != len(migrations) {
+		t.Fatalf("Expected 3 rewrites and all migrations, got %d and %d", len(plan.Rewrites), len(plan.Pending))
+	}
+	if plan.Rewrites[1].Table != "events" || plan.Rewrites[1].Rows != 2 {
+		t.Errorf("Unexpected events rewrite: %+v", plan.Rewrites[1])
+	}
+
+	// Planning doesn't change anything
+	if version, _ := backend.GetSchemaVersion(ctx); version != 0 {
+		t.Errorf("Expected dry run to leave version 0, got %d", version)
+	}
+
+	if err := backend.MigrateSchema(ctx, SchemaVersion); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+
+	session, err := backend.GetSession(ctx, "s1")
+	if err != nil {
+		t.Fatalf("Failed to read migrated session: %v", err)
+	}
+	if session.Status != "imported" || session.Metadata != "{}" || session.CreatedAt.Hour() != 10 {
+		t.Errorf("Unexpected migrated session: %+v", session)
+	}
+
+	events, err := backend.GetEventsBySession(ctx, "s1")
+	if err != nil {
+		t.Fatalf("Failed to read migrated events: %v", err)
+	}
+	if len(events) != 2 || events[0].Data != "started" || events[1].SequenceNum != 2 || events[0].ID != "1" {
+		t.Errorf("Unexpected migrated events: %+v %+v", events[0], events[1])
+	}
+
+	conversations, err := backend.GetConversationsBySession(ctx, "s1")
+	if err != nil {
+		t.Fatalf("Failed to read migrated conversations: %v", err)
+	}
+	if len(conversations) != 2 || conversations[0].Model != "claude-3" || conversations[0].TokenCount != 12 || conversations[1].Model != "" {
+		t.Errorf("Unexpected migrated conversations: %+v", conversations)
+	}
+
+	// New rows use the current layout and the search index covers old ones
+	createTestConversation(t, backend, "s1", "new-1", "written after the migration")
+	if hits, err := backend.SearchConversationHits(ctx, &SearchOptions{Query: "legacy OR migration"}); err != nil || len(hits) != 2 {
+		t.Errorf("Expected 2 search hits, got %d (%v)", len(hits), err)
+	}
+
+	if version, _ := backend.GetSchemaVersion(ctx); version != SchemaVersion {
+		t.Errorf("Expected version %d, got %d", SchemaVersion, version)
+	}
+	plan, err = backend.PlanMigration(ctx, SchemaVersion)
+	if err != nil || plan.Required() || plan.Layout != LayoutVersioned {
+		t.Errorf("Expected nothing left to migrate, got %+v (%v)", plan, err)
+	}
+}
+
+func TestMigrateUnversionedSchema(t *testing.T) {
+	// CreateSchema before versioning, without the newer tables
+	backend := newLegacyBackend(t, sessionsTableSchema+eventsTableSchema+conversationsTableSchema)
+	ctx := context.Background()
+	createTestSession(t, backend, "s1")
+	createTestConversation(t, backend, "s1", "c1", "kept as is")
+
+	plan, err := backend.PlanMigration(ctx, SchemaVersion)
+	if err != nil {
+		t.Fatalf("Failed to plan migration: %v", err)
+	}
+	if plan.Layout != LayoutUnversioned || len(plan.Rewrites) != 0 {
+		t.Errorf("Expected unversioned layout without rewrites, got %s with %d", plan.Layout, len(plan.Rewrites))
+	}
+
+	if err := backend.CreateSchema(ctx); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if missing, _ := backend.MissingTables(ctx); len(missing) != 0 {
+		t.Errorf("Expected all tables after migrating, missing %v", missing)
+	}
+	if hits, _ := backend.SearchConversationHits(ctx, &SearchOptions{Query: "kept"}); len(hits) != 1 {
+		t.Errorf("Expected the existing conversation to be indexed, got %d hits", len(hits))
+	}
+}
+
+func TestMigrateRefusesUnknownColumns(t *testing.T) {
+	backend := newLegacyBackend(t, legacyImportSchema+`ALTER TABLE events ADD COLUMN annotation TEXT;`)
+
+	if err := backend.MigrateSchema(context.Background(), SchemaVersion); err == nil {
+		t.Fatal("Expected migrating a table with an unknown column to fail")
+	}
+	// The failed migration left the legacy tables alone
+	if version, _ := backend.GetSchemaVersion(context.Background()); version != 0 {
+		t.Errorf("Expected version 0, got %d", version)
+	}
+	var count int
+	if err := backend.db.QueryRow("SELECT COUNT(*) FROM events WHERE event_data IS NOT NULL").Scan(&count); err != nil || count != 2 {
+		t.Errorf("Expected legacy events to be untouched, got %d (%v)", count, err)
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	backend := newTestBackend(t)
+	ctx := context.Background()
+	createTestSession(t, backend, "s1")
+	createTestConversation(t, backend, "s1", "c1", "survives the round trip")
+
+	if err := backend.MigrateSchema(ctx, 3); err != nil {
+		t.Fatalf("Failed to migrate down: %v", err)
+	}
+	if version, _ := backend.GetSchemaVersion(ctx); version != 3 {
+		t.Errorf("Expected version 3, got %d", version)
+	}
+	for _, table := range []string{"conversations_fts", "import_history", "settings"} {
+		if exists, _ := tableExists(ctx, backend.db, table); exists {
+			t.Errorf("Expected %s to be dropped", table)
+		}
+	}
+
+	states, err := backend.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get migration status: %v", err)
+	}
+	if states[2].Status != MigrationApplied || states[3].Status != MigrationPending || states[2].Checksum != migrations[2].Checksum() {
+		t.Errorf("Unexpected migration status: %+v %+v", states[2], states[3])
+	}
+
+	if err := backend.MigrateSchema(ctx, SchemaVersion); err != nil {
+		t.Fatalf("Failed to migrate up: %v", err)
+	}
+	if hits, _ := backend.SearchConversationHits(ctx, &SearchOptions{Query: "round trip"}); len(hits) != 1 {
+		t.Errorf("Expected the search index to be rebuilt, got %d hits", len(hits))
+	}
+}
+
+func TestMigrateEventSequences(t *testing.T) {
+	backend := newTestBackend(t)
+	ctx := context.Background()
+	createTestSession(t, backend, "s1")
+	createTestSession(t, backend, "s2")
+
+	if err := backend.MigrateSchema(ctx, 9); err != nil {
+		t.Fatalf("Failed to migrate down: %v", err)
+	}
+
+	// Every hook process numbered its event 1
+	for i, id := range []string{"e3", "e1", "e2"} {
+		if _, err := backend.ExecuteExec(ctx, `INSERT INTO events (id, session_id, event_type, timestamp, sequence_num, data) VALUES (?, 's1', 'user_prompt', ?, 1, '{}')`,
+			id, time.Date(2025, 3, 1, 12, 0, 3-i, 0, time.UTC)); err != nil {
+			t.Fatalf("Failed to insert event: %v", err)
+		}
+	}
+	if _, err := backend.ExecuteExec(ctx, `INSERT INTO events (id, session_id, event_type, timestamp, sequence_num, data) VALUES ('f1', 's2', 'user_prompt', ?, 7, '{}')`, time.Now()); err != nil {
+		t.Fatalf("Failed to insert event: %v", err)
+	}
+
+	if err := backend.MigrateSchema(ctx, SchemaVersion); err != nil {
+		t.Fatalf("Failed to migrate up: %v", err)
+	}
+
+	events, _ := backend.GetEventsBySession(ctx, "s1")
+	if strings.Join(eventIDs(events), ",") != "e2,e1,e3" || events[2].SequenceNum != 3 {
+		t.Errorf("Expected events renumbered by timestamp, got %v", eventIDs(events))
+	}
+	if events, _ := backend.GetEventsBySession(ctx, "s2"); events[0].SequenceNum != 7 {
+		t.Errorf("Expected a session without repeats to keep its numbers, got %d", events[0].SequenceNum)
+	}
+
+	// New events continue after the migrated ones
+	if err := backend.CreateEvent(ctx, &Event{ID: "e4", SessionID: "s1", Timestamp: time.Now()}); err != nil {
+		t.Fatalf("Failed to create event: %v", err)
+	}
+	if events, _ := backend.GetEventsBySession(ctx, "s1"); events[3].SequenceNum != 4 {
+		t.Errorf("Expected the new event to be number 4, got %d", events[3].SequenceNum)
+	}
+}
+
+func TestMigrationChecksums(t *testing.T) {
+	backend := newTestBackend(t)
+	ctx := context.Background()
+
+	// Databases migrated before checksums get them recorded on the next run
+	if _, err := backend.ExecuteExec(ctx, "UPDATE schema_migrations SET checksum = NULL"); err != nil {
+		t.Fatalf("Failed to clear checksums: %v", err)
+	}
+	if err := backend.MigrateSchema(ctx, 4); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	states, _ := backend.MigrationStatus(ctx)
+	if states[0].Checksum != migrations[0].Checksum() {
+		t.Errorf("Expected checksum to be recorded, got %q", states[0].Checksum)
+	}
+
+	// An applied migration edited afterwards blocks further migrations
+	if _, err := backend.ExecuteExec(ctx, "UPDATE schema_migrations SET checksum = 'edited' WHERE version = 2"); err != nil {
+		t.Fatalf("Failed to change checksum: %v", err)
+	}
+	if err := backend.MigrateSchema(ctx, SchemaVersion); !errors.Is(err, ErrMigrationModified) {
+		t.Errorf("Expected ErrMigrationModified, got %v", err)
+	}
+	states, _ = backend.MigrationStatus(ctx)
+	if states[1].Status != MigrationModified {
+		t.Errorf("Expected migration 2 to be reported as modified, got %s", states[1].Status)
+	}
+
+	// Migrations from a newer build are reported and never reverted
+	if _, err := backend.ExecuteExec(ctx, "UPDATE schema_migrations SET checksum = ? WHERE version = 2", migrations[1].Checksum()); err != nil {
+		t.Fatalf("Failed to restore checksum: %v", err)
+	}
+	if _, err := backend.ExecuteExec(ctx, "INSERT INTO schema_migrations VALUES (99, 'from_the_future', datetime('now'), 'x')"); err != nil {
+		t.Fatalf("Failed to insert migration: %v", err)
+	}
+	if _, err := backend.PlanMigration(ctx, SchemaVersion); err == nil {
+		t.Error("Expected planning with an unknown migration to fail")
+	}
+}
+
+func TestMigrationBackup(t *testing.T) {
+	backend := newLegacyBackend(t, legacyImportSchema)
+	ctx := context.Background()
+
+	if err := backend.MigrateSchema(ctx, SchemaVersion); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+
+	backups, _ := filepath.Glob(filepath.Join(BackupDir(backend.config.DatabasePath), fmt.Sprintf("pre-migrate-v0-to-v%d-*.db", SchemaVersion)))
+	if len(backups) != 1 {
+		t.Fatalf("Expected one pre-migration backup, got %v", backups)
+	}
+
+	// The backup holds the database as it was before migrating
+	snapshot := NewPureGoSQLiteBackend()
+	config := DefaultDatabaseConfig()
+	config.DatabasePath = backups[0]
+	if err := snapshot.Initialize(ctx, config); err != nil {
+		t.Fatalf("Failed to open backup: %v", err)
+	}
+	defer snapshot.Close()
+
+	var data string
+	if err := snapshot.db.QueryRow("SELECT event_data FROM events WHERE sequence_number = 1").Scan(&data); err != nil || data != "started" {
+		t.Errorf("Expected the legacy events in the backup, got %q (%v)", data, err)
+	}
+
+	// Nothing to back up when nothing changes
+	if err := backend.MigrateSchema(ctx, SchemaVersion); err != nil {
+		t.Fatalf("Failed to rerun migrations: %v", err)
+	}
+	if backups, _ := filepath.Glob(filepath.Join(BackupDir(backend.config.DatabasePath), "*.db")); len(backups) != 1 {
+		t.Errorf("Expected no new backup, got %v", backups)
+	}
+}
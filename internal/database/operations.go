@@ -1,10 +1,13 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // Types are now defined in types.go to avoid circular imports
@@ -15,19 +18,23 @@ func CreateSession(session *Session) error {
 		return err
 	}
 
-	metadataJSON := session.Metadata
+	metadataJSON, err := globalCipher.sealField(fieldSessionMetadata, session.Metadata)
+	if err != nil {
+		return err
+	}
 
 	query := `
-		INSERT INTO sessions (id, created_at, updated_at, status, metadata)
-		VALUES (?, ?, ?, ?, ?)
+		INSERT INTO sessions (id, created_at, updated_at, status, metadata, project_bidx)
+		VALUES (?, ?, ?, ?, ?, ?)
 	`
 
 	_, err = db.Exec(query,
 		session.ID,
-		session.CreatedAt.Format(time.RFC3339),
-		session.UpdatedAt.Format(time.RFC3339),
+		session.CreatedAt,
+		session.UpdatedAt,
 		session.Status,
 		metadataJSON,
+		globalCipher.projectBlindIndex(session.Metadata),
 	)
 
 	return err
@@ -39,7 +46,7 @@ func UpdateSession(sessionID string, status string, metadata map[string]string)
 		return err
 	}
 
-	metadataJSON := ""
+	metadataJSON := "{}"
 	if metadata != nil {
 		metadataBytes, err := json.Marshal(metadata)
 		if err != nil {
@@ -47,17 +54,22 @@ func UpdateSession(sessionID string, status string, metadata map[string]string)
 		}
 		metadataJSON = string(metadataBytes)
 	}
+	sealedMetadata, err := globalCipher.sealField(fieldSessionMetadata, metadataJSON)
+	if err != nil {
+		return err
+	}
 
 	query := `
 		UPDATE sessions
-		SET updated_at = ?, status = ?, metadata = ?
+		SET updated_at = ?, status = ?, metadata = ?, project_bidx = ?
 		WHERE id = ?
 	`
 
 	_, err = db.Exec(query,
-		time.Now().Format(time.RFC3339),
+		time.Now(),
 		status,
-		metadataJSON,
+		sealedMetadata,
+		globalCipher.projectBlindIndex(metadataJSON),
 		sessionID,
 	)
 
@@ -79,23 +91,19 @@ func GetSession(sessionID string) (*Session, error) {
 	row := db.QueryRow(query, sessionID)
 
 	var session Session
-	var createdAt, updatedAt, metadataJSON string
+	var status, metadataJSON sql.NullString
 
-	err = row.Scan(&session.ID, &createdAt, &updatedAt, &session.Status, &metadataJSON)
+	err = row.Scan(&session.ID, &session.CreatedAt, &session.UpdatedAt, &status, &metadataJSON)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, err
 	}
-
-	session.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
-	session.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
-
-	if metadataJSON != "" {
-		if err := json.Unmarshal([]byte(metadataJSON), &session.Metadata); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
-		}
+	session.Status = status.String
+	session.Metadata, err = globalCipher.openField(fieldSessionMetadata, metadataJSON.String)
+	if err != nil {
+		return nil, err
 	}
 
 	return &session, nil
@@ -107,29 +115,43 @@ func CreateEvent(event *Event) error {
 		return err
 	}
 
-	query := `
-		INSERT INTO events (session_id, event_type, event_data, timestamp, sequence_number)
-		VALUES (?, ?, ?, ?, ?)
-	`
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	data, err := globalCipher.sealField(fieldEventData, event.Data)
+	if err != nil {
+		return err
+	}
 
-	result, err := db.Exec(query,
-		event.SessionID,
-		event.EventType,
-		event.Data,
-		event.Timestamp.Format(time.RFC3339),
-		event.SequenceNum,
-	)
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
+	sequence, err := nextEventSequence(ctx, tx, event.SessionID, event.SequenceNum)
 	if err != nil {
 		return err
 	}
 
-	id, err := result.LastInsertId()
+	query := `
+		INSERT INTO events (id, session_id, event_type, data, timestamp, sequence_num)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+
+	_, err = tx.Exec(query,
+		event.ID,
+		event.SessionID,
+		event.EventType,
+		data,
+		event.Timestamp,
+		sequence,
+	)
 	if err != nil {
 		return err
 	}
-	event.ID = fmt.Sprintf("%d", id)
-	return nil
+	return tx.Commit()
 }
 
 func GetEventsBySession(sessionID string) ([]*Event, error) {
@@ -139,10 +161,10 @@ func GetEventsBySession(sessionID string) ([]*Event, error) {
 	}
 
 	query := `
-		SELECT id, session_id, event_type, event_data, timestamp, sequence_number
+		SELECT id, session_id, event_type, data, timestamp, sequence_num
 		FROM events
 		WHERE session_id = ?
-		ORDER BY sequence_number ASC
+		ORDER BY sequence_num ASC
 	`
 
 	rows, err := db.Query(query, sessionID)
@@ -154,21 +176,22 @@ func GetEventsBySession(sessionID string) ([]*Event, error) {
 	var events []*Event
 	for rows.Next() {
 		var event Event
-		var timestampStr string
 
 		err := rows.Scan(
 			&event.ID,
 			&event.SessionID,
 			&event.EventType,
 			&event.Data,
-			&timestampStr,
+			&event.Timestamp,
 			&event.SequenceNum,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if event.Data, err = globalCipher.openField(fieldEventData, event.Data); err != nil {
+			return nil, err
+		}
 
-		event.Timestamp, _ = time.Parse(time.RFC3339, timestampStr)
 		events = append(events, &event)
 	}
 
@@ -181,30 +204,33 @@ func CreateConversation(conversation *Conversation) error {
 		return err
 	}
 
+	if conversation.ID == "" {
+		conversation.ID = uuid.New().String()
+	}
+	if conversation.Metadata == "" {
+		conversation.Metadata = "{}"
+	}
+	content, err := globalCipher.sealField(fieldConversationContent, conversation.Content)
+	if err != nil {
+		return err
+	}
+
 	query := `
-		INSERT INTO conversations (session_id, message_type, content, timestamp, token_count, model_info)
-		VALUES (?, ?, ?, ?, ?, ?)
+		INSERT INTO conversations (id, session_id, message_type, content, timestamp, metadata, token_count, model)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	result, err := db.Exec(query,
+	_, err = db.Exec(query,
+		conversation.ID,
 		conversation.SessionID,
 		conversation.MessageType,
-		conversation.Content,
-		conversation.Timestamp.Format(time.RFC3339),
+		content,
+		conversation.Timestamp,
+		conversation.Metadata,
 		conversation.TokenCount,
 		conversation.Model,
 	)
-
-	if err != nil {
-		return err
-	}
-
-	id, err := result.LastInsertId()
-	if err != nil {
-		return err
-	}
-	conversation.ID = fmt.Sprintf("%d", id)
-	return nil
+	return err
 }
 
 func GetConversationsBySession(sessionID string) ([]*Conversation, error) {
@@ -214,7 +240,7 @@ func GetConversationsBySession(sessionID string) ([]*Conversation, error) {
 	}
 
 	query := `
-		SELECT id, session_id, message_type, content, timestamp, token_count, model_info
+		SELECT id, session_id, message_type, content, timestamp, metadata, token_count, model
 		FROM conversations
 		WHERE session_id = ?
 		ORDER BY timestamp ASC
@@ -229,22 +255,24 @@ func GetConversationsBySession(sessionID string) ([]*Conversation, error) {
 	var conversations []*Conversation
 	for rows.Next() {
 		var conversation Conversation
-		var timestampStr string
 
 		err := rows.Scan(
 			&conversation.ID,
 			&conversation.SessionID,
 			&conversation.MessageType,
 			&conversation.Content,
-			&timestampStr,
+			&conversation.Timestamp,
+			&conversation.Metadata,
 			&conversation.TokenCount,
 			&conversation.Model,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if conversation.Content, err = globalCipher.openField(fieldConversationContent, conversation.Content); err != nil {
+			return nil, err
+		}
 
-		conversation.Timestamp, _ = time.Parse(time.RFC3339, timestampStr)
 		conversations = append(conversations, &conversation)
 	}
 
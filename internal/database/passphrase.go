@@ -0,0 +1,199 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// A passphrase protects the data key by wrapping it: the key is sealed
+// under a key derived from the passphrase, and only the sealed copy and
+// the derivation parameters are stored. Changing the passphrase rewraps
+// the same data key, so the data doesn't need to be re-encrypted.
+
+// Key derivation functions for passphrases
+const (
+	KDFArgon2id = "argon2id"
+	KDFScrypt   = "scrypt"
+)
+
+// Environment variables a passphrase is read from
+const (
+	// PassphraseEnv holds the passphrase itself
+	PassphraseEnv = "CONTEXT_EXTENDER_PASSPHRASE"
+	// PassphraseFileEnv names a key file holding the passphrase
+	PassphraseFileEnv = "CONTEXT_EXTENDER_KEY_FILE"
+)
+
+var (
+	// ErrPassphraseRequired is returned when a wrapped key must be unlocked
+	// but no passphrase is available
+	ErrPassphraseRequired = errors.New("encryption key is protected by a passphrase, set " + PassphraseEnv + " or " + PassphraseFileEnv)
+	// ErrWrongPassphrase is returned when a passphrase doesn't unwrap the key
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+)
+
+// Derivation parameters for newly wrapped keys. Argon2id uses 64 MiB, which
+// takes a fraction of a second, once per process that opens the database.
+var (
+	argon2idTime    uint32 = 3
+	argon2idMemory  uint32 = 64 * 1024
+	argon2idThreads uint8  = 4
+	scryptCost             = 1 << 15
+	scryptBlockSize        = 8
+	scryptParallel  uint8  = 1
+)
+
+// wrapKeyInfo is the associated data of wrapped keys
+const wrapKeyInfo = "context-extender data key"
+
+// PassphrasePrompt asks for a passphrase interactively. The CLI sets it;
+// without it passphrases only come from the environment.
+var PassphrasePrompt func(prompt string) (string, error)
+
+var (
+	passphraseMu sync.Mutex
+	passphrase   *string
+)
+
+// SetPassphrase sets the passphrase the process unlocks keys with, ahead of
+// the environment and the prompt
+func SetPassphrase(value string) {
+	passphraseMu.Lock()
+	defer passphraseMu.Unlock()
+	passphrase = &value
+}
+
+// forgetPassphrase drops a passphrase that turned out to be wrong
+func forgetPassphrase() {
+	passphraseMu.Lock()
+	defer passphraseMu.Unlock()
+	passphrase = nil
+}
+
+// ResolvePassphrase returns the passphrase to unlock a key with, taken from
+// SetPassphrase, PassphraseEnv, the key file in PassphraseFileEnv or the
+// prompt, in that order. A prompted passphrase is remembered.
+func ResolvePassphrase(prompt string) (string, error) {
+	passphraseMu.Lock()
+	defer passphraseMu.Unlock()
+
+	if passphrase != nil {
+		return *passphrase, nil
+	}
+	if value, ok := os.LookupEnv(PassphraseEnv); ok {
+		return value, nil
+	}
+	if path := os.Getenv(PassphraseFileEnv); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read key file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	if PassphrasePrompt == nil {
+		return "", ErrPassphraseRequired
+	}
+
+	value, err := PassphrasePrompt(prompt)
+	if err != nil {
+		return "", err
+	}
+	passphrase = &value
+	return value, nil
+}
+
+// setKDF fills in fresh derivation parameters for a key function
+func (info *KeyInfo) setKDF(kdf string) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	switch kdf {
+	case KDFArgon2id:
+		info.Iterations, info.Memory, info.Parallelism, info.BlockSize = int(argon2idTime), argon2idMemory, argon2idThreads, 0
+	case KDFScrypt:
+		info.Iterations, info.Memory, info.Parallelism, info.BlockSize = scryptCost, 0, scryptParallel, scryptBlockSize
+	default:
+		return fmt.Errorf("unknown key derivation function %q, use %s or %s", kdf, KDFArgon2id, KDFScrypt)
+	}
+	info.Algorithm = kdf
+	info.Salt = base64.StdEncoding.EncodeToString(salt)
+	return nil
+}
+
+// deriveWrappingKey derives the key that wraps the data key
+func (info *KeyInfo) deriveWrappingKey(passphrase string) ([]byte, error) {
+	salt, err := base64.StdEncoding.DecodeString(info.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode salt: %w", err)
+	}
+
+	switch info.Algorithm {
+	case KDFArgon2id:
+		return argon2.IDKey([]byte(passphrase), salt, uint32(info.Iterations), info.Memory, info.Parallelism, chacha20poly1305.KeySize), nil
+	case KDFScrypt:
+		key, err := scrypt.Key([]byte(passphrase), salt, info.Iterations, info.BlockSize, int(info.Parallelism), chacha20poly1305.KeySize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive key: %w", err)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key derivation function %q", info.Algorithm)
+}
+
+// wrap seals the data key under the passphrase with fresh parameters
+func (info *KeyInfo) wrap(key []byte, passphrase, kdf string) error {
+	if err := info.setKDF(kdf); err != nil {
+		return err
+	}
+	wrappingKey, err := info.deriveWrappingKey(passphrase)
+	if err != nil {
+		return err
+	}
+	aead, err := chacha20poly1305.NewX(wrappingKey)
+	if err != nil {
+		return fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	info.WrappedKey = base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, key, []byte(wrapKeyInfo)))
+	return nil
+}
+
+// unwrap opens the data key with the passphrase
+func (info *KeyInfo) unwrap(passphrase string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(info.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode wrapped key: %w", err)
+	}
+	wrappingKey, err := info.deriveWrappingKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(wrappingKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped key is malformed")
+	}
+
+	key, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(wrapKeyInfo))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return key, nil
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useFastKDF lowers the derivation costs for the duration of a test
+func useFastKDF(t *testing.T) {
+	t.Helper()
+
+	time, memory, cost := argon2idTime, argon2idMemory, scryptCost
+	argon2idTime, argon2idMemory, scryptCost = 1, 1024, 1<<10
+	t.Cleanup(func() {
+		argon2idTime, argon2idMemory, scryptCost = time, memory, cost
+		forgetPassphrase()
+	})
+}
+
+func TestWrappedKey(t *testing.T) {
+	useFastKDF(t)
+	// Only SetPassphrase supplies passphrases here
+	for _, env := range []string{PassphraseEnv, PassphraseFileEnv} {
+		t.Setenv(env, "")
+		os.Unsetenv(env)
+	}
+
+	for _, kdf := range []string{KDFArgon2id, KDFScrypt} {
+		t.Run(kdf, func(t *testing.T) {
+			km := NewKeyManager(t.TempDir())
+			key, err := km.GenerateKey()
+			if err != nil {
+				t.Fatalf("Failed to generate key: %v", err)
+			}
+			if err := km.SaveWrappedKey(key, "correct horse", kdf); err != nil {
+				t.Fatalf("Failed to save wrapped key: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(km.KeyPath(), "db.key")); !os.IsNotExist(err) {
+				t.Error("Expected no unprotected key file")
+			}
+
+			// A fresh manager reads everything from the metadata
+			km = NewKeyManager(km.KeyPath())
+			if !km.KeyExists() || !km.IsWrapped() {
+				t.Fatal("Expected a wrapped key to exist")
+			}
+
+			SetPassphrase("wrong")
+			if _, err := km.LoadKey(); !errors.Is(err, ErrWrongPassphrase) {
+				t.Errorf("Expected ErrWrongPassphrase, got %v", err)
+			}
+			if _, err := km.LoadKey(); !errors.Is(err, ErrPassphraseRequired) {
+				t.Errorf("Expected a wrong passphrase to be forgotten, got %v", err)
+			}
+
+			SetPassphrase("correct horse")
+			if loaded, err := km.LoadKey(); err != nil || loaded != key {
+				t.Fatalf("Expected the key back, got %v", err)
+			}
+
+			// Changing the passphrase keeps the key
+			if err := km.ChangePassphrase("battery staple", kdf); err != nil {
+				t.Fatalf("Failed to change passphrase: %v", err)
+			}
+			SetPassphrase("battery staple")
+			if loaded, err := NewKeyManager(km.KeyPath()).LoadKey(); err != nil || loaded != key {
+				t.Errorf("Expected the same key under the new passphrase, got %v", err)
+			}
+			forgetPassphrase()
+
+			// Key files are replaced, never left half written
+			if files, _ := filepath.Glob(filepath.Join(km.KeyPath(), "*")); len(files) != 1 {
+				t.Errorf("Expected only the key metadata, got %v", files)
+			}
+		})
+	}
+}
+
+func TestResolvePassphrase(t *testing.T) {
+	t.Cleanup(forgetPassphrase)
+
+	keyFile := filepath.Join(t.TempDir(), "passphrase")
+	if err := os.WriteFile(keyFile, []byte("from file\n"), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	t.Setenv(PassphraseFileEnv, keyFile)
+	t.Setenv(PassphraseEnv, "from env")
+
+	if value, err := ResolvePassphrase(""); err != nil || value != "from env" {
+		t.Errorf("Expected the environment first, got %q (%v)", value, err)
+	}
+	os.Unsetenv(PassphraseEnv)
+	if value, err := ResolvePassphrase(""); err != nil || value != "from file" {
+		t.Errorf("Expected the key file without its newline, got %q (%v)", value, err)
+	}
+
+	os.Unsetenv(PassphraseFileEnv)
+	if _, err := ResolvePassphrase(""); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("Expected ErrPassphraseRequired without a prompt, got %v", err)
+	}
+
+	prompts := 0
+	PassphrasePrompt = func(string) (string, error) {
+		prompts++
+		return "typed", nil
+	}
+	t.Cleanup(func() { PassphrasePrompt = nil })
+	for i := 0; i < 2; i++ {
+		if value, err := ResolvePassphrase(""); err != nil || value != "typed" {
+			t.Errorf("Expected the prompted passphrase, got %q (%v)", value, err)
+		}
+	}
+	if prompts != 1 {
+		t.Errorf("Expected a prompted passphrase to be remembered, prompted %d times", prompts)
+	}
+}
@@ -3,17 +3,38 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"iter"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
 	_ "modernc.org/sqlite"
 )
 
+// ErrSessionNotFound is returned when a session lookup finds no matching row
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrToolInvocationNotFound is returned when a tool invocation lookup finds no matching row
+var ErrToolInvocationNotFound = errors.New("tool invocation not found")
+
 // PureGoSQLiteBackend implements DatabaseBackend using modernc.org/sqlite
 type PureGoSQLiteBackend struct {
 	db     *sql.DB
 	config *DatabaseConfig
+	// writeDB has a single connection all writes queue for, so processes
+	// and goroutines don't contend for the write lock within one backend
+	writeDB      *sql.DB
+	writeRetries writeRetryCounter
+	// cipher is set when field encryption is on, guarded by cipherMu as a
+	// rekey can swap it
+	cipher   *FieldCipher
+	cipherMu sync.RWMutex
+	// stmts holds the prepared write statements by query
+	stmts  map[string]*sql.Stmt
+	stmtMu sync.Mutex
 }
 
 // NewPureGoSQLiteBackend creates a new pure Go SQLite backend
@@ -25,8 +46,18 @@ func NewPureGoSQLiteBackend() *PureGoSQLiteBackend {
 func (b *PureGoSQLiteBackend) Initialize(ctx context.Context, config *DatabaseConfig) error {
 	b.config = config
 
+	busyTimeout := config.BusyTimeout
+	if busyTimeout == 0 {
+		busyTimeout = DefaultBusyTimeout
+	}
+
+	// Each in-memory connection is a database of its own, so there reads
+	// and writes share a single pool. Otherwise the reader pool is query
+	// only and all writes go through writeDB.
+	memory := strings.Contains(config.DatabasePath, ":memory:") || strings.Contains(config.DatabasePath, "mode=memory")
+
 	// Open database connection using modernc.org/sqlite driver
-	db, err := sql.Open("sqlite", config.DatabasePath)
+	db, err := sql.Open("sqlite", sqliteDSN(config.DatabasePath, busyTimeout, memory))
 	if err != nil {
 		return fmt.Errorf("failed to open database: %w", err)
 	}
@@ -36,23 +67,35 @@ func (b *PureGoSQLiteBackend) Initialize(ctx context.Context, config *DatabaseCo
 	db.SetMaxIdleConns(10)
 	db.SetConnMaxLifetime(5 * time.Minute)
 
+	writeDB := db
+	if !memory {
+		if writeDB, err = sql.Open("sqlite", sqliteDSN(config.DatabasePath, busyTimeout, true)); err != nil {
+			db.Close()
+			return fmt.Errorf("failed to open database: %w", err)
+		}
+		writeDB.SetMaxOpenConns(1)
+	}
+
 	// Test connection
-	if err := db.PingContext(ctx); err != nil {
-		db.Close()
+	if err := writeDB.PingContext(ctx); err != nil {
+		b.closePools(db, writeDB)
 		return fmt.Errorf("failed to ping database: %w", err)
 	}
 
 	// Enable WAL mode for better concurrency
-	if _, err := db.ExecContext(ctx, "PRAGMA journal_mode=WAL"); err != nil {
+	if _, err := writeDB.ExecContext(ctx, "PRAGMA journal_mode=WAL"); err != nil {
 		log.Printf("Warning: failed to enable WAL mode: %v", err)
 	}
 
-	// Enable foreign keys
-	if _, err := db.ExecContext(ctx, "PRAGMA foreign_keys=ON"); err != nil {
-		log.Printf("Warning: failed to enable foreign keys: %v", err)
+	fieldCipher, err := openFieldCipher(ctx, db, b.keyDir())
+	if err != nil {
+		b.closePools(db, writeDB)
+		return err
 	}
 
 	b.db = db
+	b.writeDB = writeDB
+	b.setFieldCipher(fieldCipher)
 	log.Printf("Pure Go SQLite backend initialized at %s", config.DatabasePath)
 
 	return nil
@@ -61,69 +104,53 @@ func (b *PureGoSQLiteBackend) Initialize(ctx context.Context, config *DatabaseCo
 // Close closes the database connection
 func (b *PureGoSQLiteBackend) Close() error {
 	if b.db != nil {
-		return b.db.Close()
+		if err := b.saveWriteRetryStats(context.Background()); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		b.closeStatements()
+		return b.closePools(b.db, b.writeDB)
 	}
 	return nil
 }
 
-// GetConnection returns the database connection
+// closePools closes the reader and writer connection pools
+func (b *PureGoSQLiteBackend) closePools(db, writeDB *sql.DB) error {
+	if writeDB != db {
+		writeDB.Close()
+	}
+	return db.Close()
+}
+
+// GetConnection returns the writer connection, the only one taking writes
 func (b *PureGoSQLiteBackend) GetConnection() (*sql.DB, error) {
 	if b.db == nil {
 		return nil, fmt.Errorf("database not initialized")
 	}
-	return b.db, nil
+	return b.writeDB, nil
 }
 
-// CreateSchema creates the database schema
+// CreateSchema creates the database schema, or brings an existing one up
+// to date
 func (b *PureGoSQLiteBackend) CreateSchema(ctx context.Context) error {
 	if b.db == nil {
 		return fmt.Errorf("database not initialized")
 	}
 
-	schema := `
-	CREATE TABLE IF NOT EXISTS sessions (
-		id TEXT PRIMARY KEY,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		status TEXT,
-		metadata TEXT
-	);
-
-	CREATE TABLE IF NOT EXISTS events (
-		id TEXT PRIMARY KEY,
-		session_id TEXT,
-		event_type TEXT,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		sequence_num INTEGER,
-		data TEXT,
-		FOREIGN KEY (session_id) REFERENCES sessions(id)
-	);
-
-	CREATE TABLE IF NOT EXISTS conversations (
-		id TEXT PRIMARY KEY,
-		session_id TEXT,
-		message_type TEXT,
-		content TEXT,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		metadata TEXT,
-		token_count INTEGER,
-		model TEXT,
-		FOREIGN KEY (session_id) REFERENCES sessions(id)
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_events_session_id ON events(session_id);
-	CREATE INDEX IF NOT EXISTS idx_events_timestamp ON events(timestamp);
-	CREATE INDEX IF NOT EXISTS idx_conversations_session_id ON conversations(session_id);
-	CREATE INDEX IF NOT EXISTS idx_conversations_timestamp ON conversations(timestamp);
-	`
-
-	if _, err := b.db.ExecContext(ctx, schema); err != nil {
+	if err := b.migrate(ctx, SchemaVersion); err != nil {
 		return fmt.Errorf("failed to create schema: %w", err)
 	}
-
 	return nil
 }
 
+// migrate runs migrateSchema on the writer connection, again when it
+// finds the database locked
+func (b *PureGoSQLiteBackend) migrate(ctx context.Context, targetVersion int) error {
+	return b.retryBusy(ctx, func() error {
+		_, err := migrateSchema(ctx, b.writeDB, targetVersion, false, b.backupDir())
+		return err
+	})
+}
+
 // RunMigrations runs database migrations
 func (b *PureGoSQLiteBackend) RunMigrations(ctx context.Context) error {
 	// For now, just ensure schema exists
@@ -133,7 +160,7 @@ func (b *PureGoSQLiteBackend) RunMigrations(ctx context.Context) error {
 // GetBackendInfo returns backend information
 func (b *PureGoSQLiteBackend) GetBackendInfo() *BackendInfo {
 	capabilities := map[string]bool{
-		"encryption":   false, // Application-level encryption can be added
+		"encryption":   true, // Application-level field encryption
 		"full_text":    true,  // SQLite FTS available
 		"transactions": true,
 		"cgo":          false, // Pure Go!
@@ -143,84 +170,43 @@ func (b *PureGoSQLiteBackend) GetBackendInfo() *BackendInfo {
 		Name:         "Pure Go SQLite",
 		Version:      "modernc.org/sqlite v1.39.0",
 		RequiresCGO:  false,
-		Features:     []string{"WAL mode", "Foreign keys", "FTS5", "JSON support"},
+		Features:     []string{"WAL mode", "Foreign keys", "FTS5", "JSON support", "Field encryption"},
 		Capabilities: capabilities,
 	}
 }
 
 // CreateSession creates a new session
 func (b *PureGoSQLiteBackend) CreateSession(ctx context.Context, session *Session) error {
-	query := `
-		INSERT INTO sessions (id, created_at, updated_at, status, metadata)
-		VALUES (?, ?, ?, ?, ?)
-	`
-	_, err := b.db.ExecContext(ctx, query,
-		session.ID,
-		session.CreatedAt,
-		session.UpdatedAt,
-		session.Status,
-		session.Metadata,
-	)
-	return err
+	return b.writeTx(ctx, func(w *sqliteWriter) error {
+		return w.CreateSession(ctx, session)
+	})
 }
 
 // GetSession retrieves a session by ID
 func (b *PureGoSQLiteBackend) GetSession(ctx context.Context, sessionID string) (*Session, error) {
-	query := `
-		SELECT id, created_at, updated_at, status, metadata
-		FROM sessions WHERE id = ?
-	`
-
-	session := &Session{}
-	err := b.db.QueryRowContext(ctx, query, sessionID).Scan(
-		&session.ID,
-		&session.CreatedAt,
-		&session.UpdatedAt,
-		&session.Status,
-		&session.Metadata,
-	)
-
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("session not found")
-	}
-
-	return session, err
+	return (&sqliteWriter{b: b}).GetSession(ctx, sessionID)
 }
 
 // CreateEvent creates a new event
 func (b *PureGoSQLiteBackend) CreateEvent(ctx context.Context, event *Event) error {
-	query := `
-		INSERT INTO events (id, session_id, event_type, timestamp, sequence_num, data)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`
-	_, err := b.db.ExecContext(ctx, query,
-		event.ID,
-		event.SessionID,
-		event.EventType,
-		event.Timestamp,
-		event.SequenceNum,
-		event.Data,
-	)
-	return err
+	return b.writeTx(ctx, func(w *sqliteWriter) error {
+		return w.CreateEvent(ctx, event)
+	})
 }
 
 // CreateConversation creates a new conversation entry
 func (b *PureGoSQLiteBackend) CreateConversation(ctx context.Context, conv *Conversation) error {
-	query := `
-		INSERT INTO conversations (id, session_id, message_type, content, timestamp, metadata, token_count, model)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	`
-	_, err := b.db.ExecContext(ctx, query,
-		conv.ID,
-		conv.SessionID,
-		conv.MessageType,
-		conv.Content,
-		conv.Timestamp,
-		conv.Metadata,
-		conv.TokenCount,
-		conv.Model,
-	)
-	return err
+	return b.writeTx(ctx, func(w *sqliteWriter) error {
+		return w.CreateConversation(ctx, conv)
+	})
+}
+
+// CreateConversationBatch creates multiple conversations in a single
+// transaction
+func (b *PureGoSQLiteBackend) CreateConversationBatch(ctx context.Context, convs []*Conversation) error {
+	return b.writeTx(ctx, func(w *sqliteWriter) error {
+		return w.CreateConversationBatch(ctx, convs)
+	})
 }
 
 // ExecuteQuery executes a raw SQL query
@@ -230,12 +216,19 @@ func (b *PureGoSQLiteBackend) ExecuteQuery(ctx context.Context, query string, ar
 
 // ExecuteExec executes a raw SQL exec command
 func (b *PureGoSQLiteBackend) ExecuteExec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
-	return b.db.ExecContext(ctx, query, args...)
+	return b.execWrite(ctx, query, args...)
 }
 
-// BeginTransaction starts a new transaction
+// BeginTransaction starts a new transaction on the writer connection. It
+// holds the write lock until it ends.
 func (b *PureGoSQLiteBackend) BeginTransaction(ctx context.Context) (*sql.Tx, error) {
-	return b.db.BeginTx(ctx, nil)
+	var tx *sql.Tx
+	err := b.retryBusy(ctx, func() error {
+		var err error
+		tx, err = b.writeDB.BeginTx(ctx, nil)
+		return err
+	})
+	return tx, err
 }
 
 // Missing interface methods
@@ -248,104 +241,245 @@ func (b *PureGoSQLiteBackend) Ping(ctx context.Context) error {
 	return b.db.PingContext(ctx)
 }
 
-// GetSchemaVersion returns the current schema version
+// GetSchemaVersion returns the current schema version, 0 for databases
+// created before schema versioning
 func (b *PureGoSQLiteBackend) GetSchemaVersion(ctx context.Context) (int, error) {
-	// For now, return version 1 (initial schema)
-	// In the future, this could be stored in a schema_info table
-	return 1, nil
+	if b.db == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+	return schemaVersion(ctx, b.db)
 }
 
-// MigrateSchema runs migrations to reach target version
+// schemaTables lists the tables created by the migrations
+var schemaTables = []string{"sessions", "events", "conversations", "tool_invocations", "transcript_cursors", "import_history", "settings", "session_tags", "session_sequences"}
+
+// MissingTables returns the schema tables that don't exist in the database,
+// e.g. because it was created by an older version
+func (b *PureGoSQLiteBackend) MissingTables(ctx context.Context) ([]string, error) {
+	if b.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	var missing []string
+	for _, table := range schemaTables {
+		var name string
+		err := b.db.QueryRowContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&name)
+		if err == sql.ErrNoRows {
+			missing = append(missing, table)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to check table %s: %w", table, err)
+		}
+	}
+	return missing, nil
+}
+
+// MigrateSchema rewrites legacy tables into the current layout and applies
+// or reverts migrations to reach the target version. The database is backed
+// up first.
 func (b *PureGoSQLiteBackend) MigrateSchema(ctx context.Context, targetVersion int) error {
-	currentVersion, err := b.GetSchemaVersion(ctx)
-	if err != nil {
-		return err
+	if b.db == nil {
+		return fmt.Errorf("database not initialized")
 	}
 
-	if currentVersion == targetVersion {
-		return nil // Already at target version
+	return b.migrate(ctx, targetVersion)
+}
+
+// PlanMigration returns what MigrateSchema would do without changing anything
+func (b *PureGoSQLiteBackend) PlanMigration(ctx context.Context, targetVersion int) (*MigrationPlan, error) {
+	if b.db == nil {
+		return nil, fmt.Errorf("database not initialized")
 	}
+	return migrateSchema(ctx, b.db, targetVersion, true, "")
+}
 
-	// For now, just ensure schema exists
-	return b.CreateSchema(ctx)
+// MigrationStatus lists the known and applied migrations
+func (b *PureGoSQLiteBackend) MigrationStatus(ctx context.Context) ([]*MigrationState, error) {
+	if b.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	return migrationStatus(ctx, b.db)
 }
 
-// UpdateSession updates an existing session
+// backupDir returns where pre-migration backups are kept
+func (b *PureGoSQLiteBackend) backupDir() string {
+	if b.config == nil {
+		return ""
+	}
+	return BackupDir(b.config.DatabasePath)
+}
+
+// keyDir returns the KeyManager directory of the field encryption key, set
+// with the "key_path" backend option. Empty means the KeyManager default.
+func (b *PureGoSQLiteBackend) keyDir() string {
+	if b.config == nil {
+		return ""
+	}
+	keyDir, _ := b.config.BackendOptions["key_path"].(string)
+	return keyDir
+}
+
+// UpdateSession updates a session's status, metadata and update time
 func (b *PureGoSQLiteBackend) UpdateSession(ctx context.Context, session *Session) error {
-	query := `
-		UPDATE sessions
-		SET updated_at = ?, status = ?, metadata = ?
-		WHERE id = ?
-	`
-	_, err := b.db.ExecContext(ctx, query,
-		session.UpdatedAt,
-		session.Status,
-		session.Metadata,
-		session.ID,
-	)
-	return err
+	return b.writeTx(ctx, func(w *sqliteWriter) error {
+		return w.UpdateSession(ctx, session)
+	})
 }
 
-// DeleteSession deletes a session by ID
+// DeleteSession moves a session to the trash
 func (b *PureGoSQLiteBackend) DeleteSession(ctx context.Context, id string) error {
-	query := `DELETE FROM sessions WHERE id = ?`
-	_, err := b.db.ExecContext(ctx, query, id)
-	return err
+	result, err := b.execWrite(ctx, `UPDATE sessions SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL`, time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to move session to trash: %w", err)
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
 }
 
-// ListSessions returns sessions based on filters
-func (b *PureGoSQLiteBackend) ListSessions(ctx context.Context, filters *SessionFilters) ([]*Session, error) {
-	query := `SELECT id, created_at, updated_at, status, metadata FROM sessions WHERE 1=1`
-	args := []interface{}{}
+// RestoreSession takes a session back out of the trash
+func (b *PureGoSQLiteBackend) RestoreSession(ctx context.Context, id string) error {
+	result, err := b.execWrite(ctx, `UPDATE sessions SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		return fmt.Errorf("failed to restore session: %w", err)
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
 
-	if filters != nil {
-		if filters.Status != "" {
-			query += " AND status = ?"
-			args = append(args, filters.Status)
-		}
-		if filters.CreatedAfter != nil {
-			query += " AND created_at > ?"
-			args = append(args, filters.CreatedAfter)
-		}
-		if filters.CreatedBefore != nil {
-			query += " AND created_at < ?"
-			args = append(args, filters.CreatedBefore)
-		}
-		if filters.SortBy != "" {
-			query += " ORDER BY " + filters.SortBy
-			if filters.SortOrder == "DESC" {
-				query += " DESC"
-			}
-		}
-		if filters.Limit > 0 {
-			query += " LIMIT ?"
-			args = append(args, filters.Limit)
+// PurgeSession deletes a session and everything recorded for it, whether
+// it is in the trash or not
+func (b *PureGoSQLiteBackend) PurgeSession(ctx context.Context, id string) error {
+	tx, err := b.BeginTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, spilled, err := deleteSessionRows(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return removeSpilledOutputs(spilled)
+}
+
+// sessionChildTables lists the tables whose rows belong to a session
+var sessionChildTables = []string{"events", "conversations", "tool_invocations", "transcript_cursors", "session_tags", "session_sequences"}
+
+// deleteSessionRows deletes a session and its child rows, returning how
+// many child rows were deleted and the files its full tool outputs were
+// spilled to, for the caller to remove once the deletion is committed
+func deleteSessionRows(ctx context.Context, tx *sql.Tx, id string) (int64, []string, error) {
+	spilled, err := spilledOutputs(ctx, tx, id)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// Child rows first, foreign keys are enforced
+	var deleted int64
+	for _, table := range sessionChildTables {
+		result, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE session_id = ?", id)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to delete %s: %w", table, err)
 		}
-		if filters.Offset > 0 {
-			query += " OFFSET ?"
-			args = append(args, filters.Offset)
+		count, _ := result.RowsAffected()
+		deleted += count
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE id = ?`, id); err != nil {
+		return 0, nil, err
+	}
+	return deleted, spilled, nil
+}
+
+// spilledOutputs returns the files holding the full tool outputs of a session
+func spilledOutputs(ctx context.Context, tx *sql.Tx, id string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT DISTINCT output_path FROM tool_invocations
+		WHERE session_id = ? AND output_path IS NOT NULL AND output_path != ''
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tool output paths: %w", err)
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("failed to read tool output paths: %w", err)
 		}
+		paths = append(paths, path)
 	}
+	return paths, rows.Err()
+}
 
-	rows, err := b.db.QueryContext(ctx, query, args...)
+// ListTrash returns the sessions in the trash, most recently deleted first
+func (b *PureGoSQLiteBackend) ListTrash(ctx context.Context) ([]*Session, error) {
+	rows, err := b.db.QueryContext(ctx, `
+		SELECT id, created_at, updated_at, status, metadata, deleted_at, message_count, token_count
+		FROM sessions WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC
+	`)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to list trash: %w", err)
 	}
 	defer rows.Close()
+	return b.scanSessions(ctx, rows)
+}
+
+// EmptyTrash deletes the sessions moved to the trash before deletedBefore
+// and reclaims their space
+func (b *PureGoSQLiteBackend) EmptyTrash(ctx context.Context, deletedBefore time.Time) (*PurgeResult, error) {
+	trash, err := b.ListTrash(ctx)
+	if err != nil {
+		return nil, err
+	}
 
+	var ids []string
+	for _, session := range trash {
+		if session.DeletedAt.Before(deletedBefore) {
+			ids = append(ids, session.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return &PurgeResult{}, nil
+	}
+	return b.PurgeSessions(ctx, ids)
+}
+
+// scanSessions reads sessions selected with their deleted_at and count
+// columns
+func (b *PureGoSQLiteBackend) scanSessions(ctx context.Context, rows *sql.Rows) ([]*Session, error) {
 	var sessions []*Session
 	for rows.Next() {
 		session := &Session{}
+		var deletedAt sql.NullTime
 		err := rows.Scan(
 			&session.ID,
 			&session.CreatedAt,
 			&session.UpdatedAt,
 			&session.Status,
 			&session.Metadata,
+			&deletedAt,
+			&session.MessageCount,
+			&session.TokenCount,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if session.Metadata, err = b.openField(ctx, fieldSessionMetadata, session.Metadata); err != nil {
+			return nil, err
+		}
+		if deletedAt.Valid {
+			session.DeletedAt = &deletedAt.Time
+		}
 		sessions = append(sessions, session)
 	}
 
@@ -354,114 +488,138 @@ func (b *PureGoSQLiteBackend) ListSessions(ctx context.Context, filters *Session
 
 // GetEventsBySession returns all events for a session
 func (b *PureGoSQLiteBackend) GetEventsBySession(ctx context.Context, sessionID string) ([]*Event, error) {
-	query := `
-		SELECT id, session_id, event_type, timestamp, sequence_num, data
-		FROM events WHERE session_id = ? ORDER BY sequence_num
-	`
-
-	rows, err := b.db.QueryContext(ctx, query, sessionID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+	return collect(b.IterEventsBySession(ctx, sessionID))
+}
 
-	var events []*Event
-	for rows.Next() {
-		event := &Event{}
-		err := rows.Scan(
-			&event.ID,
-			&event.SessionID,
-			&event.EventType,
-			&event.Timestamp,
-			&event.SequenceNum,
-			&event.Data,
-		)
+// IterEventsBySession yields a session's events in sequence order, reading
+// one row at a time
+func (b *PureGoSQLiteBackend) IterEventsBySession(ctx context.Context, sessionID string) iter.Seq2[*Event, error] {
+	return func(yield func(*Event, error) bool) {
+		rows, err := b.db.QueryContext(ctx, `
+			SELECT id, session_id, event_type, timestamp, sequence_num, data
+			FROM events WHERE session_id = ? ORDER BY sequence_num
+		`, sessionID)
 		if err != nil {
-			return nil, err
+			yield(nil, err)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			event := &Event{}
+			err := rows.Scan(
+				&event.ID,
+				&event.SessionID,
+				&event.EventType,
+				&event.Timestamp,
+				&event.SequenceNum,
+				&event.Data,
+			)
+			if err == nil {
+				event.Data, err = b.openField(ctx, fieldEventData, event.Data)
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(event, nil) {
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			yield(nil, err)
 		}
-		events = append(events, event)
 	}
-
-	return events, rows.Err()
 }
 
 // CreateEventBatch creates multiple events in a single transaction
 func (b *PureGoSQLiteBackend) CreateEventBatch(ctx context.Context, events []*Event) error {
-	tx, err := b.BeginTransaction(ctx)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
+	return b.writeTx(ctx, func(w *sqliteWriter) error {
+		return w.CreateEventBatch(ctx, events)
+	})
+}
 
-	query := `
-		INSERT INTO events (id, session_id, event_type, timestamp, sequence_num, data)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`
-	stmt, err := tx.PrepareContext(ctx, query)
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
+// GetConversationsBySession returns all conversations for a session
+func (b *PureGoSQLiteBackend) GetConversationsBySession(ctx context.Context, sessionID string) ([]*Conversation, error) {
+	return collect(b.IterConversationsBySession(ctx, sessionID))
+}
 
-	for _, event := range events {
-		_, err := stmt.ExecContext(ctx,
-			event.ID,
-			event.SessionID,
-			event.EventType,
-			event.Timestamp,
-			event.SequenceNum,
-			event.Data,
-		)
+// IterConversationsBySession yields a session's conversations in time
+// order, reading one row at a time
+func (b *PureGoSQLiteBackend) IterConversationsBySession(ctx context.Context, sessionID string) iter.Seq2[*Conversation, error] {
+	return func(yield func(*Conversation, error) bool) {
+		rows, err := b.db.QueryContext(ctx, `
+			SELECT id, session_id, message_type, content, timestamp, metadata, token_count, model
+			FROM conversations WHERE session_id = ? ORDER BY timestamp
+		`, sessionID)
 		if err != nil {
-			return err
+			yield(nil, err)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			conv := &Conversation{}
+			err := rows.Scan(
+				&conv.ID,
+				&conv.SessionID,
+				&conv.MessageType,
+				&conv.Content,
+				&conv.Timestamp,
+				&conv.Metadata,
+				&conv.TokenCount,
+				&conv.Model,
+			)
+			if err == nil {
+				conv.Content, err = b.openField(ctx, fieldConversationContent, conv.Content)
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(conv, nil) {
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			yield(nil, err)
 		}
 	}
-
-	return tx.Commit()
 }
 
-// GetConversationsBySession returns all conversations for a session
-func (b *PureGoSQLiteBackend) GetConversationsBySession(ctx context.Context, sessionID string) ([]*Conversation, error) {
-	query := `
-		SELECT id, session_id, message_type, content, timestamp, metadata, token_count, model
-		FROM conversations WHERE session_id = ? ORDER BY timestamp
-	`
-
-	rows, err := b.db.QueryContext(ctx, query, sessionID)
+// SearchConversations searches conversations by content, most relevant first
+func (b *PureGoSQLiteBackend) SearchConversations(ctx context.Context, query string, limit int) ([]*Conversation, error) {
+	hits, err := b.SearchConversationHits(ctx, &SearchOptions{Query: query, Limit: limit})
+	if errors.Is(err, ErrInvalidSearchQuery) {
+		// Queries without words, e.g. "++", can still be matched literally
+		return b.searchConversationsLike(ctx, query, limit)
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var conversations []*Conversation
-	for rows.Next() {
-		conv := &Conversation{}
-		err := rows.Scan(
-			&conv.ID,
-			&conv.SessionID,
-			&conv.MessageType,
-			&conv.Content,
-			&conv.Timestamp,
-			&conv.Metadata,
-			&conv.TokenCount,
-			&conv.Model,
-		)
+	conversations := make([]*Conversation, len(hits))
+	for i, hit := range hits {
+		conversations[i] = hit.Conversation
+	}
+	return conversations, nil
+}
+
+// searchConversationsLike searches conversations by substring
+func (b *PureGoSQLiteBackend) searchConversationsLike(ctx context.Context, query string, limit int) ([]*Conversation, error) {
+	if b.fieldCipher() != nil {
+		// LIKE can't see into encrypted content
+		conversations, err := b.decryptedConversations(ctx)
 		if err != nil {
 			return nil, err
 		}
-		conversations = append(conversations, conv)
+		return scanConversationsLike(conversations, query, limit), nil
 	}
 
-	return conversations, rows.Err()
-}
-
-// SearchConversations searches conversations by content
-func (b *PureGoSQLiteBackend) SearchConversations(ctx context.Context, query string, limit int) ([]*Conversation, error) {
-	// Simple text search - can be enhanced with FTS later
 	searchQuery := `
 		SELECT id, session_id, message_type, content, timestamp, metadata, token_count, model
-		FROM conversations
-		WHERE content LIKE ?
+		FROM conversations c
+		WHERE content LIKE ? AND ` + notTrashed + `
 		ORDER BY timestamp DESC
 		LIMIT ?
 	`
@@ -488,6 +646,9 @@ func (b *PureGoSQLiteBackend) SearchConversations(ctx context.Context, query str
 		if err != nil {
 			return nil, err
 		}
+		if conv.Content, err = b.openField(ctx, fieldConversationContent, conv.Content); err != nil {
+			return nil, err
+		}
 		conversations = append(conversations, conv)
 	}
 
@@ -516,6 +677,12 @@ func (b *PureGoSQLiteBackend) GetDatabaseStats(ctx context.Context) (*DatabaseSt
 		return nil, err
 	}
 
+	// Count tool invocations; databases created before tool capture
+	// existed have no table yet, which simply means no invocations
+	if err := b.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM tool_invocations").Scan(&stats.ToolCount); err != nil {
+		stats.ToolCount = 0
+	}
+
 	// Get oldest record - using string scanning for SQLite datetime compatibility
 	var oldestTimeStr sql.NullString
 	err = b.db.QueryRowContext(ctx, `
@@ -561,4 +728,212 @@ func (b *PureGoSQLiteBackend) GetDatabaseStats(ctx context.Context) (*DatabaseSt
 	}
 
 	return stats, nil
-}
\ No newline at end of file
+}
+
+// CreateToolInvocation records the start of a tool invocation
+func (b *PureGoSQLiteBackend) CreateToolInvocation(ctx context.Context, inv *ToolInvocation) error {
+	return b.writeTx(ctx, func(w *sqliteWriter) error {
+		return w.CreateToolInvocation(ctx, inv)
+	})
+}
+
+// UpdateToolInvocation records the result of a tool invocation
+func (b *PureGoSQLiteBackend) UpdateToolInvocation(ctx context.Context, inv *ToolInvocation) error {
+	return b.writeTx(ctx, func(w *sqliteWriter) error {
+		return w.UpdateToolInvocation(ctx, inv)
+	})
+}
+
+// GetRunningToolInvocation returns the most recent invocation with the given
+// tool use ID that has not received its result yet
+func (b *PureGoSQLiteBackend) GetRunningToolInvocation(ctx context.Context, sessionID, toolUseID string) (*ToolInvocation, error) {
+	query := `
+		SELECT id, session_id, tool_use_id, tool_name, input, output, output_truncated,
+			output_path, status, success, started_at, ended_at, duration_ms
+		FROM tool_invocations
+		WHERE session_id = ? AND tool_use_id = ? AND status = ?
+		ORDER BY started_at DESC LIMIT 1
+	`
+
+	inv, err := scanToolInvocation(b.db.QueryRowContext(ctx, query, sessionID, toolUseID, ToolStatusRunning))
+	if err == sql.ErrNoRows {
+		return nil, ErrToolInvocationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := b.openToolInvocation(ctx, inv); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// GetToolInvocation returns the invocation with the given tool use ID: the
+// most recent one still running, or else the most recent one
+func (b *PureGoSQLiteBackend) GetToolInvocation(ctx context.Context, sessionID, toolUseID string) (*ToolInvocation, error) {
+	return (&sqliteWriter{b: b}).GetToolInvocation(ctx, sessionID, toolUseID)
+}
+
+// GetToolInvocationsBySession returns all tool invocations for a session in call order
+func (b *PureGoSQLiteBackend) GetToolInvocationsBySession(ctx context.Context, sessionID string) ([]*ToolInvocation, error) {
+	query := `
+		SELECT id, session_id, tool_use_id, tool_name, input, output, output_truncated,
+			output_path, status, success, started_at, ended_at, duration_ms
+		FROM tool_invocations WHERE session_id = ? ORDER BY started_at
+	`
+
+	rows, err := b.db.QueryContext(ctx, query, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invocations []*ToolInvocation
+	for rows.Next() {
+		inv, err := scanToolInvocation(rows)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.openToolInvocation(ctx, inv); err != nil {
+			return nil, err
+		}
+		invocations = append(invocations, inv)
+	}
+
+	return invocations, rows.Err()
+}
+
+// GetToolUsageCounts returns the number of invocations per tool name.
+// An empty sessionID counts invocations across all sessions.
+func (b *PureGoSQLiteBackend) GetToolUsageCounts(ctx context.Context, sessionID string) (map[string]int, error) {
+	query := `SELECT tool_name, COUNT(*) FROM tool_invocations`
+	args := []interface{}{}
+	if sessionID != "" {
+		query += " WHERE session_id = ?"
+		args = append(args, sessionID)
+	}
+	query += " GROUP BY tool_name"
+
+	rows, err := b.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var name string
+		var count int
+		if err := rows.Scan(&name, &count); err != nil {
+			return nil, err
+		}
+		counts[name] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanToolInvocation scans a tool_invocations row
+func scanToolInvocation(row rowScanner) (*ToolInvocation, error) {
+	inv := &ToolInvocation{}
+	var toolUseID, input, output, outputPath, status sql.NullString
+	var endedAt sql.NullTime
+
+	err := row.Scan(
+		&inv.ID,
+		&inv.SessionID,
+		&toolUseID,
+		&inv.ToolName,
+		&input,
+		&output,
+		&inv.OutputTruncated,
+		&outputPath,
+		&status,
+		&inv.Success,
+		&inv.StartedAt,
+		&endedAt,
+		&inv.DurationMs,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	inv.ToolUseID = toolUseID.String
+	inv.Input = input.String
+	inv.Output = output.String
+	inv.OutputPath = outputPath.String
+	inv.Status = status.String
+	if endedAt.Valid {
+		inv.EndedAt = &endedAt.Time
+	}
+
+	return inv, nil
+}
+
+// GetTranscriptCursor returns the ingestion cursor for a session, or a zero
+// cursor when the session's transcript hasn't been ingested yet
+func (b *PureGoSQLiteBackend) GetTranscriptCursor(ctx context.Context, sessionID string) (*TranscriptCursor, error) {
+	return (&sqliteWriter{b: b}).GetTranscriptCursor(ctx, sessionID)
+}
+
+// SaveTranscriptCursor creates or updates the ingestion cursor for a session
+func (b *PureGoSQLiteBackend) SaveTranscriptCursor(ctx context.Context, cursor *TranscriptCursor) error {
+	return (&sqliteWriter{b: b}).SaveTranscriptCursor(ctx, cursor)
+}
+
+// RecordImport records an imported file, replacing an earlier record of it
+func (b *PureGoSQLiteBackend) RecordImport(ctx context.Context, record *ImportRecord) error {
+	return (&sqliteWriter{b: b}).RecordImport(ctx, record)
+}
+
+// IsFileImported reports whether a file was imported before
+func (b *PureGoSQLiteBackend) IsFileImported(ctx context.Context, filePath string) (bool, error) {
+	var exists bool
+	err := b.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM import_history WHERE file_path = ?)`, filePath).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up import history: %w", err)
+	}
+	return exists, nil
+}
+
+// ListImports returns the import history, most recent first
+func (b *PureGoSQLiteBackend) ListImports(ctx context.Context) ([]*ImportRecord, error) {
+	rows, err := b.db.QueryContext(ctx, `
+		SELECT file_path, imported_at, session_count, event_count, checksum
+		FROM import_history
+		ORDER BY imported_at DESC, id DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query import history: %w", err)
+	}
+	defer rows.Close()
+
+	var records []*ImportRecord
+	for rows.Next() {
+		record := &ImportRecord{}
+		var importedAt string
+		var checksum sql.NullString
+		if err := rows.Scan(&record.FilePath, &importedAt, &record.SessionCount, &record.EventCount, &checksum); err != nil {
+			return nil, fmt.Errorf("failed to scan import record: %w", err)
+		}
+		record.ImportedAt, _ = time.Parse(time.RFC3339, importedAt)
+		record.Checksum = checksum.String
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// IsUniqueViolation reports whether err was caused by a duplicate primary or unique key
+func IsUniqueViolation(err error) bool {
+	if err == nil {
+		return false
+	}
+	message := err.Error()
+	return strings.Contains(message, "UNIQUE constraint failed") ||
+		strings.Contains(message, "PRIMARY KEY constraint failed")
+}
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestBackend creates a pure Go SQLite backend with the schema in a temp directory
+func newTestBackend(t *testing.T) *PureGoSQLiteBackend {
+	t.Helper()
+
+	config := DefaultDatabaseConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+
+	backend := NewPureGoSQLiteBackend()
+	ctx := context.Background()
+	if err := backend.Initialize(ctx, config); err != nil {
+		t.Fatalf("Failed to initialize backend: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+
+	if err := backend.CreateSchema(ctx); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+
+	return backend
+}
+
+// createTestSession inserts a session for tests that need a parent row
+func createTestSession(t *testing.T, backend DatabaseBackend, id string) {
+	t.Helper()
+
+	now := time.Now()
+	session := &Session{ID: id, CreatedAt: now, UpdatedAt: now, Status: "active", Metadata: "{}"}
+	if err := backend.CreateSession(context.Background(), session); err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+}
+
+func TestGetSessionNotFound(t *testing.T) {
+	backend := newTestBackend(t)
+
+	_, err := backend.GetSession(context.Background(), "missing")
+	if !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound, got: %v", err)
+	}
+}
+
+func TestToolInvocations(t *testing.T) {
+	backend := newTestBackend(t)
+	ctx := context.Background()
+	createTestSession(t, backend, "session-1")
+
+	started := time.Now().Add(-2 * time.Second)
+	inv := &ToolInvocation{
+		ID:        "session-1_tool_1",
+		SessionID: "session-1",
+		ToolUseID: "toolu_01",
+		ToolName:  "Bash",
+		Input:     `{"command":"ls"}`,
+		Status:    ToolStatusRunning,
+		StartedAt: started,
+	}
+	if err := backend.CreateToolInvocation(ctx, inv); err != nil {
+		t.Fatalf("Failed to create tool invocation: %v", err)
+	}
+
+	running, err := backend.GetRunningToolInvocation(ctx, "session-1", "toolu_01")
+	if err != nil {
+		t.Fatalf("Failed to get running tool invocation: %v", err)
+	}
+	if running.ToolName != "Bash" || running.Input != `{"command":"ls"}` {
+		t.Errorf("Unexpected running invocation: %+v", running)
+	}
+
+	ended := time.Now()
+	running.Output = "file.txt"
+	running.Status = ToolStatusCompleted
+	running.Success = true
+	running.EndedAt = &ended
+	running.DurationMs = ended.Sub(started).Milliseconds()
+	if err := backend.UpdateToolInvocation(ctx, running); err != nil {
+		t.Fatalf("Failed to update tool invocation: %v", err)
+	}
+
+	// Completed invocations are no longer returned as running
+	if _, err := backend.GetRunningToolInvocation(ctx, "session-1", "toolu_01"); !errors.Is(err, ErrToolInvocationNotFound) {
+		t.Errorf("Expected ErrToolInvocationNotFound, got: %v", err)
+	}
+
+	second := &ToolInvocation{
+		ID:        "session-1_tool_2",
+		SessionID: "session-1",
+		ToolUseID: "toolu_02",
+		ToolName:  "Read",
+		Status:    ToolStatusFailed,
+		StartedAt: ended,
+	}
+	if err := backend.CreateToolInvocation(ctx, second); err != nil {
+		t.Fatalf("Failed to create second tool invocation: %v", err)
+	}
+
+	invocations, err := backend.GetToolInvocationsBySession(ctx, "session-1")
+	if err != nil {
+		t.Fatalf("Failed to list tool invocations: %v", err)
+	}
+	if len(invocations) != 2 {
+		t.Fatalf("Expected 2 tool invocations, got %d", len(invocations))
+	}
+	if invocations[0].ID != "session-1_tool_1" || !invocations[0].Success || invocations[0].EndedAt == nil {
+		t.Errorf("Unexpected first invocation: %+v", invocations[0])
+	}
+	if invocations[0].DurationMs < 2000 {
+		t.Errorf("Expected duration of at least 2000ms, got %d", invocations[0].DurationMs)
+	}
+
+	counts, err := backend.GetToolUsageCounts(ctx, "session-1")
+	if err != nil {
+		t.Fatalf("Failed to get tool usage counts: %v", err)
+	}
+	if counts["Bash"] != 1 || counts["Read"] != 1 {
+		t.Errorf("Unexpected tool usage counts: %v", counts)
+	}
+
+	stats, err := backend.GetDatabaseStats(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get database stats: %v", err)
+	}
+	if stats.ToolCount != 2 {
+		t.Errorf("Expected tool count 2, got %d", stats.ToolCount)
+	}
+}
+
+func TestPurgeSessionWithChildRows(t *testing.T) {
+	backend := newTestBackend(t)
+	ctx := context.Background()
+	createTestSession(t, backend, "session-1")
+
+	now := time.Now()
+	if err := backend.CreateEvent(ctx, &Event{ID: "event-1", SessionID: "session-1", EventType: "session_start", Timestamp: now, Data: "{}"}); err != nil {
+		t.Fatalf("Failed to create event: %v", err)
+	}
+	if err := backend.CreateConversation(ctx, &Conversation{ID: "conv-1", SessionID: "session-1", MessageType: "user", Content: "hello", Timestamp: now}); err != nil {
+		t.Fatalf("Failed to create conversation: %v", err)
+	}
+	if err := backend.CreateToolInvocation(ctx, &ToolInvocation{ID: "tool-1", SessionID: "session-1", ToolUseID: "toolu_01", ToolName: "Bash", Status: ToolStatusRunning, StartedAt: now}); err != nil {
+		t.Fatalf("Failed to create tool invocation: %v", err)
+	}
+
+	if err := backend.PurgeSession(ctx, "session-1"); err != nil {
+		t.Fatalf("Failed to purge session: %v", err)
+	}
+
+	if _, err := backend.GetSession(ctx, "session-1"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound, got: %v", err)
+	}
+	conversations, err := backend.GetConversationsBySession(ctx, "session-1")
+	if err != nil {
+		t.Fatalf("Failed to get conversations: %v", err)
+	}
+	if len(conversations) != 0 {
+		t.Errorf("Expected conversations to be deleted, got %d", len(conversations))
+	}
+
+	missing, err := backend.MissingTables(ctx)
+	if err != nil {
+		t.Fatalf("Failed to check tables: %v", err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("Expected no missing tables, got %v", missing)
+	}
+}
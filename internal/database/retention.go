@@ -0,0 +1,327 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// SessionFootprint describes a session for retention decisions
+type SessionFootprint struct {
+	ID           string
+	Project      string
+	Status       string
+	CreatedAt    time.Time
+	LastActivity time.Time // newest of the session, its events and conversations
+	Starred      bool
+	Tags         []string
+	Rows         int64 // events, conversations and tool invocations
+	Bytes        int64 // estimated size of the stored text
+}
+
+// PurgeResult reports what PurgeSessions deleted and reclaimed
+type PurgeResult struct {
+	Sessions    int
+	Rows        int64
+	BytesBefore int64
+	BytesAfter  int64
+}
+
+// Reclaimed returns how many bytes the database file shrank by
+func (r *PurgeResult) Reclaimed() int64 {
+	return max(r.BytesBefore-r.BytesAfter, 0)
+}
+
+// SessionFootprints returns every session outside the trash with its size
+// and last activity
+func (b *PureGoSQLiteBackend) SessionFootprints(ctx context.Context) ([]*SessionFootprint, error) {
+	tags, err := b.sessionTags(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := b.db.QueryContext(ctx, `
+		SELECT s.id, s.status, s.created_at, s.updated_at, s.metadata, s.starred,
+			(SELECT MAX(timestamp) FROM conversations WHERE session_id = s.id),
+			(SELECT MAX(timestamp) FROM events WHERE session_id = s.id),
+			(SELECT COUNT(*) FROM conversations WHERE session_id = s.id) +
+				(SELECT COUNT(*) FROM events WHERE session_id = s.id) +
+				(SELECT COUNT(*) FROM tool_invocations WHERE session_id = s.id),
+			COALESCE(length(s.metadata), 0) +
+				(SELECT COALESCE(SUM(length(content) + COALESCE(length(metadata), 0)), 0) FROM conversations WHERE session_id = s.id) +
+				(SELECT COALESCE(SUM(length(data)), 0) FROM events WHERE session_id = s.id) +
+				(SELECT COALESCE(SUM(COALESCE(length(input), 0) + COALESCE(length(output), 0)), 0) FROM tool_invocations WHERE session_id = s.id)
+		FROM sessions s
+		WHERE s.deleted_at IS NULL
+		ORDER BY s.created_at
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var footprints []*SessionFootprint
+	for rows.Next() {
+		footprint := &SessionFootprint{}
+		var status, metadata, lastConversation, lastEvent sql.NullString
+		var updatedAt time.Time
+		if err := rows.Scan(&footprint.ID, &status, &footprint.CreatedAt, &updatedAt, &metadata, &footprint.Starred,
+			&lastConversation, &lastEvent, &footprint.Rows, &footprint.Bytes); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+
+		plaintext, err := b.openField(ctx, fieldSessionMetadata, metadata.String)
+		if err != nil {
+			return nil, err
+		}
+		footprint.Project = sessionProject(plaintext)
+		footprint.Status = status.String
+		footprint.Tags = tags[footprint.ID]
+
+		footprint.LastActivity = footprint.CreatedAt
+		for _, candidate := range []time.Time{updatedAt, parseStoredTime(lastConversation), parseStoredTime(lastEvent)} {
+			if candidate.After(footprint.LastActivity) {
+				footprint.LastActivity = candidate
+			}
+		}
+		footprints = append(footprints, footprint)
+	}
+	return footprints, rows.Err()
+}
+
+// sessionTags returns the tags of every tagged session
+func (b *PureGoSQLiteBackend) sessionTags(ctx context.Context) (map[string][]string, error) {
+	rows, err := b.db.QueryContext(ctx, `SELECT session_id, tag FROM session_tags ORDER BY tag`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query session tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := make(map[string][]string)
+	for rows.Next() {
+		var sessionID, tag string
+		if err := rows.Scan(&sessionID, &tag); err != nil {
+			return nil, fmt.Errorf("failed to scan session tag: %w", err)
+		}
+		tags[sessionID] = append(tags[sessionID], tag)
+	}
+	return tags, rows.Err()
+}
+
+// parseStoredTime parses a timestamp SQL returned as text, e.g. from MAX().
+// Timestamps are stored in Go's time.String format, possibly with a
+// monotonic clock reading.
+func parseStoredTime(value sql.NullString) time.Time {
+	if !value.Valid {
+		return time.Time{}
+	}
+	text, _, _ := strings.Cut(value.String, " m=")
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999 -0700 MST", "2006-01-02 15:04:05", time.RFC3339Nano} {
+		if parsed, err := time.Parse(layout, text); err == nil {
+			return parsed
+		}
+	}
+	return time.Time{}
+}
+
+// SetSessionStarred stars or unstars a session
+func (b *PureGoSQLiteBackend) SetSessionStarred(ctx context.Context, id string, starred bool) error {
+	result, err := b.execWrite(ctx, `UPDATE sessions SET starred = ? WHERE id = ?`, starred, id)
+	if err != nil {
+		return fmt.Errorf("failed to star session: %w", err)
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// TagSession adds tags to a session
+func (b *PureGoSQLiteBackend) TagSession(ctx context.Context, id string, tags []string) error {
+	tx, err := b.BeginTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM sessions WHERE id = ?)`, id).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to look up session: %w", err)
+	}
+	if !exists {
+		return ErrSessionNotFound
+	}
+
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO session_tags (session_id, tag) VALUES (?, ?)`, id, tag); err != nil {
+			return fmt.Errorf("failed to tag session: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// UntagSession removes tags from a session
+func (b *PureGoSQLiteBackend) UntagSession(ctx context.Context, id string, tags []string) error {
+	for _, tag := range tags {
+		if _, err := b.execWrite(ctx, `DELETE FROM session_tags WHERE session_id = ? AND tag = ?`, id, tag); err != nil {
+			return fmt.Errorf("failed to untag session: %w", err)
+		}
+	}
+	return nil
+}
+
+// PurgeSessions deletes sessions with everything recorded for them,
+// including their spilled tool outputs, and returns the freed pages to the
+// file system
+func (b *PureGoSQLiteBackend) PurgeSessions(ctx context.Context, ids []string) (*PurgeResult, error) {
+	result := &PurgeResult{}
+	var err error
+	if result.BytesBefore, err = b.databaseSize(ctx); err != nil {
+		return nil, err
+	}
+
+	tx, err := b.BeginTransaction(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var spilled []string
+	sorted := append([]string(nil), ids...)
+	sort.Strings(sorted)
+	for _, id := range sorted {
+		rows, paths, err := deleteSessionRows(ctx, tx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete session %s: %w", id, err)
+		}
+		result.Sessions++
+		result.Rows += rows
+		spilled = append(spilled, paths...)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit purge: %w", err)
+	}
+	if err := removeSpilledOutputs(spilled); err != nil {
+		return result, fmt.Errorf("purged, but %w", err)
+	}
+
+	if err := b.reclaimSpace(ctx); err != nil {
+		return result, fmt.Errorf("purged, but failed to reclaim space: %w", err)
+	}
+	if result.BytesAfter, err = b.databaseSize(ctx); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// ToolOutputDir is the directory under the storage directory that full
+// tool outputs are spilled to, a subdirectory per session
+const ToolOutputDir = "tool-output"
+
+// TruncateToolOutput cuts output to at most max bytes, short of any UTF-8
+// character the cut would split
+func TruncateToolOutput(output string, max int) string {
+	if len(output) <= max {
+		return output
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(output[cut]) {
+		cut--
+	}
+	return output[:cut]
+}
+
+// removeSpilledOutputs removes the files full tool outputs were spilled
+// to, with the session directories holding them
+func removeSpilledOutputs(paths []string) error {
+	var failed []string
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			failed = append(failed, path)
+			continue
+		}
+		if dir := filepath.Dir(path); filepath.Base(filepath.Dir(dir)) == ToolOutputDir {
+			if err := os.RemoveAll(dir); err != nil {
+				failed = append(failed, dir)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to remove tool outputs: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// reclaimSpace runs an incremental vacuum. Databases created without
+// incremental auto-vacuum are switched to it, which takes one full VACUUM.
+func (b *PureGoSQLiteBackend) reclaimSpace(ctx context.Context) error {
+	return b.retryBusy(ctx, func() error {
+		// auto_vacuum only takes effect with the VACUUM on the same connection
+		conn, err := b.writeDB.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		var mode int
+		if err := conn.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&mode); err != nil {
+			return err
+		}
+
+		// Deleted rows stay in the search index until its segments are merged
+		if b.fieldCipher() == nil {
+			if _, err := conn.ExecContext(ctx, `INSERT INTO conversations_fts (conversations_fts) VALUES ('optimize')`); err != nil {
+				return fmt.Errorf("failed to optimize search index: %w", err)
+			}
+		}
+
+		if mode == 2 {
+			// incremental_vacuum frees a page per step, so read all its rows
+			rows, err := conn.QueryContext(ctx, "PRAGMA incremental_vacuum")
+			if err != nil {
+				return err
+			}
+			for rows.Next() {
+			}
+			if err := rows.Close(); err != nil {
+				return err
+			}
+		} else {
+			if _, err := conn.ExecContext(ctx, "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+				return err
+			}
+			if _, err := conn.ExecContext(ctx, "VACUUM"); err != nil {
+				return err
+			}
+			// VACUUM may renumber conversations, which the search index follows
+			// by rowid. Encrypted databases don't index content.
+			if b.fieldCipher() == nil {
+				if _, err := conn.ExecContext(ctx, rebuildSearchIndexSQL); err != nil {
+					return fmt.Errorf("failed to rebuild search index: %w", err)
+				}
+			}
+		}
+
+		_, err = conn.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)")
+		return err
+	})
+}
+
+// databaseSize returns the size of the database in bytes, including pages
+// still in the WAL
+func (b *PureGoSQLiteBackend) databaseSize(ctx context.Context) (int64, error) {
+	var pageCount, pageSize int64
+	if err := b.db.QueryRowContext(ctx, "PRAGMA page_count").Scan(&pageCount); err != nil {
+		return 0, fmt.Errorf("failed to read page count: %w", err)
+	}
+	if err := b.db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, fmt.Errorf("failed to read page size: %w", err)
+	}
+	return pageCount * pageSize, nil
+}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestSessionFootprints(t *testing.T) {
+	ctx := context.Background()
+	backend := newEncryptedTestBackend(t, t.TempDir(), t.TempDir())
+
+	started := time.Now().Add(-48 * time.Hour)
+	session := &Session{ID: "session-1", CreatedAt: started, UpdatedAt: started, Status: "completed", Metadata: `{"project":"api"}`}
+	if err := backend.CreateSession(ctx, session); err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	createTestConversation(t, backend, "session-1", "c1", "the latest activity")
+
+	if err := backend.SetSessionStarred(ctx, "session-1", true); err != nil {
+		t.Fatalf("Failed to star session: %v", err)
+	}
+	if err := backend.TagSession(ctx, "session-1", []string{"keep", "review", "keep"}); err != nil {
+		t.Fatalf("Failed to tag session: %v", err)
+	}
+	if err := backend.UntagSession(ctx, "session-1", []string{"review"}); err != nil {
+		t.Fatalf("Failed to untag session: %v", err)
+	}
+	if err := backend.SetSessionStarred(ctx, "missing", true); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+	if err := backend.TagSession(ctx, "missing", []string{"keep"}); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+
+	footprints, err := backend.SessionFootprints(ctx)
+	if err != nil || len(footprints) != 1 {
+		t.Fatalf("Expected one session, got %v (%v)", footprints, err)
+	}
+	footprint := footprints[0]
+	if footprint.Project != "api" || !footprint.Starred || strings.Join(footprint.Tags, ",") != "keep" {
+		t.Errorf("Unexpected footprint: %+v", footprint)
+	}
+	if footprint.Rows != 1 || footprint.Bytes == 0 {
+		t.Errorf("Expected the conversation to be counted, got %d rows and %d bytes", footprint.Rows, footprint.Bytes)
+	}
+	if time.Since(footprint.LastActivity) > time.Hour {
+		t.Errorf("Expected the conversation to be the last activity, got %v", footprint.LastActivity)
+	}
+}
+
+func TestPurgeSessions(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBackend(t)
+
+	padding := strings.Repeat("filler text to grow the database ", 2000)
+	for _, id := range []string{"old-1", "old-2", "kept"} {
+		createTestSession(t, backend, id)
+		createTestConversation(t, backend, id, id+"-c", id+" conversation "+padding)
+	}
+	backend.TagSession(ctx, "old-1", []string{"keep"})
+	outputPath := filepath.Join(t.TempDir(), ToolOutputDir, "old-2", "t.txt")
+	os.MkdirAll(filepath.Dir(outputPath), 0700)
+	if err := os.WriteFile(outputPath, []byte(padding), 0600); err != nil {
+		t.Fatalf("Failed to write tool output: %v", err)
+	}
+	if err := backend.CreateToolInvocation(ctx, &ToolInvocation{ID: "t", SessionID: "old-2", ToolName: "Bash", OutputTruncated: true, OutputPath: outputPath, StartedAt: time.Now()}); err != nil {
+		t.Fatalf("Failed to create tool invocation: %v", err)
+	}
+
+	result, err := backend.PurgeSessions(ctx, []string{"old-1", "old-2"})
+	if err != nil {
+		t.Fatalf("Failed to purge: %v", err)
+	}
+	if result.Sessions != 2 || result.Rows != 4 {
+		t.Errorf("Expected 2 sessions and 4 rows (with the tag and tool call) deleted, got %+v", result)
+	}
+	if _, err := os.Stat(filepath.Dir(outputPath)); !os.IsNotExist(err) {
+		t.Errorf("Expected the spilled tool output to be removed, got %v", err)
+	}
+	if result.Reclaimed() == 0 {
+		t.Errorf("Expected space to be reclaimed, got %+v", result)
+	}
+
+	var mode int
+	backend.db.QueryRow("PRAGMA auto_vacuum").Scan(&mode)
+	if mode != 2 {
+		t.Errorf("Expected incremental auto-vacuum, got %d", mode)
+	}
+	if count := countConversations(t, backend); count != 1 {
+		t.Errorf("Expected one conversation left, got %d", count)
+	}
+	// The search index follows the rowids VACUUM may have changed
+	hits, err := backend.SearchConversationHits(ctx, &SearchOptions{Query: "conversation"})
+	if err != nil || len(hits) != 1 || hits[0].Conversation.ID != "kept-c" {
+		t.Errorf("Expected the kept conversation to be found, got %v (%v)", hits, err)
+	}
+
+	// Once incremental, later purges don't rewrite the database
+	createTestSession(t, backend, "old-3")
+	createTestConversation(t, backend, "old-3", "old-3-c", padding)
+	if result, err := backend.PurgeSessions(ctx, []string{"old-3"}); err != nil || result.Reclaimed() == 0 {
+		t.Errorf("Expected the incremental vacuum to reclaim space, got %+v (%v)", result, err)
+	}
+}
+
+func TestTruncateToolOutput(t *testing.T) {
+	output := "ab€dé" // € is 3 bytes, é 2
+	for max, expected := range map[int]string{
+		0:  "",
+		2:  "ab",
+		3:  "ab",
+		4:  "ab",
+		5:  "ab€",
+		7:  "ab€d",
+		8:  "ab€dé",
+		50: "ab€dé",
+	} {
+		got := TruncateToolOutput(output, max)
+		if got != expected || !utf8.ValidString(got) {
+			t.Errorf("%d bytes: expected %q, got %q", max, expected, got)
+		}
+	}
+}
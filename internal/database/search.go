@@ -0,0 +1,409 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrInvalidSearchQuery is returned for search queries that can't be parsed
+var ErrInvalidSearchQuery = errors.New("invalid search query")
+
+// DefaultSnippetTokens is the approximate length of a search snippet in words
+const DefaultSnippetTokens = 24
+
+// Markers delimiting matches in highlight() and snippet() output. They are
+// turned into offsets and never reach callers.
+const (
+	matchStartMarker = "\x01"
+	matchEndMarker   = "\x02"
+)
+
+// SearchOptions describes a full-text search over conversation content.
+//
+// The query supports "quoted phrases", prefix* terms, AND, OR, NOT and
+// parentheses. Terms without an operator between them must all match.
+type SearchOptions struct {
+	Query         string
+	Limit         int
+	Offset        int
+	SessionID     string    // only search this session
+	Project       string    // only search sessions whose project contains this
+	From          time.Time // only messages at or after this time
+	To            time.Time // only messages before this time
+	SnippetTokens int       // defaults to DefaultSnippetTokens
+}
+
+// SearchMatch is the byte range of a match in a text
+type SearchMatch struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// SearchHit is a conversation message matching a search, best first
+type SearchHit struct {
+	Conversation   *Conversation `json:"conversation"`
+	Score          float64       `json:"score"`           // BM25 relevance, higher is better
+	Matches        []SearchMatch `json:"matches"`         // offsets into Conversation.Content
+	Snippet        string        `json:"snippet"`         // the part of the content around the best matches
+	SnippetMatches []SearchMatch `json:"snippet_matches"` // offsets into Snippet
+}
+
+// ParseSearchQuery translates a user search query into FTS5 query syntax.
+// Every term is quoted, so punctuation like "C++" or "foo-bar" is searched
+// for instead of being parsed as FTS5 operators.
+func ParseSearchQuery(query string) (string, error) {
+	var terms []string
+	depth := 0
+	expectTerm := true // an operator or ")" can't come next
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '(':
+			if !expectTerm {
+				terms = append(terms, "AND")
+			}
+			terms = append(terms, "(")
+			depth++
+			expectTerm = true
+			i++
+
+		case c == ')':
+			if depth == 0 || expectTerm {
+				return "", fmt.Errorf("%w: unbalanced parentheses", ErrInvalidSearchQuery)
+			}
+			terms = append(terms, ")")
+			depth--
+			i++
+
+		case c == '"':
+			end := strings.IndexByte(query[i+1:], '"')
+			if end < 0 {
+				return "", fmt.Errorf("%w: unterminated phrase", ErrInvalidSearchQuery)
+			}
+			phrase := query[i+1 : i+1+end]
+			i += end + 2
+
+			prefix := i < len(query) && query[i] == '*'
+			if prefix {
+				i++
+			}
+			if strings.TrimSpace(phrase) == "" {
+				continue
+			}
+			if !expectTerm {
+				terms = append(terms, "AND")
+			}
+			terms = append(terms, quoteSearchTerm(phrase, prefix))
+			expectTerm = false
+
+		default:
+			end := i
+			for end < len(query) && !strings.ContainsRune(" \t\r\n()\"", rune(query[end])) {
+				end++
+			}
+			word := query[i:end]
+			i = end
+
+			if word == "AND" || word == "OR" || word == "NOT" {
+				if expectTerm {
+					return "", fmt.Errorf("%w: %s needs a term on both sides", ErrInvalidSearchQuery, word)
+				}
+				terms = append(terms, word)
+				expectTerm = true
+				continue
+			}
+
+			prefix := strings.HasSuffix(word, "*")
+			word = strings.TrimRight(word, "*")
+			if !hasSearchableRune(word) {
+				continue
+			}
+			if !expectTerm {
+				terms = append(terms, "AND")
+			}
+			terms = append(terms, quoteSearchTerm(word, prefix))
+			expectTerm = false
+		}
+	}
+
+	if depth != 0 {
+		return "", fmt.Errorf("%w: unbalanced parentheses", ErrInvalidSearchQuery)
+	}
+	if len(terms) == 0 {
+		return "", fmt.Errorf("%w: nothing to search for", ErrInvalidSearchQuery)
+	}
+	if expectTerm {
+		return "", fmt.Errorf("%w: %s needs a term on both sides", ErrInvalidSearchQuery, terms[len(terms)-1])
+	}
+
+	return strings.Join(terms, " "), nil
+}
+
+// quoteSearchTerm quotes a term as an FTS5 string
+func quoteSearchTerm(term string, prefix bool) string {
+	quoted := `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	if prefix {
+		quoted += "*"
+	}
+	return quoted
+}
+
+// hasSearchableRune reports whether the tokenizer would find a token in s
+func hasSearchableRune(s string) bool {
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// extractMatches removes match markers from text and returns the plain
+// text with the byte offsets of the marked ranges
+func extractMatches(marked string) (string, []SearchMatch) {
+	var plain strings.Builder
+	plain.Grow(len(marked))
+
+	var matches []SearchMatch
+	start := -1
+	for i := 0; i < len(marked); i++ {
+		switch marked[i] {
+		case matchStartMarker[0]:
+			start = plain.Len()
+		case matchEndMarker[0]:
+			if start >= 0 {
+				matches = append(matches, SearchMatch{Start: start, End: plain.Len()})
+				start = -1
+			}
+		default:
+			plain.WriteByte(marked[i])
+		}
+	}
+	return plain.String(), matches
+}
+
+// searchIndexSchema creates the full-text index over conversation content.
+// It is an external content table: the text lives only in conversations and
+// the triggers keep the index in sync with it. The create_search_index
+// migration backfills it.
+const searchIndexSchema = `
+	CREATE VIRTUAL TABLE IF NOT EXISTS conversations_fts USING fts5(
+		content,
+		content = 'conversations',
+		content_rowid = 'rowid',
+		tokenize = 'unicode61 remove_diacritics 2'
+	);
+
+	CREATE TRIGGER IF NOT EXISTS conversations_fts_insert AFTER INSERT ON conversations BEGIN
+		INSERT INTO conversations_fts (rowid, content) VALUES (new.rowid, new.content);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS conversations_fts_delete AFTER DELETE ON conversations BEGIN
+		INSERT INTO conversations_fts (conversations_fts, rowid, content) VALUES ('delete', old.rowid, old.content);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS conversations_fts_update AFTER UPDATE OF content ON conversations BEGIN
+		INSERT INTO conversations_fts (conversations_fts, rowid, content) VALUES ('delete', old.rowid, old.content);
+		INSERT INTO conversations_fts (rowid, content) VALUES (new.rowid, new.content);
+	END;
+`
+
+// notTrashed is a condition on conversations aliased c that leaves out
+// conversations of sessions in the trash
+const notTrashed = "c.session_id NOT IN (SELECT id FROM sessions WHERE deleted_at IS NOT NULL)"
+
+// rebuildSearchIndexSQL rebuilds the full-text index from the conversations table
+const rebuildSearchIndexSQL = `INSERT INTO conversations_fts (conversations_fts) VALUES ('rebuild')`
+
+// RebuildSearchIndex rebuilds the full-text index from the conversations
+// table. It is needed when rows were copied in a way that changed their
+// rowids, e.g. by VACUUM.
+func (b *PureGoSQLiteBackend) RebuildSearchIndex(ctx context.Context) error {
+	if _, err := b.execWrite(ctx, rebuildSearchIndexSQL); err != nil {
+		return fmt.Errorf("failed to rebuild search index: %w", err)
+	}
+	return nil
+}
+
+// SearchConversationHits runs a ranked full-text search over conversation
+// content
+func (b *PureGoSQLiteBackend) SearchConversationHits(ctx context.Context, opts *SearchOptions) ([]*SearchHit, error) {
+	if b.fieldCipher() != nil {
+		return b.searchEncryptedConversations(ctx, opts)
+	}
+
+	match, err := ParseSearchQuery(opts.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	snippetTokens := opts.SnippetTokens
+	if snippetTokens <= 0 {
+		snippetTokens = DefaultSnippetTokens
+	}
+	// FTS5 caps snippets at 64 tokens
+	snippetTokens = min(snippetTokens, 64)
+
+	query := `
+		SELECT c.id, c.session_id, c.message_type, c.timestamp, c.metadata, c.token_count, c.model,
+			bm25(conversations_fts),
+			highlight(conversations_fts, 0, ?, ?),
+			snippet(conversations_fts, 0, ?, ?, '…', ?)
+		FROM conversations_fts
+		JOIN conversations c ON c.rowid = conversations_fts.rowid
+	`
+	args := []interface{}{
+		matchStartMarker, matchEndMarker,
+		matchStartMarker, matchEndMarker, snippetTokens,
+	}
+	conditions := []string{"conversations_fts MATCH ?", notTrashed}
+	args = append(args, match)
+
+	if opts.SessionID != "" {
+		conditions = append(conditions, "c.session_id = ?")
+		args = append(args, opts.SessionID)
+	}
+	if opts.Project != "" {
+		query += " JOIN sessions s ON s.id = c.session_id"
+		conditions = append(conditions, "LOWER(json_extract(s.metadata, '$.project')) LIKE ?")
+		args = append(args, "%"+strings.ToLower(opts.Project)+"%")
+	}
+	if !opts.From.IsZero() {
+		conditions = append(conditions, "c.timestamp >= ?")
+		args = append(args, opts.From)
+	}
+	if !opts.To.IsZero() {
+		conditions = append(conditions, "c.timestamp < ?")
+		args = append(args, opts.To)
+	}
+
+	query += " WHERE " + strings.Join(conditions, " AND ")
+	query += " ORDER BY bm25(conversations_fts), c.timestamp DESC"
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = -1 // no limit
+	}
+	query += " LIMIT ? OFFSET ?"
+	args = append(args, limit, max(opts.Offset, 0))
+
+	rows, err := b.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		if strings.Contains(err.Error(), "fts5") {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSearchQuery, err)
+		}
+		return nil, fmt.Errorf("failed to search conversations: %w", err)
+	}
+	defer rows.Close()
+
+	var hits []*SearchHit
+	for rows.Next() {
+		conv := &Conversation{}
+		var rank float64
+		var highlighted, snippet string
+		var metadata, model sql.NullString
+		var tokenCount sql.NullInt64
+		if err := rows.Scan(
+			&conv.ID,
+			&conv.SessionID,
+			&conv.MessageType,
+			&conv.Timestamp,
+			&metadata,
+			&tokenCount,
+			&model,
+			&rank,
+			&highlighted,
+			&snippet,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan search hit: %w", err)
+		}
+		conv.Metadata = metadata.String
+		conv.Model = model.String
+		conv.TokenCount = int(tokenCount.Int64)
+
+		hit := &SearchHit{Conversation: conv, Score: -rank}
+		conv.Content, hit.Matches = extractMatches(highlighted)
+		hit.Snippet, hit.SnippetMatches = extractMatches(snippet)
+		hits = append(hits, hit)
+	}
+
+	return hits, rows.Err()
+}
+
+// searchEncryptedConversations searches an encrypted database. The index
+// can't see encrypted content, so messages are decrypted and searched in
+// memory.
+func (b *PureGoSQLiteBackend) searchEncryptedConversations(ctx context.Context, opts *SearchOptions) ([]*SearchHit, error) {
+	if _, err := ParseSearchQuery(opts.Query); err != nil {
+		return nil, err
+	}
+
+	conversations, err := b.decryptedConversations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	projects := make(map[string]string)
+	if opts.Project != "" {
+		sessions, err := b.ListSessions(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, session := range sessions {
+			projects[session.ID] = sessionProject(session.Metadata)
+		}
+	}
+
+	return scanSearch(conversations, opts, func(sessionID string) string {
+		return projects[sessionID]
+	})
+}
+
+// decryptedConversations returns the conversations outside the trash with
+// their content decrypted, in insertion order
+func (b *PureGoSQLiteBackend) decryptedConversations(ctx context.Context) ([]*Conversation, error) {
+	rows, err := b.db.QueryContext(ctx, `
+		SELECT id, session_id, message_type, content, timestamp, metadata, token_count, model
+		FROM conversations c WHERE `+notTrashed+` ORDER BY rowid
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read conversations: %w", err)
+	}
+	defer rows.Close()
+
+	var conversations []*Conversation
+	for rows.Next() {
+		conv := &Conversation{}
+		var content, metadata, model sql.NullString
+		var tokenCount sql.NullInt64
+		if err := rows.Scan(
+			&conv.ID,
+			&conv.SessionID,
+			&conv.MessageType,
+			&content,
+			&conv.Timestamp,
+			&metadata,
+			&tokenCount,
+			&model,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan conversation: %w", err)
+		}
+		conv.Metadata = metadata.String
+		conv.Model = model.String
+		conv.TokenCount = int(tokenCount.Int64)
+		if conv.Content, err = b.openField(ctx, fieldConversationContent, content.String); err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, conv)
+	}
+	return conversations, rows.Err()
+}
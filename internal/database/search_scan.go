@@ -0,0 +1,526 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Full-text search over conversations in memory, for the in-memory backend
+// and encrypted databases whose index can't see the content. It mirrors the
+// FTS5 index: the unicode61 tokenizer with diacritics removed, the query
+// syntax ParseSearchQuery produces, bm25() ranking and the highlight() and
+// snippet() functions.
+
+// BM25 parameters, the FTS5 defaults
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// searchToken is a token of a searched text and its byte range
+type searchToken struct {
+	term       string
+	start, end int
+}
+
+// isTokenRune reports whether unicode61 treats r as part of a token
+func isTokenRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Co, r) || unicode.Is(unicode.Mn, r)
+}
+
+// foldSearchTerm lowercases a token and removes its diacritics
+func foldSearchTerm(token string) string {
+	var folded strings.Builder
+	for _, r := range norm.NFD.String(token) {
+		if !unicode.Is(unicode.Mn, r) {
+			folded.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return folded.String()
+}
+
+// tokenizeSearchText splits text into folded tokens
+func tokenizeSearchText(text string) []searchToken {
+	var tokens []searchToken
+	start := -1
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		if term := foldSearchTerm(text[start:end]); term != "" {
+			tokens = append(tokens, searchToken{term: term, start: start, end: end})
+		}
+		start = -1
+	}
+	for i, r := range text {
+		if isTokenRune(r) {
+			if start < 0 {
+				start = i
+			}
+		} else {
+			flush(i)
+		}
+	}
+	flush(len(text))
+	return tokens
+}
+
+// searchPhrase is a sequence of terms that must appear next to each other.
+// With prefix set, the last term matches any token it is a prefix of.
+type searchPhrase struct {
+	terms  []string
+	prefix bool
+}
+
+// searchDocument is a tokenized conversation message
+type searchDocument struct {
+	text   string
+	tokens []searchToken
+}
+
+func newSearchDocument(text string) *searchDocument {
+	return &searchDocument{text: text, tokens: tokenizeSearchText(text)}
+}
+
+// instances returns the token positions at which the phrase starts
+func (d *searchDocument) instances(phrase *searchPhrase) []int {
+	n := len(phrase.terms)
+	if n == 0 {
+		return nil
+	}
+
+	var positions []int
+	for p := 0; p+n <= len(d.tokens); p++ {
+		matched := true
+		for k, term := range phrase.terms {
+			token := d.tokens[p+k].term
+			if k == n-1 && phrase.prefix {
+				matched = strings.HasPrefix(token, term)
+			} else {
+				matched = token == term
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			positions = append(positions, p)
+		}
+	}
+	return positions
+}
+
+// tokenSpan is an inclusive range of token positions
+type tokenSpan struct {
+	first, last int
+}
+
+// matchSpans returns the phrase instances in the document in text order,
+// overlapping instances merged like highlight() does
+func (d *searchDocument) matchSpans(phrases []*searchPhrase) []tokenSpan {
+	var spans []tokenSpan
+	for _, phrase := range phrases {
+		for _, p := range d.instances(phrase) {
+			spans = append(spans, tokenSpan{first: p, last: p + len(phrase.terms) - 1})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		if spans[i].first != spans[j].first {
+			return spans[i].first < spans[j].first
+		}
+		return spans[i].last < spans[j].last
+	})
+
+	var merged []tokenSpan
+	for _, span := range spans {
+		if n := len(merged); n > 0 && span.first <= merged[n-1].last {
+			merged[n-1].last = max(merged[n-1].last, span.last)
+			continue
+		}
+		merged = append(merged, span)
+	}
+	return merged
+}
+
+// highlight returns the byte ranges of the spans
+func (d *searchDocument) highlight(spans []tokenSpan) []SearchMatch {
+	matches := make([]SearchMatch, 0, len(spans))
+	for _, span := range spans {
+		matches = append(matches, SearchMatch{Start: d.tokens[span.first].start, End: d.tokens[span.last].end})
+	}
+	return matches
+}
+
+// snippet returns a window of about size tokens around the most matches,
+// marked with "…" where the text was cut, and the match offsets in it
+func (d *searchDocument) snippet(spans []tokenSpan, size int) (string, []SearchMatch) {
+	if len(d.tokens) <= size {
+		return d.text, d.highlight(spans)
+	}
+
+	// Find the window covering the most matches, then centre them in it
+	best, bestCount := 0, -1
+	for start := 0; start+size <= len(d.tokens); start++ {
+		count := 0
+		for _, span := range spans {
+			if span.first >= start && span.last < start+size {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = start, count
+		}
+	}
+	first, last := -1, -1
+	for _, span := range spans {
+		if span.first >= best && span.last < best+size {
+			if first < 0 {
+				first = span.first
+			}
+			last = span.last
+		}
+	}
+	if first >= 0 {
+		best = first - (size-(last-first+1))/2
+	}
+	best = max(0, min(best, len(d.tokens)-size))
+	end := best + size
+
+	from, to := 0, len(d.text)
+	prefix, suffix := "", ""
+	if best > 0 {
+		from, prefix = d.tokens[best].start, "…"
+	}
+	if end < len(d.tokens) {
+		to, suffix = d.tokens[end-1].end, "…"
+	}
+
+	var matches []SearchMatch
+	for _, span := range spans {
+		if span.first >= best && span.last < end {
+			matches = append(matches, SearchMatch{
+				Start: len(prefix) + d.tokens[span.first].start - from,
+				End:   len(prefix) + d.tokens[span.last].end - from,
+			})
+		}
+	}
+	return prefix + d.text[from:to] + suffix, matches
+}
+
+// matchOp is the kind of a match expression node
+type matchOp int
+
+const (
+	matchPhrase matchOp = iota
+	matchAnd
+	matchOr
+	matchNot
+)
+
+// matchExpression is a parsed FTS5 query
+type matchExpression struct {
+	op          matchOp
+	phrase      *searchPhrase
+	left, right *matchExpression
+}
+
+// matches reports whether the document satisfies the expression
+func (e *matchExpression) matches(doc *searchDocument) bool {
+	switch e.op {
+	case matchAnd:
+		return e.left.matches(doc) && e.right.matches(doc)
+	case matchOr:
+		return e.left.matches(doc) || e.right.matches(doc)
+	case matchNot:
+		return e.left.matches(doc) && !e.right.matches(doc)
+	default:
+		return len(doc.instances(e.phrase)) > 0
+	}
+}
+
+// phrases appends the expression's phrases to acc
+func (e *matchExpression) phrases(acc []*searchPhrase) []*searchPhrase {
+	if e.op == matchPhrase {
+		return append(acc, e.phrase)
+	}
+	return e.right.phrases(e.left.phrases(acc))
+}
+
+// parseMatchExpression parses the FTS5 query syntax ParseSearchQuery
+// produces. NOT binds tighter than AND, which binds tighter than OR.
+func parseMatchExpression(match string) (*matchExpression, error) {
+	tokens, err := lexMatchExpression(match)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &matchParser{tokens: tokens}
+	expr, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos != len(tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidSearchQuery, tokens[parser.pos])
+	}
+	return expr, nil
+}
+
+// lexMatchExpression splits an FTS5 query into operators, parentheses and
+// quoted phrases
+func lexMatchExpression(match string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(match); {
+		switch c := match[i]; {
+		case c == ' ':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			end := i + 1
+			for {
+				next := strings.IndexByte(match[end:], '"')
+				if next < 0 {
+					return nil, fmt.Errorf("%w: unterminated phrase", ErrInvalidSearchQuery)
+				}
+				end += next + 1
+				if end < len(match) && match[end] == '"' {
+					end++ // escaped quote
+					continue
+				}
+				break
+			}
+			if end < len(match) && match[end] == '*' {
+				end++
+			}
+			tokens = append(tokens, match[i:end])
+			i = end
+		default:
+			end := i
+			for end < len(match) && match[end] != ' ' && match[end] != '(' && match[end] != ')' {
+				end++
+			}
+			tokens = append(tokens, match[i:end])
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+// matchParser is a recursive descent parser over lexed FTS5 query tokens
+type matchParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *matchParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *matchParser) parseOr() (*matchExpression, error) {
+	return p.parseBinary("OR", matchOr, p.parseAnd)
+}
+
+func (p *matchParser) parseAnd() (*matchExpression, error) {
+	return p.parseBinary("AND", matchAnd, p.parseNot)
+}
+
+func (p *matchParser) parseNot() (*matchExpression, error) {
+	return p.parseBinary("NOT", matchNot, p.parsePrimary)
+}
+
+// parseBinary parses a left-associative chain of one operator
+func (p *matchParser) parseBinary(keyword string, op matchOp, operand func() (*matchExpression, error)) (*matchExpression, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == keyword {
+		p.pos++
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &matchExpression{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *matchParser) parsePrimary() (*matchExpression, error) {
+	token := p.peek()
+	switch {
+	case token == "(":
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("%w: unbalanced parentheses", ErrInvalidSearchQuery)
+		}
+		p.pos++
+		return expr, nil
+
+	case strings.HasPrefix(token, `"`):
+		p.pos++
+		prefix := strings.HasSuffix(token, "*")
+		text := strings.TrimSuffix(token, "*")
+		text = strings.ReplaceAll(text[1:len(text)-1], `""`, `"`)
+
+		phrase := &searchPhrase{prefix: prefix}
+		for _, t := range tokenizeSearchText(text) {
+			phrase.terms = append(phrase.terms, t.term)
+		}
+		return &matchExpression{op: matchPhrase, phrase: phrase}, nil
+
+	case token == "":
+		return nil, fmt.Errorf("%w: unexpected end of query", ErrInvalidSearchQuery)
+
+	default:
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidSearchQuery, token)
+	}
+}
+
+// bm25Ranker scores documents like FTS5's bm25() with default weights
+type bm25Ranker struct {
+	phrases   []*searchPhrase
+	idf       []float64
+	avgDocLen float64
+}
+
+// newBM25Ranker computes the collection statistics over all documents
+func newBM25Ranker(docs []*searchDocument, phrases []*searchPhrase) *bm25Ranker {
+	ranker := &bm25Ranker{phrases: phrases, idf: make([]float64, len(phrases))}
+
+	total := 0
+	for _, doc := range docs {
+		total += len(doc.tokens)
+	}
+	if len(docs) > 0 {
+		ranker.avgDocLen = float64(total) / float64(len(docs))
+	}
+
+	n := float64(len(docs))
+	for i, phrase := range phrases {
+		containing := 0
+		for _, doc := range docs {
+			if len(doc.instances(phrase)) > 0 {
+				containing++
+			}
+		}
+		idf := math.Log((n - float64(containing) + 0.5) / (float64(containing) + 0.5))
+		if idf <= 0 {
+			idf = 1e-6
+		}
+		ranker.idf[i] = idf
+	}
+	return ranker
+}
+
+// score returns the relevance of a document, higher is better
+func (r *bm25Ranker) score(doc *searchDocument) float64 {
+	if r.avgDocLen == 0 {
+		return 0
+	}
+
+	score := 0.0
+	docLen := float64(len(doc.tokens))
+	for i, phrase := range r.phrases {
+		frequency := float64(len(doc.instances(phrase)))
+		score += r.idf[i] * (frequency * (bm25K1 + 1)) / (frequency + bm25K1*(1-bm25B+bm25B*docLen/r.avgDocLen))
+	}
+	return score
+}
+
+// scanSearch runs a search over conversations held in memory, the same way
+// SearchConversationHits does with the FTS5 index. projectOf returns the
+// project of a session for the Project filter.
+func scanSearch(conversations []*Conversation, opts *SearchOptions, projectOf func(sessionID string) string) ([]*SearchHit, error) {
+	match, err := ParseSearchQuery(opts.Query)
+	if err != nil {
+		return nil, err
+	}
+	expr, err := parseMatchExpression(match)
+	if err != nil {
+		return nil, err
+	}
+
+	snippetTokens := opts.SnippetTokens
+	if snippetTokens <= 0 {
+		snippetTokens = DefaultSnippetTokens
+	}
+	snippetTokens = min(snippetTokens, 64)
+
+	// Relevance statistics cover the whole index, not just filtered rows
+	docs := make([]*searchDocument, len(conversations))
+	for i, conv := range conversations {
+		docs[i] = newSearchDocument(conv.Content)
+	}
+	phrases := expr.phrases(nil)
+	ranker := newBM25Ranker(docs, phrases)
+	project := strings.ToLower(opts.Project)
+
+	var hits []*SearchHit
+	for i, conv := range conversations {
+		if opts.SessionID != "" && conv.SessionID != opts.SessionID {
+			continue
+		}
+		if project != "" && !strings.Contains(strings.ToLower(projectOf(conv.SessionID)), project) {
+			continue
+		}
+		if !opts.From.IsZero() && conv.Timestamp.Before(opts.From) {
+			continue
+		}
+		if !opts.To.IsZero() && !conv.Timestamp.Before(opts.To) {
+			continue
+		}
+
+		doc := docs[i]
+		if !expr.matches(doc) {
+			continue
+		}
+
+		hit := &SearchHit{Conversation: conv, Score: ranker.score(doc)}
+		spans := doc.matchSpans(phrases)
+		hit.Matches = doc.highlight(spans)
+		hit.Snippet, hit.SnippetMatches = doc.snippet(spans, snippetTokens)
+		hits = append(hits, hit)
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Conversation.Timestamp.After(hits[j].Conversation.Timestamp)
+	})
+	return paginate(hits, opts.Limit, opts.Offset), nil
+}
+
+// scanConversationsLike returns the conversations containing query,
+// ignoring case, newest first
+func scanConversationsLike(conversations []*Conversation, query string, limit int) []*Conversation {
+	needle := strings.ToLower(query)
+	var found []*Conversation
+	for _, conv := range conversations {
+		if strings.Contains(strings.ToLower(conv.Content), needle) {
+			found = append(found, conv)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].Timestamp.After(found[j].Timestamp)
+	})
+
+	// Like SQL's LIMIT, a negative limit means no limit
+	if limit >= 0 && limit < len(found) {
+		found = found[:limit]
+	}
+	return found
+}
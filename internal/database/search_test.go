@@ -0,0 +1,163 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := map[string]string{
+		`error handling`:             `"error" AND "handling"`,
+		`"error handling"`:           `"error handling"`,
+		`handl*`:                     `"handl"*`,
+		`sqlite OR postgres`:         `"sqlite" OR "postgres"`,
+		`cache NOT redis`:            `"cache" NOT "redis"`,
+		`(go OR rust) wasm`:          `( "go" OR "rust" ) AND "wasm"`,
+		`C++ foo-bar`:                `"C++" AND "foo-bar"`,
+		`say "it's ""fine"""`:        `"say" AND "it's " AND "fine"`,
+		`  spaces   ++  everywhere `: `"spaces" AND "everywhere"`,
+	}
+	for query, expected := range tests {
+		got, err := ParseSearchQuery(query)
+		if err != nil {
+			t.Errorf("ParseSearchQuery(%q) failed: %v", query, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("ParseSearchQuery(%q) = %s, expected %s", query, got, expected)
+		}
+	}
+
+	for _, query := range []string{"", "++", "NOT cache", "cache OR", "(cache", "cache)", `"open`} {
+		if _, err := ParseSearchQuery(query); !errors.Is(err, ErrInvalidSearchQuery) {
+			t.Errorf("ParseSearchQuery(%q): expected ErrInvalidSearchQuery, got %v", query, err)
+		}
+	}
+}
+
+// createTestConversation inserts a conversation message for search tests
+func createTestConversation(t *testing.T, backend DatabaseBackend, sessionID, id, content string) {
+	t.Helper()
+
+	conv := &Conversation{ID: id, SessionID: sessionID, MessageType: "user", Content: content, Timestamp: time.Now(), Metadata: "{}"}
+	if err := backend.CreateConversation(context.Background(), conv); err != nil {
+		t.Fatalf("Failed to create conversation: %v", err)
+	}
+}
+
+func TestSearchConversationHits(t *testing.T) {
+	backend := newTestBackend(t)
+	ctx := context.Background()
+	createTestSession(t, backend, "session-1")
+
+	createTestConversation(t, backend, "session-1", "c1", "The database migration failed with a locking error")
+	createTestConversation(t, backend, "session-1", "c2", "Database, database, database: the migration guide")
+	createTestConversation(t, backend, "session-1", "c3", "Café au lait")
+	createTestConversation(t, backend, "session-1", "c4", "Nothing relevant here")
+
+	hits, err := backend.SearchConversationHits(ctx, &SearchOptions{Query: "database"})
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	if len(hits) != 2 {
+		t.Fatalf("Expected 2 hits, got %d", len(hits))
+	}
+	if hits[0].Conversation.ID != "c2" || hits[0].Score <= hits[1].Score {
+		t.Errorf("Expected the message mentioning database most to rank first, got %s (%f, %f)", hits[0].Conversation.ID, hits[0].Score, hits[1].Score)
+	}
+
+	// Offsets point at the matches in the original content
+	first := hits[1]
+	if len(first.Matches) != 1 {
+		t.Fatalf("Expected 1 match, got %v", first.Matches)
+	}
+	match := first.Matches[0]
+	if got := first.Conversation.Content[match.Start:match.End]; got != "database" {
+		t.Errorf("Expected match offsets to cover 'database', got %q", got)
+	}
+	if first.Conversation.Content != "The database migration failed with a locking error" {
+		t.Errorf("Expected the content without markers, got %q", first.Conversation.Content)
+	}
+	if len(first.SnippetMatches) != 1 || first.Snippet[first.SnippetMatches[0].Start:first.SnippetMatches[0].End] != "database" {
+		t.Errorf("Unexpected snippet matches: %q %v", first.Snippet, first.SnippetMatches)
+	}
+
+	// Phrases, prefixes, boolean operators and accent folding
+	for query, expected := range map[string]int{
+		`"migration failed"`:      1,
+		`migr*`:                   2,
+		`locking OR guide`:        2,
+		`database NOT locking`:    1,
+		`cafe`:                    1,
+		`DATABASE migration`:      2,
+		`(locking OR guide) lait`: 0,
+	} {
+		hits, err := backend.SearchConversationHits(ctx, &SearchOptions{Query: query})
+		if err != nil {
+			t.Errorf("Search %q failed: %v", query, err)
+			continue
+		}
+		if len(hits) != expected {
+			t.Errorf("Search %q: expected %d hits, got %d", query, expected, len(hits))
+		}
+	}
+
+	// The index follows updates and deletes
+	if _, err := backend.ExecuteExec(ctx, `UPDATE conversations SET content = 'rewritten' WHERE id = 'c1'`); err != nil {
+		t.Fatalf("Failed to update conversation: %v", err)
+	}
+	if hits, _ := backend.SearchConversationHits(ctx, &SearchOptions{Query: "locking"}); len(hits) != 0 {
+		t.Errorf("Expected updated content to leave the index, got %d hits", len(hits))
+	}
+	if err := backend.PurgeSession(ctx, "session-1"); err != nil {
+		t.Fatalf("Failed to purge session: %v", err)
+	}
+	if hits, _ := backend.SearchConversationHits(ctx, &SearchOptions{Query: "database"}); len(hits) != 0 {
+		t.Errorf("Expected deleted conversations to leave the index, got %d hits", len(hits))
+	}
+}
+
+func TestSearchIndexBackfill(t *testing.T) {
+	backend := newTestBackend(t)
+	ctx := context.Background()
+	createTestSession(t, backend, "session-1")
+
+	// Simulate a database created before the search index existed
+	for _, statement := range []string{
+		"DROP TRIGGER conversations_fts_insert",
+		"DROP TRIGGER conversations_fts_delete",
+		"DROP TRIGGER conversations_fts_update",
+		"DROP TABLE conversations_fts",
+		"DELETE FROM schema_migrations WHERE name = 'create_search_index'",
+	} {
+		if _, err := backend.ExecuteExec(ctx, statement); err != nil {
+			t.Fatalf("Failed to drop search index: %v", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		createTestConversation(t, backend, "session-1", fmt.Sprintf("c%d", i), "legacy message")
+	}
+
+	if err := backend.CreateSchema(ctx); err != nil {
+		t.Fatalf("Failed to migrate schema: %v", err)
+	}
+
+	hits, err := backend.SearchConversationHits(ctx, &SearchOptions{Query: "legacy", Limit: 2})
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	if len(hits) != 2 {
+		t.Errorf("Expected backfilled rows to be found (limited to 2), got %d", len(hits))
+	}
+
+	// Running the migration again doesn't index rows twice
+	if err := backend.CreateSchema(ctx); err != nil {
+		t.Fatalf("Failed to rerun schema: %v", err)
+	}
+	if hits, _ := backend.SearchConversationHits(ctx, &SearchOptions{Query: "legacy"}); len(hits) != 3 {
+		t.Errorf("Expected 3 hits, got %d", len(hits))
+	}
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// nextEventSequence moves a session's sequence counter and returns the
+// number of the event being inserted: sequence when the caller chose one,
+// the next number otherwise. It runs in the insert's transaction, so
+// concurrent hook processes never hand out the same number.
+func nextEventSequence(ctx context.Context, tx *sql.Tx, sessionID string, sequence int) (int, error) {
+	if sequence > 0 {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO session_sequences (session_id, last_sequence) VALUES (?, ?)
+			ON CONFLICT(session_id) DO UPDATE SET last_sequence = MAX(last_sequence, excluded.last_sequence)
+		`, sessionID, sequence)
+		return sequence, err
+	}
+
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO session_sequences (session_id, last_sequence) VALUES (?, 1)
+		ON CONFLICT(session_id) DO UPDATE SET last_sequence = last_sequence + 1
+		RETURNING last_sequence
+	`, sessionID).Scan(&sequence)
+	return sequence, err
+}
+
+// RenumberEvents numbers the events of a session, or of all sessions when
+// sessionID is empty, by timestamp starting at 1, and resets the sessions'
+// counters. It returns how many events got a new number.
+func (b *PureGoSQLiteBackend) RenumberEvents(ctx context.Context, sessionID string) (int, error) {
+	tx, err := b.BeginTransaction(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Numbers are unique per session while rows are updated one by one,
+	// so they go through negative values to swap places
+	result, err := tx.ExecContext(ctx, `
+		UPDATE events SET sequence_num = -numbered.seq
+		FROM (
+			SELECT id, ROW_NUMBER() OVER (PARTITION BY session_id ORDER BY timestamp, sequence_num, id) AS seq
+			FROM events WHERE ?1 = '' OR session_id = ?1
+		) AS numbered
+		WHERE events.id = numbered.id AND events.sequence_num IS NOT numbered.seq
+	`, sessionID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to renumber events: %w", err)
+	}
+	renumbered, _ := result.RowsAffected()
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE events SET sequence_num = -sequence_num
+		WHERE sequence_num < 0 AND (?1 = '' OR session_id = ?1)
+	`, sessionID); err != nil {
+		return 0, fmt.Errorf("failed to renumber events: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO session_sequences (session_id, last_sequence)
+		SELECT session_id, MAX(sequence_num) FROM events
+		WHERE (?1 = '' OR session_id = ?1) AND session_id IN (SELECT id FROM sessions)
+		GROUP BY session_id
+		ON CONFLICT(session_id) DO UPDATE SET last_sequence = excluded.last_sequence
+	`, sessionID); err != nil {
+		return 0, fmt.Errorf("failed to reset sequence counters: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return int(renumbered), nil
+}
@@ -0,0 +1,388 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidCursor is returned for cursors that don't continue the listing
+// they are used with
+var ErrInvalidCursor = errors.New("invalid session cursor")
+
+// SessionPage is one page of a session listing
+type SessionPage struct {
+	Sessions []*Session `json:"sessions"`
+	// NextCursor continues the listing after this page in
+	// SessionFilters.Cursor, empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// sessionSortColumns are the columns sessions can be sorted by
+var sessionSortColumns = map[string]bool{
+	"id":            true,
+	"created_at":    true,
+	"updated_at":    true,
+	"status":        true,
+	"message_count": true,
+	"token_count":   true,
+}
+
+// sessionCursor is the position after the last session of a page: its sort
+// value and ID as they were when the page was read. Capture keeps changing
+// the counts and times sessions are sorted by, so the next page continues
+// from these rather than the session's current values.
+type sessionCursor struct {
+	SortBy string          `json:"sort"`
+	Desc   bool            `json:"desc,omitempty"`
+	Value  json.RawMessage `json:"value"`
+	ID     string          `json:"id"`
+}
+
+// newSessionCursor returns the cursor after session in a listing sorted by
+// column
+func newSessionCursor(session *Session, column string, desc bool) *sessionCursor {
+	value, _ := json.Marshal(sessionSortValue(session, column))
+	return &sessionCursor{SortBy: column, Desc: desc, Value: value, ID: session.ID}
+}
+
+// session returns a session holding only the cursor's ID and sort value
+func (c *sessionCursor) session() (*Session, error) {
+	session := &Session{ID: c.ID}
+	var err error
+	switch c.SortBy {
+	case "id":
+	case "created_at":
+		err = json.Unmarshal(c.Value, &session.CreatedAt)
+	case "updated_at":
+		err = json.Unmarshal(c.Value, &session.UpdatedAt)
+	case "status":
+		err = json.Unmarshal(c.Value, &session.Status)
+	case "message_count":
+		err = json.Unmarshal(c.Value, &session.MessageCount)
+	case "token_count":
+		err = json.Unmarshal(c.Value, &session.TokenCount)
+	}
+	return session, err
+}
+
+// sessionSortValue returns the value of a session a listing is sorted by
+func sessionSortValue(session *Session, column string) interface{} {
+	switch column {
+	case "created_at":
+		return session.CreatedAt
+	case "updated_at":
+		return session.UpdatedAt
+	case "status":
+		return session.Status
+	case "message_count":
+		return session.MessageCount
+	case "token_count":
+		return session.TokenCount
+	}
+	return session.ID
+}
+
+// encode returns the cursor as an opaque token
+func (c *sessionCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// sessionOrder is how a listing is sorted and where it continues
+type sessionOrder struct {
+	column string
+	desc   bool
+	after  *Session // the cursor's position, nil for the first page
+}
+
+// newSessionOrder validates the sort of filters and decodes its cursor.
+// Listings are sorted by creation time unless told otherwise, and by ID
+// among equal values.
+func newSessionOrder(filters *SessionFilters) (*sessionOrder, error) {
+	order := &sessionOrder{column: "created_at", desc: strings.EqualFold(filters.SortOrder, "DESC")}
+	if filters.SortBy != "" {
+		if !sessionSortColumns[filters.SortBy] {
+			return nil, fmt.Errorf("cannot sort sessions by %q", filters.SortBy)
+		}
+		order.column = filters.SortBy
+	}
+
+	if filters.Cursor != "" {
+		data, err := base64.RawURLEncoding.DecodeString(filters.Cursor)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		var cursor sessionCursor
+		if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" {
+			return nil, ErrInvalidCursor
+		}
+		if cursor.SortBy != order.column || cursor.Desc != order.desc {
+			return nil, fmt.Errorf("%w: it continues a listing sorted by %s", ErrInvalidCursor, cursor.SortBy)
+		}
+		if order.after, err = cursor.session(); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return order, nil
+}
+
+// page cuts sessions, fetched with one more than the limit, into a page
+func (o *sessionOrder) page(sessions []*Session, limit int) *SessionPage {
+	page := &SessionPage{Sessions: sessions}
+	if limit > 0 && len(sessions) > limit {
+		page.Sessions = sessions[:limit]
+		page.NextCursor = newSessionCursor(sessions[limit-1], o.column, o.desc).encode()
+	}
+	return page
+}
+
+// underDir reports whether path is dir or inside it
+func underDir(path, dir string) bool {
+	if path == "" {
+		return false
+	}
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// sessionWorkingDir returns the working directory in session metadata
+func sessionWorkingDir(metadata string) string {
+	var fields struct {
+		WorkingDirectory string `json:"working_directory"`
+	}
+	json.Unmarshal([]byte(metadata), &fields)
+	return fields.WorkingDirectory
+}
+
+// ListSessions returns sessions based on filters
+func (b *PureGoSQLiteBackend) ListSessions(ctx context.Context, filters *SessionFilters) ([]*Session, error) {
+	page, err := b.ListSessionPage(ctx, filters)
+	if err != nil {
+		return nil, err
+	}
+	return page.Sessions, nil
+}
+
+// ListSessionPage returns a page of the sessions matching filters. All
+// filters and the sort run in SQL, so a page costs the same however many
+// sessions there are.
+func (b *PureGoSQLiteBackend) ListSessionPage(ctx context.Context, filters *SessionFilters) (*SessionPage, error) {
+	if filters == nil {
+		filters = &SessionFilters{}
+	}
+	order, err := newSessionOrder(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions, args, err := b.sessionConditions(ctx, filters)
+	if err != nil {
+		return nil, err
+	}
+	if order.after != nil {
+		comparison := ">"
+		if order.desc {
+			comparison = "<"
+		}
+		conditions = append(conditions, fmt.Sprintf("(s.%s, s.id) %s (?, ?)", order.column, comparison))
+		args = append(args, sessionSortValue(order.after, order.column), order.after.ID)
+	}
+
+	query := `SELECT s.id, s.created_at, s.updated_at, s.status, s.metadata, s.deleted_at, s.message_count, s.token_count FROM sessions s`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	direction := ""
+	if order.desc {
+		direction = " DESC"
+	}
+	query += fmt.Sprintf(" ORDER BY s.%s%s, s.id%s", order.column, direction, direction)
+
+	// One more than asked for tells whether there is a next page
+	limit := -1
+	if filters.Limit > 0 {
+		limit = filters.Limit + 1
+	}
+	query += " LIMIT ? OFFSET ?"
+	args = append(args, limit, max(filters.Offset, 0))
+
+	rows, err := b.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions, err := b.scanSessions(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+	return order.page(sessions, filters.Limit), nil
+}
+
+// IterSessions yields the sessions matching filters, reading them from the
+// database a page at a time
+func (b *PureGoSQLiteBackend) IterSessions(ctx context.Context, filters *SessionFilters) iter.Seq2[*Session, error] {
+	return iterSessionPages(ctx, b.ListSessionPage, filters)
+}
+
+// sessionConditions translates filters into SQL conditions on sessions
+// aliased s
+func (b *PureGoSQLiteBackend) sessionConditions(ctx context.Context, filters *SessionFilters) ([]string, []interface{}, error) {
+	var conditions []string
+	var args []interface{}
+	fieldCipher := b.fieldCipher()
+
+	if !filters.IncludeDeleted {
+		conditions = append(conditions, "s.deleted_at IS NULL")
+	}
+	if filters.Status != "" {
+		conditions = append(conditions, "s.status = ?")
+		args = append(args, filters.Status)
+	}
+	if len(filters.Statuses) > 0 {
+		conditions = append(conditions, "s.status IN (?"+strings.Repeat(", ?", len(filters.Statuses)-1)+")")
+		for _, status := range filters.Statuses {
+			args = append(args, status)
+		}
+	}
+	if filters.Project != "" {
+		// Encrypted metadata can only be matched through its blind
+		// index, under any key version a rekey hasn't replaced yet
+		if fieldCipher != nil {
+			indexes := fieldCipher.BlindIndexes(indexSessionProject, filters.Project)
+			conditions = append(conditions, "s.project_bidx IN (?"+strings.Repeat(", ?", len(indexes)-1)+")")
+			for _, index := range indexes {
+				args = append(args, index)
+			}
+		} else {
+			conditions = append(conditions, "json_valid(s.metadata) AND LOWER(json_extract(s.metadata, '$.project')) = LOWER(?)")
+			args = append(args, filters.Project)
+		}
+	}
+	if filters.WorkingDir != "" {
+		if fieldCipher != nil {
+			ids, err := b.sessionsUnderDir(ctx, filters.WorkingDir)
+			if err != nil {
+				return nil, nil, err
+			}
+			conditions = append(conditions, "s.id IN (SELECT value FROM json_each(?))")
+			args = append(args, ids)
+		} else {
+			dir := filepath.Clean(filters.WorkingDir)
+			escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.TrimSuffix(dir, string(filepath.Separator)))
+			conditions = append(conditions, `json_valid(s.metadata) AND (json_extract(s.metadata, '$.working_directory') = ? OR json_extract(s.metadata, '$.working_directory') LIKE ? ESCAPE '\')`)
+			args = append(args, dir, escaped+string(filepath.Separator)+"%")
+		}
+	}
+	if filters.Model != "" {
+		conditions = append(conditions, "s.id IN (SELECT session_id FROM conversations WHERE model = ?)")
+		args = append(args, filters.Model)
+	}
+	if filters.Text != "" {
+		if fieldCipher != nil {
+			ids, err := b.sessionsMatching(ctx, filters.Text)
+			if err != nil {
+				return nil, nil, err
+			}
+			conditions = append(conditions, "s.id IN (SELECT value FROM json_each(?))")
+			args = append(args, ids)
+		} else {
+			match, err := ParseSearchQuery(filters.Text)
+			if err != nil {
+				return nil, nil, err
+			}
+			conditions = append(conditions, `s.id IN (
+				SELECT c.session_id FROM conversations_fts
+				JOIN conversations c ON c.rowid = conversations_fts.rowid
+				WHERE conversations_fts MATCH ? AND `+notTrashed+`)`)
+			args = append(args, match)
+		}
+	}
+	if filters.MinMessages > 0 {
+		conditions = append(conditions, "s.message_count >= ?")
+		args = append(args, filters.MinMessages)
+	}
+	if filters.MaxMessages > 0 {
+		conditions = append(conditions, "s.message_count <= ?")
+		args = append(args, filters.MaxMessages)
+	}
+	if filters.MinTokens > 0 {
+		conditions = append(conditions, "s.token_count >= ?")
+		args = append(args, filters.MinTokens)
+	}
+	if filters.MaxTokens > 0 {
+		conditions = append(conditions, "s.token_count <= ?")
+		args = append(args, filters.MaxTokens)
+	}
+	if filters.CreatedAfter != nil {
+		conditions = append(conditions, "s.created_at > ?")
+		args = append(args, filters.CreatedAfter)
+	}
+	if filters.CreatedBefore != nil {
+		conditions = append(conditions, "s.created_at < ?")
+		args = append(args, filters.CreatedBefore)
+	}
+	return conditions, args, nil
+}
+
+// sessionsUnderDir returns, as a JSON array, the sessions of an encrypted
+// database whose working directory is dir or inside it. SQL can't see
+// into encrypted metadata.
+func (b *PureGoSQLiteBackend) sessionsUnderDir(ctx context.Context, dir string) (string, error) {
+	rows, err := b.db.QueryContext(ctx, `SELECT id, metadata FROM sessions`)
+	if err != nil {
+		return "", fmt.Errorf("failed to read sessions: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		var metadata sql.NullString
+		if err := rows.Scan(&id, &metadata); err != nil {
+			return "", fmt.Errorf("failed to scan session: %w", err)
+		}
+		plaintext, err := b.openField(ctx, fieldSessionMetadata, metadata.String)
+		if err != nil {
+			return "", err
+		}
+		if underDir(sessionWorkingDir(plaintext), dir) {
+			ids = append(ids, id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	data, _ := json.Marshal(ids)
+	return string(data), nil
+}
+
+// sessionsMatching returns, as a JSON array, the sessions of an encrypted
+// database with a message matching a search query
+func (b *PureGoSQLiteBackend) sessionsMatching(ctx context.Context, query string) (string, error) {
+	conversations, err := b.decryptedConversations(ctx)
+	if err != nil {
+		return "", err
+	}
+	hits, err := scanSearch(conversations, &SearchOptions{Query: query}, func(string) string { return "" })
+	if err != nil {
+		return "", err
+	}
+
+	ids := []string{}
+	seen := make(map[string]bool)
+	for _, hit := range hits {
+		if !seen[hit.Conversation.SessionID] {
+			seen[hit.Conversation.SessionID] = true
+			ids = append(ids, hit.Conversation.SessionID)
+		}
+	}
+	data, _ := json.Marshal(ids)
+	return string(data), nil
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// readSetting decodes the JSON value of a settings row into value and
+// reports whether the row exists
+func readSetting(ctx context.Context, q queryer, key string, value interface{}) (bool, error) {
+	exists, err := tableExists(ctx, q, "settings")
+	if err != nil || !exists {
+		return false, err
+	}
+
+	var raw string
+	err = q.QueryRowContext(ctx, `SELECT value FROM settings WHERE key = ?`, key).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read setting %s: %w", key, err)
+	}
+	if err := json.Unmarshal([]byte(raw), value); err != nil {
+		return false, fmt.Errorf("failed to parse setting %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// writeSetting stores value as JSON in a settings row, nil removes the row
+func writeSetting(ctx context.Context, q queryer, key string, value interface{}) error {
+	if value == nil {
+		if _, err := q.ExecContext(ctx, `DELETE FROM settings WHERE key = ?`, key); err != nil {
+			return fmt.Errorf("failed to clear setting %s: %w", key, err)
+		}
+		return nil
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal setting %s: %w", key, err)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err = q.ExecContext(ctx, `
+		INSERT INTO settings (key, value, created_at, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at
+	`, key, string(raw), now, now)
+	if err != nil {
+		return fmt.Errorf("failed to write setting %s: %w", key, err)
+	}
+	return nil
+}
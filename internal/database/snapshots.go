@@ -0,0 +1,603 @@
+package database
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// Backups taken on request or on schedule are named backup-<time>.db, with
+// .gz appended when compressed and .enc when sealed under the passphrase.
+// They are kept in BackupDir next to the pre-migration backups.
+
+// snapshotPrefix starts the file names of backups
+const snapshotPrefix = "backup-"
+
+// snapshotTimeFormat is the time in backup file names
+const snapshotTimeFormat = "20060102-150405.000"
+
+// restoreBackupPrefix starts the file names of the copies taken before a
+// restore
+const restoreBackupPrefix = "pre-restore-"
+
+// backupScheduleSetting is the settings key of the backup schedule
+const backupScheduleSetting = "backup_schedule"
+
+// scheduleLockName is the lock file of scheduled backups
+const scheduleLockName = ".backup.lock"
+
+// staleScheduleLockAge is how old a schedule lock must be before it is
+// considered left behind by a crashed process
+const staleScheduleLockAge = time.Hour
+
+// ErrBackupTooNew is returned when restoring a backup taken by a newer
+// version
+var ErrBackupTooNew = errors.New("backup is newer than this version supports")
+
+// BackupOptions controls how a backup is taken and which old ones are pruned
+type BackupOptions struct {
+	Dir      string        `json:"dir,omitempty"`     // defaults to BackupDir of the database
+	Compress bool          `json:"compress"`          // gzip the snapshot
+	Encrypt  bool          `json:"encrypt"`           // seal the snapshot under the passphrase
+	Keep     int           `json:"keep,omitempty"`    // backups to keep, 0 keeps all
+	MaxAge   time.Duration `json:"max_age,omitempty"` // prune backups older than this, 0 keeps all
+}
+
+// BackupSchedule makes capture take a backup once the newest is older
+// than Interval
+type BackupSchedule struct {
+	BackupOptions
+	Interval time.Duration `json:"interval"`
+}
+
+// BackupFile describes a backup on disk
+type BackupFile struct {
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	CreatedAt  time.Time `json:"created_at"`
+	Compressed bool      `json:"compressed"`
+	Encrypted  bool      `json:"encrypted"`
+}
+
+// BackupResult describes a backup that was taken
+type BackupResult struct {
+	Backup        *BackupFile `json:"backup"`
+	SchemaVersion int         `json:"schema_version"`
+	Pruned        []string    `json:"pruned,omitempty"`
+}
+
+// RestoreResult describes a restored backup
+type RestoreResult struct {
+	SchemaVersion int    `json:"schema_version"`
+	SafetyBackup  string `json:"safety_backup"` // copy of the database as it was before
+}
+
+// onlineRestorer is implemented by modernc.org/sqlite connections
+type onlineRestorer interface {
+	NewRestore(srcUri string) (*sqlite.Backup, error)
+}
+
+// CreateBackup takes a consistent snapshot of the live database, checks its
+// integrity, stores it compressed or sealed as asked and prunes old backups
+func (b *PureGoSQLiteBackend) CreateBackup(ctx context.Context, opts *BackupOptions) (*BackupResult, error) {
+	if b.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	if opts == nil {
+		opts = &BackupOptions{}
+	}
+	dir := opts.Dir
+	if dir == "" {
+		dir = b.backupDir()
+	}
+	if dir == "" {
+		return nil, fmt.Errorf("in-memory databases can't be backed up")
+	}
+	return createSnapshot(ctx, b.db, dir, opts)
+}
+
+// createSnapshot writes a backup of db to dir
+func createSnapshot(ctx context.Context, db *sql.DB, dir string, opts *BackupOptions) (*BackupResult, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	// Resolve the passphrase before copying anything
+	var passphrase string
+	if opts.Encrypt {
+		var err error
+		if passphrase, err = ResolvePassphrase("Backup passphrase: "); err != nil {
+			return nil, err
+		}
+	}
+
+	snapshotPath, err := tempPath(dir, ".snapshot-*.db")
+	if err != nil {
+		return nil, err
+	}
+	defer removeDatabaseFiles(snapshotPath)
+
+	if err := BackupDatabase(ctx, db, snapshotPath); err != nil {
+		return nil, err
+	}
+	version, _, err := verifySnapshot(ctx, snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+
+	createdAt := time.Now()
+	name := snapshotPrefix + createdAt.Format(snapshotTimeFormat) + ".db"
+	if opts.Compress {
+		name += ".gz"
+	}
+	if opts.Encrypt {
+		name += ".enc"
+	}
+	backupPath := filepath.Join(dir, name)
+	if err := encodeSnapshot(snapshotPath, backupPath, opts.Compress, opts.Encrypt, passphrase); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(backupPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat backup: %w", err)
+	}
+	result := &BackupResult{
+		Backup:        &BackupFile{Path: backupPath, Size: info.Size(), CreatedAt: createdAt, Compressed: opts.Compress, Encrypted: opts.Encrypt},
+		SchemaVersion: version,
+	}
+	if result.Pruned, err = PruneBackups(dir, opts.Keep, opts.MaxAge); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// encodeSnapshot writes a snapshot to backupPath, compressing it before
+// sealing it. The backup only appears once it is complete.
+func encodeSnapshot(snapshotPath, backupPath string, compress, encrypt bool, passphrase string) error {
+	src, err := os.Open(snapshotPath)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer src.Close()
+
+	tmpPath, err := tempPath(filepath.Dir(backupPath), ".backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	dst, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create backup: %w", err)
+	}
+	defer dst.Close()
+
+	var w io.Writer = dst
+	var closers []io.Closer
+	if encrypt {
+		sw, err := newSealWriter(w, passphrase)
+		if err != nil {
+			return err
+		}
+		w, closers = sw, append(closers, sw)
+	}
+	if compress {
+		zw := gzip.NewWriter(w)
+		w, closers = zw, append(closers, zw)
+	}
+
+	if _, err := io.Copy(w, src); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	// The innermost writer closes first so it flushes into the next
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].Close(); err != nil {
+			return fmt.Errorf("failed to write backup: %w", err)
+		}
+	}
+	if err := dst.Sync(); err != nil {
+		return fmt.Errorf("failed to sync backup: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := os.Rename(tmpPath, backupPath); err != nil {
+		return fmt.Errorf("failed to save backup: %w", err)
+	}
+	return nil
+}
+
+// decodeSnapshot unseals and decompresses a backup into a database file.
+// The format is recognized from the content, so plain database files such
+// as pre-migration backups work too.
+func decodeSnapshot(backupPath, snapshotPath string) error {
+	src, err := os.Open(backupPath)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer src.Close()
+
+	br := bufio.NewReader(src)
+	if isSealedBackup(br) {
+		passphrase, err := ResolvePassphrase(fmt.Sprintf("Passphrase for %s: ", filepath.Base(backupPath)))
+		if err != nil {
+			return err
+		}
+		or, err := newOpenReader(br, passphrase)
+		if err != nil {
+			return err
+		}
+		br = bufio.NewReader(or)
+	}
+
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrBackupCorrupt, err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	dst, err := os.OpenFile(snapshotPath, os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, r); err != nil {
+		if errors.Is(err, ErrWrongPassphrase) {
+			forgetPassphrase()
+			return err
+		}
+		if errors.Is(err, ErrBackupCorrupt) {
+			return err
+		}
+		return fmt.Errorf("%w: %v", ErrBackupCorrupt, err)
+	}
+	return dst.Close()
+}
+
+// verifySnapshot runs an integrity check on a database file and returns
+// its schema version and encryption state
+func verifySnapshot(ctx context.Context, path string) (int, *FieldEncryptionState, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrBackupCorrupt, err)
+	}
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			rows.Close()
+			return 0, nil, fmt.Errorf("failed to check integrity: %w", err)
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrBackupCorrupt, err)
+	}
+	if len(problems) > 0 {
+		return 0, nil, fmt.Errorf("%w: %s", ErrBackupCorrupt, strings.Join(problems, "; "))
+	}
+
+	version, err := schemaVersion(ctx, db)
+	if err != nil {
+		return 0, nil, err
+	}
+	state, err := readFieldEncryption(ctx, db)
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, state, nil
+}
+
+// checkSnapshotKeys makes sure the key ring can decrypt an encrypted
+// snapshot, which it can't once a rekey retired the key versions it uses
+func checkSnapshotKeys(ctx context.Context, path, keyDir string, state *FieldEncryptionState) error {
+	ring, err := loadFieldKeyRing(keyDir, false)
+	if err != nil {
+		return err
+	}
+	if _, ok := ring.Keys[state.keyVersion()]; !ok {
+		return fmt.Errorf("%w: the backup uses key version %d, which is no longer in the key ring", ErrWrongEncryptionKey, state.keyVersion())
+	}
+	fieldCipher, err := NewFieldCipherFromRing(ring)
+	if err != nil {
+		return err
+	}
+	if !fieldCipher.matchesState(state) {
+		return ErrWrongEncryptionKey
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer db.Close()
+	for version := 1; version < state.keyVersion(); version++ {
+		if _, ok := ring.Keys[version]; ok {
+			continue
+		}
+		count, err := countKeyVersionValues(ctx, db, version)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: %d values in the backup use retired key version %d", ErrWrongEncryptionKey, count, version)
+		}
+	}
+	return nil
+}
+
+// RestoreBackup replaces the database with a backup. The backup is
+// decoded and verified first and the database is copied aside. The
+// contents are then swapped in with SQLite's backup API, in one
+// transaction under the write lock, because processes may have the
+// database open and replacing the file under them would corrupt it.
+// Writers meanwhile wait or spool their events.
+func (b *PureGoSQLiteBackend) RestoreBackup(ctx context.Context, backupPath string) (*RestoreResult, error) {
+	if b.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	dir := b.backupDir()
+	if dir == "" {
+		return nil, fmt.Errorf("in-memory databases can't be restored")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	snapshotPath, err := tempPath(dir, ".restore-*.db")
+	if err != nil {
+		return nil, err
+	}
+	defer removeDatabaseFiles(snapshotPath)
+
+	if err := decodeSnapshot(backupPath, snapshotPath); err != nil {
+		return nil, err
+	}
+	version, state, err := verifySnapshot(ctx, snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	if version > SchemaVersion {
+		return nil, fmt.Errorf("%w: schema version %d, this version supports up to %d", ErrBackupTooNew, version, SchemaVersion)
+	}
+	if state != nil {
+		if err := checkSnapshotKeys(ctx, snapshotPath, b.keyDir(), state); err != nil {
+			return nil, err
+		}
+	}
+
+	safetyPath := filepath.Join(dir, restoreBackupPrefix+time.Now().Format(snapshotTimeFormat)+".db")
+	if err := BackupDatabase(ctx, b.db, safetyPath); err != nil {
+		os.Remove(safetyPath)
+		return nil, fmt.Errorf("failed to back up database before restoring: %w", err)
+	}
+	keepNewestFiles(filepath.Join(dir, restoreBackupPrefix+"*.db"), MaxMigrationBackups)
+
+	err = b.retryBusy(ctx, func() error {
+		return restoreDatabase(ctx, b.writeDB, snapshotPath)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := b.reloadFieldCipher(ctx, b.db); err != nil {
+		return nil, err
+	}
+	return &RestoreResult{SchemaVersion: version, SafetyBackup: safetyPath}, nil
+}
+
+// restoreDatabase copies a database file over the live database
+func restoreDatabase(ctx context.Context, db *sql.DB, srcPath string) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		restorer, ok := driverConn.(onlineRestorer)
+		if !ok {
+			return fmt.Errorf("database driver doesn't support online restores")
+		}
+
+		restore, err := restorer.NewRestore(srcPath)
+		if err != nil {
+			return fmt.Errorf("failed to start restore: %w", err)
+		}
+		// All pages in one step, so readers see either database but never a mix
+		if _, err := restore.Step(-1); err != nil {
+			restore.Finish()
+			return fmt.Errorf("failed to restore database: %w", err)
+		}
+		if err := restore.Finish(); err != nil {
+			return fmt.Errorf("failed to finish restore: %w", err)
+		}
+		return nil
+	})
+}
+
+// ListBackups returns the backups in dir, newest first
+func ListBackups(dir string) ([]*BackupFile, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, snapshotPrefix+"*"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var backups []*BackupFile
+	for _, match := range matches {
+		name := strings.TrimPrefix(filepath.Base(match), snapshotPrefix)
+		stamp, _, _ := strings.Cut(name, ".db")
+		createdAt, err := time.ParseInLocation(snapshotTimeFormat, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(match)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, &BackupFile{
+			Path:       match,
+			Size:       info.Size(),
+			CreatedAt:  createdAt,
+			Compressed: strings.Contains(name, ".gz"),
+			Encrypted:  strings.HasSuffix(name, ".enc"),
+		})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+	return backups, nil
+}
+
+// PruneBackups removes the backups in dir beyond the newest keep and those
+// older than maxAge, zero disabling either. The newest backup is always
+// kept.
+func PruneBackups(dir string, keep int, maxAge time.Duration) ([]string, error) {
+	backups, err := ListBackups(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []string
+	for i, backup := range backups {
+		if i == 0 {
+			continue
+		}
+		if (keep > 0 && i >= keep) || (maxAge > 0 && time.Since(backup.CreatedAt) > maxAge) {
+			if err := os.Remove(backup.Path); err != nil && !os.IsNotExist(err) {
+				return pruned, fmt.Errorf("failed to remove backup: %w", err)
+			}
+			pruned = append(pruned, backup.Path)
+		}
+	}
+	return pruned, nil
+}
+
+// BackupSchedule returns the backup schedule, nil when there is none
+func (b *PureGoSQLiteBackend) BackupSchedule(ctx context.Context) (*BackupSchedule, error) {
+	if b.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	var schedule BackupSchedule
+	found, err := readSetting(ctx, b.db, backupScheduleSetting, &schedule)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// SetBackupSchedule stores the backup schedule, nil removes it
+func (b *PureGoSQLiteBackend) SetBackupSchedule(ctx context.Context, schedule *BackupSchedule) error {
+	if b.db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	if schedule != nil && schedule.Interval <= 0 {
+		return fmt.Errorf("backup interval must be positive")
+	}
+	return b.retryBusy(ctx, func() error {
+		if schedule == nil {
+			return writeSetting(ctx, b.writeDB, backupScheduleSetting, nil)
+		}
+		return writeSetting(ctx, b.writeDB, backupScheduleSetting, schedule)
+	})
+}
+
+// ScheduledBackup takes a backup when the schedule says one is due. It
+// returns nil when none is due or another process is taking it.
+func (b *PureGoSQLiteBackend) ScheduledBackup(ctx context.Context) (*BackupResult, error) {
+	schedule, err := b.BackupSchedule(ctx)
+	if err != nil || schedule == nil {
+		return nil, err
+	}
+	dir := schedule.Dir
+	if dir == "" {
+		dir = b.backupDir()
+	}
+	if dir == "" || !backupDue(dir, schedule.Interval) {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	unlock, ok := lockSchedule(dir)
+	if !ok {
+		return nil, nil
+	}
+	defer unlock()
+	// Another process may have finished one while we waited for the lock
+	if !backupDue(dir, schedule.Interval) {
+		return nil, nil
+	}
+	return createSnapshot(ctx, b.db, dir, &schedule.BackupOptions)
+}
+
+// backupDue reports whether the newest backup in dir is older than interval
+func backupDue(dir string, interval time.Duration) bool {
+	backups, err := ListBackups(dir)
+	if err != nil {
+		return false
+	}
+	return len(backups) == 0 || time.Since(backups[0].CreatedAt) >= interval
+}
+
+// lockSchedule takes the scheduled backup lock, breaking locks left behind
+// by crashed processes
+func lockSchedule(dir string) (func(), bool) {
+	path := filepath.Join(dir, scheduleLockName)
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintf(file, "%d", os.Getpid())
+			file.Close()
+			return func() { os.Remove(path) }, true
+		}
+
+		info, err := os.Stat(path)
+		if err != nil || time.Since(info.ModTime()) < staleScheduleLockAge {
+			return nil, false
+		}
+		os.Remove(path)
+	}
+	return nil, false
+}
+
+// tempPath creates an empty temporary file in dir and returns its path
+func tempPath(dir, pattern string) (string, error) {
+	file, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	file.Close()
+	return file.Name(), nil
+}
+
+// removeDatabaseFiles removes a database file and its journals
+func removeDatabaseFiles(path string) {
+	for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+		os.Remove(path + suffix)
+	}
+}
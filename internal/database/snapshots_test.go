@@ -0,0 +1,243 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// countConversations counts the conversations in a backend's database
+func countConversations(t *testing.T, backend *PureGoSQLiteBackend) int {
+	t.Helper()
+
+	var count int
+	if err := backend.db.QueryRow("SELECT COUNT(*) FROM conversations").Scan(&count); err != nil {
+		t.Fatalf("Failed to count conversations: %v", err)
+	}
+	return count
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	useFastKDF(t)
+	SetPassphrase("correct horse")
+	ctx := context.Background()
+
+	for _, opts := range []BackupOptions{{}, {Compress: true}, {Compress: true, Encrypt: true}} {
+		backend := newTestBackend(t)
+		createTestSession(t, backend, "session-1")
+		createTestConversation(t, backend, "session-1", "c1", "kept in the backup")
+
+		result, err := backend.CreateBackup(ctx, &opts)
+		if err != nil {
+			t.Fatalf("Failed to back up with %+v: %v", opts, err)
+		}
+		if result.SchemaVersion != SchemaVersion || result.Backup.Compressed != opts.Compress || result.Backup.Encrypted != opts.Encrypt {
+			t.Errorf("Unexpected backup result: %+v", result.Backup)
+		}
+		backups, err := ListBackups(backend.backupDir())
+		if err != nil || len(backups) != 1 || backups[0].Path != result.Backup.Path {
+			t.Fatalf("Expected the backup to be listed, got %v (%v)", backups, err)
+		}
+
+		createTestConversation(t, backend, "session-1", "c2", "written after the backup")
+		restored, err := backend.RestoreBackup(ctx, result.Backup.Path)
+		if err != nil {
+			t.Fatalf("Failed to restore %+v: %v", opts, err)
+		}
+		if count := countConversations(t, backend); count != 1 {
+			t.Errorf("Expected the backup's conversation only, got %d", count)
+		}
+		hits, err := backend.SearchConversationHits(ctx, &SearchOptions{Query: "backup"})
+		if err != nil || len(hits) != 1 || hits[0].Conversation.ID != "c1" {
+			t.Errorf("Expected the restored search index to match, got %v (%v)", hits, err)
+		}
+
+		// The database as it was is kept aside
+		if _, err := os.Stat(restored.SafetyBackup); err != nil {
+			t.Errorf("Expected a safety backup: %v", err)
+		}
+		if _, err := backend.RestoreBackup(ctx, restored.SafetyBackup); err != nil || countConversations(t, backend) != 2 {
+			t.Errorf("Expected the safety backup to restore both conversations (%v)", err)
+		}
+	}
+}
+
+func TestRestoreRejectsBadBackups(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBackend(t)
+	createTestSession(t, backend, "session-1")
+
+	junk := filepath.Join(t.TempDir(), "junk.db")
+	os.WriteFile(junk, []byte("not a database"), 0600)
+	if _, err := backend.RestoreBackup(ctx, junk); !errors.Is(err, ErrBackupCorrupt) {
+		t.Errorf("Expected ErrBackupCorrupt, got %v", err)
+	}
+
+	// A backup taken by a newer version
+	result, err := backend.CreateBackup(ctx, nil)
+	if err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+	newer := NewPureGoSQLiteBackend()
+	config := DefaultDatabaseConfig()
+	config.DatabasePath = result.Backup.Path
+	if err := newer.Initialize(ctx, config); err != nil {
+		t.Fatalf("Failed to open backup: %v", err)
+	}
+	_, err = newer.writeDB.Exec("INSERT INTO schema_migrations (version, name, applied_at, checksum) VALUES (?, 'future', datetime('now'), '')", SchemaVersion+1)
+	newer.Close()
+	if err != nil {
+		t.Fatalf("Failed to record a future migration: %v", err)
+	}
+	if _, err := backend.RestoreBackup(ctx, result.Backup.Path); !errors.Is(err, ErrBackupTooNew) {
+		t.Errorf("Expected ErrBackupTooNew, got %v", err)
+	}
+
+	var sessions int
+	backend.db.QueryRow("SELECT COUNT(*) FROM sessions").Scan(&sessions)
+	if sessions != 1 {
+		t.Errorf("Expected failed restores to leave the database alone, got %d sessions", sessions)
+	}
+}
+
+func TestSealedBackupStream(t *testing.T) {
+	useFastKDF(t)
+	plaintext := make([]byte, 3*sealedChunkSize+100)
+	rand.Read(plaintext)
+
+	var sealed bytes.Buffer
+	sw, err := newSealWriter(&sealed, "secret")
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	sw.Write(plaintext[:1000])
+	sw.Write(plaintext[1000:])
+	if err := sw.Close(); err != nil {
+		t.Fatalf("Failed to seal: %v", err)
+	}
+
+	open := func(data []byte, passphrase string) ([]byte, error) {
+		r := bufio.NewReader(bytes.NewReader(data))
+		if !isSealedBackup(r) {
+			t.Fatal("Expected a sealed backup")
+		}
+		or, err := newOpenReader(r, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(or)
+	}
+
+	if opened, err := open(sealed.Bytes(), "secret"); err != nil || !bytes.Equal(opened, plaintext) {
+		t.Fatalf("Expected the plaintext back (%v)", err)
+	}
+	if _, err := open(sealed.Bytes(), "wrong"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Expected ErrWrongPassphrase, got %v", err)
+	}
+
+	// Dropping whole chunks off the end must not go unnoticed
+	chunk := 4 + sealedChunkSize + 16
+	if _, err := open(sealed.Bytes()[:sealed.Len()-(4+100+16)], "secret"); !errors.Is(err, ErrBackupCorrupt) {
+		t.Errorf("Expected a truncated backup to fail, got %v", err)
+	}
+	tampered := bytes.Clone(sealed.Bytes())
+	tampered[len(tampered)-chunk] ^= 1
+	if _, err := open(tampered, "secret"); !errors.Is(err, ErrBackupCorrupt) {
+		t.Errorf("Expected a tampered backup to fail, got %v", err)
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for _, age := range []time.Duration{0, time.Hour, 48 * time.Hour, 72 * time.Hour} {
+		name := snapshotPrefix + now.Add(-age).Format(snapshotTimeFormat) + ".db.gz"
+		os.WriteFile(filepath.Join(dir, name), []byte("x"), 0600)
+	}
+	os.WriteFile(filepath.Join(dir, "pre-migrate-v1-to-v2-20200101-000000.000.db"), []byte("x"), 0600)
+
+	pruned, err := PruneBackups(dir, 3, 0)
+	if err != nil || len(pruned) != 1 {
+		t.Fatalf("Expected the oldest backup to be pruned, got %v (%v)", pruned, err)
+	}
+	pruned, err = PruneBackups(dir, 0, 24*time.Hour)
+	if err != nil || len(pruned) != 1 {
+		t.Fatalf("Expected the backup older than a day to be pruned, got %v (%v)", pruned, err)
+	}
+	// The newest backup stays however old it is
+	pruned, _ = PruneBackups(dir, 0, time.Nanosecond)
+	backups, _ := ListBackups(dir)
+	if len(pruned) != 1 || len(backups) != 1 || !backups[0].Compressed {
+		t.Errorf("Expected only the newest backup to be left, got %v", backups)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pre-migrate-v1-to-v2-20200101-000000.000.db")); err != nil {
+		t.Error("Expected pre-migration backups to be left alone")
+	}
+}
+
+func TestScheduledBackup(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBackend(t)
+
+	if result, err := backend.ScheduledBackup(ctx); err != nil || result != nil {
+		t.Fatalf("Expected no backup without a schedule, got %v (%v)", result, err)
+	}
+	if err := backend.SetBackupSchedule(ctx, &BackupSchedule{Interval: time.Hour, BackupOptions: BackupOptions{Compress: true}}); err != nil {
+		t.Fatalf("Failed to set schedule: %v", err)
+	}
+	if schedule, err := backend.BackupSchedule(ctx); err != nil || schedule.Interval != time.Hour || !schedule.Compress {
+		t.Fatalf("Expected the schedule back, got %+v (%v)", schedule, err)
+	}
+
+	result, err := backend.ScheduledBackup(ctx)
+	if err != nil || result == nil || !result.Backup.Compressed {
+		t.Fatalf("Expected a scheduled backup, got %v (%v)", result, err)
+	}
+	if result, err := backend.ScheduledBackup(ctx); err != nil || result != nil {
+		t.Errorf("Expected no backup while the last one is recent, got %v (%v)", result, err)
+	}
+
+	// Another process holding the lock takes the backup instead
+	backend.SetBackupSchedule(ctx, &BackupSchedule{Interval: time.Nanosecond})
+	os.WriteFile(filepath.Join(backend.backupDir(), scheduleLockName), nil, 0600)
+	if result, err := backend.ScheduledBackup(ctx); err != nil || result != nil {
+		t.Errorf("Expected no backup while locked, got %v (%v)", result, err)
+	}
+
+	if err := backend.SetBackupSchedule(ctx, nil); err != nil {
+		t.Fatalf("Failed to remove schedule: %v", err)
+	}
+	if schedule, err := backend.BackupSchedule(ctx); err != nil || schedule != nil {
+		t.Errorf("Expected no schedule, got %+v (%v)", schedule, err)
+	}
+}
+
+func TestRestoreNeedsBackupKeys(t *testing.T) {
+	ctx := context.Background()
+	backend := newEncryptedTestBackend(t, t.TempDir(), t.TempDir())
+	createTestSession(t, backend, "session-1")
+	createTestConversation(t, backend, "session-1", "c1", "sealed with key version 1")
+
+	result, err := backend.CreateBackup(ctx, nil)
+	if err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+	if _, err := backend.RestoreBackup(ctx, result.Backup.Path); err != nil {
+		t.Fatalf("Failed to restore with the same key: %v", err)
+	}
+
+	// Once a rekey retired version 1 the backup can't be read anymore
+	if _, err := backend.RekeyFields(ctx, 0, nil); err != nil {
+		t.Fatalf("Failed to rekey: %v", err)
+	}
+	if _, err := backend.RestoreBackup(ctx, result.Backup.Path); !errors.Is(err, ErrWrongEncryptionKey) {
+		t.Errorf("Expected ErrWrongEncryptionKey, got %v", err)
+	}
+}
@@ -0,0 +1,484 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// sqliteWriter runs the writes of the SQLite backend, in autocommit mode or,
+// inside WithTx, in its transaction. Statements are prepared once per
+// backend and reused.
+type sqliteWriter struct {
+	b       *PureGoSQLiteBackend
+	tx      *sql.Tx // nil outside WithTx
+	cipher  *FieldCipher
+	txStmts map[string]*sql.Stmt // statements bound to tx
+}
+
+// WithTx runs fn in a transaction, committing its writes when it returns nil
+func (b *PureGoSQLiteBackend) WithTx(ctx context.Context, fn func(tx BackendTx) error) error {
+	return b.writeTx(ctx, func(w *sqliteWriter) error {
+		return fn(w)
+	})
+}
+
+// writeTx runs fn in a transaction with the current field cipher, loaded
+// once the transaction holds the write lock
+func (b *PureGoSQLiteBackend) writeTx(ctx context.Context, fn func(w *sqliteWriter) error) error {
+	return b.withTx(ctx, nil, func(w *sqliteWriter) error {
+		fieldCipher, err := b.writeCipher(ctx, w.tx)
+		if err != nil {
+			return err
+		}
+		w.cipher = fieldCipher
+		return fn(w)
+	})
+}
+
+// withTx runs fn with a writer bound to a new transaction. A transaction
+// that finds the database locked is rolled back and fn runs again.
+func (b *PureGoSQLiteBackend) withTx(ctx context.Context, fieldCipher *FieldCipher, fn func(w *sqliteWriter) error) error {
+	return b.retryBusy(ctx, func() error {
+		tx, err := b.writeDB.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		if err := fn(&sqliteWriter{b: b, tx: tx, cipher: fieldCipher}); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return nil
+	})
+}
+
+// stmt returns the prepared statement for query, preparing it on first use
+func (b *PureGoSQLiteBackend) stmt(ctx context.Context, query string) (*sql.Stmt, error) {
+	b.stmtMu.Lock()
+	defer b.stmtMu.Unlock()
+
+	if stmt, ok := b.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := b.writeDB.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	if b.stmts == nil {
+		b.stmts = make(map[string]*sql.Stmt)
+	}
+	b.stmts[query] = stmt
+	return stmt, nil
+}
+
+// cachedStmt returns the prepared statement for query, nil when it hasn't
+// been prepared yet
+func (b *PureGoSQLiteBackend) cachedStmt(query string) *sql.Stmt {
+	b.stmtMu.Lock()
+	defer b.stmtMu.Unlock()
+	return b.stmts[query]
+}
+
+// closeStatements closes the prepared statements
+func (b *PureGoSQLiteBackend) closeStatements() {
+	b.stmtMu.Lock()
+	defer b.stmtMu.Unlock()
+
+	for _, stmt := range b.stmts {
+		stmt.Close()
+	}
+	b.stmts = nil
+}
+
+// exec runs a prepared write statement
+func (w *sqliteWriter) exec(ctx context.Context, query string, args ...interface{}) error {
+	if w.tx == nil {
+		stmt, err := w.b.stmt(ctx, query)
+		if err != nil {
+			return err
+		}
+		return w.b.retryBusy(ctx, func() error {
+			_, err := stmt.ExecContext(ctx, args...)
+			return err
+		})
+	}
+
+	stmt, ok := w.txStmts[query]
+	if !ok {
+		// The transaction holds the only writer connection, so a statement
+		// not prepared yet is prepared on the transaction
+		var err error
+		if cached := w.b.cachedStmt(query); cached != nil {
+			stmt = w.tx.StmtContext(ctx, cached)
+		} else if stmt, err = w.tx.PrepareContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to prepare statement: %w", err)
+		}
+		if w.txStmts == nil {
+			w.txStmts = make(map[string]*sql.Stmt)
+		}
+		w.txStmts[query] = stmt
+	}
+	_, err := stmt.ExecContext(ctx, args...)
+	return err
+}
+
+// queryRow runs a query returning at most one row
+func (w *sqliteWriter) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if w.tx != nil {
+		return w.tx.QueryRowContext(ctx, query, args...)
+	}
+	return w.b.db.QueryRowContext(ctx, query, args...)
+}
+
+// batch runs fn all or nothing: in a transaction of its own, or in a
+// savepoint when the writer is already in one
+func (w *sqliteWriter) batch(ctx context.Context, fn func(w *sqliteWriter) error) error {
+	if w.tx == nil {
+		return w.b.withTx(ctx, w.cipher, fn)
+	}
+
+	if _, err := w.tx.ExecContext(ctx, "SAVEPOINT batch"); err != nil {
+		return err
+	}
+	if err := fn(w); err != nil {
+		w.tx.ExecContext(ctx, "ROLLBACK TO batch")
+		w.tx.ExecContext(ctx, "RELEASE batch")
+		return err
+	}
+	_, err := w.tx.ExecContext(ctx, "RELEASE batch")
+	return err
+}
+
+// GetSession retrieves a session by ID
+func (w *sqliteWriter) GetSession(ctx context.Context, sessionID string) (*Session, error) {
+	query := `
+		SELECT id, created_at, updated_at, status, metadata, deleted_at, message_count, token_count
+		FROM sessions WHERE id = ?
+	`
+
+	session := &Session{}
+	var deletedAt sql.NullTime
+	err := w.queryRow(ctx, query, sessionID).Scan(
+		&session.ID,
+		&session.CreatedAt,
+		&session.UpdatedAt,
+		&session.Status,
+		&session.Metadata,
+		&deletedAt,
+		&session.MessageCount,
+		&session.TokenCount,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if session.Metadata, err = w.b.openField(ctx, fieldSessionMetadata, session.Metadata); err != nil {
+		return nil, err
+	}
+	if deletedAt.Valid {
+		session.DeletedAt = &deletedAt.Time
+	}
+	return session, nil
+}
+
+// CreateSession creates a new session
+func (w *sqliteWriter) CreateSession(ctx context.Context, session *Session) error {
+	if w.cipher != nil {
+		metadata, err := w.cipher.Encrypt(fieldSessionMetadata, session.Metadata)
+		if err != nil {
+			return err
+		}
+		query := `
+			INSERT INTO sessions (id, created_at, updated_at, status, metadata, project_bidx)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`
+		return w.exec(ctx, query,
+			session.ID,
+			session.CreatedAt,
+			session.UpdatedAt,
+			session.Status,
+			metadata,
+			w.cipher.projectBlindIndex(session.Metadata),
+		)
+	}
+
+	query := `
+		INSERT INTO sessions (id, created_at, updated_at, status, metadata)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	return w.exec(ctx, query,
+		session.ID,
+		session.CreatedAt,
+		session.UpdatedAt,
+		session.Status,
+		session.Metadata,
+	)
+}
+
+// UpdateSession updates a session's status, metadata and update time
+func (w *sqliteWriter) UpdateSession(ctx context.Context, session *Session) error {
+	if w.cipher != nil {
+		metadata, err := w.cipher.Encrypt(fieldSessionMetadata, session.Metadata)
+		if err != nil {
+			return err
+		}
+		query := `
+			UPDATE sessions
+			SET updated_at = ?, status = ?, metadata = ?, project_bidx = ?
+			WHERE id = ?
+		`
+		return w.exec(ctx, query,
+			session.UpdatedAt,
+			session.Status,
+			metadata,
+			w.cipher.projectBlindIndex(session.Metadata),
+			session.ID,
+		)
+	}
+
+	query := `
+		UPDATE sessions
+		SET updated_at = ?, status = ?, metadata = ?
+		WHERE id = ?
+	`
+	return w.exec(ctx, query,
+		session.UpdatedAt,
+		session.Status,
+		session.Metadata,
+		session.ID,
+	)
+}
+
+// CreateEvent creates a new event
+func (w *sqliteWriter) CreateEvent(ctx context.Context, event *Event) error {
+	return w.batch(ctx, func(w *sqliteWriter) error {
+		return w.insertEvent(ctx, event)
+	})
+}
+
+// CreateEventBatch creates multiple events; either all of them are stored
+// or none
+func (w *sqliteWriter) CreateEventBatch(ctx context.Context, events []*Event) error {
+	return w.batch(ctx, func(w *sqliteWriter) error {
+		for _, event := range events {
+			if err := w.insertEvent(ctx, event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// insertEvent numbers and inserts an event. The writer is in a transaction,
+// which a failed insert must roll back with the counter.
+func (w *sqliteWriter) insertEvent(ctx context.Context, event *Event) error {
+	data, err := w.cipher.sealField(fieldEventData, event.Data)
+	if err != nil {
+		return err
+	}
+	sequence, err := nextEventSequence(ctx, w.tx, event.SessionID, event.SequenceNum)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO events (id, session_id, event_type, timestamp, sequence_num, data)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	return w.exec(ctx, query,
+		event.ID,
+		event.SessionID,
+		event.EventType,
+		event.Timestamp,
+		sequence,
+		data,
+	)
+}
+
+// CreateConversation creates a new conversation entry
+func (w *sqliteWriter) CreateConversation(ctx context.Context, conv *Conversation) error {
+	content, err := w.cipher.sealField(fieldConversationContent, conv.Content)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO conversations (id, session_id, message_type, content, timestamp, metadata, token_count, model)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	return w.exec(ctx, query,
+		conv.ID,
+		conv.SessionID,
+		conv.MessageType,
+		content,
+		conv.Timestamp,
+		conv.Metadata,
+		conv.TokenCount,
+		conv.Model,
+	)
+}
+
+// CreateConversationBatch creates multiple conversations; either all of
+// them are stored or none
+func (w *sqliteWriter) CreateConversationBatch(ctx context.Context, convs []*Conversation) error {
+	return w.batch(ctx, func(w *sqliteWriter) error {
+		for _, conv := range convs {
+			if err := w.CreateConversation(ctx, conv); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CreateToolInvocation records the start of a tool invocation
+func (w *sqliteWriter) CreateToolInvocation(ctx context.Context, inv *ToolInvocation) error {
+	input, output, err := w.cipher.sealToolInvocation(inv)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO tool_invocations (id, session_id, tool_use_id, tool_name, input, output,
+			output_truncated, output_path, status, success, started_at, ended_at, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	return w.exec(ctx, query,
+		inv.ID,
+		inv.SessionID,
+		inv.ToolUseID,
+		inv.ToolName,
+		input,
+		output,
+		inv.OutputTruncated,
+		inv.OutputPath,
+		inv.Status,
+		inv.Success,
+		inv.StartedAt,
+		inv.EndedAt,
+		inv.DurationMs,
+	)
+}
+
+// UpdateToolInvocation records the result of a tool invocation
+func (w *sqliteWriter) UpdateToolInvocation(ctx context.Context, inv *ToolInvocation) error {
+	input, output, err := w.cipher.sealToolInvocation(inv)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE tool_invocations
+		SET input = ?, output = ?, output_truncated = ?, output_path = ?,
+			status = ?, success = ?, ended_at = ?, duration_ms = ?
+		WHERE id = ?
+	`
+	return w.exec(ctx, query,
+		input,
+		output,
+		inv.OutputTruncated,
+		inv.OutputPath,
+		inv.Status,
+		inv.Success,
+		inv.EndedAt,
+		inv.DurationMs,
+		inv.ID,
+	)
+}
+
+// GetToolInvocation returns the invocation with the given tool use ID: the
+// most recent one still running, or else the most recent one
+func (w *sqliteWriter) GetToolInvocation(ctx context.Context, sessionID, toolUseID string) (*ToolInvocation, error) {
+	query := `
+		SELECT id, session_id, tool_use_id, tool_name, input, output, output_truncated,
+			output_path, status, success, started_at, ended_at, duration_ms
+		FROM tool_invocations
+		WHERE session_id = ? AND tool_use_id = ?
+		ORDER BY status = ? DESC, started_at DESC LIMIT 1
+	`
+
+	inv, err := scanToolInvocation(w.queryRow(ctx, query, sessionID, toolUseID, ToolStatusRunning))
+	if err == sql.ErrNoRows {
+		return nil, ErrToolInvocationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := w.b.openToolInvocation(ctx, inv); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// GetTranscriptCursor returns the ingestion cursor for a session, or a zero
+// cursor when the session's transcript hasn't been ingested yet
+func (w *sqliteWriter) GetTranscriptCursor(ctx context.Context, sessionID string) (*TranscriptCursor, error) {
+	query := `
+		SELECT session_id, transcript_path, byte_offset, last_uuid, updated_at
+		FROM transcript_cursors WHERE session_id = ?
+	`
+
+	cursor := &TranscriptCursor{}
+	var lastUUID sql.NullString
+	err := w.queryRow(ctx, query, sessionID).Scan(
+		&cursor.SessionID,
+		&cursor.TranscriptPath,
+		&cursor.ByteOffset,
+		&lastUUID,
+		&cursor.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return &TranscriptCursor{SessionID: sessionID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cursor.LastUUID = lastUUID.String
+	return cursor, nil
+}
+
+// SaveTranscriptCursor creates or updates the ingestion cursor for a session
+func (w *sqliteWriter) SaveTranscriptCursor(ctx context.Context, cursor *TranscriptCursor) error {
+	query := `
+		INSERT INTO transcript_cursors (session_id, transcript_path, byte_offset, last_uuid, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(session_id) DO UPDATE SET
+			transcript_path = excluded.transcript_path,
+			byte_offset = excluded.byte_offset,
+			last_uuid = excluded.last_uuid,
+			updated_at = excluded.updated_at
+	`
+	return w.exec(ctx, query,
+		cursor.SessionID,
+		cursor.TranscriptPath,
+		cursor.ByteOffset,
+		cursor.LastUUID,
+		cursor.UpdatedAt,
+	)
+}
+
+// RecordImport records an imported file, replacing an earlier record of it
+func (w *sqliteWriter) RecordImport(ctx context.Context, record *ImportRecord) error {
+	query := `
+		INSERT OR REPLACE INTO import_history
+		(file_path, imported_at, session_count, event_count, checksum)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	return w.exec(ctx, query,
+		record.FilePath,
+		record.ImportedAt.Format(time.RFC3339),
+		record.SessionCount,
+		record.EventCount,
+		record.Checksum,
+	)
+}
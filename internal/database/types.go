@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"iter"
 	"time"
 )
 
@@ -16,6 +17,8 @@ type DatabaseBackend interface {
 	CreateSchema(ctx context.Context) error
 	GetSchemaVersion(ctx context.Context) (int, error)
 	MigrateSchema(ctx context.Context, targetVersion int) error
+	PlanMigration(ctx context.Context, targetVersion int) (*MigrationPlan, error)
+	MigrationStatus(ctx context.Context) ([]*MigrationState, error)
 
 	// Session Operations
 	CreateSession(ctx context.Context, session *Session) error
@@ -23,16 +26,54 @@ type DatabaseBackend interface {
 	UpdateSession(ctx context.Context, session *Session) error
 	DeleteSession(ctx context.Context, id string) error
 	ListSessions(ctx context.Context, filters *SessionFilters) ([]*Session, error)
-
-	// Event Operations
+	ListSessionPage(ctx context.Context, filters *SessionFilters) (*SessionPage, error)
+
+	// Streaming, for reading more rows than fit in memory. Iteration stops
+	// at the first error, which is yielded with a nil value.
+	IterSessions(ctx context.Context, filters *SessionFilters) iter.Seq2[*Session, error]
+	IterConversationsBySession(ctx context.Context, sessionID string) iter.Seq2[*Conversation, error]
+	IterEventsBySession(ctx context.Context, sessionID string) iter.Seq2[*Event, error]
+
+	// Trash
+	RestoreSession(ctx context.Context, id string) error
+	PurgeSession(ctx context.Context, id string) error
+	ListTrash(ctx context.Context) ([]*Session, error)
+	EmptyTrash(ctx context.Context, deletedBefore time.Time) (*PurgeResult, error)
+
+	// Event Operations. Events without a sequence number are numbered
+	// after the last event of their session.
 	CreateEvent(ctx context.Context, event *Event) error
 	GetEventsBySession(ctx context.Context, sessionID string) ([]*Event, error)
 	CreateEventBatch(ctx context.Context, events []*Event) error
+	RenumberEvents(ctx context.Context, sessionID string) (int, error)
 
 	// Conversation Operations
 	CreateConversation(ctx context.Context, conv *Conversation) error
+	CreateConversationBatch(ctx context.Context, convs []*Conversation) error
 	GetConversationsBySession(ctx context.Context, sessionID string) ([]*Conversation, error)
 	SearchConversations(ctx context.Context, query string, limit int) ([]*Conversation, error)
+	SearchConversationHits(ctx context.Context, opts *SearchOptions) ([]*SearchHit, error)
+
+	// Tool Invocation Operations
+	CreateToolInvocation(ctx context.Context, inv *ToolInvocation) error
+	UpdateToolInvocation(ctx context.Context, inv *ToolInvocation) error
+	GetRunningToolInvocation(ctx context.Context, sessionID, toolUseID string) (*ToolInvocation, error)
+	GetToolInvocation(ctx context.Context, sessionID, toolUseID string) (*ToolInvocation, error)
+	GetToolInvocationsBySession(ctx context.Context, sessionID string) ([]*ToolInvocation, error)
+	GetToolUsageCounts(ctx context.Context, sessionID string) (map[string]int, error)
+
+	// Transcript Ingestion
+	GetTranscriptCursor(ctx context.Context, sessionID string) (*TranscriptCursor, error)
+	SaveTranscriptCursor(ctx context.Context, cursor *TranscriptCursor) error
+
+	// Import History
+	RecordImport(ctx context.Context, record *ImportRecord) error
+	IsFileImported(ctx context.Context, filePath string) (bool, error)
+	ListImports(ctx context.Context) ([]*ImportRecord, error)
+
+	// Units of Work. fn's writes are committed together when it returns
+	// nil and rolled back otherwise. Only tx may be used inside fn.
+	WithTx(ctx context.Context, fn func(tx BackendTx) error) error
 
 	// Statistics
 	GetDatabaseStats(ctx context.Context) (*DatabaseStats, error)
@@ -41,6 +82,27 @@ type DatabaseBackend interface {
 	GetBackendInfo() *BackendInfo
 }
 
+// BackendTx is the set of operations available inside WithTx. A failed
+// write, e.g. a duplicate, only undoes itself, and batches are still all
+// or nothing.
+type BackendTx interface {
+	GetSession(ctx context.Context, id string) (*Session, error)
+	CreateSession(ctx context.Context, session *Session) error
+	UpdateSession(ctx context.Context, session *Session) error
+
+	CreateEvent(ctx context.Context, event *Event) error
+	CreateEventBatch(ctx context.Context, events []*Event) error
+	CreateConversation(ctx context.Context, conv *Conversation) error
+	CreateConversationBatch(ctx context.Context, convs []*Conversation) error
+	CreateToolInvocation(ctx context.Context, inv *ToolInvocation) error
+	UpdateToolInvocation(ctx context.Context, inv *ToolInvocation) error
+	GetToolInvocation(ctx context.Context, sessionID, toolUseID string) (*ToolInvocation, error)
+
+	GetTranscriptCursor(ctx context.Context, sessionID string) (*TranscriptCursor, error)
+	SaveTranscriptCursor(ctx context.Context, cursor *TranscriptCursor) error
+	RecordImport(ctx context.Context, record *ImportRecord) error
+}
+
 // BackendFactory creates database backend instances
 type BackendFactory interface {
 	CreateBackend(config *DatabaseConfig) (DatabaseBackend, error)
@@ -55,6 +117,10 @@ type Session struct {
 	UpdatedAt time.Time `json:"updated_at"`
 	Status    string    `json:"status"`
 	Metadata  string    `json:"metadata,omitempty"`
+	// DeletedAt is when the session was moved to the trash, nil when it isn't
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
+	MessageCount int        `json:"message_count,omitempty"`
+	TokenCount   int        `json:"token_count,omitempty"`
 }
 
 // Event represents a session event
@@ -63,7 +129,7 @@ type Event struct {
 	SessionID   string    `json:"session_id"`
 	EventType   string    `json:"event_type"`
 	Timestamp   time.Time `json:"timestamp"`
-	SequenceNum int       `json:"sequence_num"`
+	SequenceNum int       `json:"sequence_num"` // 0 to number it on create
 	Data        string    `json:"data,omitempty"`
 }
 
@@ -79,15 +145,72 @@ type Conversation struct {
 	Model       string    `json:"model,omitempty"`
 }
 
+// ToolInvocation represents a single tool call made by Claude during a session
+type ToolInvocation struct {
+	ID              string     `json:"id"`
+	SessionID       string     `json:"session_id"`
+	ToolUseID       string     `json:"tool_use_id,omitempty"` // correlates PreToolUse with PostToolUse
+	ToolName        string     `json:"tool_name"`
+	Input           string     `json:"input,omitempty"`  // tool input as JSON
+	Output          string     `json:"output,omitempty"` // tool response, truncated when large
+	OutputTruncated bool       `json:"output_truncated,omitempty"`
+	OutputPath      string     `json:"output_path,omitempty"` // file holding the full output when truncated
+	Status          string     `json:"status"`                // running, completed or failed
+	Success         bool       `json:"success"`
+	StartedAt       time.Time  `json:"started_at"`
+	EndedAt         *time.Time `json:"ended_at,omitempty"`
+	DurationMs      int64      `json:"duration_ms"`
+}
+
+// Tool invocation status values
+const (
+	ToolStatusRunning   = "running"
+	ToolStatusCompleted = "completed"
+	ToolStatusFailed    = "failed"
+)
+
+// TranscriptCursor records how far a session's Claude transcript has been ingested
+type TranscriptCursor struct {
+	SessionID      string    `json:"session_id"`
+	TranscriptPath string    `json:"transcript_path"`
+	ByteOffset     int64     `json:"byte_offset"`
+	LastUUID       string    `json:"last_uuid,omitempty"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// ImportRecord records a transcript file imported into the database
+type ImportRecord struct {
+	FilePath     string    `json:"file_path"`
+	ImportedAt   time.Time `json:"imported_at"`
+	SessionCount int       `json:"session_count"`
+	EventCount   int       `json:"event_count"` // messages imported
+	Checksum     string    `json:"checksum,omitempty"`
+}
+
 // SessionFilters defines filters for session queries
 type SessionFilters struct {
 	Status        string     `json:"status,omitempty"`
+	Project       string     `json:"project,omitempty"` // exact project name, ignoring case
 	CreatedAfter  *time.Time `json:"created_after,omitempty"`
 	CreatedBefore *time.Time `json:"created_before,omitempty"`
 	Limit         int        `json:"limit,omitempty"`
 	Offset        int        `json:"offset,omitempty"`
 	SortBy        string     `json:"sort_by,omitempty"`
 	SortOrder     string     `json:"sort_order,omitempty"`
+	// IncludeDeleted also returns sessions in the trash
+	IncludeDeleted bool     `json:"include_deleted,omitempty"`
+	Statuses       []string `json:"statuses,omitempty"`    // any of these statuses
+	WorkingDir     string   `json:"working_dir,omitempty"` // this directory or any below it
+	Model          string   `json:"model,omitempty"`       // a message from this model
+	MinMessages    int      `json:"min_messages,omitempty"`
+	MaxMessages    int      `json:"max_messages,omitempty"` // 0 means no limit
+	MinTokens      int      `json:"min_tokens,omitempty"`
+	MaxTokens      int      `json:"max_tokens,omitempty"` // 0 means no limit
+	// Text is a search query one of the session's messages must match. Like
+	// search, it doesn't look into the trash.
+	Text string `json:"text,omitempty"`
+	// Cursor continues a listing from the NextCursor of its previous page
+	Cursor string `json:"cursor,omitempty"`
 }
 
 // DatabaseStats provides database statistics
@@ -95,6 +218,7 @@ type DatabaseStats struct {
 	SessionCount      int        `json:"session_count"`
 	EventCount        int        `json:"event_count"`
 	ConversationCount int        `json:"conversation_count"`
+	ToolCount         int        `json:"tool_count"`
 	ImportCount       int        `json:"import_count"`
 	DatabaseSize      int64      `json:"database_size"`
 	OldestRecord      *time.Time `json:"oldest_record,omitempty"`
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/csv"
 	"fmt"
+	"iter"
 	"os"
 	"strconv"
 	"strings"
@@ -23,59 +24,75 @@ func NewCSVExporter() *CSVExporter {
 	}
 }
 
-// Export performs the CSV export operation
-func (e *CSVExporter) Export(ctx context.Context, backend database.DatabaseBackend, sessions []*database.Session, options *ExportOptions) error {
+// Export performs the CSV export operation, writing each session as it
+// is summarized
+func (e *CSVExporter) Export(ctx context.Context, backend database.DatabaseBackend, sessions iter.Seq2[*database.Session, error], options *ExportOptions) error {
 	// Validate options
 	if err := e.ValidateOptions(options); err != nil {
 		return fmt.Errorf("invalid export options: %w", err)
 	}
 
-	// Prepare session data
-	exportData, err := PrepareSessionData(ctx, backend, sessions)
-	if err != nil {
-		return fmt.Errorf("failed to prepare session data: %w", err)
-	}
-
-	if len(exportData) == 0 {
-		return fmt.Errorf("no data to export")
-	}
+	return e.writeCSV(ctx, backend, sessions, options, nil)
+}
 
+// writeCSV streams sessions to the output file, calling progress after each
+// record. The file is created with the first session, so an empty export
+// leaves nothing behind.
+func (e *CSVExporter) writeCSV(ctx context.Context, backend database.DatabaseBackend, sessions iter.Seq2[*database.Session, error], options *ExportOptions, progress func(written int)) error {
 	// Determine columns to export
 	columns := options.Columns
 	if len(columns) == 0 {
 		columns = DefaultCSVColumns
 	}
 
-	// Create output file
-	file, err := os.Create(options.Output)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
-	}
-	defer file.Close()
+	var file *os.File
+	var writer *csv.Writer
+	written := 0
 
-	// Create CSV writer
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	for session, err := range sessions {
+		if err != nil {
+			return fmt.Errorf("failed to read sessions: %w", err)
+		}
+		data, err := SummarizeSession(ctx, backend, session)
+		if err != nil {
+			return fmt.Errorf("failed to prepare session data: %w", err)
+		}
 
-	// Write header row
-	if err := writer.Write(columns); err != nil {
-		return fmt.Errorf("failed to write CSV header: %w", err)
-	}
+		if file == nil {
+			if file, err = os.Create(options.Output); err != nil {
+				return fmt.Errorf("failed to create output file: %w", err)
+			}
+			defer file.Close()
 
-	// Write data rows
-	for _, session := range exportData {
-		record := make([]string, len(columns))
+			writer = csv.NewWriter(file)
+			if err := writer.Write(columns); err != nil {
+				return fmt.Errorf("failed to write CSV header: %w", err)
+			}
+		}
 
+		record := make([]string, len(columns))
 		for i, column := range columns {
-			record[i] = e.getColumnValue(session, column)
+			record[i] = e.getColumnValue(data, column)
 		}
-
 		if err := writer.Write(record); err != nil {
 			return fmt.Errorf("failed to write CSV record: %w", err)
 		}
+
+		written++
+		if progress != nil {
+			progress(written)
+		}
 	}
 
-	return nil
+	if file == nil {
+		return fmt.Errorf("no data to export")
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV data: %w", err)
+	}
+	return file.Close()
 }
 
 // GetColumnValue extracts the value for a specific column from session data (public method)
@@ -172,31 +189,20 @@ func (e *CSVExporter) ValidateOptions(options *ExportOptions) error {
 	return nil
 }
 
-// ExportWithProgress exports CSV data with progress reporting
-func (e *CSVExporter) ExportWithProgress(ctx context.Context, backend database.DatabaseBackend, sessions []*database.Session, options *ExportOptions) error {
+// ExportWithProgress exports CSV data with progress reporting. total is
+// the number of sessions expected, for the percentage.
+func (e *CSVExporter) ExportWithProgress(ctx context.Context, backend database.DatabaseBackend, sessions iter.Seq2[*database.Session, error], total int, options *ExportOptions) error {
 	if !options.ShowProgress {
 		return e.Export(ctx, backend, sessions, options)
 	}
 
-	fmt.Printf("🔄 Preparing CSV export for %d sessions...\n", len(sessions))
+	fmt.Printf("🔄 Preparing CSV export for %d sessions...\n", total)
 
 	// Validate options
 	if err := e.ValidateOptions(options); err != nil {
 		return fmt.Errorf("invalid export options: %w", err)
 	}
 
-	// Prepare session data with progress
-	fmt.Print("📊 Processing session data... ")
-	exportData, err := PrepareSessionData(ctx, backend, sessions)
-	if err != nil {
-		return fmt.Errorf("failed to prepare session data: %w", err)
-	}
-	fmt.Println("✅")
-
-	if len(exportData) == 0 {
-		return fmt.Errorf("no data to export")
-	}
-
 	// Determine columns
 	columns := options.Columns
 	if len(columns) == 0 {
@@ -205,43 +211,13 @@ func (e *CSVExporter) ExportWithProgress(ctx context.Context, backend database.D
 
 	fmt.Printf("📝 Writing CSV with %d columns: %s\n", len(columns), strings.Join(columns, ", "))
 
-	// Create output file
-	file, err := os.Create(options.Output)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
-	}
-	defer file.Close()
-
-	// Create CSV writer
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	// Write header
-	if err := writer.Write(columns); err != nil {
-		return fmt.Errorf("failed to write CSV header: %w", err)
-	}
-
-	// Write data with progress
-	total := len(exportData)
-	for i, session := range exportData {
-		record := make([]string, len(columns))
-
-		for j, column := range columns {
-			record[j] = e.getColumnValue(session, column)
-		}
-
-		if err := writer.Write(record); err != nil {
-			return fmt.Errorf("failed to write CSV record: %w", err)
-		}
-
+	return e.writeCSV(ctx, backend, sessions, options, func(written int) {
 		// Show progress every 10% or for small datasets, every record
-		if total <= 10 || (i+1)%(total/10) == 0 || i+1 == total {
-			percent := float64(i+1) / float64(total) * 100
-			fmt.Printf("📈 Progress: %.0f%% (%d/%d sessions)\n", percent, i+1, total)
+		if total <= 10 || written%(total/10) == 0 || written == total {
+			percent := float64(written) / float64(max(total, written)) * 100
+			fmt.Printf("📈 Progress: %.0f%% (%d/%d sessions)\n", percent, written, total)
 		}
-	}
-
-	return nil
+	})
 }
 
 // GetCSVPreview returns a preview of what the CSV export would look like
@@ -252,11 +228,6 @@ func (e *CSVExporter) GetCSVPreview(ctx context.Context, backend database.Databa
 		limitedSessions = sessions[:maxRows]
 	}
 
-	exportData, err := PrepareSessionData(ctx, backend, limitedSessions)
-	if err != nil {
-		return nil, fmt.Errorf("failed to prepare session data: %w", err)
-	}
-
 	// Determine columns
 	columns := options.Columns
 	if len(columns) == 0 {
@@ -270,13 +241,17 @@ func (e *CSVExporter) GetCSVPreview(ctx context.Context, backend database.Databa
 	preview = append(preview, columns)
 
 	// Add data rows
-	for _, session := range exportData {
+	for _, session := range limitedSessions {
+		data, err := SummarizeSession(ctx, backend, session)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare session data: %w", err)
+		}
 		record := make([]string, len(columns))
 		for i, column := range columns {
-			record[i] = e.getColumnValue(session, column)
+			record[i] = e.getColumnValue(data, column)
 		}
 		preview = append(preview, record)
 	}
 
 	return preview, nil
-}
\ No newline at end of file
+}
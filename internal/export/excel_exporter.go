@@ -3,6 +3,7 @@ package export
 import (
 	"context"
 	"fmt"
+	"iter"
 	"strconv"
 	"strings"
 
@@ -22,17 +23,25 @@ func NewExcelExporter() *ExcelExporter {
 	}
 }
 
-// Export performs the Excel export operation
-func (e *ExcelExporter) Export(ctx context.Context, backend database.DatabaseBackend, sessions []*database.Session, options *ExportOptions) error {
+// Export performs the Excel export operation. The workbook is built in
+// memory, so only session summaries are kept, not their rows.
+func (e *ExcelExporter) Export(ctx context.Context, backend database.DatabaseBackend, sessions iter.Seq2[*database.Session, error], options *ExportOptions) error {
 	// Validate options
 	if err := e.ValidateOptions(options); err != nil {
 		return fmt.Errorf("invalid export options: %w", err)
 	}
 
-	// Prepare session data
-	exportData, err := PrepareSessionData(ctx, backend, sessions)
-	if err != nil {
-		return fmt.Errorf("failed to prepare session data: %w", err)
+	// Summarize session data
+	var exportData []*SessionExportData
+	for session, err := range sessions {
+		if err != nil {
+			return fmt.Errorf("failed to read sessions: %w", err)
+		}
+		data, err := SummarizeSession(ctx, backend, session)
+		if err != nil {
+			return fmt.Errorf("failed to prepare session data: %w", err)
+		}
+		exportData = append(exportData, data)
 	}
 
 	if len(exportData) == 0 {
@@ -3,6 +3,7 @@ package export
 import (
 	"context"
 	"fmt"
+	"iter"
 	"path/filepath"
 	"strings"
 	"time"
@@ -12,8 +13,8 @@ import (
 
 // Exporter defines the interface for all export implementations
 type Exporter interface {
-	// Export performs the export operation
-	Export(ctx context.Context, backend database.DatabaseBackend, sessions []*database.Session, options *ExportOptions) error
+	// Export performs the export operation, reading sessions as it writes
+	Export(ctx context.Context, backend database.DatabaseBackend, sessions iter.Seq2[*database.Session, error], options *ExportOptions) error
 
 	// GetSupportedColumns returns the list of columns this exporter supports
 	GetSupportedColumns() []string
@@ -32,13 +33,14 @@ type ExportOptions struct {
 	Columns  []string `json:"columns,omitempty"`  // custom column selection
 
 	// Filtering options
-	From        string   `json:"from,omitempty"`         // date filter (YYYY-MM-DD)
-	To          string   `json:"to,omitempty"`           // date filter (YYYY-MM-DD)
-	Project     string   `json:"project,omitempty"`      // project name filter
-	Sessions    []string `json:"sessions,omitempty"`     // specific session IDs
-	Status      string   `json:"status,omitempty"`       // session status filter
-	MinDuration string   `json:"min_duration,omitempty"` // minimum session duration
-	MaxDuration string   `json:"max_duration,omitempty"` // maximum session duration
+	From         string   `json:"from,omitempty"`          // date filter (YYYY-MM-DD)
+	To           string   `json:"to,omitempty"`            // date filter (YYYY-MM-DD)
+	Project      string   `json:"project,omitempty"`       // project name filter
+	Sessions     []string `json:"sessions,omitempty"`      // specific session IDs
+	Status       string   `json:"status,omitempty"`        // session status filter
+	MinDuration  string   `json:"min_duration,omitempty"`  // minimum session duration
+	MaxDuration  string   `json:"max_duration,omitempty"`  // maximum session duration
+	IncludeTrash bool     `json:"include_trash,omitempty"` // also export sessions in the trash
 
 	// Output formatting options
 	Pretty   bool `json:"pretty,omitempty"`   // pretty-print JSON
@@ -92,9 +94,10 @@ type SessionExportData struct {
 	WorkingDirName    string   `json:"working_dir_name"`
 
 	// Additional metadata
-	Conversations []*database.Conversation `json:"conversations,omitempty"` // for JSON export
-	Events        []*database.Event        `json:"events,omitempty"`        // for JSON export
-	RawMetadata   string                   `json:"raw_metadata,omitempty"`  // original session metadata
+	Conversations   []*database.Conversation   `json:"conversations,omitempty"`    // for JSON export
+	Events          []*database.Event          `json:"events,omitempty"`           // for JSON export
+	ToolInvocations []*database.ToolInvocation `json:"tool_invocations,omitempty"` // for JSON export
+	RawMetadata     string                     `json:"raw_metadata,omitempty"`     // original session metadata
 }
 
 // DefaultCSVColumns defines the standard columns for CSV export
@@ -136,100 +139,133 @@ var AllAvailableColumns = []string{
 	"raw_metadata",
 }
 
-// PrepareSessionData converts database sessions to export format
-func PrepareSessionData(ctx context.Context, backend database.DatabaseBackend, sessions []*database.Session) ([]*SessionExportData, error) {
-	var exportData []*SessionExportData
+// SummarizeSession computes the export statistics of a session. Its
+// conversations and events are streamed, not kept, so the memory used
+// doesn't grow with the session.
+func SummarizeSession(ctx context.Context, backend database.DatabaseBackend, session *database.Session) (*SessionExportData, error) {
+	summary := newSessionSummary(session)
 
-	for _, session := range sessions {
-		// Get conversations for this session
-		conversations, err := backend.GetConversationsBySession(ctx, session.ID)
+	for conv, err := range backend.IterConversationsBySession(ctx, session.ID) {
 		if err != nil {
-			// Continue with what we have, don't fail entire export
-			conversations = []*database.Conversation{}
+			return nil, fmt.Errorf("failed to read conversations of %s: %w", session.ID, err)
 		}
-
-		// Get events for this session
-		events, err := backend.GetEventsBySession(ctx, session.ID)
+		summary.addConversation(conv)
+	}
+	for event, err := range backend.IterEventsBySession(ctx, session.ID) {
 		if err != nil {
-			events = []*database.Event{}
+			return nil, fmt.Errorf("failed to read events of %s: %w", session.ID, err)
 		}
+		summary.addEvent(event)
+	}
 
-		// Calculate statistics
-		userPrompts := 0
-		claudeReplies := 0
-		userWords := 0
-		claudeWords := 0
-
-		for _, conv := range conversations {
-			switch conv.MessageType {
-			case "user":
-				userPrompts++
-				userWords += countWords(conv.Content)
-			case "assistant":
-				claudeReplies++
-				claudeWords += countWords(conv.Content)
-			}
+	// Tool invocations are captured by the PreToolUse/PostToolUse hooks
+	toolCounts, err := backend.GetToolUsageCounts(ctx, session.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count tool usage of %s: %w", session.ID, err)
+	}
+	for _, count := range toolCounts {
+		summary.data.ToolUsageCount += count
+	}
+
+	return summary.finish(), nil
+}
+
+// sessionSummary accumulates the statistics of a session as its
+// conversations and events go past
+type sessionSummary struct {
+	session *database.Session
+	data    *SessionExportData
+
+	conversations  int
+	hasCodeContent bool
+	lastActivity   time.Time
+	previous       *database.Conversation // for response times
+	totalGap       time.Duration
+	gapCount       int
+}
+
+// newSessionSummary starts the summary of a session from its metadata
+func newSessionSummary(session *database.Session) *sessionSummary {
+	data := &SessionExportData{
+		SessionID:   session.ID,
+		StartTime:   session.CreatedAt,
+		EndTime:     session.UpdatedAt,
+		Duration:    session.UpdatedAt.Sub(session.CreatedAt).String(),
+		Status:      session.Status,
+		RawMetadata: session.Metadata,
+	}
+
+	// Extract working directory from JSON-like metadata
+	if start := strings.Index(session.Metadata, "working_directory\":\""); start != -1 {
+		start += len("working_directory\":\"")
+		if end := strings.Index(session.Metadata[start:], "\""); end != -1 {
+			data.WorkingDir = session.Metadata[start : start+end]
 		}
+	}
+	if data.WorkingDir != "" {
+		data.WorkingDirName = filepath.Base(data.WorkingDir)
+	}
+
+	return &sessionSummary{session: session, data: data}
+}
 
-		// Parse metadata for project and working directory
-		projectName := ""
-		workingDir := ""
-		if session.Metadata != "" {
-			// Simple metadata extraction
-			if strings.Contains(session.Metadata, "working_directory") {
-				// Extract working directory from JSON-like metadata
-				if start := strings.Index(session.Metadata, "working_directory\":\""); start != -1 {
-					start += len("working_directory\":\"")
-					if end := strings.Index(session.Metadata[start:], "\""); end != -1 {
-						workingDir = session.Metadata[start : start+end]
-					}
-				}
+// addConversation counts a conversation, which arrive in time order
+func (s *sessionSummary) addConversation(conv *database.Conversation) {
+	s.conversations++
+	switch conv.MessageType {
+	case "user":
+		s.data.UserPrompts++
+		s.data.UserWords += countWords(conv.Content)
+		if s.data.UserPrompts == 1 {
+			s.data.FirstPrompt = conv.Content
+			if len(s.data.FirstPrompt) > 100 {
+				s.data.FirstPrompt = s.data.FirstPrompt[:97] + "..."
 			}
 		}
+	case "assistant":
+		s.data.ClaudeReplies++
+		s.data.ClaudeWords += countWords(conv.Content)
+	}
 
-		// Calculate analytics
-		avgResponseTime := calculateAverageResponseTime(conversations)
-		compressionEvents := countCompressionEvents(events)
-		toolUsageCount := countToolUsage(conversations)
-		sessionTags := generateSessionTags(session, conversations, events)
-		firstPrompt := getFirstPrompt(conversations)
-		lastActivity := getLastActivity(conversations, events)
-		workingDirName := ""
-		if workingDir != "" {
-			workingDirName = filepath.Base(workingDir)
-		}
+	// Time between user prompts and Claude responses
+	if s.previous != nil && s.previous.MessageType == "user" && conv.MessageType == "assistant" {
+		s.totalGap += conv.Timestamp.Sub(s.previous.Timestamp)
+		s.gapCount++
+	}
+	s.previous = conv
+
+	if strings.Contains(conv.Content, "```") || strings.Contains(conv.Content, "function") {
+		s.hasCodeContent = true
+	}
+	if conv.Timestamp.After(s.lastActivity) {
+		s.lastActivity = conv.Timestamp
+	}
+}
 
-		// Calculate duration
-		duration := session.UpdatedAt.Sub(session.CreatedAt).String()
-
-		exportData = append(exportData, &SessionExportData{
-			SessionID:         session.ID,
-			ProjectName:       projectName,
-			WorkingDir:        workingDir,
-			StartTime:         session.CreatedAt,
-			EndTime:           session.UpdatedAt,
-			Duration:          duration,
-			Status:            session.Status,
-			EventCount:        len(events),
-			UserPrompts:       userPrompts,
-			ClaudeReplies:     claudeReplies,
-			TotalWords:        userWords + claudeWords,
-			UserWords:         userWords,
-			ClaudeWords:       claudeWords,
-			AvgResponseTime:   avgResponseTime,
-			CompressionEvents: compressionEvents,
-			ToolUsageCount:    toolUsageCount,
-			SessionTags:       sessionTags,
-			FirstPrompt:       firstPrompt,
-			LastActivity:      lastActivity,
-			WorkingDirName:    workingDirName,
-			Conversations:     conversations,
-			Events:            events,
-			RawMetadata:       session.Metadata,
-		})
+// addEvent counts an event
+func (s *sessionSummary) addEvent(event *database.Event) {
+	s.data.EventCount++
+	if event.EventType == "compression" || strings.Contains(event.EventType, "compress") {
+		s.data.CompressionEvents++
+	}
+	if event.Timestamp.After(s.lastActivity) {
+		s.lastActivity = event.Timestamp
 	}
+}
+
+// finish derives the remaining statistics
+func (s *sessionSummary) finish() *SessionExportData {
+	s.data.TotalWords = s.data.UserWords + s.data.ClaudeWords
 
-	return exportData, nil
+	s.data.AvgResponseTime = "0s"
+	if s.gapCount > 0 {
+		s.data.AvgResponseTime = (s.totalGap / time.Duration(s.gapCount)).String()
+	}
+	if !s.lastActivity.IsZero() {
+		s.data.LastActivity = s.lastActivity.Format("2006-01-02 15:04:05")
+	}
+	s.data.SessionTags = generateSessionTags(s.session, s.conversations, s.hasCodeContent)
+	return s.data
 }
 
 // countWords provides a simple word count for content
@@ -251,61 +287,8 @@ func countWords(content string) int {
 	return words
 }
 
-// Helper functions for advanced analytics
-
-// calculateAverageResponseTime calculates average time between user prompts and Claude responses
-func calculateAverageResponseTime(conversations []*database.Conversation) string {
-	if len(conversations) < 2 {
-		return "0s"
-	}
-
-	var totalGap time.Duration
-	var gapCount int
-
-	for i := 0; i < len(conversations)-1; i++ {
-		if conversations[i].MessageType == "user" && conversations[i+1].MessageType == "assistant" {
-			gap := conversations[i+1].Timestamp.Sub(conversations[i].Timestamp)
-			totalGap += gap
-			gapCount++
-		}
-	}
-
-	if gapCount == 0 {
-		return "0s"
-	}
-
-	avgGap := totalGap / time.Duration(gapCount)
-	return avgGap.String()
-}
-
-// countCompressionEvents counts compression-related events
-func countCompressionEvents(events []*database.Event) int {
-	count := 0
-	for _, event := range events {
-		if event.EventType == "compression" || strings.Contains(event.EventType, "compress") {
-			count++
-		}
-	}
-	return count
-}
-
-// countToolUsage counts tool usage in conversations
-func countToolUsage(conversations []*database.Conversation) int {
-	count := 0
-	for _, conv := range conversations {
-		// Look for tool usage indicators in content
-		content := strings.ToLower(conv.Content)
-		if strings.Contains(content, "tool_calls") ||
-		   strings.Contains(content, "function_call") ||
-		   strings.Contains(content, "<function_calls>") {
-			count++
-		}
-	}
-	return count
-}
-
 // generateSessionTags creates tags based on session characteristics
-func generateSessionTags(session *database.Session, conversations []*database.Conversation, events []*database.Event) []string {
+func generateSessionTags(session *database.Session, conversations int, hasCodeContent bool) []string {
 	var tags []string
 
 	// Duration-based tags
@@ -317,9 +300,9 @@ func generateSessionTags(session *database.Session, conversations []*database.Co
 	}
 
 	// Activity-based tags
-	if len(conversations) >= 10 {
+	if conversations >= 10 {
 		tags = append(tags, "active-conversation")
-	} else if len(conversations) <= 3 {
+	} else if conversations <= 3 {
 		tags = append(tags, "brief-interaction")
 	}
 
@@ -346,13 +329,6 @@ func generateSessionTags(session *database.Session, conversations []*database.Co
 	}
 
 	// Content-based tags
-	hasCodeContent := false
-	for _, conv := range conversations {
-		if strings.Contains(conv.Content, "```") || strings.Contains(conv.Content, "function") {
-			hasCodeContent = true
-			break
-		}
-	}
 	if hasCodeContent {
 		tags = append(tags, "coding-session")
 	}
@@ -360,45 +336,6 @@ func generateSessionTags(session *database.Session, conversations []*database.Co
 	return tags
 }
 
-// getFirstPrompt extracts the first user prompt
-func getFirstPrompt(conversations []*database.Conversation) string {
-	for _, conv := range conversations {
-		if conv.MessageType == "user" {
-			preview := conv.Content
-			if len(preview) > 100 {
-				preview = preview[:97] + "..."
-			}
-			return preview
-		}
-	}
-	return ""
-}
-
-// getLastActivity finds the timestamp of the last activity
-func getLastActivity(conversations []*database.Conversation, events []*database.Event) string {
-	var lastTime time.Time
-
-	// Check conversations
-	for _, conv := range conversations {
-		if conv.Timestamp.After(lastTime) {
-			lastTime = conv.Timestamp
-		}
-	}
-
-	// Check events
-	for _, event := range events {
-		if event.Timestamp.After(lastTime) {
-			lastTime = event.Timestamp
-		}
-	}
-
-	if lastTime.IsZero() {
-		return ""
-	}
-
-	return lastTime.Format("2006-01-02 15:04:05")
-}
-
 // ValidateColumns checks if the specified columns are valid
 func ValidateColumns(columns []string) error {
 	validColumns := make(map[string]bool)
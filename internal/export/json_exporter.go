@@ -1,12 +1,16 @@
 package export
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"iter"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -25,124 +29,202 @@ func NewJSONExporter() *JSONExporter {
 	}
 }
 
-// Export performs the JSON export operation
-func (e *JSONExporter) Export(ctx context.Context, backend database.DatabaseBackend, sessions []*database.Session, options *ExportOptions) error {
+// Export performs the JSON export operation. Sessions are written as they
+// are read and their conversations and events are streamed, so memory use
+// doesn't grow with the export.
+func (e *JSONExporter) Export(ctx context.Context, backend database.DatabaseBackend, sessions iter.Seq2[*database.Session, error], options *ExportOptions) error {
 	// Validate options
 	if err := e.ValidateOptions(options); err != nil {
 		return fmt.Errorf("invalid export options: %w", err)
 	}
 
-	// Prepare session data
-	exportData, err := PrepareSessionData(ctx, backend, sessions)
-	if err != nil {
-		return fmt.Errorf("failed to prepare session data: %w", err)
-	}
-
-	if len(exportData) == 0 {
+	// Single session export - simplified structure
+	if len(options.Sessions) == 1 {
+		for session, err := range sessions {
+			if err != nil {
+				return fmt.Errorf("failed to read sessions: %w", err)
+			}
+			return e.exportSingleSession(ctx, backend, session, options)
+		}
 		return fmt.Errorf("no data to export")
 	}
 
-	// Check if this is a single-session export
-	var exportStructure interface{}
-	if len(options.Sessions) == 1 && len(exportData) == 1 {
-		// Single session export - simplified structure
-		exportStructure = map[string]interface{}{
-			"session_metadata": map[string]interface{}{
-				"id":           exportData[0].SessionID,
-				"project_name": exportData[0].ProjectName,
-				"working_dir":  exportData[0].WorkingDir,
-				"start_time":   exportData[0].StartTime,
-				"end_time":     exportData[0].EndTime,
-				"duration":     exportData[0].Duration,
-				"status":       exportData[0].Status,
-				"export_time":  time.Now(),
-			},
-			"conversation_flow": e.buildConversationFlow(exportData[0]),
-			"analytics": map[string]interface{}{
-				"total_events":    exportData[0].EventCount,
-				"user_prompts":    exportData[0].UserPrompts,
-				"claude_replies":  exportData[0].ClaudeReplies,
-				"total_words":     exportData[0].TotalWords,
-				"user_words":      exportData[0].UserWords,
-				"claude_words":    exportData[0].ClaudeWords,
-			},
+	// Multi-session export - full structure. The metadata comes last, as
+	// the session count is only known once all sessions are written.
+	var out *jsonStream
+	count := 0
+	for session, err := range sessions {
+		if err != nil {
+			return fmt.Errorf("failed to read sessions: %w", err)
 		}
-	} else {
-		// Multi-session export - full structure
-		exportStructure = map[string]interface{}{
-			"metadata": ExportMetadata{
-				ExportTime:    time.Now(),
-				ExportVersion: "1.0.0",
-				Format:        "json",
-				SessionCount:  len(exportData),
-				TotalRecords:  len(exportData),
-				FilePath:      options.Output,
-				Options:       options,
-			},
-			"sessions": exportData,
+
+		if out == nil {
+			if out, err = createJSONStream(options); err != nil {
+				return err
+			}
+			defer out.abort()
+			out.raw("{")
+			out.newline(1)
+			out.key("sessions")
+			out.raw("[")
+		} else {
+			out.raw(",")
+		}
+		out.newline(2)
+		if err := e.writeSession(ctx, backend, out, session, 2); err != nil {
+			return err
 		}
+		count++
 	}
 
-	// Determine if compression is needed
-	useCompression := options.Compress || strings.HasSuffix(strings.ToLower(options.Output), ".gz")
+	if out == nil {
+		return fmt.Errorf("no data to export")
+	}
 
-	// Create output file
-	file, err := os.Create(options.Output)
+	out.newline(1)
+	out.raw("],")
+	out.newline(1)
+	out.key("metadata")
+	out.value(ExportMetadata{
+		ExportTime:    time.Now(),
+		ExportVersion: "1.0.0",
+		Format:        "json",
+		SessionCount:  count,
+		TotalRecords:  count,
+		FilePath:      options.Output,
+		Options:       options,
+	}, 1)
+	out.newline(0)
+	out.raw("}\n")
+	return out.close()
+}
+
+// writeSession writes a session's summary with its conversations, events
+// and tool invocations
+func (e *JSONExporter) writeSession(ctx context.Context, backend database.DatabaseBackend, out *jsonStream, session *database.Session, depth int) error {
+	data, err := SummarizeSession(ctx, backend, session)
+	if err != nil {
+		return fmt.Errorf("failed to prepare session data: %w", err)
+	}
+	invocations, err := backend.GetToolInvocationsBySession(ctx, session.ID)
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return fmt.Errorf("failed to get tool invocations of %s: %w", session.ID, err)
 	}
-	defer file.Close()
 
-	// Create writer (with or without compression)
-	var writer io.Writer = file
-	var gzipWriter *gzip.Writer
+	// Leave the summary object open for the streamed fields
+	out.openObject(data, depth)
 
-	if useCompression {
-		gzipWriter = gzip.NewWriter(file)
-		writer = gzipWriter
-		defer gzipWriter.Close()
+	conversations := out.arrayField("conversations", depth+1)
+	for conv, err := range backend.IterConversationsBySession(ctx, session.ID) {
+		if err != nil {
+			return fmt.Errorf("failed to read conversations of %s: %w", session.ID, err)
+		}
+		conversations.add(conv)
 	}
+	conversations.close()
 
-	// Create JSON encoder
-	encoder := json.NewEncoder(writer)
-	if options.Pretty {
-		encoder.SetIndent("", "  ")
+	events := out.arrayField("events", depth+1)
+	for event, err := range backend.IterEventsBySession(ctx, session.ID) {
+		if err != nil {
+			return fmt.Errorf("failed to read events of %s: %w", session.ID, err)
+		}
+		events.add(event)
 	}
+	events.close()
 
-	// Write JSON data
-	if err := encoder.Encode(exportStructure); err != nil {
-		return fmt.Errorf("failed to write JSON data: %w", err)
+	tools := out.arrayField("tool_invocations", depth+1)
+	for _, inv := range invocations {
+		tools.add(inv)
 	}
+	tools.close()
 
-	// Flush gzip if used
-	if gzipWriter != nil {
-		if err := gzipWriter.Close(); err != nil {
-			return fmt.Errorf("failed to close compression: %w", err)
-		}
+	out.newline(depth)
+	out.raw("}")
+	return out.err
+}
+
+// exportSingleSession writes one session with its conversation flow
+func (e *JSONExporter) exportSingleSession(ctx context.Context, backend database.DatabaseBackend, session *database.Session, options *ExportOptions) error {
+	data, err := SummarizeSession(ctx, backend, session)
+	if err != nil {
+		return fmt.Errorf("failed to prepare session data: %w", err)
+	}
+	invocations, err := backend.GetToolInvocationsBySession(ctx, session.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get tool invocations of %s: %w", session.ID, err)
 	}
 
-	return nil
+	out, err := createJSONStream(options)
+	if err != nil {
+		return err
+	}
+	defer out.abort()
+
+	out.raw("{")
+	out.newline(1)
+	out.key("analytics")
+	out.value(map[string]interface{}{
+		"total_events":   data.EventCount,
+		"user_prompts":   data.UserPrompts,
+		"claude_replies": data.ClaudeReplies,
+		"total_words":    data.TotalWords,
+		"user_words":     data.UserWords,
+		"claude_words":   data.ClaudeWords,
+		"tool_usage":     data.ToolUsageCount,
+	}, 1)
+
+	if err := e.writeConversationFlow(ctx, backend, out, session.ID, invocations); err != nil {
+		return err
+	}
+
+	out.raw(",")
+	out.newline(1)
+	out.key("session_metadata")
+	out.value(map[string]interface{}{
+		"id":           data.SessionID,
+		"project_name": data.ProjectName,
+		"working_dir":  data.WorkingDir,
+		"start_time":   data.StartTime,
+		"end_time":     data.EndTime,
+		"duration":     data.Duration,
+		"status":       data.Status,
+		"export_time":  time.Now(),
+	}, 1)
+	out.newline(0)
+	out.raw("}\n")
+	return out.close()
 }
 
-// buildConversationFlow creates a chronological conversation flow for single-session export
-func (e *JSONExporter) buildConversationFlow(session *SessionExportData) []map[string]interface{} {
-	var flow []map[string]interface{}
+// writeConversationFlow writes the conversations, events and tool
+// invocations of a session as one flow for single-session export
+func (e *JSONExporter) writeConversationFlow(ctx context.Context, backend database.DatabaseBackend, out *jsonStream, sessionID string, invocations []*database.ToolInvocation) error {
+	out.raw(",")
+	out.newline(1)
+	out.key("conversation_flow")
+
+	flow := out.arrayField("", 1)
 
 	// Add conversations in chronological order
-	for _, conv := range session.Conversations {
-		flow = append(flow, map[string]interface{}{
-			"timestamp":    conv.Timestamp,
-			"type":         conv.MessageType,
-			"content":      conv.Content,
-			"token_count":  conv.TokenCount,
-			"model":        conv.Model,
-			"metadata":     conv.Metadata,
+	for conv, err := range backend.IterConversationsBySession(ctx, sessionID) {
+		if err != nil {
+			return fmt.Errorf("failed to read conversations of %s: %w", sessionID, err)
+		}
+		flow.add(map[string]interface{}{
+			"timestamp":   conv.Timestamp,
+			"type":        conv.MessageType,
+			"content":     conv.Content,
+			"token_count": conv.TokenCount,
+			"model":       conv.Model,
+			"metadata":    conv.Metadata,
 		})
 	}
 
 	// Add events in chronological order
-	for _, event := range session.Events {
-		flow = append(flow, map[string]interface{}{
+	for event, err := range backend.IterEventsBySession(ctx, sessionID) {
+		if err != nil {
+			return fmt.Errorf("failed to read events of %s: %w", sessionID, err)
+		}
+		flow.add(map[string]interface{}{
 			"timestamp":    event.Timestamp,
 			"type":         "event:" + event.EventType,
 			"data":         event.Data,
@@ -150,9 +232,176 @@ func (e *JSONExporter) buildConversationFlow(session *SessionExportData) []map[s
 		})
 	}
 
-	// Sort by timestamp
-	// Note: In a real implementation, we'd sort this slice by timestamp
-	return flow
+	// Add tool invocations in chronological order
+	for _, inv := range invocations {
+		flow.add(map[string]interface{}{
+			"timestamp":   inv.StartedAt,
+			"type":        "tool:" + inv.ToolName,
+			"input":       inv.Input,
+			"output":      inv.Output,
+			"success":     inv.Success,
+			"duration_ms": inv.DurationMs,
+		})
+	}
+
+	// An empty flow was encoded as null
+	if flow.items == 0 {
+		out.raw("null")
+	}
+	flow.close()
+	return out.err
+}
+
+// jsonStream writes a JSON document piece by piece to the export file,
+// indenting like json.Encoder.SetIndent("", "  ") when pretty. The first
+// write error is kept and returned by close.
+type jsonStream struct {
+	file   *os.File
+	gzip   *gzip.Writer
+	writer *bufio.Writer
+	pretty bool
+	err    error
+}
+
+// createJSONStream creates the output file, compressed if asked for or
+// named .gz
+func createJSONStream(options *ExportOptions) (*jsonStream, error) {
+	file, err := os.Create(options.Output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+
+	out := &jsonStream{file: file, pretty: options.Pretty}
+	var writer io.Writer = file
+	if options.Compress || strings.HasSuffix(strings.ToLower(options.Output), ".gz") {
+		out.gzip = gzip.NewWriter(file)
+		writer = out.gzip
+	}
+	out.writer = bufio.NewWriter(writer)
+	return out, nil
+}
+
+// raw writes text as is
+func (s *jsonStream) raw(text string) {
+	if s.err == nil {
+		_, s.err = s.writer.WriteString(text)
+	}
+}
+
+// newline starts a line at depth when pretty
+func (s *jsonStream) newline(depth int) {
+	if s.pretty {
+		s.raw("\n" + strings.Repeat("  ", depth))
+	}
+}
+
+// key writes an object key
+func (s *jsonStream) key(name string) {
+	s.raw(strconv.Quote(name) + ":")
+	if s.pretty {
+		s.raw(" ")
+	}
+}
+
+// marshal encodes v for a value at depth
+func (s *jsonStream) marshal(v any, depth int) []byte {
+	if s.err != nil {
+		return nil
+	}
+	var data []byte
+	if s.pretty {
+		data, s.err = json.MarshalIndent(v, strings.Repeat("  ", depth), "  ")
+	} else {
+		data, s.err = json.Marshal(v)
+	}
+	return data
+}
+
+// value writes v at depth
+func (s *jsonStream) value(v any, depth int) {
+	if data := s.marshal(v, depth); s.err == nil {
+		_, s.err = s.writer.Write(data)
+	}
+}
+
+// openObject writes v, which must encode as a non-empty object, without
+// its closing brace
+func (s *jsonStream) openObject(v any, depth int) {
+	data := s.marshal(v, depth)
+	if s.err == nil {
+		data = bytes.TrimRight(bytes.TrimSuffix(data, []byte("}")), " \n")
+		_, s.err = s.writer.Write(data)
+	}
+}
+
+// arrayField starts an array in an open object. Like an omitempty field,
+// nothing is written until its first item. An empty name continues an
+// array whose key was already written.
+func (s *jsonStream) arrayField(name string, depth int) *jsonArrayField {
+	return &jsonArrayField{stream: s, name: name, depth: depth}
+}
+
+// jsonArrayField writes the items of an array field one at a time
+type jsonArrayField struct {
+	stream *jsonStream
+	name   string
+	depth  int
+	items  int
+}
+
+// add writes an item
+func (f *jsonArrayField) add(v any) {
+	if f.items == 0 {
+		if f.name != "" {
+			f.stream.raw(",")
+			f.stream.newline(f.depth)
+			f.stream.key(f.name)
+		}
+		f.stream.raw("[")
+	} else {
+		f.stream.raw(",")
+	}
+	f.stream.newline(f.depth + 1)
+	f.stream.value(v, f.depth+1)
+	f.items++
+}
+
+// close ends the array, if it was started
+func (f *jsonArrayField) close() {
+	if f.items > 0 {
+		f.stream.newline(f.depth)
+		f.stream.raw("]")
+	}
+}
+
+// close flushes and closes the output file
+func (s *jsonStream) close() error {
+	if s.err == nil {
+		s.err = s.writer.Flush()
+	}
+	if s.gzip != nil {
+		if err := s.gzip.Close(); err != nil && s.err == nil {
+			s.err = fmt.Errorf("failed to close compression: %w", err)
+		}
+		s.gzip = nil
+	}
+	if s.file != nil {
+		if err := s.file.Close(); err != nil && s.err == nil {
+			s.err = err
+		}
+		s.file = nil
+	}
+	if s.err != nil {
+		return fmt.Errorf("failed to write JSON data: %w", s.err)
+	}
+	return nil
+}
+
+// abort closes the output file if close wasn't reached
+func (s *jsonStream) abort() {
+	if s.file != nil {
+		s.file.Close()
+	}
 }
 
 // GetSupportedColumns returns the list of columns this exporter supports
@@ -0,0 +1,111 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"context-extender/internal/database"
+)
+
+func newExportTestBackend(t *testing.T) database.DatabaseBackend {
+	t.Helper()
+	backend := database.NewMemoryBackend()
+	ctx := context.Background()
+	if err := backend.Initialize(ctx, &database.DatabaseConfig{Backend: database.BackendMemory}); err != nil {
+		t.Fatalf("Failed to initialize backend: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+
+	start := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	for i := 1; i <= 3; i++ {
+		id := fmt.Sprintf("session-%d", i)
+		if err := backend.CreateSession(ctx, &database.Session{
+			ID: id, CreatedAt: start, UpdatedAt: start.Add(time.Minute), Status: "completed",
+			Metadata: `{"working_directory":"/src/app"}`,
+		}); err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		// The last session has nothing recorded
+		for j := 0; j < 2 && i < 3; j++ {
+			if err := backend.CreateConversation(ctx, &database.Conversation{
+				ID: fmt.Sprintf("%s-%d", id, j), SessionID: id, MessageType: []string{"user", "assistant"}[j],
+				Content: "<b>hello</b> world", Timestamp: start.Add(time.Duration(j) * time.Second),
+			}); err != nil {
+				t.Fatalf("Failed to create conversation: %v", err)
+			}
+		}
+	}
+	return backend
+}
+
+func TestJSONExportStreams(t *testing.T) {
+	ctx := context.Background()
+	backend := newExportTestBackend(t)
+
+	for _, pretty := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pretty=%v", pretty), func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "export.json")
+			options := &ExportOptions{Format: "json", Output: output, Pretty: pretty}
+			if err := NewJSONExporter().Export(ctx, backend, backend.IterSessions(ctx, nil), options); err != nil {
+				t.Fatalf("Export failed: %v", err)
+			}
+
+			data, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatalf("Failed to read export: %v", err)
+			}
+			var exported struct {
+				Sessions []*SessionExportData `json:"sessions"`
+				Metadata ExportMetadata       `json:"metadata"`
+			}
+			if err := json.Unmarshal(data, &exported); err != nil {
+				t.Fatalf("Export is not valid JSON: %v\n%s", err, data)
+			}
+			if exported.Metadata.SessionCount != 3 || len(exported.Sessions) != 3 {
+				t.Fatalf("Expected 3 sessions, got %d (count %d)", len(exported.Sessions), exported.Metadata.SessionCount)
+			}
+			first := exported.Sessions[0]
+			if len(first.Conversations) != 2 || first.UserPrompts != 1 || first.ClaudeReplies != 1 || first.AvgResponseTime != "1s" {
+				t.Errorf("Unexpected first session: %+v", first)
+			}
+			if exported.Sessions[2].Conversations != nil {
+				t.Errorf("Expected no conversations field for an empty session")
+			}
+
+			// The output is formatted as json.Encoder would
+			var want bytes.Buffer
+			if pretty {
+				err = json.Indent(&want, data, "", "  ")
+			} else {
+				err = json.Compact(&want, data)
+			}
+			if err != nil {
+				t.Fatalf("Failed to reformat export: %v", err)
+			}
+			if !bytes.Equal(bytes.TrimSpace(want.Bytes()), bytes.TrimSpace(data)) {
+				t.Errorf("Export isn't formatted like json.Encoder:\n%s", data)
+			}
+		})
+	}
+}
+
+func TestJSONExportEmpty(t *testing.T) {
+	ctx := context.Background()
+	backend := newExportTestBackend(t)
+
+	output := filepath.Join(t.TempDir(), "export.json")
+	options := &ExportOptions{Format: "json", Output: output}
+	sessions := backend.IterSessions(ctx, &database.SessionFilters{Status: "error"})
+	if err := NewJSONExporter().Export(ctx, backend, sessions, options); err == nil {
+		t.Fatal("Expected an error for an empty export")
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("Expected no output file, got %v", err)
+	}
+}
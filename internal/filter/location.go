@@ -0,0 +1,151 @@
+package filter
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"context-extender/internal/config"
+)
+
+// IgnoreMarker is a file that turns off capture in its directory and every
+// directory below it
+const IgnoreMarker = ".context-extender-ignore"
+
+// Location describes where Claude Code is running, as seen by the rules
+type Location struct {
+	Dir          string   // working directory, absolute
+	ProjectRoot  string   // see config.FindProjectRoot
+	Project      string   // base name of the project root
+	GitRemotes   []string // normalized remote URLs, e.g. github.com/owner/repo
+	IgnoreMarker string   // path of the closest ignore marker, if any
+}
+
+// ResolveLocation gathers what the rules match on for a working directory
+func ResolveLocation(dir string) *Location {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+
+	root := config.FindProjectRoot(dir)
+	return &Location{
+		Dir:          dir,
+		ProjectRoot:  root,
+		Project:      filepath.Base(root),
+		GitRemotes:   gitRemotes(dir),
+		IgnoreMarker: findIgnoreMarker(dir),
+	}
+}
+
+// findIgnoreMarker returns the closest ignore marker at or above dir
+func findIgnoreMarker(dir string) string {
+	for current := dir; ; {
+		marker := filepath.Join(current, IgnoreMarker)
+		if _, err := os.Stat(marker); err == nil {
+			return marker
+		}
+
+		parent := filepath.Dir(current)
+		if parent == current {
+			return ""
+		}
+		current = parent
+	}
+}
+
+// gitRemotes returns the remote URLs of the repository containing dir. The
+// git config is read directly, so git doesn't need to be installed.
+func gitRemotes(dir string) []string {
+	gitDir := findGitDir(dir)
+	if gitDir == "" {
+		return nil
+	}
+
+	file, err := os.Open(filepath.Join(gitDir, "config"))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var remotes []string
+	inRemote := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inRemote = strings.HasPrefix(line, "[remote ")
+			continue
+		}
+		if !inRemote {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if found && strings.TrimSpace(key) == "url" {
+			remotes = append(remotes, NormalizeGitRemote(strings.TrimSpace(value)))
+		}
+	}
+	return remotes
+}
+
+// findGitDir returns the git directory of the repository containing dir,
+// following the .git file used by worktrees and submodules
+func findGitDir(dir string) string {
+	for current := dir; ; {
+		gitPath := filepath.Join(current, ".git")
+		if info, err := os.Stat(gitPath); err == nil {
+			if info.IsDir() {
+				return gitPath
+			}
+			if data, err := os.ReadFile(gitPath); err == nil {
+				target := strings.TrimSpace(strings.TrimPrefix(string(data), "gitdir:"))
+				if !filepath.IsAbs(target) {
+					target = filepath.Join(current, target)
+				}
+				// Worktrees keep the shared config in the common directory
+				if common, err := os.ReadFile(filepath.Join(target, "commondir")); err == nil {
+					commonDir := strings.TrimSpace(string(common))
+					if !filepath.IsAbs(commonDir) {
+						commonDir = filepath.Join(target, commonDir)
+					}
+					return filepath.Clean(commonDir)
+				}
+				return target
+			}
+		}
+
+		parent := filepath.Dir(current)
+		if parent == current {
+			return ""
+		}
+		current = parent
+	}
+}
+
+// NormalizeGitRemote turns the different spellings of a remote URL into
+// host/path, so one pattern matches SSH and HTTPS remotes alike:
+//
+//	git@github.com:owner/repo.git      -> github.com/owner/repo
+//	https://user@github.com/owner/repo -> github.com/owner/repo
+func NormalizeGitRemote(url string) string {
+	if _, rest, found := strings.Cut(url, "://"); found {
+		url = rest
+	} else if host, path, found := strings.Cut(url, ":"); found && !strings.Contains(host, "/") {
+		// scp-like syntax: [user@]host:path
+		url = host + "/" + path
+	}
+
+	if at := strings.Index(url, "@"); at >= 0 && at < strings.Index(url+"/", "/") {
+		url = url[at+1:]
+	}
+
+	url = strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git")
+
+	// Host names are case-insensitive, paths may not be
+	host, path, _ := strings.Cut(url, "/")
+	if path == "" {
+		return strings.ToLower(host)
+	}
+	return strings.ToLower(host) + "/" + path
+}
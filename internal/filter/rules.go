@@ -0,0 +1,273 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"context-extender/internal/config"
+)
+
+// RulesFileName is the rules file in the context-extender config directory
+const RulesFileName = "capture-rules.json"
+
+// Action is what happens to a capture matched by a rule
+type Action string
+
+const (
+	// ActionInclude captures the event
+	ActionInclude Action = "include"
+	// ActionExclude drops the event before anything is written
+	ActionExclude Action = "exclude"
+)
+
+// Rule matches captures by where they happen and, optionally, by prompt
+// text. Every condition that is set must match. Patterns are globs where *
+// matches within a path segment and ** across segments; ~ is the home
+// directory.
+type Rule struct {
+	Name      string `json:"name,omitempty"`
+	Action    Action `json:"action"`
+	CWD       string `json:"cwd,omitempty"`        // matches the working directory or any parent
+	GitRemote string `json:"git_remote,omitempty"` // matches any normalized remote URL
+	Project   string `json:"project,omitempty"`    // matches the project directory name
+	Prompt    string `json:"prompt,omitempty"`     // regular expression on prompt text
+
+	prompt *regexp.Regexp
+}
+
+// Rules is the capture rules file. Rules without a prompt condition decide
+// whether a location is captured at all, first match wins. Rules with a
+// prompt condition then decide, again first match wins, whether individual
+// prompts in a captured location are stored.
+type Rules struct {
+	Default Action `json:"default,omitempty"` // for locations no rule matches, include when unset
+	Rules   []Rule `json:"rules"`
+
+	// Path is the file the rules were loaded from, empty when there is none
+	Path string `json:"-"`
+}
+
+// Decision is the outcome of evaluating the rules
+type Decision struct {
+	Capture bool
+	Rule    *Rule // the matching rule, nil when the default or marker applied
+	Index   int   // position of Rule in the rules file
+	Reason  string
+}
+
+// GetRulesPath returns the path of the capture rules file
+func GetRulesPath() (string, error) {
+	configPath, err := config.GetContextExtenderConfigPath()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configPath, RulesFileName), nil
+}
+
+// LoadRules loads the capture rules file. Without a rules file everything
+// is captured.
+func LoadRules() (*Rules, error) {
+	rulesPath, err := GetRulesPath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rules path: %w", err)
+	}
+
+	return LoadRulesFrom(rulesPath)
+}
+
+// LoadRulesFrom loads and validates a rules file
+func LoadRulesFrom(rulesPath string) (*Rules, error) {
+	data, err := os.ReadFile(rulesPath)
+	if os.IsNotExist(err) {
+		return &Rules{Default: ActionInclude}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+
+	var rules Rules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse rules file %s: %w", rulesPath, err)
+	}
+	rules.Path = rulesPath
+
+	if err := rules.compile(); err != nil {
+		return nil, fmt.Errorf("invalid rules file %s: %w", rulesPath, err)
+	}
+	return &rules, nil
+}
+
+// compile validates the rules and compiles prompt patterns
+func (r *Rules) compile() error {
+	switch r.Default {
+	case "":
+		r.Default = ActionInclude
+	case ActionInclude, ActionExclude:
+	default:
+		return fmt.Errorf("default must be %q or %q, got %q", ActionInclude, ActionExclude, r.Default)
+	}
+
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		if rule.Action != ActionInclude && rule.Action != ActionExclude {
+			return fmt.Errorf("rule %d: action must be %q or %q, got %q", i+1, ActionInclude, ActionExclude, rule.Action)
+		}
+		if rule.CWD == "" && rule.GitRemote == "" && rule.Project == "" && rule.Prompt == "" {
+			return fmt.Errorf("rule %d: needs at least one of cwd, git_remote, project or prompt", i+1)
+		}
+		if rule.Prompt != "" {
+			pattern, err := regexp.Compile(rule.Prompt)
+			if err != nil {
+				return fmt.Errorf("rule %d: invalid prompt pattern: %w", i+1, err)
+			}
+			rule.prompt = pattern
+		}
+	}
+	return nil
+}
+
+// Evaluate decides whether anything is captured in a location
+func (r *Rules) Evaluate(loc *Location) Decision {
+	if loc.IgnoreMarker != "" {
+		return Decision{Capture: false, Index: -1, Reason: "ignore marker " + loc.IgnoreMarker}
+	}
+
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		if rule.Prompt == "" && rule.matchesLocation(loc) {
+			return Decision{Capture: rule.Action == ActionInclude, Rule: rule, Index: i, Reason: rule.describe(i)}
+		}
+	}
+
+	return Decision{Capture: r.Default != ActionExclude, Index: -1, Reason: fmt.Sprintf("default (%s)", r.Default)}
+}
+
+// EvaluatePrompt decides whether a prompt is stored. Prompts are only
+// stored in locations Evaluate captures.
+func (r *Rules) EvaluatePrompt(loc *Location, prompt string) Decision {
+	decision := r.Evaluate(loc)
+	if !decision.Capture {
+		return decision
+	}
+
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		if rule.prompt != nil && rule.prompt.MatchString(prompt) && rule.matchesLocation(loc) {
+			return Decision{Capture: rule.Action == ActionInclude, Rule: rule, Index: i, Reason: rule.describe(i)}
+		}
+	}
+	return decision
+}
+
+// HasPromptRules reports whether any rule looks at prompt text
+func (r *Rules) HasPromptRules() bool {
+	for _, rule := range r.Rules {
+		if rule.Prompt != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesLocation reports whether the rule's location conditions match
+func (rule *Rule) matchesLocation(loc *Location) bool {
+	if rule.CWD != "" && !matchesDirOrParent(rule.CWD, loc.Dir) {
+		return false
+	}
+	if rule.Project != "" && !matchGlob(rule.Project, loc.Project) {
+		return false
+	}
+	if rule.GitRemote != "" {
+		pattern := NormalizeGitRemote(rule.GitRemote)
+		matched := false
+		for _, remote := range loc.GitRemotes {
+			if matchGlob(pattern, remote) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// describe names a rule for output
+func (rule *Rule) describe(index int) string {
+	name := rule.Name
+	if name == "" {
+		var conditions []string
+		for _, condition := range []struct{ key, value string }{
+			{"cwd", rule.CWD}, {"git_remote", rule.GitRemote}, {"project", rule.Project}, {"prompt", rule.Prompt},
+		} {
+			if condition.value != "" {
+				conditions = append(conditions, fmt.Sprintf("%s=%q", condition.key, condition.value))
+			}
+		}
+		name = strings.Join(conditions, " ")
+	}
+	return fmt.Sprintf("rule %d (%s %s)", index+1, rule.Action, name)
+}
+
+// matchesDirOrParent matches a directory pattern against dir and each of
+// its parents, so a pattern for a directory covers everything below it
+func matchesDirOrParent(pattern, dir string) bool {
+	pattern = expandHome(pattern)
+	for current := dir; ; {
+		if matchGlob(pattern, current) {
+			return true
+		}
+
+		parent := filepath.Dir(current)
+		if parent == current {
+			return false
+		}
+		current = parent
+	}
+}
+
+// expandHome replaces a leading ~ with the home directory
+func expandHome(pattern string) string {
+	if pattern != "~" && !strings.HasPrefix(pattern, "~/") && !strings.HasPrefix(pattern, `~\`) {
+		return pattern
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return pattern
+	}
+	return home + pattern[1:]
+}
+
+// matchGlob matches a glob against a path using forward slashes
+func matchGlob(pattern, value string) bool {
+	pattern = strings.TrimSuffix(filepath.ToSlash(pattern), "/")
+	value = filepath.ToSlash(value)
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				expr.WriteString(".*")
+				i++
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	matched, err := regexp.MatchString(expr.String(), value)
+	return err == nil && matched
+}
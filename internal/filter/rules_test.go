@@ -0,0 +1,164 @@
+package filter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeRules writes a rules file into an isolated config directory
+func writeRules(t *testing.T, content string) {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("APPDATA", filepath.Join(home, "AppData"))
+
+	rulesPath, err := GetRulesPath()
+	if err != nil {
+		t.Fatalf("Failed to get rules path: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(rulesPath), 0755); err != nil {
+		t.Fatalf("Failed to create config directory: %v", err)
+	}
+	if err := os.WriteFile(rulesPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write rules: %v", err)
+	}
+}
+
+func TestNormalizeGitRemote(t *testing.T) {
+	tests := map[string]string{
+		"git@GitHub.com:owner/repo.git":                    "github.com/owner/repo",
+		"https://github.com/owner/repo":                    "github.com/owner/repo",
+		"https://user@github.com/owner/repo.git":           "github.com/owner/repo",
+		"ssh://git@gitlab.example.com/group/sub/repo.git/": "gitlab.example.com/group/sub/repo",
+	}
+
+	for url, expected := range tests {
+		if got := NormalizeGitRemote(url); got != expected {
+			t.Errorf("NormalizeGitRemote(%q) = %q, expected %q", url, got, expected)
+		}
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, value string
+		expected       bool
+	}{
+		{"github.com/acme/*", "github.com/acme/api", true},
+		{"github.com/acme/*", "github.com/acme/api/sub", false},
+		{"/work/**", "/work/a/b/c", true},
+		{"scratch-?", "scratch-1", true},
+		{"scratch-?", "scratch-12", false},
+		{"a.b", "axb", false},
+	}
+
+	for _, test := range tests {
+		if got := matchGlob(test.pattern, test.value); got != test.expected {
+			t.Errorf("matchGlob(%q, %q) = %v, expected %v", test.pattern, test.value, got, test.expected)
+		}
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	writeRules(t, `{
+		"default": "include",
+		"rules": [
+			{"action": "include", "cwd": "~/clients/internal-tools"},
+			{"name": "clients", "action": "exclude", "cwd": "~/clients/*"},
+			{"action": "exclude", "git_remote": "git@github.com:acme/*"},
+			{"action": "exclude", "prompt": "(?i)password"},
+			{"action": "include", "project": "vault", "prompt": "^#keep"}
+		]
+	}`)
+
+	rules, err := LoadRules()
+	if err != nil {
+		t.Fatalf("Failed to load rules: %v", err)
+	}
+	home, _ := os.UserHomeDir()
+
+	tests := []struct {
+		name     string
+		location *Location
+		expected bool
+	}{
+		{"earlier include wins", &Location{Dir: filepath.Join(home, "clients", "internal-tools", "cmd")}, true},
+		{"parent directory matches", &Location{Dir: filepath.Join(home, "clients", "acme", "api")}, false},
+		{"git remote", &Location{Dir: "/src/api", GitRemotes: []string{"github.com/acme/api"}}, false},
+		{"default", &Location{Dir: "/src/other"}, true},
+		{"ignore marker", &Location{Dir: "/src/other", IgnoreMarker: "/src/.context-extender-ignore"}, false},
+	}
+	for _, test := range tests {
+		if decision := rules.Evaluate(test.location); decision.Capture != test.expected {
+			t.Errorf("%s: expected capture=%v, got %v (%s)", test.name, test.expected, decision.Capture, decision.Reason)
+		}
+	}
+
+	// Prompt rules apply in captured locations, first match wins
+	other := &Location{Dir: "/src/other", Project: "other"}
+	if rules.EvaluatePrompt(other, "my Password is").Capture {
+		t.Error("Expected prompt with a password to be excluded")
+	}
+	if !rules.EvaluatePrompt(other, "list the files").Capture {
+		t.Error("Expected ordinary prompt to be captured")
+	}
+	vault := &Location{Dir: "/src/vault", Project: "vault"}
+	if !rules.EvaluatePrompt(vault, "#keep this").Capture {
+		t.Error("Expected #keep prompt in vault to be captured")
+	}
+}
+
+func TestLoadRulesValidation(t *testing.T) {
+	writeRules(t, `{"rules": [{"action": "drop", "cwd": "/tmp"}]}`)
+	if _, err := LoadRules(); err == nil {
+		t.Error("Expected an error for an unknown action")
+	}
+
+	writeRules(t, `{"rules": [{"action": "exclude"}]}`)
+	if _, err := LoadRules(); err == nil {
+		t.Error("Expected an error for a rule without conditions")
+	}
+
+	writeRules(t, `{"rules": [{"action": "exclude", "prompt": "("}]}`)
+	if _, err := LoadRules(); err == nil {
+		t.Error("Expected an error for an invalid prompt pattern")
+	}
+}
+
+func TestResolveLocation(t *testing.T) {
+	root := t.TempDir()
+	project := filepath.Join(root, "api")
+	sub := filepath.Join(project, "internal")
+	if err := os.MkdirAll(filepath.Join(project, ".git"), 0755); err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	gitConfig := "[core]\n\tbare = false\n[remote \"origin\"]\n\turl = git@github.com:acme/api.git\n[branch \"main\"]\n\tremote = origin\n"
+	if err := os.WriteFile(filepath.Join(project, ".git", "config"), []byte(gitConfig), 0644); err != nil {
+		t.Fatalf("Failed to write git config: %v", err)
+	}
+
+	location := ResolveLocation(sub)
+	if location.Project != "api" {
+		t.Errorf("Expected project api, got %q", location.Project)
+	}
+	if len(location.GitRemotes) != 1 || location.GitRemotes[0] != "github.com/acme/api" {
+		t.Errorf("Unexpected git remotes: %v", location.GitRemotes)
+	}
+	if location.IgnoreMarker != "" {
+		t.Errorf("Expected no ignore marker, got %s", location.IgnoreMarker)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, IgnoreMarker), nil, 0644); err != nil {
+		t.Fatalf("Failed to write ignore marker: %v", err)
+	}
+	if location := ResolveLocation(sub); location.IgnoreMarker != filepath.Join(root, IgnoreMarker) {
+		t.Errorf("Expected ignore marker from a parent directory, got %q", location.IgnoreMarker)
+	}
+}
@@ -9,12 +9,39 @@ import (
 	"context-extender/internal/config"
 )
 
+// HookTypes lists every Claude Code hook event context-extender installs
+var HookTypes = []string{
+	"SessionStart",
+	"UserPromptSubmit",
+	"PreToolUse",
+	"PostToolUse",
+	"PreCompact",
+	"Stop",
+	"SessionEnd",
+}
+
 // ContextExtenderHooks defines the hooks that context-extender needs to install
 type ContextExtenderHooks struct {
-	SessionStart    config.HookEntry
+	SessionStart     config.HookEntry
 	UserPromptSubmit config.HookEntry
-	Stop            config.HookEntry
-	SessionEnd      config.HookEntry
+	PreToolUse       config.HookEntry
+	PostToolUse      config.HookEntry
+	PreCompact       []config.HookEntry // one entry per compaction trigger
+	Stop             config.HookEntry
+	SessionEnd       config.HookEntry
+}
+
+// ByType returns the hook entries keyed by Claude Code hook event name
+func (h *ContextExtenderHooks) ByType() map[string][]config.HookEntry {
+	return map[string][]config.HookEntry{
+		"SessionStart":     {h.SessionStart},
+		"UserPromptSubmit": {h.UserPromptSubmit},
+		"PreToolUse":       {h.PreToolUse},
+		"PostToolUse":      {h.PostToolUse},
+		"PreCompact":       h.PreCompact,
+		"Stop":             {h.Stop},
+		"SessionEnd":       {h.SessionEnd},
+	}
 }
 
 // GetContextExtenderHooks returns the hook configuration for context-extender
@@ -52,6 +79,52 @@ func GetContextExtenderHooks() (*ContextExtenderHooks, error) {
 				},
 			},
 		},
+		// Tool hooks run around every tool call, so they match all tools
+		// and use a short timeout to keep Claude responsive
+		PreToolUse: config.HookEntry{
+			Matcher: "*",
+			Hooks: []config.HookConfig{
+				{
+					Type:    "command",
+					Command: fmt.Sprintf("%s capture --event=tool-use", execPath),
+					Timeout: 10,
+				},
+			},
+		},
+		PostToolUse: config.HookEntry{
+			Matcher: "*",
+			Hooks: []config.HookConfig{
+				{
+					Type:    "command",
+					Command: fmt.Sprintf("%s capture --event=tool-result", execPath),
+					Timeout: 10,
+				},
+			},
+		},
+		// PreCompact matchers select the compaction trigger, so install
+		// one entry for /compact and one for automatic compaction
+		PreCompact: []config.HookEntry{
+			{
+				Matcher: "manual",
+				Hooks: []config.HookConfig{
+					{
+						Type:    "command",
+						Command: fmt.Sprintf("%s capture --event=conversation-compress", execPath),
+						Timeout: 30,
+					},
+				},
+			},
+			{
+				Matcher: "auto",
+				Hooks: []config.HookConfig{
+					{
+						Type:    "command",
+						Command: fmt.Sprintf("%s capture --event=conversation-compress", execPath),
+						Timeout: 30,
+					},
+				},
+			},
+		},
 		Stop: config.HookEntry{
 			Matcher: "",
 			Hooks: []config.HookConfig{
@@ -77,15 +150,29 @@ func GetContextExtenderHooks() (*ContextExtenderHooks, error) {
 	return hooks, nil
 }
 
-// InstallHooks installs context-extender hooks into Claude Code settings
+// InstallHooks installs context-extender hooks into Claude Code user settings
 func InstallHooks() error {
 	// Validate Claude Code installation
 	if err := config.ValidateClaudeInstallation(); err != nil {
 		return fmt.Errorf("Claude Code validation failed: %w", err)
 	}
 
+	settingsPath, err := config.GetClaudeSettingsPath()
+	if err != nil {
+		return fmt.Errorf("failed to get Claude settings path: %w", err)
+	}
+
+	return InstallHooksAt(settingsPath)
+}
+
+// InstallHooksAt installs context-extender hooks into the settings file at settingsPath
+func InstallHooksAt(settingsPath string) error {
+	if err := config.ValidatePermissions(settingsPath); err != nil {
+		return fmt.Errorf("insufficient permissions for Claude settings: %w", err)
+	}
+
 	// Read existing settings
-	settings, err := config.ReadClaudeSettings()
+	settings, err := config.ReadClaudeSettingsAt(settingsPath)
 	if err != nil {
 		return fmt.Errorf("failed to read Claude settings: %w", err)
 	}
@@ -102,29 +189,22 @@ func InstallHooks() error {
 	}
 
 	// Install each hook type
-	hookTypes := map[string]config.HookEntry{
-		"SessionStart":     contextHooks.SessionStart,
-		"UserPromptSubmit": contextHooks.UserPromptSubmit,
-		"Stop":             contextHooks.Stop,
-		"SessionEnd":       contextHooks.SessionEnd,
-	}
-
-	for hookType, hookEntry := range hookTypes {
-		if err := installSingleHook(settings, hookType, hookEntry); err != nil {
+	for hookType, hookEntries := range contextHooks.ByType() {
+		if err := installSingleHook(settings, hookType, hookEntries...); err != nil {
 			return fmt.Errorf("failed to install %s hook: %w", hookType, err)
 		}
 	}
 
 	// Write updated settings
-	if err := config.WriteClaudeSettings(settings); err != nil {
+	if err := config.WriteClaudeSettingsAt(settingsPath, settings); err != nil {
 		return fmt.Errorf("failed to write Claude settings: %w", err)
 	}
 
 	return nil
 }
 
-// installSingleHook installs a single hook, avoiding duplicates
-func installSingleHook(settings *config.ClaudeSettings, hookType string, hookEntry config.HookEntry) error {
+// installSingleHook installs the entries for a single hook type, avoiding duplicates
+func installSingleHook(settings *config.ClaudeSettings, hookType string, hookEntries ...config.HookEntry) error {
 	// Get existing hooks for this type
 	existingHooks := settings.Hooks[hookType]
 
@@ -154,8 +234,8 @@ func installSingleHook(settings *config.ClaudeSettings, hookType string, hookEnt
 		}
 	}
 
-	// Add our hook
-	filteredHooks = append(filteredHooks, hookEntry)
+	// Add our hooks
+	filteredHooks = append(filteredHooks, hookEntries...)
 
 	// Update settings
 	settings.Hooks[hookType] = filteredHooks
@@ -193,41 +273,58 @@ func containsContextExtender(command, execPath string) bool {
 		   strings.Contains(command, "context-extender")
 }
 
-// UninstallHooks removes context-extender hooks from Claude Code settings
+// UninstallHooks removes context-extender hooks from Claude Code user settings
 func UninstallHooks() error {
+	settingsPath, err := config.GetClaudeSettingsPath()
+	if err != nil {
+		return fmt.Errorf("failed to get Claude settings path: %w", err)
+	}
+
+	_, err = UninstallHooksAt(settingsPath)
+	return err
+}
+
+// UninstallHooksAt removes context-extender hooks from the settings file at
+// settingsPath. It reports whether any hook was removed; the file is left
+// untouched when there was nothing to remove.
+func UninstallHooksAt(settingsPath string) (bool, error) {
 	// Read existing settings
-	settings, err := config.ReadClaudeSettings()
+	settings, err := config.ReadClaudeSettingsAt(settingsPath)
 	if err != nil {
-		return fmt.Errorf("failed to read Claude settings: %w", err)
+		return false, fmt.Errorf("failed to read Claude settings: %w", err)
 	}
 
 	if settings.Hooks == nil {
 		// No hooks to uninstall
-		return nil
+		return false, nil
 	}
 
 	// Get executable path for comparison
 	execPath, err := os.Executable()
 	if err != nil {
-		return fmt.Errorf("failed to get executable path: %w", err)
+		return false, fmt.Errorf("failed to get executable path: %w", err)
 	}
 
 	execPath, err = filepath.Abs(execPath)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path: %w", err)
+		return false, fmt.Errorf("failed to get absolute path: %w", err)
 	}
 
 	// Remove context-extender hooks from each hook type
-	hookTypes := []string{"SessionStart", "UserPromptSubmit", "Stop", "SessionEnd"}
+	removed := false
+	for _, hookType := range HookTypes {
+		existingHooks, exists := settings.Hooks[hookType]
+		if !exists {
+			continue
+		}
 
-	for _, hookType := range hookTypes {
-		existingHooks := settings.Hooks[hookType]
 		var filteredHooks []config.HookEntry
-
 		for _, entry := range existingHooks {
 			var filteredCommands []config.HookConfig
 			for _, hook := range entry.Hooks {
-				if !containsContextExtender(hook.Command, execPath) {
+				if containsContextExtender(hook.Command, execPath) {
+					removed = true
+				} else {
 					filteredCommands = append(filteredCommands, hook)
 				}
 			}
@@ -247,64 +344,99 @@ func UninstallHooks() error {
 		}
 	}
 
+	if !removed {
+		return false, nil
+	}
+
 	// Write updated settings
-	if err := config.WriteClaudeSettings(settings); err != nil {
-		return fmt.Errorf("failed to write Claude settings: %w", err)
+	if err := config.WriteClaudeSettingsAt(settingsPath, settings); err != nil {
+		return false, fmt.Errorf("failed to write Claude settings: %w", err)
 	}
 
-	return nil
+	return true, nil
 }
 
-// IsInstalled checks if context-extender hooks are currently installed
+// IsInstalled checks if context-extender hooks are currently installed in user settings
 func IsInstalled() (bool, error) {
-	// Read existing settings
-	settings, err := config.ReadClaudeSettings()
+	settingsPath, err := config.GetClaudeSettingsPath()
 	if err != nil {
-		return false, fmt.Errorf("failed to read Claude settings: %w", err)
+		return false, fmt.Errorf("failed to get Claude settings path: %w", err)
 	}
 
-	if settings.Hooks == nil {
-		return false, nil
-	}
+	return IsInstalledAt(settingsPath)
+}
 
-	// Get executable path for comparison
-	execPath, err := os.Executable()
+// IsInstalledAt checks if context-extender hooks are installed in the settings file at settingsPath
+func IsInstalledAt(settingsPath string) (bool, error) {
+	status, err := GetInstallationStatusAt(settingsPath)
 	if err != nil {
-		return false, fmt.Errorf("failed to get executable path: %w", err)
+		return false, err
 	}
 
-	execPath, err = filepath.Abs(execPath)
+	return isInstalled(status), nil
+}
+
+// isInstalled decides from a per-hook-type status whether hooks are installed
+func isInstalled(status map[string]bool) bool {
+	installedCount := 0
+	for _, hookType := range HookTypes {
+		if status[hookType] {
+			installedCount++
+		}
+	}
+
+	// Consider installed if at most one hook type is missing
+	// (allows for some flexibility, while installs from older versions
+	// without the tool hooks are reported as not installed so they upgrade)
+	return installedCount >= len(HookTypes)-1
+}
+
+// MissingHookTypes returns the hook types without a context-extender hook
+// in user settings, e.g. hook types added by a newer version than the one
+// that installed them
+func MissingHookTypes() ([]string, error) {
+	settingsPath, err := config.GetClaudeSettingsPath()
 	if err != nil {
-		return false, fmt.Errorf("failed to get absolute path: %w", err)
+		return nil, fmt.Errorf("failed to get Claude settings path: %w", err)
 	}
 
-	// Check if any of our required hooks are installed
-	hookTypes := []string{"SessionStart", "UserPromptSubmit", "Stop", "SessionEnd"}
-	installedCount := 0
+	return MissingHookTypesAt(settingsPath)
+}
 
-	for _, hookType := range hookTypes {
-		existingHooks := settings.Hooks[hookType]
-		for _, entry := range existingHooks {
-			for _, hook := range entry.Hooks {
-				if containsContextExtender(hook.Command, execPath) {
-					installedCount++
-					break
-				}
-			}
-		}
+// MissingHookTypesAt returns the hook types without a context-extender hook
+// in the settings file at settingsPath
+func MissingHookTypesAt(settingsPath string) ([]string, error) {
+	status, err := GetInstallationStatusAt(settingsPath)
+	if err != nil {
+		return nil, err
 	}
 
-	// Consider installed if at least 3 of 4 hooks are present
-	// (allows for some flexibility in case a hook type is missing)
-	return installedCount >= 3, nil
+	var missing []string
+	for _, hookType := range HookTypes {
+		if !status[hookType] {
+			missing = append(missing, hookType)
+		}
+	}
+	return missing, nil
 }
 
-// GetInstallationStatus returns detailed information about hook installation
+// GetInstallationStatus returns detailed information about hook installation in user settings
 func GetInstallationStatus() (map[string]bool, error) {
+	settingsPath, err := config.GetClaudeSettingsPath()
+	if err != nil {
+		return make(map[string]bool), fmt.Errorf("failed to get Claude settings path: %w", err)
+	}
+
+	return GetInstallationStatusAt(settingsPath)
+}
+
+// GetInstallationStatusAt returns which hook types have a context-extender
+// hook in the settings file at settingsPath
+func GetInstallationStatusAt(settingsPath string) (map[string]bool, error) {
 	status := make(map[string]bool)
 
 	// Read existing settings
-	settings, err := config.ReadClaudeSettings()
+	settings, err := config.ReadClaudeSettingsAt(settingsPath)
 	if err != nil {
 		return status, fmt.Errorf("failed to read Claude settings: %w", err)
 	}
@@ -321,9 +453,7 @@ func GetInstallationStatus() (map[string]bool, error) {
 	}
 
 	// Check each hook type
-	hookTypes := []string{"SessionStart", "UserPromptSubmit", "Stop", "SessionEnd"}
-
-	for _, hookType := range hookTypes {
+	for _, hookType := range HookTypes {
 		status[hookType] = false
 
 		if settings.Hooks != nil {
@@ -343,4 +473,53 @@ func GetInstallationStatus() (map[string]bool, error) {
 	}
 
 	return status, nil
-}
\ No newline at end of file
+}
+
+// InstalledCommand is a context-extender hook command found in a settings file
+type InstalledCommand struct {
+	HookType   string
+	Command    string
+	Executable string // binary the command runs
+}
+
+// GetInstalledCommands returns the context-extender hook commands in the
+// settings file at settingsPath
+func GetInstalledCommands(settingsPath string) ([]InstalledCommand, error) {
+	settings, err := config.ReadClaudeSettingsAt(settingsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Claude settings: %w", err)
+	}
+
+	execPath, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get executable path: %w", err)
+	}
+
+	var commands []InstalledCommand
+	for _, hookType := range HookTypes {
+		for _, entry := range settings.Hooks[hookType] {
+			for _, hook := range entry.Hooks {
+				if containsContextExtender(hook.Command, execPath) {
+					commands = append(commands, InstalledCommand{
+						HookType:   hookType,
+						Command:    hook.Command,
+						Executable: commandExecutable(hook.Command),
+					})
+				}
+			}
+		}
+	}
+	return commands, nil
+}
+
+// commandExecutable extracts the binary from a hook command. Installed
+// commands are "<path> capture ...", and the path may contain spaces.
+func commandExecutable(command string) string {
+	if i := strings.Index(command, " capture "); i > 0 {
+		return strings.Trim(command[:i], `"`)
+	}
+	if fields := strings.Fields(command); len(fields) > 0 {
+		return strings.Trim(fields[0], `"`)
+	}
+	return ""
+}
@@ -127,6 +127,76 @@ func TestContainsContextExtender(t *testing.T) {
 	}
 }
 
+// TestToolHooks tests the PreToolUse/PostToolUse hook configuration
+func TestToolHooks(t *testing.T) {
+	hooks, err := GetContextExtenderHooks()
+	if err != nil {
+		t.Fatalf("Failed to get context-extender hooks: %v", err)
+	}
+
+	toolHooks := map[string]config.HookEntry{
+		"tool-use":    hooks.PreToolUse,
+		"tool-result": hooks.PostToolUse,
+	}
+
+	for event, hook := range toolHooks {
+		if hook.Matcher != "*" {
+			t.Errorf("Expected %s hook to match all tools, got matcher: %s", event, hook.Matcher)
+		}
+		if len(hook.Hooks) != 1 {
+			t.Fatalf("Expected %s hook to have one command, got %d", event, len(hook.Hooks))
+		}
+		if !strings.Contains(hook.Hooks[0].Command, "--event="+event) {
+			t.Errorf("Expected %s hook command to capture %s, got: %s", event, event, hook.Hooks[0].Command)
+		}
+	}
+
+	// Every installed hook type must have an entry
+	byType := hooks.ByType()
+	for _, hookType := range HookTypes {
+		if len(byType[hookType]) == 0 {
+			t.Errorf("Expected %s hook entry to be configured", hookType)
+		}
+	}
+}
+
+// TestPreCompactHooks tests that PreCompact is installed for both compaction triggers
+func TestPreCompactHooks(t *testing.T) {
+	hooks, err := GetContextExtenderHooks()
+	if err != nil {
+		t.Fatalf("Failed to get context-extender hooks: %v", err)
+	}
+
+	matchers := make(map[string]bool)
+	for _, entry := range hooks.PreCompact {
+		matchers[entry.Matcher] = true
+		if len(entry.Hooks) != 1 || !strings.Contains(entry.Hooks[0].Command, "--event=conversation-compress") {
+			t.Errorf("Unexpected PreCompact hook for matcher %q: %+v", entry.Matcher, entry.Hooks)
+		}
+	}
+
+	if !matchers["manual"] || !matchers["auto"] || len(matchers) != 2 {
+		t.Errorf("Expected manual and auto PreCompact matchers, got: %v", matchers)
+	}
+
+	settings := &config.ClaudeSettings{
+		Hooks: map[string][]config.HookEntry{
+			"PreCompact": {{Matcher: "auto", Hooks: []config.HookConfig{{Type: "command", Command: "other-tool"}}}},
+		},
+	}
+	if err := installSingleHook(settings, "PreCompact", hooks.PreCompact...); err != nil {
+		t.Fatalf("Failed to install PreCompact hooks: %v", err)
+	}
+	if err := installSingleHook(settings, "PreCompact", hooks.PreCompact...); err != nil {
+		t.Fatalf("Failed to reinstall PreCompact hooks: %v", err)
+	}
+
+	// Other tools' entries are kept and ours are not duplicated
+	if len(settings.Hooks["PreCompact"]) != 3 {
+		t.Errorf("Expected 3 PreCompact entries, got %d", len(settings.Hooks["PreCompact"]))
+	}
+}
+
 // TestInstallSingleHook tests individual hook installation logic
 func TestInstallSingleHook(t *testing.T) {
 	// Create test settings
@@ -279,4 +349,20 @@ func BenchmarkContainsContextExtender(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		containsContextExtender(command, execPath)
 	}
-}
\ No newline at end of file
+}
+
+func TestCommandExecutable(t *testing.T) {
+	tests := map[string]string{
+		"/usr/local/bin/context-extender capture --event=session-start":       "/usr/local/bin/context-extender",
+		"/Users/me/My Tools/context-extender capture --event=user-prompt":     "/Users/me/My Tools/context-extender",
+		`"C:\Program Files\context-extender.exe" capture --event=session-end`: `C:\Program Files\context-extender.exe`,
+		"context-extender": "context-extender",
+		"":                 "",
+	}
+
+	for command, expected := range tests {
+		if got := commandExecutable(command); got != expected {
+			t.Errorf("commandExecutable(%q) = %q, expected %q", command, got, expected)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package hooks
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// HookInput contains the fields Claude Code sends on stdin for every hook event
+type HookInput struct {
+	SessionID      string `json:"session_id"`
+	TranscriptPath string `json:"transcript_path"`
+	CWD            string `json:"cwd"`
+	HookEventName  string `json:"hook_event_name"`
+	PermissionMode string `json:"permission_mode,omitempty"`
+}
+
+// SessionStartInput is the stdin payload for SessionStart hooks
+type SessionStartInput struct {
+	HookInput
+	Source string `json:"source"` // startup, resume, clear or compact
+}
+
+// UserPromptSubmitInput is the stdin payload for UserPromptSubmit hooks
+type UserPromptSubmitInput struct {
+	HookInput
+	Prompt string `json:"prompt"`
+}
+
+// PreToolUseInput is the stdin payload for PreToolUse hooks
+type PreToolUseInput struct {
+	HookInput
+	ToolName  string          `json:"tool_name"`
+	ToolInput json.RawMessage `json:"tool_input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+}
+
+// PostToolUseInput is the stdin payload for PostToolUse hooks
+type PostToolUseInput struct {
+	PreToolUseInput
+	ToolResponse json.RawMessage `json:"tool_response,omitempty"`
+}
+
+// InvocationKey returns the key that correlates a PreToolUse payload with
+// its PostToolUse payload. Claude Code's tool_use_id is used when present;
+// older versions don't send it, so a hash of the tool name and input is used instead.
+func (p *PreToolUseInput) InvocationKey() string {
+	if p.ToolUseID != "" {
+		return p.ToolUseID
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(p.SessionID))
+	hash.Write([]byte{0})
+	hash.Write([]byte(p.ToolName))
+	hash.Write([]byte{0})
+	hash.Write(compactJSON(p.ToolInput))
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil))[:32]
+}
+
+// Succeeded reports whether the tool response indicates success.
+// Tool responses have no common schema, so the usual error markers are checked.
+func (p *PostToolUseInput) Succeeded() bool {
+	var response map[string]interface{}
+	if err := json.Unmarshal(p.ToolResponse, &response); err != nil {
+		// Non-object responses (plain strings, arrays) carry no error marker
+		return true
+	}
+
+	if success, ok := response["success"].(bool); ok && !success {
+		return false
+	}
+	if isError, ok := response["is_error"].(bool); ok && isError {
+		return false
+	}
+	if interrupted, ok := response["interrupted"].(bool); ok && interrupted {
+		return false
+	}
+	if errValue, ok := response["error"]; ok && errValue != nil && errValue != "" && errValue != false {
+		return false
+	}
+
+	return true
+}
+
+// ResponseText returns the tool response as text. JSON strings are unquoted,
+// everything else is returned as compact JSON.
+func (p *PostToolUseInput) ResponseText() string {
+	var text string
+	if err := json.Unmarshal(p.ToolResponse, &text); err == nil {
+		return text
+	}
+	return string(compactJSON(p.ToolResponse))
+}
+
+// compactJSON removes insignificant whitespace so equal inputs hash equally
+func compactJSON(data json.RawMessage) []byte {
+	if len(data) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
+
+// StopInput is the stdin payload for Stop hooks
+type StopInput struct {
+	HookInput
+	StopHookActive bool `json:"stop_hook_active"`
+}
+
+// SessionEndInput is the stdin payload for SessionEnd hooks
+type SessionEndInput struct {
+	HookInput
+	Reason string `json:"reason"`
+}
+
+// PreCompactInput is the stdin payload for PreCompact hooks
+type PreCompactInput struct {
+	HookInput
+	Trigger            string `json:"trigger"` // manual or auto
+	CustomInstructions string `json:"custom_instructions"`
+}
+
+// HookOutput is the JSON a hook can print on stdout to control Claude Code
+type HookOutput struct {
+	HookSpecificOutput *HookSpecificOutput `json:"hookSpecificOutput,omitempty"`
+}
+
+// HookSpecificOutput carries event-specific hook results
+type HookSpecificOutput struct {
+	HookEventName     string `json:"hookEventName"`
+	AdditionalContext string `json:"additionalContext,omitempty"`
+}
+
+// NewAdditionalContextOutput creates output that adds text to Claude's context.
+// Only SessionStart and UserPromptSubmit hooks support additional context.
+func NewAdditionalContextOutput(hookEventName, additionalContext string) *HookOutput {
+	return &HookOutput{
+		HookSpecificOutput: &HookSpecificOutput{
+			HookEventName:     hookEventName,
+			AdditionalContext: additionalContext,
+		},
+	}
+}
+
+// WriteHookOutput writes hook output as a single JSON document
+func WriteHookOutput(w io.Writer, output *HookOutput) error {
+	if err := json.NewEncoder(w).Encode(output); err != nil {
+		return fmt.Errorf("failed to write hook output: %w", err)
+	}
+	return nil
+}
+
+// ReadHookPayload reads the raw hook payload from r.
+// An empty payload (no input, or only whitespace) is returned as nil.
+func ReadHookPayload(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hook payload: %w", err)
+	}
+
+	if strings.TrimSpace(string(data)) == "" {
+		return nil, nil
+	}
+
+	return data, nil
+}
+
+// ReadStdinPayload reads the hook payload from stdin.
+// When stdin is an interactive terminal nothing is read, so manual
+// invocations of the CLI don't block waiting for input.
+func ReadStdinPayload() ([]byte, error) {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return nil, nil
+	}
+
+	if info.Mode()&os.ModeCharDevice != 0 {
+		return nil, nil
+	}
+
+	return ReadHookPayload(os.Stdin)
+}
+
+// DecodePayload decodes a raw hook payload into one of the typed input structs.
+// A nil payload leaves target untouched.
+func DecodePayload(payload []byte, target interface{}) error {
+	if len(payload) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(payload, target); err != nil {
+		return fmt.Errorf("failed to parse hook payload: %w", err)
+	}
+
+	return nil
+}
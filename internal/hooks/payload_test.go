@@ -0,0 +1,165 @@
+package hooks
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestReadHookPayload tests reading raw payloads
+func TestReadHookPayload(t *testing.T) {
+	payload, err := ReadHookPayload(strings.NewReader("  \n\t"))
+	if err != nil {
+		t.Fatalf("Failed to read empty payload: %v", err)
+	}
+	if payload != nil {
+		t.Errorf("Expected whitespace-only payload to be nil, got: %q", payload)
+	}
+
+	payload, err = ReadHookPayload(strings.NewReader(`{"session_id":"abc"}`))
+	if err != nil {
+		t.Fatalf("Failed to read payload: %v", err)
+	}
+	if string(payload) != `{"session_id":"abc"}` {
+		t.Errorf("Unexpected payload: %q", payload)
+	}
+}
+
+// TestDecodePayload tests decoding payloads into typed event structs
+func TestDecodePayload(t *testing.T) {
+	raw := []byte(`{
+		"session_id": "abc123",
+		"transcript_path": "/home/user/.claude/projects/demo/abc123.jsonl",
+		"cwd": "/home/user/demo",
+		"hook_event_name": "UserPromptSubmit",
+		"prompt": "Write a function"
+	}`)
+
+	var prompt UserPromptSubmitInput
+	if err := DecodePayload(raw, &prompt); err != nil {
+		t.Fatalf("Failed to decode payload: %v", err)
+	}
+
+	if prompt.SessionID != "abc123" {
+		t.Errorf("Expected session_id abc123, got: %s", prompt.SessionID)
+	}
+	if prompt.CWD != "/home/user/demo" {
+		t.Errorf("Expected cwd /home/user/demo, got: %s", prompt.CWD)
+	}
+	if prompt.TranscriptPath != "/home/user/.claude/projects/demo/abc123.jsonl" {
+		t.Errorf("Unexpected transcript_path: %s", prompt.TranscriptPath)
+	}
+	if prompt.HookEventName != "UserPromptSubmit" {
+		t.Errorf("Expected hook_event_name UserPromptSubmit, got: %s", prompt.HookEventName)
+	}
+	if prompt.Prompt != "Write a function" {
+		t.Errorf("Expected prompt to be decoded, got: %s", prompt.Prompt)
+	}
+
+	var end SessionEndInput
+	if err := DecodePayload([]byte(`{"session_id":"abc123","reason":"logout"}`), &end); err != nil {
+		t.Fatalf("Failed to decode session end payload: %v", err)
+	}
+	if end.Reason != "logout" {
+		t.Errorf("Expected reason logout, got: %s", end.Reason)
+	}
+}
+
+// TestDecodePayloadEmpty tests that an empty payload leaves the target untouched
+func TestDecodePayloadEmpty(t *testing.T) {
+	input := SessionStartInput{Source: "startup"}
+	if err := DecodePayload(nil, &input); err != nil {
+		t.Fatalf("Expected no error for empty payload, got: %v", err)
+	}
+	if input.Source != "startup" {
+		t.Errorf("Expected target to be untouched, got source: %s", input.Source)
+	}
+}
+
+// TestDecodePayloadInvalid tests that malformed JSON is reported
+func TestDecodePayloadInvalid(t *testing.T) {
+	var input HookInput
+	if err := DecodePayload([]byte("{not json"), &input); err == nil {
+		t.Error("Expected error for malformed payload")
+	}
+}
+
+// TestInvocationKey tests correlation of PreToolUse and PostToolUse payloads
+func TestInvocationKey(t *testing.T) {
+	withID := PreToolUseInput{ToolName: "Bash", ToolUseID: "toolu_01"}
+	if withID.InvocationKey() != "toolu_01" {
+		t.Errorf("Expected tool_use_id to be used as key, got: %s", withID.InvocationKey())
+	}
+
+	var pre PreToolUseInput
+	if err := DecodePayload([]byte(`{"session_id":"s1","tool_name":"Read","tool_input":{"file_path": "/tmp/a.go"}}`), &pre); err != nil {
+		t.Fatalf("Failed to decode pre payload: %v", err)
+	}
+
+	var post PostToolUseInput
+	if err := DecodePayload([]byte(`{"session_id":"s1","tool_name":"Read","tool_input":{"file_path":"/tmp/a.go"},"tool_response":"ok"}`), &post); err != nil {
+		t.Fatalf("Failed to decode post payload: %v", err)
+	}
+
+	if pre.InvocationKey() != post.InvocationKey() {
+		t.Errorf("Expected matching keys, got %s and %s", pre.InvocationKey(), post.InvocationKey())
+	}
+
+	other := pre
+	other.ToolName = "Edit"
+	if other.InvocationKey() == pre.InvocationKey() {
+		t.Error("Expected different tools to produce different keys")
+	}
+}
+
+// TestPostToolUseSucceeded tests success detection from tool responses
+func TestPostToolUseSucceeded(t *testing.T) {
+	testCases := []struct {
+		name     string
+		response string
+		expected bool
+	}{
+		{"plain string", `"file contents"`, true},
+		{"bash output", `{"stdout":"ok","stderr":"","interrupted":false}`, true},
+		{"success false", `{"success":false}`, false},
+		{"is_error", `{"is_error":true,"content":"boom"}`, false},
+		{"interrupted", `{"stdout":"","interrupted":true}`, false},
+		{"error message", `{"error":"file not found"}`, false},
+		{"empty error", `{"error":"","filePath":"/tmp/a.go"}`, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			input := PostToolUseInput{ToolResponse: []byte(tc.response)}
+			if input.Succeeded() != tc.expected {
+				t.Errorf("Expected Succeeded() = %v for %s", tc.expected, tc.response)
+			}
+		})
+	}
+}
+
+// TestPostToolUseResponseText tests tool response text extraction
+func TestPostToolUseResponseText(t *testing.T) {
+	input := PostToolUseInput{ToolResponse: []byte(`"line one\nline two"`)}
+	if input.ResponseText() != "line one\nline two" {
+		t.Errorf("Expected string response to be unquoted, got: %q", input.ResponseText())
+	}
+
+	input = PostToolUseInput{ToolResponse: []byte(`{ "stdout": "ok" }`)}
+	if input.ResponseText() != `{"stdout":"ok"}` {
+		t.Errorf("Expected compact JSON response, got: %s", input.ResponseText())
+	}
+}
+
+// TestWriteHookOutput tests the additionalContext JSON format Claude Code expects
+func TestWriteHookOutput(t *testing.T) {
+	var buf strings.Builder
+	output := NewAdditionalContextOutput("SessionStart", "## Critical Context")
+	if err := WriteHookOutput(&buf, output); err != nil {
+		t.Fatalf("Failed to write hook output: %v", err)
+	}
+
+	expected := `{"hookSpecificOutput":{"hookEventName":"SessionStart","additionalContext":"## Critical Context"}}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected hook output:\n got: %s\nwant: %s", buf.String(), expected)
+	}
+}
@@ -0,0 +1,91 @@
+package hooks
+
+import (
+	"fmt"
+
+	"context-extender/internal/config"
+)
+
+// ScopeStatus describes the context-extender hooks in one settings scope
+type ScopeStatus struct {
+	Scope     config.SettingsScope
+	Path      string
+	Hooks     map[string]bool // hook type -> context-extender hook present
+	Installed bool            // hooks are installed (see IsInstalledAt)
+}
+
+// HasAny reports whether any context-extender hook is present in the scope
+func (s *ScopeStatus) HasAny() bool {
+	for _, present := range s.Hooks {
+		if present {
+			return true
+		}
+	}
+	return false
+}
+
+// GetScopeStatuses returns the hook status of every settings scope for the
+// project containing projectDir
+func GetScopeStatuses(projectDir string) ([]*ScopeStatus, error) {
+	var statuses []*ScopeStatus
+	for _, scope := range config.SettingsScopes {
+		settingsPath, err := config.GetScopedSettingsPath(scope, projectDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s settings path: %w", scope, err)
+		}
+
+		hookStatus, err := GetInstallationStatusAt(settingsPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s settings: %w", scope, err)
+		}
+
+		statuses = append(statuses, &ScopeStatus{
+			Scope:     scope,
+			Path:      settingsPath,
+			Hooks:     hookStatus,
+			Installed: isInstalled(hookStatus),
+		})
+	}
+	return statuses, nil
+}
+
+// DuplicateHookTypes returns the hook types installed in more than one
+// scope. Claude Code runs the hooks of every scope, so each of these events
+// would be captured more than once.
+func DuplicateHookTypes(statuses []*ScopeStatus) map[string][]config.SettingsScope {
+	duplicates := make(map[string][]config.SettingsScope)
+	for _, hookType := range HookTypes {
+		var scopes []config.SettingsScope
+		for _, status := range statuses {
+			if status.Hooks[hookType] {
+				scopes = append(scopes, status.Scope)
+			}
+		}
+		if len(scopes) > 1 {
+			duplicates[hookType] = scopes
+		}
+	}
+	return duplicates
+}
+
+// UninstallAllScopes removes context-extender hooks from every settings
+// scope for the project containing projectDir, and returns the settings
+// files that were changed
+func UninstallAllScopes(projectDir string) ([]string, error) {
+	var cleaned []string
+	for _, scope := range config.SettingsScopes {
+		settingsPath, err := config.GetScopedSettingsPath(scope, projectDir)
+		if err != nil {
+			return cleaned, fmt.Errorf("failed to get %s settings path: %w", scope, err)
+		}
+
+		removed, err := UninstallHooksAt(settingsPath)
+		if err != nil {
+			return cleaned, fmt.Errorf("failed to remove %s hooks: %w", scope, err)
+		}
+		if removed {
+			cleaned = append(cleaned, settingsPath)
+		}
+	}
+	return cleaned, nil
+}
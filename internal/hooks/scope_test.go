@@ -0,0 +1,102 @@
+package hooks
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"context-extender/internal/config"
+)
+
+// setupScopes isolates user settings and creates a git project with a subdirectory
+func setupScopes(t *testing.T) string {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("APPDATA", filepath.Join(home, "AppData"))
+
+	project := t.TempDir()
+	for _, dir := range []string{".git", "src"} {
+		if err := os.MkdirAll(filepath.Join(project, dir), 0755); err != nil {
+			t.Fatalf("Failed to create project: %v", err)
+		}
+	}
+	return filepath.Join(project, "src")
+}
+
+func TestScopedSettingsPath(t *testing.T) {
+	projectDir := setupScopes(t)
+	root := filepath.Dir(projectDir)
+
+	path, err := config.GetScopedSettingsPath(config.ScopeLocal, projectDir)
+	if err != nil {
+		t.Fatalf("Failed to get settings path: %v", err)
+	}
+	if path != filepath.Join(root, ".claude", "settings.local.json") {
+		t.Errorf("Expected local settings at the project root, got %s", path)
+	}
+
+	if _, err := config.ParseSettingsScope("global"); err == nil {
+		t.Error("Expected an error for an unknown scope")
+	}
+}
+
+func TestScopeStatusesAndDuplicates(t *testing.T) {
+	projectDir := setupScopes(t)
+
+	localPath, _ := config.GetScopedSettingsPath(config.ScopeLocal, projectDir)
+	userPath, _ := config.GetScopedSettingsPath(config.ScopeUser, projectDir)
+
+	if err := InstallHooksAt(localPath); err != nil {
+		t.Fatalf("Failed to install local hooks: %v", err)
+	}
+
+	statuses, err := GetScopeStatuses(projectDir)
+	if err != nil {
+		t.Fatalf("Failed to get scope statuses: %v", err)
+	}
+	for _, status := range statuses {
+		expected := status.Scope == config.ScopeLocal
+		if status.Installed != expected {
+			t.Errorf("Expected %s installed=%v, got %v", status.Scope, expected, status.Installed)
+		}
+	}
+	if len(DuplicateHookTypes(statuses)) != 0 {
+		t.Error("Expected no duplicates with a single scope")
+	}
+
+	if err := InstallHooksAt(userPath); err != nil {
+		t.Fatalf("Failed to install user hooks: %v", err)
+	}
+	statuses, err = GetScopeStatuses(projectDir)
+	if err != nil {
+		t.Fatalf("Failed to get scope statuses: %v", err)
+	}
+	duplicates := DuplicateHookTypes(statuses)
+	if len(duplicates) != len(HookTypes) {
+		t.Errorf("Expected every hook type to be duplicated, got %v", duplicates)
+	}
+
+	cleaned, err := UninstallAllScopes(projectDir)
+	if err != nil {
+		t.Fatalf("Failed to uninstall: %v", err)
+	}
+	if len(cleaned) != 2 {
+		t.Errorf("Expected 2 settings files to be cleaned, got %v", cleaned)
+	}
+
+	// The project settings file was never created and must not be
+	projectPath, _ := config.GetScopedSettingsPath(config.ScopeProject, projectDir)
+	if _, err := os.Stat(projectPath); !os.IsNotExist(err) {
+		t.Errorf("Expected project settings to not be created, got: %v", err)
+	}
+
+	statuses, _ = GetScopeStatuses(projectDir)
+	for _, status := range statuses {
+		if status.HasAny() {
+			t.Errorf("Expected no hooks in %s settings", status.Scope)
+		}
+	}
+}
@@ -2,8 +2,10 @@ package importer
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,6 +17,7 @@ import (
 // ClaudeEntry represents a single line in Claude's JSONL file
 type ClaudeEntry struct {
 	Type        string          `json:"type"`
+	UUID        string          `json:"uuid,omitempty"`
 	ParentUUID  string          `json:"parentUuid,omitempty"`
 	UserType    string          `json:"userType,omitempty"`
 	CWD         string          `json:"cwd,omitempty"`
@@ -30,14 +33,48 @@ type ClaudeEntry struct {
 
 // ClaudeMessage represents a message in the conversation
 type ClaudeMessage struct {
-	Role    string                 `json:"role"`
-	Content []ClaudeMessageContent `json:"content"`
+	Role    string              `json:"role"`
+	Model   string              `json:"model,omitempty"`
+	Content ClaudeContentBlocks `json:"content"`
+	Usage   *ClaudeUsage        `json:"usage,omitempty"`
+}
+
+// ClaudeUsage contains token usage reported for assistant messages
+type ClaudeUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
 }
 
 // ClaudeMessageContent represents content within a message
 type ClaudeMessageContent struct {
-	Type string `json:"type"`
-	Text string `json:"text,omitempty"`
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`          // tool_use
+	Name      string          `json:"name,omitempty"`        // tool_use
+	Input     json.RawMessage `json:"input,omitempty"`       // tool_use
+	ToolUseID string          `json:"tool_use_id,omitempty"` // tool_result
+	Content   json.RawMessage `json:"content,omitempty"`     // tool_result
+	IsError   bool            `json:"is_error,omitempty"`    // tool_result
+}
+
+// ClaudeContentBlocks is the content of a message. Claude writes user
+// prompts as a plain string and everything else as a list of blocks.
+type ClaudeContentBlocks []ClaudeMessageContent
+
+// UnmarshalJSON accepts both a plain string and a list of content blocks
+func (c *ClaudeContentBlocks) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = ClaudeContentBlocks{{Type: "text", Text: text}}
+		return nil
+	}
+
+	var blocks []ClaudeMessageContent
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return err
+	}
+	*c = blocks
+	return nil
 }
 
 // ClaudeConversation represents a parsed Claude conversation
@@ -53,14 +90,19 @@ type ClaudeConversation struct {
 
 // ParsedMessage represents a normalized message
 type ParsedMessage struct {
-	ID        string
-	ParentID  string
-	Role      string // "user" or "assistant"
-	Content   string
-	Timestamp time.Time
-	Metadata  map[string]interface{}
+	ID         string
+	ParentID   string
+	Role       string // "user", "assistant" or "tool"
+	Content    string
+	Timestamp  time.Time
+	Model      string
+	TokenCount int
+	Metadata   map[string]interface{}
 }
 
+// maxLineSize bounds a single transcript line; tool results can be large
+const maxLineSize = 32 * 1024 * 1024
+
 // ClaudeParser parses Claude JSONL files
 type ClaudeParser struct {
 	verbose bool
@@ -88,6 +130,7 @@ func (cp *ClaudeParser) ParseFile(filePath string) (*ClaudeConversation, error)
 	}
 
 	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
 	lineNum := 0
 
 	for scanner.Scan() {
@@ -98,57 +141,130 @@ func (cp *ClaudeParser) ParseFile(filePath string) (*ClaudeConversation, error)
 			continue
 		}
 
-		var entry ClaudeEntry
-		if err := json.Unmarshal([]byte(line), &entry); err != nil {
-			if cp.verbose {
-				fmt.Printf("Warning: Failed to parse line %d: %v\n", lineNum, err)
-			}
-			continue
+		cp.processLine([]byte(line), lineNum, conversation)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+
+	// Post-process conversation
+	cp.postProcess(conversation)
+
+	return conversation, nil
+}
+
+// IncrementalParse is the result of parsing part of a transcript
+type IncrementalParse struct {
+	Conversation *ClaudeConversation
+	StartOffset  int64  // byte offset parsing started at
+	EndOffset    int64  // byte offset of the first unconsumed byte
+	LastUUID     string // UUID of the last consumed line, empty if it had none
+	Complete     bool   // true when every complete line up to EOF was consumed
+}
+
+// ParseFrom parses a Claude JSONL file starting at a byte offset.
+// Only complete, newline-terminated lines are consumed, so a line Claude
+// is still writing is picked up by the next call. Parsing stops after
+// roughly maxBytes (0 means no limit) so callers can bound their latency.
+func (cp *ClaudeParser) ParseFrom(filePath string, offset, maxBytes int64) (*IncrementalParse, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek to offset %d: %w", offset, err)
+	}
+
+	result := &IncrementalParse{
+		Conversation: &ClaudeConversation{
+			Messages:  []ParsedMessage{},
+			Summaries: []string{},
+			Metadata:  make(map[string]interface{}),
+		},
+		StartOffset: offset,
+		EndOffset:   offset,
+	}
+
+	reader := bufio.NewReader(file)
+	lineNum := 0
+
+	for {
+		if maxBytes > 0 && result.EndOffset-offset >= maxBytes {
+			return result, nil
 		}
 
-		// Process entry based on type
-		switch entry.Type {
-		case "user", "assistant":
-			if err := cp.processMessage(&entry, conversation); err != nil {
-				if cp.verbose {
-					fmt.Printf("Warning: Failed to process message at line %d: %v\n", lineNum, err)
-				}
-			}
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A trailing partial line is left for the next call
+			result.Complete = true
+			return result, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading file: %w", err)
+		}
 
-		case "summary":
-			if entry.Summary != "" {
-				conversation.Summaries = append(conversation.Summaries, entry.Summary)
-			}
+		result.EndOffset += int64(len(line))
+		lineNum++
 
-		case "session-start", "session_start":
-			cp.processSessionStart(&entry, conversation)
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			result.LastUUID = ""
+			continue
+		}
 
-		case "session-end", "session_end":
-			cp.processSessionEnd(&entry, conversation)
+		result.LastUUID = cp.processLine(line, lineNum, result.Conversation)
+	}
+}
 
-		default:
+// processLine parses a single JSONL line, adds it to the conversation and
+// returns the entry's UUID
+func (cp *ClaudeParser) processLine(line []byte, lineNum int, conversation *ClaudeConversation) string {
+	var entry ClaudeEntry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		if cp.verbose {
+			fmt.Printf("Warning: Failed to parse line %d: %v\n", lineNum, err)
+		}
+		return ""
+	}
+
+	// Process entry based on type
+	switch entry.Type {
+	case "user", "assistant":
+		if err := cp.processMessage(&entry, conversation); err != nil {
 			if cp.verbose {
-				fmt.Printf("Info: Unknown entry type '%s' at line %d\n", entry.Type, lineNum)
+				fmt.Printf("Warning: Failed to process message at line %d: %v\n", lineNum, err)
 			}
 		}
 
-		// Update session info
-		if entry.SessionID != "" && conversation.SessionID == "" {
-			conversation.SessionID = entry.SessionID
+	case "summary":
+		if entry.Summary != "" {
+			conversation.Summaries = append(conversation.Summaries, entry.Summary)
 		}
-		if entry.CWD != "" && conversation.ProjectPath == "" {
-			conversation.ProjectPath = entry.CWD
+
+	case "session-start", "session_start":
+		cp.processSessionStart(&entry, conversation)
+
+	case "session-end", "session_end":
+		cp.processSessionEnd(&entry, conversation)
+
+	default:
+		if cp.verbose {
+			fmt.Printf("Info: Unknown entry type '%s' at line %d\n", entry.Type, lineNum)
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading file: %w", err)
+	// Update session info
+	if entry.SessionID != "" && conversation.SessionID == "" {
+		conversation.SessionID = entry.SessionID
+	}
+	if entry.CWD != "" && conversation.ProjectPath == "" {
+		conversation.ProjectPath = entry.CWD
 	}
 
-	// Post-process conversation
-	cp.postProcess(conversation)
-
-	return conversation, nil
+	return entry.UUID
 }
 
 // processMessage processes a message entry
@@ -157,20 +273,48 @@ func (cp *ClaudeParser) processMessage(entry *ClaudeEntry, conv *ClaudeConversat
 		return fmt.Errorf("message entry has no message content")
 	}
 
-	// Extract text content
+	// Extract text content, rendering tool calls and results so they stay searchable
 	var textContent strings.Builder
+	var toolUses []string
+	var toolUseIDs []string
+	toolResults := 0
+	isError := false
 	for _, content := range entry.Message.Content {
-		if content.Type == "text" && content.Text != "" {
-			textContent.WriteString(content.Text)
+		switch content.Type {
+		case "text":
+			if content.Text != "" {
+				textContent.WriteString(content.Text)
+				textContent.WriteString("\n")
+			}
+		case "tool_use":
+			toolUses = append(toolUses, content.Name)
+			fmt.Fprintf(&textContent, "[Tool: %s] %s\n", content.Name, string(content.Input))
+		case "tool_result":
+			toolResults++
+			toolUseIDs = append(toolUseIDs, content.ToolUseID)
+			isError = isError || content.IsError
+			textContent.WriteString(toolResultText(content.Content))
 			textContent.WriteString("\n")
 		}
 	}
 
+	// Prefer the entry's own UUID; older exports only carry leafUuid
+	id := entry.UUID
+	if id == "" {
+		id = entry.LeafUUID
+	}
+
+	role := entry.Message.Role
+	if role == "user" && toolResults > 0 && toolResults == len(entry.Message.Content) {
+		role = "tool"
+	}
+
 	message := ParsedMessage{
-		ID:       entry.LeafUUID,
+		ID:       id,
 		ParentID: entry.ParentUUID,
-		Role:     entry.Message.Role,
+		Role:     role,
 		Content:  strings.TrimSpace(textContent.String()),
+		Model:    entry.Message.Model,
 		Metadata: map[string]interface{}{
 			"user_type":   entry.UserType,
 			"version":     entry.Version,
@@ -179,6 +323,17 @@ func (cp *ClaudeParser) processMessage(entry *ClaudeEntry, conv *ClaudeConversat
 		},
 	}
 
+	if entry.Message.Usage != nil {
+		message.TokenCount = entry.Message.Usage.OutputTokens
+	}
+	if len(toolUses) > 0 {
+		message.Metadata["tool_uses"] = toolUses
+	}
+	if len(toolUseIDs) > 0 {
+		message.Metadata["tool_use_ids"] = toolUseIDs
+		message.Metadata["is_error"] = isError
+	}
+
 	// Parse timestamp if available
 	if entry.Timestamp != "" {
 		if t, err := time.Parse(time.RFC3339, entry.Timestamp); err == nil {
@@ -190,6 +345,32 @@ func (cp *ClaudeParser) processMessage(entry *ClaudeEntry, conv *ClaudeConversat
 	return nil
 }
 
+// toolResultText extracts the text of a tool_result block, which is either
+// a plain string or a list of content blocks
+func toolResultText(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+
+	var blocks []ClaudeMessageContent
+	if err := json.Unmarshal(raw, &blocks); err == nil {
+		var parts []string
+		for _, block := range blocks {
+			if block.Type == "text" && block.Text != "" {
+				parts = append(parts, block.Text)
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+
+	return string(raw)
+}
+
 // processSessionStart processes session start events
 func (cp *ClaudeParser) processSessionStart(entry *ClaudeEntry, conv *ClaudeConversation) {
 	if entry.Timestamp != "" {
@@ -1,9 +1,11 @@
 package importer
 
 import (
+	"context"
 	"context-extender/internal/database"
 	"crypto/md5"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -13,6 +15,7 @@ import (
 
 // ImportManager manages the import of Claude conversations
 type ImportManager struct {
+	backend      database.DatabaseBackend
 	parser       *ClaudeParser
 	verbose      bool
 	dryRun       bool
@@ -39,9 +42,10 @@ type ImportResult struct {
 	Errors           []string
 }
 
-// NewImportManager creates a new import manager
-func NewImportManager(options ImportOptions) *ImportManager {
+// NewImportManager creates a new import manager writing to backend
+func NewImportManager(backend database.DatabaseBackend, options ImportOptions) *ImportManager {
 	return &ImportManager{
+		backend:      backend,
 		parser:       NewClaudeParser(options.Verbose),
 		verbose:      options.Verbose,
 		dryRun:       options.DryRun,
@@ -50,14 +54,14 @@ func NewImportManager(options ImportOptions) *ImportManager {
 }
 
 // ImportFile imports a single Claude JSONL file
-func (im *ImportManager) ImportFile(filePath string) error {
+func (im *ImportManager) ImportFile(ctx context.Context, filePath string) error {
 	if im.verbose {
 		fmt.Printf("Importing file: %s\n", filePath)
 	}
 
 	// Check if already imported
 	if im.skipExisting {
-		if exists, err := im.isFileImported(filePath); err == nil && exists {
+		if exists, err := im.backend.IsFileImported(ctx, filePath); err == nil && exists {
 			if im.verbose {
 				fmt.Println("  File already imported, skipping...")
 			}
@@ -84,24 +88,20 @@ func (im *ImportManager) ImportFile(filePath string) error {
 	}
 
 	// Import to database
-	if err := im.importConversation(conversation, filePath); err != nil {
+	stored, err := im.importConversation(ctx, conversation, filePath)
+	if err != nil {
 		return fmt.Errorf("failed to import conversation: %w", err)
 	}
 
-	// Record import
-	if err := im.recordImport(filePath, conversation); err != nil {
-		return fmt.Errorf("failed to record import: %w", err)
-	}
-
 	if im.verbose {
-		fmt.Printf("  ✅ Successfully imported session %s\n", conversation.SessionID)
+		fmt.Printf("  ✅ Successfully imported session %s (%d new messages)\n", conversation.SessionID, stored)
 	}
 
 	return nil
 }
 
 // ImportDirectory imports all JSONL files from a directory
-func (im *ImportManager) ImportDirectory(dirPath string) (*ImportResult, error) {
+func (im *ImportManager) ImportDirectory(ctx context.Context, dirPath string) (*ImportResult, error) {
 	result := &ImportResult{
 		Errors: []string{},
 	}
@@ -128,7 +128,7 @@ func (im *ImportManager) ImportDirectory(dirPath string) (*ImportResult, error)
 
 	// Import each file
 	for _, file := range files {
-		if err := im.ImportFile(file); err != nil {
+		if err := im.ImportFile(ctx, file); err != nil {
 			result.FailedFiles++
 			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", file, err))
 			if im.verbose {
@@ -144,7 +144,7 @@ func (im *ImportManager) ImportDirectory(dirPath string) (*ImportResult, error)
 }
 
 // ImportAllClaude imports all Claude conversations found on the system
-func (im *ImportManager) ImportAllClaude() (*ImportResult, error) {
+func (im *ImportManager) ImportAllClaude(ctx context.Context) (*ImportResult, error) {
 	result := &ImportResult{
 		Errors: []string{},
 	}
@@ -168,7 +168,7 @@ func (im *ImportManager) ImportAllClaude() (*ImportResult, error) {
 			fmt.Printf("\n[%d/%d] Processing %s\n", i+1, len(files), filepath.Base(file))
 		}
 
-		if err := im.ImportFile(file); err != nil {
+		if err := im.ImportFile(ctx, file); err != nil {
 			result.FailedFiles++
 			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", file, err))
 		} else {
@@ -180,9 +180,16 @@ func (im *ImportManager) ImportAllClaude() (*ImportResult, error) {
 	return result, nil
 }
 
-// importConversation imports a parsed conversation to the database
-func (im *ImportManager) importConversation(conv *ClaudeConversation, filePath string) error {
-	// Create session
+// importConversation imports a parsed conversation to the database in a
+// single transaction, together with its import record. Messages imported
+// before are skipped; it returns how many were new.
+func (im *ImportManager) importConversation(ctx context.Context, conv *ClaudeConversation, filePath string) (int, error) {
+	// Calculate file checksum
+	checksum, err := im.calculateChecksum(filePath)
+	if err != nil {
+		checksum = "" // Non-fatal error
+	}
+
 	metadataMap := map[string]string{
 		"source":       "claude",
 		"project_path": conv.ProjectPath,
@@ -191,116 +198,92 @@ func (im *ImportManager) importConversation(conv *ClaudeConversation, filePath s
 	}
 	metadataJSON, _ := json.Marshal(metadataMap)
 
-	session := &database.Session{
-		ID:        conv.SessionID,
-		CreatedAt: conv.StartTime,
-		UpdatedAt: conv.EndTime,
-		Status:    "imported",
-		Metadata:  string(metadataJSON),
-	}
-
-	if err := database.CreateSession(session); err != nil {
-		// Session might already exist, try to update
-		metadataMap := map[string]string{
-			"source":       "claude",
-			"project_path": conv.ProjectPath,
-			"file_path":    filePath,
-			"import_date":  time.Now().Format(time.RFC3339),
-		}
-		if err := database.UpdateSession(session.ID, "imported", metadataMap); err != nil {
-			return fmt.Errorf("failed to create/update session: %w", err)
-		}
-	}
-
-	// Import messages as conversations
+	var conversations []*database.Conversation
 	for i, msg := range conv.Messages {
 		conversation := &database.Conversation{
+			ID:          fmt.Sprintf("%s_%s", conv.SessionID, msg.ID),
 			SessionID:   conv.SessionID,
 			MessageType: msg.Role,
 			Content:     msg.Content,
 			Timestamp:   msg.Timestamp,
+			Metadata:    "{}",
+			TokenCount:  msg.TokenCount,
+			Model:       msg.Model,
+		}
+		if msg.ID == "" {
+			conversation.ID = fmt.Sprintf("%s_import_%d", conv.SessionID, i)
 		}
-
-		// Add metadata
 		if metadata, err := json.Marshal(msg.Metadata); err == nil {
 			conversation.Metadata = string(metadata)
 		}
-
-		if err := database.CreateConversation(conversation); err != nil {
-			if im.verbose {
-				fmt.Printf("  Warning: Failed to import message %d: %v\n", i+1, err)
-			}
-		}
+		conversations = append(conversations, conversation)
 	}
 
 	// Create events for session lifecycle
-	startEvent := &database.Event{
-		SessionID: conv.SessionID,
-		EventType: "import_start",
-		Data: fmt.Sprintf(`{"file":"%s","time":"%s"}`,
-			filepath.Base(filePath), conv.StartTime.Format(time.RFC3339)),
-		Timestamp:   conv.StartTime,
-		SequenceNum: 1,
-	}
-	database.CreateEvent(startEvent)
-
-	endEvent := &database.Event{
-		SessionID: conv.SessionID,
-		EventType: "import_end",
-		Data: fmt.Sprintf(`{"file":"%s","time":"%s","messages":%d}`,
-			filepath.Base(filePath), conv.EndTime.Format(time.RFC3339), len(conv.Messages)),
-		Timestamp:   conv.EndTime,
-		SequenceNum: 2,
-	}
-	database.CreateEvent(endEvent)
-
-	return nil
-}
-
-// recordImport records the import in the database
-func (im *ImportManager) recordImport(filePath string, conv *ClaudeConversation) error {
-	// Calculate file checksum
-	checksum, err := im.calculateChecksum(filePath)
-	if err != nil {
-		checksum = "" // Non-fatal error
+	now := time.Now()
+	events := []*database.Event{
+		{
+			ID:        fmt.Sprintf("%s_import_start_%d", conv.SessionID, now.UnixNano()),
+			SessionID: conv.SessionID,
+			EventType: "import_start",
+			Data: fmt.Sprintf(`{"file":"%s","time":"%s"}`,
+				filepath.Base(filePath), conv.StartTime.Format(time.RFC3339)),
+			Timestamp: conv.StartTime,
+		},
+		{
+			ID:        fmt.Sprintf("%s_import_end_%d", conv.SessionID, now.UnixNano()),
+			SessionID: conv.SessionID,
+			EventType: "import_end",
+			Data: fmt.Sprintf(`{"file":"%s","time":"%s","messages":%d}`,
+				filepath.Base(filePath), conv.EndTime.Format(time.RFC3339), len(conv.Messages)),
+			Timestamp: conv.EndTime,
+		},
 	}
 
-	// Get database connection
-	db, err := database.GetConnection()
-	if err != nil {
-		return err
-	}
-
-	// Insert or update import record
-	query := `
-		INSERT OR REPLACE INTO import_history
-		(file_path, imported_at, session_count, event_count, checksum)
-		VALUES (?, ?, ?, ?, ?)
-	`
-
-	_, err = db.Exec(query,
-		filePath,
-		time.Now().Format(time.RFC3339),
-		1, // One session per file
-		len(conv.Messages),
-		checksum,
-	)
-
-	return err
-}
-
-// isFileImported checks if a file has already been imported
-func (im *ImportManager) isFileImported(filePath string) (bool, error) {
-	db, err := database.GetConnection()
-	if err != nil {
-		return false, err
-	}
+	var stored int
+	err = im.backend.WithTx(ctx, func(tx database.BackendTx) error {
+		session, err := tx.GetSession(ctx, conv.SessionID)
+		switch {
+		case errors.Is(err, database.ErrSessionNotFound):
+			session = &database.Session{
+				ID:        conv.SessionID,
+				CreatedAt: conv.StartTime,
+				UpdatedAt: conv.EndTime,
+				Status:    "imported",
+				Metadata:  string(metadataJSON),
+			}
+			err = tx.CreateSession(ctx, session)
+		case err == nil:
+			// Session already exists, update it
+			session.Status = "imported"
+			session.Metadata = string(metadataJSON)
+			session.UpdatedAt = now
+			err = tx.UpdateSession(ctx, session)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create/update session: %w", err)
+		}
 
-	var count int
-	query := "SELECT COUNT(*) FROM import_history WHERE file_path = ?"
-	err = db.QueryRow(query, filePath).Scan(&count)
+		if stored, _, err = storeConversations(ctx, tx, conversations); err != nil {
+			return fmt.Errorf("failed to import messages: %w", err)
+		}
+		if err := tx.CreateEventBatch(ctx, events); err != nil {
+			return fmt.Errorf("failed to create import events: %w", err)
+		}
 
-	return count > 0, err
+		record := &database.ImportRecord{
+			FilePath:     filePath,
+			ImportedAt:   now,
+			SessionCount: 1, // One session per file
+			EventCount:   len(conv.Messages),
+			Checksum:     checksum,
+		}
+		if err := tx.RecordImport(ctx, record); err != nil {
+			return fmt.Errorf("failed to record import: %w", err)
+		}
+		return nil
+	})
+	return stored, err
 }
 
 // calculateChecksum calculates MD5 checksum of a file
@@ -318,50 +301,3 @@ func (im *ImportManager) calculateChecksum(filePath string) (string, error) {
 
 	return fmt.Sprintf("%x", hash.Sum(nil)), nil
 }
-
-// GetImportHistory returns the import history
-func GetImportHistory() ([]ImportRecord, error) {
-	db, err := database.GetConnection()
-	if err != nil {
-		return nil, err
-	}
-
-	query := `
-		SELECT file_path, imported_at, session_count, event_count, checksum
-		FROM import_history
-		ORDER BY imported_at DESC
-	`
-
-	rows, err := db.Query(query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var records []ImportRecord
-	for rows.Next() {
-		var record ImportRecord
-		err := rows.Scan(
-			&record.FilePath,
-			&record.ImportedAt,
-			&record.SessionCount,
-			&record.EventCount,
-			&record.Checksum,
-		)
-		if err != nil {
-			continue
-		}
-		records = append(records, record)
-	}
-
-	return records, rows.Err()
-}
-
-// ImportRecord represents an import history record
-type ImportRecord struct {
-	FilePath     string
-	ImportedAt   string
-	SessionCount int
-	EventCount   int
-	Checksum     string
-}
\ No newline at end of file
@@ -0,0 +1,48 @@
+package importer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportFileTwice(t *testing.T) {
+	backend := newIngestBackend(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "conversation.jsonl")
+	if err := os.WriteFile(path, []byte(transcriptUser+transcriptAssistant+transcriptTool), 0644); err != nil {
+		t.Fatalf("Failed to write conversation: %v", err)
+	}
+
+	// Re-importing a file stores nothing twice
+	manager := NewImportManager(backend, ImportOptions{})
+	for i := 0; i < 2; i++ {
+		if err := manager.ImportFile(ctx, path); err != nil {
+			t.Fatalf("Failed to import file: %v", err)
+		}
+	}
+
+	conversations, err := backend.GetConversationsBySession(ctx, "s1")
+	if err != nil {
+		t.Fatalf("Failed to get conversations: %v", err)
+	}
+	if len(conversations) != 3 {
+		t.Errorf("Expected 3 conversations, got %d", len(conversations))
+	}
+	session, err := backend.GetSession(ctx, "s1")
+	if err != nil || session.Status != "imported" {
+		t.Errorf("Expected the existing session to be marked imported, got %+v (%v)", session, err)
+	}
+
+	records, err := backend.ListImports(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list imports: %v", err)
+	}
+	if len(records) != 1 || records[0].FilePath != path || records[0].EventCount != 3 || records[0].Checksum == "" {
+		t.Errorf("Unexpected import history: %+v", records)
+	}
+	if imported, err := backend.IsFileImported(ctx, path); err != nil || !imported {
+		t.Errorf("Expected the file to be imported, got %v (%v)", imported, err)
+	}
+}
@@ -0,0 +1,212 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"context-extender/internal/database"
+)
+
+// DefaultIngestMaxBytes bounds how much of a transcript a single hook
+// invocation parses. Whatever is left is picked up by the next Stop hook.
+const DefaultIngestMaxBytes = 4 * 1024 * 1024
+
+// cursorCheckWindow is how far before the cursor offset we look for the
+// line holding the cursor's last UUID
+const cursorCheckWindow = 1024 * 1024
+
+// IngestResult contains statistics for one incremental transcript ingestion
+type IngestResult struct {
+	NewMessages int
+	Duplicates  int
+	Skipped     int
+	Filtered    int // messages dropped by the prompt filter
+	StartOffset int64
+	EndOffset   int64
+	Complete    bool // true when the transcript was ingested up to EOF
+	Reset       bool // true when the cursor was invalid and ingestion restarted at 0
+}
+
+// PromptFilter reports whether a user prompt must not be stored
+type PromptFilter func(prompt string) bool
+
+// IngestTranscript copies the transcript entries Claude appended since the
+// last call into the database as conversations. Progress is kept in a
+// per-session cursor, and messages are keyed by their transcript UUID, so
+// re-reading part of a transcript never creates duplicates.
+func IngestTranscript(ctx context.Context, backend database.DatabaseBackend, sessionID, transcriptPath string, maxBytes int64) (*IngestResult, error) {
+	return IngestTranscriptFiltered(ctx, backend, sessionID, transcriptPath, maxBytes, nil)
+}
+
+// IngestTranscriptFiltered is IngestTranscript, leaving out the prompts
+// skipPrompt rejects together with everything Claude did in response to them
+func IngestTranscriptFiltered(ctx context.Context, backend database.DatabaseBackend, sessionID, transcriptPath string, maxBytes int64, skipPrompt PromptFilter) (*IngestResult, error) {
+	cursor, err := backend.GetTranscriptCursor(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transcript cursor: %w", err)
+	}
+
+	result := &IngestResult{}
+
+	// Start over when the transcript moved or was rewritten under us
+	if cursor.ByteOffset > 0 && !cursorMatches(transcriptPath, cursor) {
+		cursor.ByteOffset = 0
+		cursor.LastUUID = ""
+		result.Reset = true
+	}
+
+	parsed, err := NewClaudeParser(false).ParseFrom(transcriptPath, cursor.ByteOffset, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	result.StartOffset = parsed.StartOffset
+	result.EndOffset = parsed.EndOffset
+	result.Complete = parsed.Complete
+
+	var conversations []*database.Conversation
+	skipping := false
+	for _, msg := range parsed.Conversation.Messages {
+		if msg.ID == "" || msg.Content == "" {
+			result.Skipped++
+			continue
+		}
+
+		// A filtered prompt also drops the responses and tool calls that
+		// follow it, up to the next prompt
+		if msg.Role == "user" {
+			skipping = skipPrompt != nil && skipPrompt(msg.Content)
+		}
+		if skipping {
+			result.Filtered++
+			continue
+		}
+
+		conversation := &database.Conversation{
+			ID:          fmt.Sprintf("%s_%s", sessionID, msg.ID),
+			SessionID:   sessionID,
+			MessageType: msg.Role,
+			Content:     msg.Content,
+			Timestamp:   msg.Timestamp,
+			Metadata:    "{}",
+			TokenCount:  msg.TokenCount,
+			Model:       msg.Model,
+		}
+		if conversation.Timestamp.IsZero() {
+			conversation.Timestamp = time.Now()
+		}
+		if metadata, err := json.Marshal(msg.Metadata); err == nil {
+			conversation.Metadata = string(metadata)
+		}
+
+		conversations = append(conversations, conversation)
+	}
+
+	// The messages and the cursor moving past them are stored together
+	err = backend.WithTx(ctx, func(tx database.BackendTx) error {
+		stored, duplicates, err := storeConversations(ctx, tx, conversations)
+		if err != nil {
+			return fmt.Errorf("failed to create conversation: %w", err)
+		}
+		result.NewMessages, result.Duplicates = stored, duplicates
+
+		if parsed.EndOffset != cursor.ByteOffset {
+			cursor.TranscriptPath = transcriptPath
+			cursor.ByteOffset = parsed.EndOffset
+			cursor.LastUUID = parsed.LastUUID
+			cursor.UpdatedAt = time.Now()
+			if err := tx.SaveTranscriptCursor(ctx, cursor); err != nil {
+				return fmt.Errorf("failed to save transcript cursor: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			// Out of time: nothing was stored and the cursor stays where
+			// it was, the next run picks the messages up again
+			return &IngestResult{StartOffset: result.StartOffset, EndOffset: result.StartOffset}, nil
+		}
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// storeConversations inserts conversations in one batch. When some were
+// stored before, it falls back to inserting them one at a time, skipping
+// the duplicates.
+func storeConversations(ctx context.Context, tx database.BackendTx, convs []*database.Conversation) (stored, duplicates int, err error) {
+	if len(convs) == 0 {
+		return 0, 0, nil
+	}
+
+	err = tx.CreateConversationBatch(ctx, convs)
+	if err == nil {
+		return len(convs), 0, nil
+	}
+	if !database.IsUniqueViolation(err) {
+		return 0, 0, err
+	}
+
+	for _, conv := range convs {
+		if err := tx.CreateConversation(ctx, conv); err != nil {
+			if database.IsUniqueViolation(err) {
+				duplicates++
+				continue
+			}
+			return 0, 0, err
+		}
+		stored++
+	}
+	return stored, duplicates, nil
+}
+
+// cursorMatches checks that a cursor still points at the end of a complete
+// line in the transcript, and that this line is the entry it recorded
+func cursorMatches(transcriptPath string, cursor *database.TranscriptCursor) bool {
+	if cursor.TranscriptPath != "" && cursor.TranscriptPath != transcriptPath {
+		return false
+	}
+
+	file, err := os.Open(transcriptPath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.Size() < cursor.ByteOffset {
+		return false
+	}
+
+	start := cursor.ByteOffset - cursorCheckWindow
+	if start < 0 {
+		start = 0
+	}
+	window := make([]byte, cursor.ByteOffset-start)
+	if _, err := file.ReadAt(window, start); err != nil && err != io.EOF {
+		return false
+	}
+
+	// The cursor always sits right after a newline
+	if len(window) == 0 || window[len(window)-1] != '\n' {
+		return false
+	}
+	if cursor.LastUUID == "" {
+		return true
+	}
+
+	lineStart := bytes.LastIndexByte(window[:len(window)-1], '\n')
+	if lineStart < 0 && start > 0 {
+		// The last line is longer than the window, trust the offset
+		return true
+	}
+
+	return bytes.Contains(window[lineStart+1:], []byte(cursor.LastUUID))
+}
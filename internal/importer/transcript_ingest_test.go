@@ -0,0 +1,239 @@
+package importer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"context-extender/internal/database"
+)
+
+const (
+	transcriptUser      = `{"type":"user","uuid":"u1","sessionId":"s1","cwd":"/tmp/demo","timestamp":"2025-06-01T10:00:00.000Z","message":{"role":"user","content":"List the files"}}` + "\n"
+	transcriptAssistant = `{"type":"assistant","uuid":"a1","parentUuid":"u1","sessionId":"s1","timestamp":"2025-06-01T10:00:02.000Z","message":{"role":"assistant","model":"claude-sonnet-4","content":[{"type":"text","text":"Running ls"},{"type":"tool_use","id":"toolu_1","name":"Bash","input":{"command":"ls"}}],"usage":{"input_tokens":10,"output_tokens":25}}}` + "\n"
+	transcriptTool      = `{"type":"user","uuid":"t1","parentUuid":"a1","sessionId":"s1","timestamp":"2025-06-01T10:00:03.000Z","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":"main.go"}]}}` + "\n"
+)
+
+// newIngestBackend creates a backend with a session for ingestion tests
+func newIngestBackend(t *testing.T) database.DatabaseBackend {
+	t.Helper()
+
+	config := database.DefaultDatabaseConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+
+	backend := database.NewPureGoSQLiteBackend()
+	ctx := context.Background()
+	if err := backend.Initialize(ctx, config); err != nil {
+		t.Fatalf("Failed to initialize backend: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+
+	if err := backend.CreateSchema(ctx); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+
+	now := time.Now()
+	session := &database.Session{ID: "s1", CreatedAt: now, UpdatedAt: now, Status: "active"}
+	if err := backend.CreateSession(ctx, session); err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	return backend
+}
+
+func appendToFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open transcript: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatalf("Failed to write transcript: %v", err)
+	}
+}
+
+func TestParseFromSkipsPartialLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transcript.jsonl")
+	appendToFile(t, path, transcriptUser+transcriptAssistant[:40])
+
+	parsed, err := NewClaudeParser(false).ParseFrom(path, 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse transcript: %v", err)
+	}
+
+	if len(parsed.Conversation.Messages) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(parsed.Conversation.Messages))
+	}
+	if parsed.EndOffset != int64(len(transcriptUser)) {
+		t.Errorf("Expected offset %d, got %d", len(transcriptUser), parsed.EndOffset)
+	}
+	if parsed.LastUUID != "u1" {
+		t.Errorf("Expected last UUID u1, got %s", parsed.LastUUID)
+	}
+
+	message := parsed.Conversation.Messages[0]
+	if message.ID != "u1" || message.Role != "user" || message.Content != "List the files" {
+		t.Errorf("Unexpected message: %+v", message)
+	}
+}
+
+func TestParseFromToolMessages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transcript.jsonl")
+	appendToFile(t, path, transcriptAssistant+transcriptTool)
+
+	parsed, err := NewClaudeParser(false).ParseFrom(path, 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse transcript: %v", err)
+	}
+	if len(parsed.Conversation.Messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(parsed.Conversation.Messages))
+	}
+
+	assistant := parsed.Conversation.Messages[0]
+	if assistant.Model != "claude-sonnet-4" || assistant.TokenCount != 25 {
+		t.Errorf("Expected model and token count, got: %+v", assistant)
+	}
+	if assistant.Content != "Running ls\n[Tool: Bash] {\"command\":\"ls\"}" {
+		t.Errorf("Unexpected assistant content: %q", assistant.Content)
+	}
+
+	tool := parsed.Conversation.Messages[1]
+	if tool.Role != "tool" || tool.Content != "main.go" {
+		t.Errorf("Expected tool result message, got: %+v", tool)
+	}
+}
+
+func TestIngestTranscriptIncremental(t *testing.T) {
+	backend := newIngestBackend(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "transcript.jsonl")
+
+	appendToFile(t, path, transcriptUser)
+	result, err := IngestTranscript(ctx, backend, "s1", path, 0)
+	if err != nil {
+		t.Fatalf("Failed to ingest transcript: %v", err)
+	}
+	if result.NewMessages != 1 {
+		t.Errorf("Expected 1 new message, got %d", result.NewMessages)
+	}
+
+	// Nothing new: nothing inserted
+	result, err = IngestTranscript(ctx, backend, "s1", path, 0)
+	if err != nil {
+		t.Fatalf("Failed to ingest transcript: %v", err)
+	}
+	if result.NewMessages != 0 || result.Duplicates != 0 {
+		t.Errorf("Expected no work, got: %+v", result)
+	}
+
+	appendToFile(t, path, transcriptAssistant+transcriptTool)
+	result, err = IngestTranscript(ctx, backend, "s1", path, 0)
+	if err != nil {
+		t.Fatalf("Failed to ingest transcript: %v", err)
+	}
+	if result.NewMessages != 2 || result.StartOffset != int64(len(transcriptUser)) {
+		t.Errorf("Expected 2 new messages from the previous offset, got: %+v", result)
+	}
+
+	conversations, err := backend.GetConversationsBySession(ctx, "s1")
+	if err != nil {
+		t.Fatalf("Failed to get conversations: %v", err)
+	}
+	if len(conversations) != 3 {
+		t.Fatalf("Expected 3 conversations, got %d", len(conversations))
+	}
+
+	cursor, err := backend.GetTranscriptCursor(ctx, "s1")
+	if err != nil {
+		t.Fatalf("Failed to get cursor: %v", err)
+	}
+	if cursor.LastUUID != "t1" {
+		t.Errorf("Expected cursor at t1, got %s", cursor.LastUUID)
+	}
+}
+
+func TestIngestTranscriptRewritten(t *testing.T) {
+	backend := newIngestBackend(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "transcript.jsonl")
+
+	appendToFile(t, path, transcriptUser+transcriptAssistant)
+	if _, err := IngestTranscript(ctx, backend, "s1", path, 0); err != nil {
+		t.Fatalf("Failed to ingest transcript: %v", err)
+	}
+
+	// Rewrite the transcript with different content at the same length
+	if err := os.WriteFile(path, []byte(transcriptTool), 0644); err != nil {
+		t.Fatalf("Failed to rewrite transcript: %v", err)
+	}
+
+	result, err := IngestTranscript(ctx, backend, "s1", path, 0)
+	if err != nil {
+		t.Fatalf("Failed to ingest transcript: %v", err)
+	}
+	if !result.Reset || result.NewMessages != 1 {
+		t.Errorf("Expected the cursor to reset and ingest 1 message, got: %+v", result)
+	}
+}
+
+func TestIngestTranscriptMaxBytes(t *testing.T) {
+	backend := newIngestBackend(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "transcript.jsonl")
+	appendToFile(t, path, transcriptUser+transcriptAssistant+transcriptTool)
+
+	result, err := IngestTranscript(ctx, backend, "s1", path, 1)
+	if err != nil {
+		t.Fatalf("Failed to ingest transcript: %v", err)
+	}
+	if result.Complete || result.NewMessages != 1 {
+		t.Errorf("Expected a single line to be ingested, got: %+v", result)
+	}
+
+	for !result.Complete {
+		if result, err = IngestTranscript(ctx, backend, "s1", path, 1); err != nil {
+			t.Fatalf("Failed to ingest transcript: %v", err)
+		}
+	}
+
+	conversations, err := backend.GetConversationsBySession(ctx, "s1")
+	if err != nil {
+		t.Fatalf("Failed to get conversations: %v", err)
+	}
+	if len(conversations) != 3 {
+		t.Errorf("Expected 3 conversations, got %d", len(conversations))
+	}
+}
+
+func TestIngestTranscriptFiltered(t *testing.T) {
+	backend := newIngestBackend(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "transcript.jsonl")
+
+	next := `{"type":"user","uuid":"u2","parentUuid":"t1","sessionId":"s1","timestamp":"2025-06-01T10:01:00.000Z","message":{"role":"user","content":"Thanks"}}` + "\n"
+	appendToFile(t, path, transcriptUser+transcriptAssistant+transcriptTool+next)
+
+	skipFiles := func(prompt string) bool { return prompt == "List the files" }
+	result, err := IngestTranscriptFiltered(ctx, backend, "s1", path, 0, skipFiles)
+	if err != nil {
+		t.Fatalf("Failed to ingest transcript: %v", err)
+	}
+
+	// The filtered prompt takes its response and tool result with it
+	if result.Filtered != 3 || result.NewMessages != 1 {
+		t.Errorf("Expected 3 filtered and 1 new message, got: %+v", result)
+	}
+
+	conversations, err := backend.GetConversationsBySession(ctx, "s1")
+	if err != nil {
+		t.Fatalf("Failed to get conversations: %v", err)
+	}
+	if len(conversations) != 1 || conversations[0].Content != "Thanks" {
+		t.Errorf("Expected only the unfiltered prompt, got %+v", conversations)
+	}
+}
@@ -0,0 +1,184 @@
+package retention
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"context-extender/internal/config"
+	"context-extender/internal/database"
+)
+
+// PolicyFileName is the retention policy in the context-extender config
+// directory
+const PolicyFileName = "retention.json"
+
+// Policy decides which sessions 'database purge' deletes. A session is
+// deleted when it breaks any limit, unless it is protected.
+type Policy struct {
+	MaxAge                string   `json:"max_age,omitempty"`                  // e.g. "90d", by last activity
+	MaxSessionsPerProject int      `json:"max_sessions_per_project,omitempty"` // newest sessions kept per project
+	KeepStarred           bool     `json:"keep_starred"`                       // starred sessions are protected, true when unset
+	KeepTagged            bool     `json:"keep_tagged,omitempty"`              // sessions with any tag are protected
+	KeepTags              []string `json:"keep_tags,omitempty"`                // sessions with one of these tags are protected
+
+	// Path is the file the policy was loaded from, empty when there is none
+	Path string `json:"-"`
+
+	maxAge time.Duration
+}
+
+// Candidate is a session the policy deletes
+type Candidate struct {
+	Session *database.SessionFootprint
+	Reason  string
+}
+
+// DefaultPolicy returns the policy used without a policy file, which
+// deletes nothing
+func DefaultPolicy() *Policy {
+	return &Policy{KeepStarred: true}
+}
+
+// GetPolicyPath returns the path of the retention policy file
+func GetPolicyPath() (string, error) {
+	configPath, err := config.GetContextExtenderConfigPath()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configPath, PolicyFileName), nil
+}
+
+// LoadPolicy loads the retention policy file
+func LoadPolicy() (*Policy, error) {
+	policyPath, err := GetPolicyPath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get retention policy path: %w", err)
+	}
+
+	return LoadPolicyFrom(policyPath)
+}
+
+// LoadPolicyFrom loads and validates a retention policy file
+func LoadPolicyFrom(policyPath string) (*Policy, error) {
+	policy := DefaultPolicy()
+	data, err := os.ReadFile(policyPath)
+	if os.IsNotExist(err) {
+		return policy, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read retention policy: %w", err)
+	}
+
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("failed to parse retention policy %s: %w", policyPath, err)
+	}
+	policy.Path = policyPath
+
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid retention policy %s: %w", policyPath, err)
+	}
+	return policy, nil
+}
+
+// Validate checks the limits, it must be called after changing them
+func (p *Policy) Validate() error {
+	p.maxAge = 0
+	if p.MaxAge != "" {
+		age, err := ParseAge(p.MaxAge)
+		if err != nil || age <= 0 {
+			return fmt.Errorf("invalid max_age %q", p.MaxAge)
+		}
+		p.maxAge = age
+	}
+	if p.MaxSessionsPerProject < 0 {
+		return fmt.Errorf("max_sessions_per_project can't be negative")
+	}
+	return nil
+}
+
+// HasLimits reports whether the policy deletes anything at all
+func (p *Policy) HasLimits() bool {
+	return p.MaxAge != "" || p.MaxSessionsPerProject > 0
+}
+
+// Protected returns why a session is never deleted, or "" when it isn't
+// protected
+func (p *Policy) Protected(session *database.SessionFootprint) string {
+	if p.KeepStarred && session.Starred {
+		return "starred"
+	}
+	if p.KeepTagged && len(session.Tags) > 0 {
+		return "tagged"
+	}
+	for _, tag := range session.Tags {
+		for _, keep := range p.KeepTags {
+			if tag == keep {
+				return "tagged " + tag
+			}
+		}
+	}
+	return ""
+}
+
+// Evaluate returns the sessions the policy deletes, oldest first. Sessions
+// count towards the per-project limit newest first, protected ones
+// included.
+func (p *Policy) Evaluate(sessions []*database.SessionFootprint, now time.Time) []Candidate {
+	byActivity := append([]*database.SessionFootprint(nil), sessions...)
+	sort.SliceStable(byActivity, func(i, j int) bool {
+		return byActivity[i].LastActivity.After(byActivity[j].LastActivity)
+	})
+
+	var candidates []Candidate
+	perProject := make(map[string]int)
+	for _, session := range byActivity {
+		perProject[session.Project]++
+		if p.Protected(session) != "" {
+			continue
+		}
+
+		switch {
+		case p.maxAge > 0 && now.Sub(session.LastActivity) > p.maxAge:
+			candidates = append(candidates, Candidate{Session: session, Reason: "inactive for more than " + p.MaxAge})
+		case p.MaxSessionsPerProject > 0 && perProject[session.Project] > p.MaxSessionsPerProject:
+			candidates = append(candidates, Candidate{Session: session, Reason: fmt.Sprintf("beyond the newest %d in %s", p.MaxSessionsPerProject, projectName(session.Project))})
+		}
+	}
+
+	// Oldest first
+	for i, j := 0, len(candidates)-1; i < j; i, j = i+1, j-1 {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	}
+	return candidates
+}
+
+// projectName names a project for messages
+func projectName(project string) string {
+	if project == "" {
+		return "sessions without a project"
+	}
+	return project
+}
+
+// ParseAge parses a duration that may also be given in days ("30d") or
+// weeks ("2w")
+func ParseAge(value string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if number, ok := strings.CutSuffix(value, suffix); ok {
+			n, err := strconv.Atoi(number)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid duration %q", value)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	return time.ParseDuration(value)
+}
@@ -0,0 +1,91 @@
+package retention
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"context-extender/internal/database"
+)
+
+func TestLoadPolicyFrom(t *testing.T) {
+	dir := t.TempDir()
+
+	policy, err := LoadPolicyFrom(filepath.Join(dir, PolicyFileName))
+	if err != nil || policy.Path != "" || policy.HasLimits() || !policy.KeepStarred {
+		t.Fatalf("Expected the default policy without a file, got %+v (%v)", policy, err)
+	}
+
+	policyPath := filepath.Join(dir, "retention.json")
+	os.WriteFile(policyPath, []byte(`{"max_age": "30d", "keep_tags": ["keep"]}`), 0644)
+	policy, err = LoadPolicyFrom(policyPath)
+	if err != nil {
+		t.Fatalf("Failed to load policy: %v", err)
+	}
+	if policy.Path != policyPath || !policy.HasLimits() || !policy.KeepStarred || policy.maxAge != 30*24*time.Hour {
+		t.Errorf("Unexpected policy: %+v", policy)
+	}
+
+	os.WriteFile(policyPath, []byte(`{"max_age": "soon"}`), 0644)
+	if _, err := LoadPolicyFrom(policyPath); err == nil {
+		t.Error("Expected an invalid max_age to be rejected")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	now := time.Now()
+	session := func(id, project string, age time.Duration) *database.SessionFootprint {
+		return &database.SessionFootprint{ID: id, Project: project, LastActivity: now.Add(-age)}
+	}
+
+	ancient := session("ancient", "api", 400*24*time.Hour)
+	starred := session("starred", "api", 300*24*time.Hour)
+	starred.Starred = true
+	tagged := session("tagged", "api", 200*24*time.Hour)
+	tagged.Tags = []string{"keep"}
+	sessions := []*database.SessionFootprint{
+		session("recent", "api", time.Hour),
+		session("older", "api", 24*time.Hour),
+		session("oldest", "api", 48*time.Hour),
+		session("web", "web", 48*time.Hour),
+		ancient, starred, tagged,
+	}
+
+	ids := func(candidates []Candidate) string {
+		var ids []string
+		for _, candidate := range candidates {
+			ids = append(ids, candidate.Session.ID)
+		}
+		return strings.Join(ids, ",")
+	}
+
+	policy := &Policy{MaxAge: "90d", KeepStarred: true}
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("Invalid policy: %v", err)
+	}
+	if got := ids(policy.Evaluate(sessions, now)); got != "ancient,tagged" {
+		t.Errorf("Expected old unstarred sessions to go, got %s", got)
+	}
+
+	policy.KeepTags = []string{"keep"}
+	if got := ids(policy.Evaluate(sessions, now)); got != "ancient" {
+		t.Errorf("Expected sessions tagged keep to stay, got %s", got)
+	}
+
+	// Protected sessions count towards the limit but stay
+	policy = &Policy{MaxSessionsPerProject: 2, KeepStarred: true, KeepTagged: true}
+	policy.Validate()
+	candidates := policy.Evaluate(sessions, now)
+	if got := ids(candidates); got != "ancient,oldest" {
+		t.Errorf("Expected all but the newest two api sessions to go, got %s", got)
+	}
+	if len(candidates) > 0 && !strings.Contains(candidates[0].Reason, "api") {
+		t.Errorf("Expected the reason to name the project, got %q", candidates[0].Reason)
+	}
+
+	if got := ids(DefaultPolicy().Evaluate(sessions, now)); got != "" {
+		t.Errorf("Expected the default policy to delete nothing, got %s", got)
+	}
+}
@@ -0,0 +1,405 @@
+package spool
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrDrop tells Drain that an entry can never be replayed and should be
+// discarded instead of blocking the spool
+var ErrDrop = errors.New("drop spooled entry")
+
+// ErrLocked is returned by Drain when another process is draining the spool
+var ErrLocked = errors.New("spool is being drained by another process")
+
+const (
+	segmentExt    = ".jsonl"
+	tempExt       = ".tmp"
+	lockName      = "drain.lock"
+	deadLetterDir = "dead"
+)
+
+// MaxAttempts is how many replays of an entry may fail before Drain moves
+// it to the dead letter directory, so it stops holding back the entries
+// after it
+const MaxAttempts = 5
+
+// staleLockAge is how old a drain lock must be before it is considered
+// abandoned by a crashed process
+const staleLockAge = 5 * time.Minute
+
+// Entry is a single spooled record
+type Entry struct {
+	ID        string          `json:"id"`
+	SpooledAt time.Time       `json:"spooled_at"`
+	Reason    string          `json:"reason,omitempty"`
+	Record    json.RawMessage `json:"record"`
+	Attempts  int             `json:"attempts,omitempty"`   // failed replays
+	LastError string          `json:"last_error,omitempty"` // why the last replay failed
+}
+
+// DrainResult contains statistics for one drain
+type DrainResult struct {
+	Replayed     int
+	Dropped      int
+	DeadLettered int // moved to the dead letter directory after MaxAttempts
+	Remaining    int
+}
+
+// Spool is a durable on-disk queue of records that couldn't be written to
+// the database. Records are stored as JSONL segments; a segment only
+// becomes visible once it has been fully written and fsynced.
+type Spool struct {
+	dir string
+}
+
+// New creates a spool stored in dir
+func New(dir string) *Spool {
+	return &Spool{dir: dir}
+}
+
+// Dir returns the spool directory
+func (s *Spool) Dir() string {
+	return s.dir
+}
+
+// Append durably stores a record. It returns once the record is on disk.
+func (s *Spool) Append(record interface{}, reason string) (*Entry, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode spool record: %w", err)
+	}
+
+	entry := &Entry{
+		ID:        newEntryID(),
+		SpooledAt: time.Now(),
+		Reason:    reason,
+		Record:    data,
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode spool entry: %w", err)
+	}
+
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	name := fmt.Sprintf("%020d-%s%s", entry.SpooledAt.UnixNano(), entry.ID, segmentExt)
+	if err := writeSegment(s.dir, name, [][]byte{line}); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// Count returns the number of spooled entries
+func (s *Spool) Count() (int, error) {
+	segments, err := s.segments()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, segment := range segments {
+		lines, err := readLines(segment)
+		if err != nil {
+			return 0, err
+		}
+		count += len(lines)
+	}
+	return count, nil
+}
+
+// DeadLetterDir returns the directory entries given up on are moved to
+func (s *Spool) DeadLetterDir() string {
+	return filepath.Join(s.dir, deadLetterDir)
+}
+
+// DeadLetterCount returns the number of entries given up on after
+// MaxAttempts failed replays
+func (s *Spool) DeadLetterCount() (int, error) {
+	entries, err := os.ReadDir(s.DeadLetterDir())
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read dead letter directory: %w", err)
+	}
+
+	count := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), segmentExt) {
+			continue
+		}
+		lines, err := readLines(filepath.Join(s.DeadLetterDir(), entry.Name()))
+		if err != nil {
+			return 0, err
+		}
+		count += len(lines)
+	}
+	return count, nil
+}
+
+// Drain replays spooled entries oldest first. Entries are removed once fn
+// succeeds or returns an error wrapping ErrDrop. Any other error stops the
+// drain and keeps that entry, with its failed attempt counted, and
+// everything after it; an entry failing for the MaxAttempts time is moved
+// to the dead letter directory instead and the drain goes on. Drain also
+// stops, without error, when ctx is done.
+func (s *Spool) Drain(ctx context.Context, fn func(*Entry) error) (*DrainResult, error) {
+	result := &DrainResult{}
+
+	segments, err := s.segments()
+	if err != nil || len(segments) == 0 {
+		return result, err
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return result, err
+	}
+	defer unlock()
+
+	// Re-list under the lock, another drain may have finished meanwhile
+	if segments, err = s.segments(); err != nil {
+		return result, err
+	}
+
+	var drainErr error
+	for i, segment := range segments {
+		if drainErr != nil || ctx.Err() != nil {
+			lines, err := readLines(segment)
+			if err == nil {
+				result.Remaining += len(lines)
+			}
+			continue
+		}
+
+		lines, err := readLines(segment)
+		if err != nil {
+			drainErr = err
+			result.Remaining += countRemaining(segments[i:])
+			break
+		}
+
+		done := 0
+		for _, line := range lines {
+			if ctx.Err() != nil {
+				break
+			}
+
+			var entry Entry
+			if err := json.Unmarshal(line, &entry); err != nil {
+				// Unreadable entries can't be replayed
+				result.Dropped++
+				done++
+				continue
+			}
+
+			if err := fn(&entry); err != nil {
+				if errors.Is(err, ErrDrop) {
+					result.Dropped++
+					done++
+					continue
+				}
+				if ctx.Err() != nil {
+					// Out of time, which isn't the entry's fault
+					break
+				}
+
+				entry.Attempts++
+				entry.LastError = err.Error()
+				updated, marshalErr := json.Marshal(&entry)
+				if marshalErr != nil {
+					drainErr = err
+					break
+				}
+				if entry.Attempts >= MaxAttempts {
+					if err := s.deadLetter(entry.SpooledAt, entry.ID, updated); err != nil {
+						drainErr = err
+						break
+					}
+					result.DeadLettered++
+					done++
+					continue
+				}
+				lines[done] = updated
+				drainErr = err
+				break
+			}
+			result.Replayed++
+			done++
+		}
+
+		if err := s.finishSegment(segment, lines[done:]); err != nil && drainErr == nil {
+			drainErr = err
+		}
+		result.Remaining += len(lines) - done
+	}
+
+	return result, drainErr
+}
+
+// deadLetter stores an entry given up on in the dead letter directory
+func (s *Spool) deadLetter(spooledAt time.Time, id string, line []byte) error {
+	dir := s.DeadLetterDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create dead letter directory: %w", err)
+	}
+	name := fmt.Sprintf("%020d-%s%s", spooledAt.UnixNano(), id, segmentExt)
+	return writeSegment(dir, name, [][]byte{line})
+}
+
+// finishSegment removes a drained segment, or rewrites it with the entries
+// that are still pending
+func (s *Spool) finishSegment(segment string, remaining [][]byte) error {
+	if len(remaining) == 0 {
+		if err := os.Remove(segment); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove spool segment: %w", err)
+		}
+		return nil
+	}
+	return writeSegment(s.dir, filepath.Base(segment), remaining)
+}
+
+// segments returns the complete segment files, oldest first
+func (s *Spool) segments() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	var segments []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), segmentExt) {
+			continue
+		}
+		segments = append(segments, filepath.Join(s.dir, entry.Name()))
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+// lock takes the drain lock, breaking locks left behind by crashed processes
+func (s *Spool) lock() (func(), error) {
+	path := filepath.Join(s.dir, lockName)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintf(file, "%d", os.Getpid())
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create spool lock: %w", err)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil || time.Since(info.ModTime()) < staleLockAge {
+			return nil, ErrLocked
+		}
+		os.Remove(path)
+	}
+
+	return nil, ErrLocked
+}
+
+// writeSegment atomically writes lines to a segment: the data is written to
+// a temp file, fsynced, renamed into place and the directory is fsynced
+func writeSegment(dir, name string, lines [][]byte) error {
+	tempPath := filepath.Join(dir, name+tempExt)
+	file, err := os.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create spool segment: %w", err)
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, line := range lines {
+		writer.Write(line)
+		writer.WriteByte('\n')
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write spool segment: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to sync spool segment: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to close spool segment: %w", err)
+	}
+
+	if err := os.Rename(tempPath, filepath.Join(dir, name)); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to commit spool segment: %w", err)
+	}
+
+	syncDir(dir)
+	return nil
+}
+
+// syncDir fsyncs a directory so a rename survives a crash. Not every
+// platform supports this, so failures are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// readLines returns the non-empty lines of a segment
+func readLines(path string) ([][]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool segment: %w", err)
+	}
+
+	var lines [][]byte
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// countRemaining counts the entries in segments, ignoring unreadable ones
+func countRemaining(segments []string) int {
+	count := 0
+	for _, segment := range segments {
+		if lines, err := readLines(segment); err == nil {
+			count += len(lines)
+		}
+	}
+	return count
+}
+
+// newEntryID returns a random entry ID
+func newEntryID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
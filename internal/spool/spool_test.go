@@ -0,0 +1,169 @@
+package spool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testRecord struct {
+	Name string `json:"name"`
+}
+
+func appendRecords(t *testing.T, s *Spool, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if _, err := s.Append(&testRecord{Name: name}, "database locked"); err != nil {
+			t.Fatalf("Failed to append: %v", err)
+		}
+	}
+}
+
+func recordName(t *testing.T, entry *Entry) string {
+	t.Helper()
+	var record testRecord
+	if err := json.Unmarshal(entry.Record, &record); err != nil {
+		t.Fatalf("Failed to decode record: %v", err)
+	}
+	return record.Name
+}
+
+func TestAppendAndDrain(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "spool"))
+	appendRecords(t, s, "a", "b", "c")
+
+	count, err := s.Count()
+	if err != nil || count != 3 {
+		t.Fatalf("Expected 3 entries, got %d (%v)", count, err)
+	}
+
+	var replayed []string
+	result, err := s.Drain(context.Background(), func(entry *Entry) error {
+		if entry.Reason != "database locked" {
+			t.Errorf("Expected reason to be kept, got %q", entry.Reason)
+		}
+		replayed = append(replayed, recordName(t, entry))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to drain: %v", err)
+	}
+
+	if result.Replayed != 3 || result.Remaining != 0 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if len(replayed) != 3 || replayed[0] != "a" || replayed[2] != "c" {
+		t.Errorf("Expected entries in order, got %v", replayed)
+	}
+	if count, _ := s.Count(); count != 0 {
+		t.Errorf("Expected empty spool, got %d entries", count)
+	}
+}
+
+func TestDrainStopsOnFailure(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "spool"))
+	appendRecords(t, s, "a", "bad", "b", "c")
+
+	failure := errors.New("database locked")
+	result, err := s.Drain(context.Background(), func(entry *Entry) error {
+		switch recordName(t, entry) {
+		case "bad":
+			return ErrDrop
+		case "b":
+			return failure
+		}
+		return nil
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("Expected drain to fail, got: %v", err)
+	}
+	if result.Replayed != 1 || result.Dropped != 1 || result.Remaining != 2 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	// The failed entry and everything after it are replayed next time
+	var replayed []string
+	if _, err := s.Drain(context.Background(), func(entry *Entry) error {
+		replayed = append(replayed, recordName(t, entry))
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to drain: %v", err)
+	}
+	if len(replayed) != 2 || replayed[0] != "b" || replayed[1] != "c" {
+		t.Errorf("Expected b and c to be replayed, got %v", replayed)
+	}
+}
+
+func TestDrainDeadLetters(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "spool"))
+	appendRecords(t, s, "bad", "a")
+
+	failure := errors.New("session not found")
+	var replayed []string
+	for i := 1; i <= MaxAttempts; i++ {
+		result, err := s.Drain(context.Background(), func(entry *Entry) error {
+			if recordName(t, entry) == "bad" {
+				if entry.Attempts != i-1 {
+					t.Errorf("Expected %d earlier attempts, got %d", i-1, entry.Attempts)
+				}
+				return failure
+			}
+			replayed = append(replayed, recordName(t, entry))
+			return nil
+		})
+		if i < MaxAttempts && (!errors.Is(err, failure) || result.Remaining != 2) {
+			t.Fatalf("Expected attempt %d to stop the drain, got %+v (%v)", i, result, err)
+		}
+		if i == MaxAttempts && (err != nil || result.DeadLettered != 1 || result.Replayed != 1) {
+			t.Fatalf("Expected the entry to be dead lettered, got %+v (%v)", result, err)
+		}
+	}
+
+	// The failing entry no longer holds back the ones after it
+	if len(replayed) != 1 || replayed[0] != "a" {
+		t.Errorf("Expected a to be replayed, got %v", replayed)
+	}
+	if count, _ := s.Count(); count != 0 {
+		t.Errorf("Expected empty spool, got %d entries", count)
+	}
+	if count, err := s.DeadLetterCount(); err != nil || count != 1 {
+		t.Errorf("Expected 1 dead letter, got %d (%v)", count, err)
+	}
+}
+
+func TestDrainLocked(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "spool"))
+	appendRecords(t, s, "a")
+
+	unlock, err := s.lock()
+	if err != nil {
+		t.Fatalf("Failed to lock: %v", err)
+	}
+
+	if _, err := s.Drain(context.Background(), func(*Entry) error { return nil }); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked, got: %v", err)
+	}
+
+	unlock()
+	if _, err := os.Stat(filepath.Join(s.Dir(), lockName)); !os.IsNotExist(err) {
+		t.Errorf("Expected lock file to be removed")
+	}
+}
+
+func TestIgnoresIncompleteSegments(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "spool"))
+	appendRecords(t, s, "a")
+
+	// A segment a crashed process never finished writing
+	if err := os.WriteFile(filepath.Join(s.Dir(), "00000000000000000001-x.jsonl"+tempExt), []byte(`{"id":`), 0600); err != nil {
+		t.Fatalf("Failed to write temp segment: %v", err)
+	}
+
+	count, err := s.Count()
+	if err != nil || count != 1 {
+		t.Errorf("Expected 1 entry, got %d (%v)", count, err)
+	}
+}